@@ -0,0 +1,129 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessMembers lets in anyone who is signed in, github sign ins included
+const accessMembers = "members"
+
+// restricted reports whether the post's Access metadata limits who can read it
+func (p BlogPost) restricted() bool {
+	return len(p.Access) > 0
+}
+
+// canAccess reports whether session may read post, any one of the post's
+// Access values is enough
+func canAccess(session *Session, post BlogPost) bool {
+	if !post.restricted() {
+		return true
+	}
+	if session == nil {
+		return false
+	}
+
+	for _, access := range post.Access {
+		switch {
+		case access == accessMembers:
+			return true
+		case validRole(access) && session.Role != "" && roleAtLeast(session.Role, access):
+			return true
+		}
+	}
+	return false
+}
+
+// publicPosts drops restricted posts, for everything that's shared with
+// anyone: search indexes, feeds, sitemaps and static builds
+func publicPosts(posts []BlogPost) []BlogPost {
+	var public []BlogPost
+	for _, post := range posts {
+		if !post.restricted() {
+			public = append(public, post)
+		}
+	}
+	return public
+}
+
+// publicChanges is what a reload changed as anyone can see it: a post that
+// becomes restricted is removed, one that stops being is added and
+// restricted posts aren't mentioned at all
+func publicChanges(old map[string]BlogPost, posts []BlogPost, changes contentChanges) contentChanges {
+	public := make(map[string]BlogPost)
+	for slug, post := range old {
+		if !post.restricted() {
+			public[slug] = post
+		}
+	}
+	diff := diffPosts(public, publicPosts(posts))
+	diff.Skipped = changes.Skipped
+	return diff
+}
+
+// visibleTo is the sidebar without the pages session can't read, and
+// without the categories that leaves empty
+func (sb SideBar) visibleTo(session *Session) SideBar {
+	var visible SideBar
+	for _, category := range sb.Categories {
+		var pages []SidebarPage
+		for _, page := range category.Pages {
+			if canAccess(session, page.BlogPost) {
+				pages = append(pages, page)
+			}
+		}
+		if len(pages) == 0 {
+			continue
+		}
+		category.Pages = pages
+		visible.Categories = append(visible.Categories, category)
+	}
+	return visible
+}
+
+// denyAccess answers a request for a post the visitor can't read. Anyone
+// signed out is sent to sign in, signed in visitors without access get a
+// not found so restricted pages don't give themselves away
+func (s *server) denyAccess(c *gin.Context, session *Session, post BlogPost) {
+	if session != nil {
+		s.notFound(c)
+		return
+	}
+
+	login := "/admin/login?return="
+	for _, access := range post.Access {
+		if access == accessMembers && config().GitHub.enabled() {
+			login = "/auth/github/login?return="
+		}
+	}
+	c.Redirect(http.StatusFound, login+url.QueryEscape(c.Request.URL.RequestURI()))
+}
+
+// apiAccess returns the access check for an api caller, who can also read
+// restricted posts with a token that has the posts:write scope
+func (s *server) apiAccess(c *gin.Context) func(BlogPost) bool {
+	session := s.sessions.get(c)
+
+	byToken := false
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token, ok := s.tokens.verify(strings.TrimPrefix(auth, "Bearer "))
+		byToken = ok && token.hasScope(scopePostsWrite)
+	}
+
+	return func(post BlogPost) bool {
+		return byToken || canAccess(session, post)
+	}
+}
+
+// readablePost finds the post with slug that the visitor may read, a
+// restricted one they can't is as missing as one that doesn't exist
+func (s *server) readablePost(c *gin.Context, slug string) (BlogPost, bool) {
+	post, ok := s.post(slug)
+	if !ok || !canAccess(s.sessions.get(c), post) {
+		return BlogPost{}, false
+	}
+	return post, true
+}
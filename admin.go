@@ -0,0 +1,220 @@
+package blog
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminRoutes sets up the login form and the admin area, viewers can look
+// around, editors can moderate and admins can manage users and tokens
+func (s *server) adminRoutes(r *gin.Engine) {
+	r.GET("/admin/login", func(c *gin.Context) {
+		s.html(c, http.StatusOK, "admin-login.html", gin.H{
+			"Title":  "Sign in",
+			"Return": localPath(c.Query("return")),
+		})
+	})
+
+	r.POST("/admin/login", func(c *gin.Context) {
+		if !s.loginLimiter.allow(c.ClientIP()) {
+			s.html(c, http.StatusTooManyRequests, "admin-login.html", gin.H{
+				"Title":  "Sign in",
+				"Return": localPath(c.PostForm("return")),
+				"Error":  "Too many attempts, try again later",
+			})
+			return
+		}
+
+		user, ok := login(s.users, c.PostForm("username"), c.PostForm("password"))
+		if !ok {
+			s.html(c, http.StatusUnauthorized, "admin-login.html", gin.H{
+				"Title":  "Sign in",
+				"Return": localPath(c.PostForm("return")),
+				"Error":  "Wrong username or password",
+			})
+			return
+		}
+
+		s.sessions.start(c, Session{Name: user.Username, Username: user.Username, Role: user.Role})
+
+		returnPath := localPath(c.PostForm("return"))
+		if returnPath == "/" {
+			returnPath = "/admin/dashboard"
+		}
+		c.Redirect(http.StatusSeeOther, returnPath)
+	})
+
+	r.POST("/admin/logout", func(c *gin.Context) {
+		s.sessions.end(c)
+		c.Redirect(http.StatusSeeOther, "/admin/login")
+	})
+
+	viewer := r.Group("/admin", s.requireRole(roleViewer))
+	editor := r.Group("/admin", s.requireRole(roleEditor))
+	moderator := r.Group("/admin", s.requireScope(scopeCommentsModerate, roleEditor))
+	admin := r.Group("/admin", s.requireRole(roleAdmin))
+
+	viewer.GET("/dashboard", s.handleDashboard(r))
+
+	viewer.GET("/comments", func(c *gin.Context) {
+		status := c.DefaultQuery("status", commentPending)
+
+		s.html(c, http.StatusOK, "admin-comments.html", gin.H{
+			"Title":    "Comments",
+			"Session":  c.MustGet("session"),
+			"Status":   status,
+			"Statuses": []string{commentPending, commentApproved, commentSpam},
+			"Comments": s.comments.withStatus(status),
+		})
+	})
+
+	moderator.POST("/comments/:id/:action", func(c *gin.Context) {
+		var err error
+		switch c.Param("action") {
+		case "approve":
+			err = s.comments.setStatus(c.Param("id"), commentApproved)
+		case "spam":
+			err = s.comments.setStatus(c.Param("id"), commentSpam)
+		case "delete":
+			err = s.comments.delete(c.Param("id"))
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+
+		if errors.Is(err, errCommentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/comments?status="+c.DefaultQuery("status", commentPending))
+	})
+
+	s.mediaRoutes(editor)
+
+	// re-reads bloog.yaml, same as sending the process a SIGHUP
+	admin.POST("/config/reload", s.handleReloadConfig)
+
+	admin.GET("/users", func(c *gin.Context) {
+		s.html(c, http.StatusOK, "admin-users.html", gin.H{
+			"Title":   "Users",
+			"Session": c.MustGet("session"),
+			"Users":   s.users.list(),
+			"Roles":   roles,
+			"Error":   c.Query("error"),
+		})
+	})
+
+	admin.POST("/users", func(c *gin.Context) {
+		username := strings.TrimSpace(c.PostForm("username"))
+		password := c.PostForm("password")
+		if username == "" || len(password) < 8 {
+			c.Redirect(http.StatusSeeOther, "/admin/users?error=username+and+a+password+of+at+least+8+characters+are+required")
+			return
+		}
+
+		err := s.users.add(username, password, c.PostForm("role"))
+		if errors.Is(err, errUserExists) || errors.Is(err, errInvalidRole) {
+			c.Redirect(http.StatusSeeOther, "/admin/users?error="+strings.ReplaceAll(err.Error(), " ", "+"))
+			return
+		}
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/users")
+	})
+
+	admin.POST("/users/:username/:action", func(c *gin.Context) {
+		var err error
+		switch c.Param("action") {
+		case "role":
+			err = s.users.setRole(c.Param("username"), c.PostForm("role"))
+		case "delete":
+			err = s.users.delete(c.Param("username"))
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+
+		if errors.Is(err, errUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		if errors.Is(err, errInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/users")
+	})
+
+	admin.GET("/tokens", func(c *gin.Context) {
+		s.html(c, http.StatusOK, "admin-tokens.html", gin.H{
+			"Title":   "API tokens",
+			"Session": c.MustGet("session"),
+			"Tokens":  s.tokens.list(),
+			"Scopes":  tokenScopes,
+		})
+	})
+
+	admin.POST("/tokens", func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		secret, token, err := s.tokens.create(name, c.PostFormArray("scope"))
+		if errors.Is(err, errInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		// the secret is only ever shown on this response
+		s.html(c, http.StatusOK, "admin-tokens.html", gin.H{
+			"Title":   "API tokens",
+			"Session": c.MustGet("session"),
+			"Tokens":  s.tokens.list(),
+			"Scopes":  tokenScopes,
+			"Created": token,
+			"Secret":  secret,
+		})
+	})
+
+	admin.POST("/tokens/:id/revoke", func(c *gin.Context) {
+		err := s.tokens.revoke(c.Param("id"))
+		if errors.Is(err, errTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/tokens")
+	})
+}
@@ -0,0 +1,113 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// keep records well under algolia's per record size limit
+const algoliaChunkSize = 1000
+
+type algoliaRecord struct {
+	ObjectID string   `json:"objectID"`
+	Title    string   `json:"title"`
+	Category string   `json:"category,omitempty"`
+	Headers  []string `json:"headers,omitempty"`
+	Content  string   `json:"content"`
+	URL      string   `json:"url"`
+}
+
+type algoliaBatchRequest struct {
+	Action string         `json:"action"`
+	Body   *algoliaRecord `json:"body,omitempty"`
+}
+
+func (a AlgoliaConfig) enabled() bool {
+	return a.AppID != "" && a.APIKey != "" && a.Index != ""
+}
+
+// syncAlgolia replaces the contents of the configured index with the posts
+func syncAlgolia(cfg AlgoliaConfig, posts []BlogPost) error {
+	requests := []algoliaBatchRequest{{Action: "clear"}}
+	for _, record := range algoliaRecords(posts) {
+		record := record
+		requests = append(requests, algoliaBatchRequest{Action: "updateObject", Body: &record})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"requests": requests})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("https://%s.algolia.net/1/indexes/%s/batch", cfg.AppID, cfg.Index)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Algolia-Application-Id", cfg.AppID)
+	req.Header.Set("X-Algolia-API-Key", cfg.APIKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("algolia batch failed: %s: %s", resp.Status, msg)
+	}
+
+	return nil
+}
+
+func algoliaRecords(posts []BlogPost) []algoliaRecord {
+	var records []algoliaRecord
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+
+		for i, chunk := range chunkText(plainText(string(post.Content)), algoliaChunkSize) {
+			records = append(records, algoliaRecord{
+				ObjectID: fmt.Sprintf("%s-%d", post.Slug, i),
+				Title:    post.Title,
+				Category: post.Parent,
+				Headers:  post.Headers,
+				Content:  chunk,
+				URL:      baseURL() + post.URL(),
+			})
+		}
+	}
+	return records
+}
+
+// chunkText splits text on word boundaries into pieces of at most size bytes
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+
+	for _, word := range strings.Fields(text) {
+		if current.Len() > 0 && current.Len()+len(word)+1 > size {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(word)
+	}
+
+	if current.Len() > 0 || len(chunks) == 0 {
+		chunks = append(chunks, current.String())
+	}
+
+	return chunks
+}
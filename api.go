@@ -0,0 +1,271 @@
+package blog
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var slugRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// PostSummary is what the post list api returns for every post
+type PostSummary struct {
+	Slug        string   `json:"slug"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Parent      string   `json:"parent,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Order       int      `json:"order"`
+	Tags        []string `json:"tags,omitempty"`
+	Version     string   `json:"version,omitempty"`
+	// YYYY-MM-DD, for posts with a Date
+	Date    string    `json:"date,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+func summarize(post BlogPost) PostSummary {
+	return PostSummary{
+		Slug:        post.Slug,
+		URL:         post.URL(),
+		Title:       post.Title,
+		Parent:      post.Parent,
+		Description: post.Description,
+		Order:       post.Order,
+		Tags:        post.Tags,
+		Version:     post.Version,
+		Date:        dateString(post.Date),
+		Updated:     post.LastModified,
+	}
+}
+
+func dateString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// the post list's sort options and the fields they sort by
+var postSorts = map[string]string{
+	"order":   "Order",
+	"title":   "Title",
+	"slug":    "Slug",
+	"date":    "Date",
+	"updated": "LastModified",
+}
+
+// how many posts a page of the post list has unless per_page says
+const postsPerPage = 20
+
+// matchesFilters reports whether the post is in one of the categories and
+// has every one of the tags, ignoring case
+func matchesFilters(post BlogPost, categories, tags []string, version string) bool {
+	if len(categories) > 0 {
+		found := false
+		for _, category := range categories {
+			found = found || strings.EqualFold(post.Parent, category)
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, tag := range tags {
+		found := false
+		for _, t := range post.Tags {
+			found = found || strings.EqualFold(t, tag)
+		}
+		if !found {
+			return false
+		}
+	}
+	return version == "" || post.Version == version
+}
+
+// handleAPIIndex is the home page of a headless site, where to find things
+func handleAPIIndex(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"posts":   baseURL() + "/api/posts",
+		"post":    baseURL() + "/api/posts/{slug}",
+		"search":  baseURL() + "/api/search?q={query}",
+		"rss":     baseURL() + "/feed.xml",
+		"atom":    baseURL() + "/atom.xml",
+		"sitemap": baseURL() + "/sitemap.xml",
+		"llms":    baseURL() + "/llms.txt",
+	})
+}
+
+// handleListPosts lists the posts the caller can read, all of them or a
+// page at a time: /api/posts?category=Guides,FAQ&tag=go&sort=-date&page=2
+// &per_page=10. Categories match any of them, tags all of them, and sort
+// takes order, title, slug, date or updated, with a - for descending
+func (s *server) handleListPosts(c *gin.Context) {
+	categories, tags := splitList(c.Query("category")), splitList(c.Query("tag"))
+	version := c.Query("version")
+
+	allowed := s.apiAccess(c)
+	var posts []BlogPost
+	for _, post := range s.allPosts() {
+		if post.Slug != "" && allowed(post) && matchesFilters(post, categories, tags, version) {
+			posts = append(posts, post)
+		}
+	}
+
+	if sortParam := c.Query("sort"); sortParam != "" {
+		name, desc := strings.CutPrefix(sortParam, "-")
+		field, ok := postSorts[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of order, title, slug, date or updated"})
+			return
+		}
+		order := "asc"
+		if desc {
+			order = "desc"
+		}
+		posts, _ = sortBy(posts, field, order)
+	}
+
+	total := len(posts)
+	response := gin.H{"total": total}
+	// without paging parameters it's every post, as it always was
+	if c.Query("page") != "" || c.Query("per_page") != "" {
+		page, _ := strconv.Atoi(c.Query("page"))
+		perPage, _ := strconv.Atoi(c.Query("per_page"))
+		if perPage < 1 {
+			perPage = postsPerPage
+		}
+		perPage = min(perPage, 100)
+		pagination := paginate(total, page, perPage)
+		start := min((pagination.Page-1)*perPage, total)
+		posts = posts[start:min(start+perPage, total)]
+		response["page"], response["per_page"], response["total_pages"] = pagination.Page, perPage, pagination.TotalPages
+	}
+
+	list := []PostSummary{}
+	for _, post := range posts {
+		list = append(list, summarize(post))
+	}
+	response["posts"] = list
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *server) handleGetPost(c *gin.Context) {
+	post, ok := s.post(c.Param("slug"))
+	if !ok || !s.apiAccess(c)(post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"post":    summarize(post),
+		"content": post.Content,
+		"headers": post.Headers,
+	})
+}
+
+// handlePutPost creates or replaces the markdown file behind a slug, the
+// request body is the whole file including its metadata
+func (s *server) handlePutPost(c *gin.Context) {
+	slug := c.Param("slug")
+	if !slugRegexp.MatchString(slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid slug"})
+		return
+	}
+
+	content, err := io.ReadAll(io.LimitReader(c.Request.Body, 10<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	// refuse anything the loader would choke on, or that lands elsewhere
+	parsed, err := parseMarkdownFile(content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	postDefaults(&parsed, slug+".md")
+	if parsed.Slug != slug {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the Slug metadata must match the url"})
+		return
+	}
+
+	status := http.StatusCreated
+	path := filepath.Join(s.contentDir, slug+".md")
+	if existing, ok := s.post(slug); ok {
+		status = http.StatusOK
+		path = filepath.Join(s.contentDir, existing.SourcePath)
+	} else if owner, ok := fileSlug(path); ok && owner != slug {
+		// index.md is "home", a new slug never takes over another post's file
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s.md belongs to %q", slug, owner)})
+		return
+	}
+
+	// the loader would leave a note in their place, an api caller is told
+	if _, _, err := expandCodeIncludes(filepath.Dir(path), content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := writeFileAtomic(path, content); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	if _, err := s.reload(); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	post, _ := s.post(slug)
+	c.JSON(status, gin.H{"post": summarize(post)})
+}
+
+// fileSlug is the slug of the post in the markdown file at path, false when
+// there's no such file
+func fileSlug(path string) (string, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	// a file that doesn't parse belongs to no post we know, but is still
+	// someone's
+	post, err := parseMarkdownFile(content)
+	if err != nil {
+		return "", true
+	}
+	postDefaults(&post, filepath.Base(path))
+	return post.Slug, true
+}
+
+func (s *server) handleDeletePost(c *gin.Context) {
+	post, ok := s.post(c.Param("slug"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	if err := os.Remove(filepath.Join(s.contentDir, post.SourcePath)); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	if _, err := s.reload(); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,284 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many chunks are given to the model as sources unless ask.sources
+// says otherwise
+const defaultAskSources = 5
+
+const askInstructions = `You answer questions about a website using only the numbered sources from it that you're given.
+Cite the sources you use by their number in square brackets, like [1] or [2][3], right after what they support.
+If the sources don't contain the answer, say you couldn't find it in the docs rather than guessing.
+Answer in markdown and keep it short.`
+
+func (a AskConfig) enabled() bool {
+	return a.Provider != ""
+}
+
+// setupAsk connects the model /api/ask answers with, which needs semantic
+// search to find what to answer from
+func (s *server) setupAsk(report *startupReport) {
+	if s.semantic == nil {
+		report.addf(problemError, "ask: search.semantic must be configured to find the sources to answer from")
+		return
+	}
+	chat, err := newChatModel(config().Ask)
+	if err != nil {
+		report.addf(problemError, "ask: %v", err)
+		return
+	}
+	s.chat = chat
+
+	limit := config().Ask.RateLimit
+	if limit < 1 {
+		limit = 20
+	}
+	s.askLimiter = newRateLimiter(limit, time.Hour)
+	if s.redis != nil {
+		s.askLimiter = s.redis.rateLimiter("ask", limit, time.Hour)
+	}
+}
+
+// chatModel answers a prompt, given instructions for how
+type chatModel interface {
+	complete(system, prompt string) (string, error)
+}
+
+func newChatModel(cfg AskConfig) (chatModel, error) {
+	switch cfg.Provider {
+	case "openai":
+		if cfg.URL == "" {
+			cfg.URL = "https://api.openai.com/v1"
+		}
+		if cfg.Model == "" {
+			cfg.Model = "gpt-4o-mini"
+		}
+		return openAIChat(cfg), nil
+	case "anthropic":
+		if cfg.URL == "" {
+			cfg.URL = "https://api.anthropic.com/v1"
+		}
+		if cfg.Model == "" {
+			return nil, errors.New("ask.model is required for anthropic")
+		}
+		return anthropicChat(cfg), nil
+	case "ollama":
+		if cfg.URL == "" {
+			cfg.URL = "http://localhost:11434"
+		}
+		if cfg.Model == "" {
+			cfg.Model = "llama3.1"
+		}
+		return ollamaChat(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown ask provider %q, use openai, anthropic or ollama", cfg.Provider)
+	}
+}
+
+func chatRequest(endpoint string, body interface{}, headers map[string]string, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return jsonRequest(req, out)
+}
+
+// openAIChat uses openai's chat completions api, or anything serving it
+type openAIChat AskConfig
+
+func (m openAIChat) complete(system, prompt string) (string, error) {
+	headers := map[string]string{}
+	if m.APIKey != "" {
+		headers["Authorization"] = "Bearer " + m.APIKey
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err := chatRequest(strings.TrimRight(m.URL, "/")+"/chat/completions", map[string]interface{}{
+		"model": m.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+	}, headers, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("the model returned no answer")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// anthropicChat uses anthropic's messages api
+type anthropicChat AskConfig
+
+func (m anthropicChat) complete(system, prompt string) (string, error) {
+	headers := map[string]string{"x-api-key": m.APIKey, "anthropic-version": "2023-06-01"}
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	err := chatRequest(strings.TrimRight(m.URL, "/")+"/messages", map[string]interface{}{
+		"model":      m.Model,
+		"max_tokens": 1024,
+		"system":     system,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	}, headers, &resp)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			b.WriteString(block.Text)
+		}
+	}
+	return b.String(), nil
+}
+
+// ollamaChat uses a local ollama server
+type ollamaChat AskConfig
+
+func (m ollamaChat) complete(system, prompt string) (string, error) {
+	var resp struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	err := chatRequest(strings.TrimRight(m.URL, "/")+"/api/chat", map[string]interface{}{
+		"model":  m.Model,
+		"stream": false,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+	}, nil, &resp)
+	return resp.Message.Content, err
+}
+
+// AskSource is a page an answer cites, numbered as the answer cites it
+type AskSource struct {
+	N       int    `json:"n"`
+	Title   string `json:"title"`
+	Heading string `json:"heading,omitempty"`
+	URL     string `json:"url"`
+}
+
+var citation = regexp.MustCompile(`\[(\d+)\]`)
+
+// askPrompt numbers the chunks as sources ahead of the question
+func askPrompt(question string, chunks []contentChunk) string {
+	var b strings.Builder
+	b.WriteString("Sources:\n\n")
+	for i, chunk := range chunks {
+		fmt.Fprintf(&b, "[%d] %s", i+1, chunk.Title)
+		if chunk.Heading != "" {
+			b.WriteString(" - " + chunk.Heading)
+		}
+		fmt.Fprintf(&b, " (%s)\n%s\n\n", chunk.URL, chunk.Content)
+	}
+	b.WriteString("Question: " + question)
+	return b.String()
+}
+
+// citedSources are the sources the answer cites, or every source when it
+// cites none of them
+func citedSources(answer string, chunks []contentChunk) []AskSource {
+	cited := make(map[int]bool)
+	for _, m := range citation.FindAllStringSubmatch(answer, -1) {
+		n, _ := strconv.Atoi(m[1])
+		cited[n] = true
+	}
+
+	sources := []AskSource{}
+	for i, chunk := range chunks {
+		if len(cited) > 0 && !cited[i+1] {
+			continue
+		}
+		sources = append(sources, AskSource{N: i + 1, Title: chunk.Title, Heading: chunk.Heading, URL: chunk.URL})
+	}
+	return sources
+}
+
+// handleAsk answers a question about the site from its most relevant
+// chunks, citing the pages it used: POST /api/ask {"question": "..."} or
+// GET /api/ask?q=...
+func (s *server) handleAsk(c *gin.Context) {
+	question := c.Query("q")
+	if c.Request.Method == http.MethodPost {
+		var body struct {
+			Question string `json:"question"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+		question = body.Question
+	}
+	question = strings.TrimSpace(question)
+	if question == "" || len(question) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a question of up to 1000 characters is required"})
+		return
+	}
+	if !s.askLimiter.allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too Many Requests"})
+		return
+	}
+
+	n := config().Ask.Sources
+	if n < 1 {
+		n = defaultAskSources
+	}
+	chunks, _, err := s.semantic.nearest(question, n)
+	if errors.Is(err, errIndexNotReady) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	answer, err := s.chat.complete(askInstructions, askPrompt(question, chunks))
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	answer = strings.TrimSpace(answer)
+
+	c.JSON(http.StatusOK, gin.H{
+		"question": question,
+		"answer":   answer,
+		"sources":  citedSources(answer, chunks),
+	})
+}
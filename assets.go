@@ -0,0 +1,132 @@
+package blog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mainStylesheet = "/static/css/style.css"
+
+// resourceHint is a preload or prefetch, rendered both as a <link> in the
+// page head and as an http Link header
+type resourceHint struct {
+	Href        string
+	Rel         string
+	As          string
+	Type        string
+	CrossOrigin bool
+}
+
+var fontURLRegexp = regexp.MustCompile(`url\(["']?(/static/[^"')]+\.woff2)["']?\)`)
+
+// header formats the hint for a Link header
+func (h resourceHint) header() string {
+	v := fmt.Sprintf("<%s>; rel=%s", h.Href, h.Rel)
+	if h.As != "" {
+		v += "; as=" + h.As
+	}
+	if h.Type != "" {
+		v += fmt.Sprintf("; type=%q", h.Type)
+	}
+	if h.CrossOrigin {
+		v += "; crossorigin"
+	}
+	return v
+}
+
+// preloadHints works out what every page should preload, the configured
+// list or otherwise the main stylesheet and the fonts it uses
+func preloadHints(cfg AssetsConfig) []resourceHint {
+	urls := cfg.Preload
+	if len(urls) == 0 {
+		urls = []string{mainStylesheet}
+		if css, err := os.ReadFile(strings.TrimPrefix(mainStylesheet, "/")); err == nil {
+			for _, m := range fontURLRegexp.FindAllStringSubmatch(string(css), -1) {
+				urls = append(urls, m[1])
+			}
+		}
+	}
+
+	var hints []resourceHint
+	for _, url := range urls {
+		hint := resourceHint{Href: url, Rel: "preload"}
+		switch path.Ext(url) {
+		case ".css":
+			hint.As = "style"
+		case ".js":
+			hint.As = "script"
+		case ".woff2", ".woff", ".ttf":
+			// fonts are always fetched in cors mode, so the preload has to be too
+			hint.As = "font"
+			hint.Type = "font/" + strings.TrimPrefix(path.Ext(url), ".")
+			hint.CrossOrigin = true
+		case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg":
+			hint.As = "image"
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
+
+// neighbours returns the posts before and after slug in sidebar order
+func (s *server) neighbours(slug string) (prev, next *BlogPost) {
+	var pages []BlogPost
+	for _, category := range s.sidebarData().Categories {
+		for _, page := range category.Pages {
+			pages = append(pages, page.BlogPost)
+		}
+	}
+
+	for i := range pages {
+		if pages[i].Slug != slug {
+			continue
+		}
+		if i > 0 {
+			prev = &pages[i-1]
+		}
+		if i < len(pages)-1 {
+			next = &pages[i+1]
+		}
+		break
+	}
+	return prev, next
+}
+
+// resourceHints are the preloads for every page plus a prefetch of the
+// previous and next post, readers are likely to go there next
+func (s *server) resourceHints(preloads []resourceHint, slug string) []resourceHint {
+	if !config().Assets.Hints {
+		return nil
+	}
+
+	hints := append([]resourceHint(nil), preloads...)
+	prev, next := s.neighbours(slug)
+	for _, post := range []*BlogPost{prev, next} {
+		if post != nil {
+			hints = append(hints, resourceHint{Href: post.URL(), Rel: "prefetch", As: "document"})
+		}
+	}
+	return hints
+}
+
+// linkHeaders sends the resource hints for a page as Link headers, so they
+// can be acted on before the html arrives
+func (s *server) linkHeaders(preloads []resourceHint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := c.Request.URL.Path
+		if c.Request.Method != "GET" || strings.HasPrefix(p, "/static/") || strings.HasPrefix(p, "/api/") {
+			c.Next()
+			return
+		}
+
+		for _, hint := range s.resourceHints(preloads, strings.TrimPrefix(p, "/")) {
+			c.Writer.Header().Add("Link", hint.header())
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,158 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many times an ip can try to sign in every 15 minutes
+const loginAttempts = 10
+
+// requireRole only lets through signed in users with at least role, pages
+// send everyone else to the login form while apis get a json error
+func (s *server) requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := s.sessions.get(c)
+		if session != nil && roleAtLeast(session.Role, role) {
+			c.Set("session", session)
+			c.Next()
+			return
+		}
+
+		if session != nil && session.Role != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+
+		if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Redirect(http.StatusFound, "/admin/login?return="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	}
+}
+
+// paths that have to stay reachable on a private site, to sign in or
+// because they check their own secret
+var privateExempt = []string{"/admin/login", "/auth/", "/hooks/"}
+
+// privateSite keeps the whole site behind a login. Signed in users, api
+// tokens and http basic auth against the user accounts all get through
+func (s *server) privateSite() gin.HandlerFunc {
+	// browsers send basic auth with every request, remember good credentials
+	// for a while rather than running bcrypt for every asset. The password
+	// they were checked against is part of the key, so changing it or
+	// deleting the user forgets them
+	var (
+		mu   sync.Mutex
+		seen = make(map[[32]byte]time.Time)
+	)
+	checkBasic := func(ip, username, password string) bool {
+		key := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + s.passwordOf(username)))
+
+		mu.Lock()
+		expires, ok := seen[key]
+		mu.Unlock()
+		if ok && time.Now().Before(expires) {
+			return true
+		}
+
+		if !s.loginLimiter.allow(ip) {
+			return false
+		}
+		if _, ok := login(s.users, username, password); !ok {
+			return false
+		}
+		mu.Lock()
+		seen[key] = time.Now().Add(5 * time.Minute)
+		mu.Unlock()
+		return true
+	}
+
+	return func(c *gin.Context) {
+		for _, prefix := range privateExempt {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		// github sign ins don't count, anyone can have a github account
+		if session := s.sessions.get(c); session != nil && session.Role != "" {
+			c.Next()
+			return
+		}
+
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			if _, ok := s.tokens.verify(strings.TrimPrefix(auth, "Bearer ")); ok {
+				c.Next()
+				return
+			}
+		}
+
+		if username, password, ok := c.Request.BasicAuth(); ok && checkBasic(c.ClientIP(), username, password) {
+			c.Next()
+			return
+		}
+
+		realm := config().Private.Realm
+		if realm == "" {
+			realm = "Private"
+		}
+		c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// passwordOf is what username's password is checked against right now: its
+// hash in the user store and the config's admin password when it's the
+// config's admin
+func (s *server) passwordOf(username string) string {
+	current := ""
+	if user, err := s.users.get(username); err == nil {
+		current = user.PasswordHash
+	}
+	if admin := config().Admin; admin.Password != "" && username == admin.Username {
+		current += "\x00" + admin.Password
+	}
+	return current
+}
+
+// login checks the credentials against the user store, falling back to the
+// admin account from the config so a fresh install can sign in
+func login(users *userStore, username, password string) (User, bool) {
+	if user, ok := users.authenticate(username, password); ok {
+		return user, true
+	}
+
+	admin := config().Admin
+	if admin.Password != "" &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(admin.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(admin.Password)) == 1 {
+		return User{Username: admin.Username, Role: roleAdmin}, true
+	}
+
+	return User{}, false
+}
+
+// userRole is the current role of the user signed in as username, the same
+// users login accepts
+func userRole(users *userStore, username string) (string, bool) {
+	if role, ok := users.role(username); ok {
+		return role, true
+	}
+	if config().Admin.Password != "" && username == config().Admin.Username {
+		return roleAdmin, true
+	}
+	return "", false
+}
@@ -0,0 +1,304 @@
+package blog
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the layout of the archive, restore refuses archives from a newer bloog
+const backupVersion = 1
+
+// backupManifest is the first file in every archive
+type backupManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files"`
+}
+
+// backupFile is a file in the archive and where its content comes from,
+// path on disk unless content is set
+type backupFile struct {
+	name    string
+	path    string
+	content []byte
+}
+
+// backupFiles is the server's runtime state: everything under the data
+// directory, local media uploads and the config, which has the redirects
+func backupFiles() ([]backupFile, error) {
+	var files []backupFile
+	add := func(prefix, dir string) error {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// .tmp files are writes that haven't finished
+			if !d.Type().IsRegular() || strings.HasSuffix(p, ".tmp") {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, backupFile{name: prefix + "/" + filepath.ToSlash(rel), path: p})
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if err := add("data", config().DataDir); err != nil {
+		return nil, err
+	}
+	if config().Media.Storage != "s3" {
+		if err := add("media", mediaDir(config().Media)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(configPath()); err == nil {
+		files = append(files, backupFile{name: "bloog.yaml", path: configPath()})
+	}
+
+	// with redis the view counts in the data directory are only what was
+	// imported at first, the live ones are in redis
+	if config().Redis.URL != "" {
+		r, err := newRedisStore(config().Redis)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := (&redisViews{redis: r}).counts()
+		if err != nil {
+			return nil, err
+		}
+		content, err := json.MarshalIndent(counts, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		views := backupFile{name: "data/views.json", content: content}
+		replaced := false
+		for i, file := range files {
+			if file.name == views.name {
+				files[i], replaced = views, true
+			}
+		}
+		if !replaced {
+			files = append(files, views)
+		}
+	}
+	return files, nil
+}
+
+// writeBackup writes files to a gzipped tar at out, after the manifest
+func writeBackup(out string, files []backupFile) error {
+	manifest := backupManifest{Version: backupVersion, Created: time.Now().UTC()}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, file.name)
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the archive has the users, tokens and config in it
+	f, err := os.OpenFile(out+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out + ".tmp")
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, "manifest.json", content, manifest.Created); err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, modified := file.content, manifest.Created
+		if content == nil {
+			info, err := os.Stat(file.path)
+			if err != nil {
+				return err
+			}
+			if content, err = os.ReadFile(file.path); err != nil {
+				return err
+			}
+			modified = info.ModTime()
+		}
+		if err := writeTarFile(tw, file.name, content, modified); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out+".tmp", out)
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, modified time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  modified,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// backupCommand archives the runtime state: bloog backup [-o file]
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "bloog-backup-"+time.Now().Format("20060102-150405")+".tar.gz", "file to write the archive to")
+	fs.Parse(args)
+
+	files, err := backupFiles()
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(*out, files); err != nil {
+		return fmt.Errorf("%s: %w", *out, err)
+	}
+	fmt.Printf("backed up %d files to %s\n", len(files), *out)
+	return nil
+}
+
+// restoreTarget is where a file from the archive goes, or "" for files
+// that are skipped
+func restoreTarget(name string, withConfig bool) (string, error) {
+	if path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%s: not a path bloog writes", name)
+	}
+	switch {
+	case strings.HasPrefix(name, "data/"):
+		return filepath.Join(config().DataDir, filepath.FromSlash(strings.TrimPrefix(name, "data/"))), nil
+	case strings.HasPrefix(name, "media/"):
+		if config().Media.Storage == "s3" {
+			return "", nil
+		}
+		return filepath.Join(mediaDir(config().Media), filepath.FromSlash(strings.TrimPrefix(name, "media/"))), nil
+	case name == "bloog.yaml":
+		if !withConfig {
+			return "", nil
+		}
+		return configPath(), nil
+	}
+	return "", fmt.Errorf("%s: not a path bloog writes", name)
+}
+
+// restoreBackup writes the files in the archive at in back where they came
+// from. Nothing is written when a file is already there, unless force is set
+func restoreBackup(in string, force, withConfig bool) (restored, skipped int, err error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != "manifest.json" {
+		return 0, 0, fmt.Errorf("not a bloog backup")
+	}
+	var manifest backupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return 0, 0, fmt.Errorf("manifest.json: %w", err)
+	}
+	if manifest.Version > backupVersion {
+		return 0, 0, fmt.Errorf("made by a newer bloog, backup version %d", manifest.Version)
+	}
+
+	targets := make(map[string]string, len(manifest.Files))
+	var existing []string
+	for _, name := range manifest.Files {
+		target, err := restoreTarget(name, withConfig)
+		if err != nil {
+			return 0, 0, err
+		}
+		targets[name] = target
+		if target == "" || force {
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			existing = append(existing, target)
+		}
+	}
+	if len(existing) > 0 {
+		return 0, 0, fmt.Errorf("%s already exist, -force to overwrite them", strings.Join(existing, ", "))
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, skipped, err
+		}
+		target, ok := targets[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return restored, skipped, fmt.Errorf("%s: not in the manifest", header.Name)
+		}
+		if target == "" {
+			skipped++
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return restored, skipped, err
+		}
+		if err := writeFileAtomic(target, content); err != nil {
+			return restored, skipped, err
+		}
+		restored++
+	}
+	return restored, skipped, nil
+}
+
+// restoreCommand puts a backup back: bloog restore [-force] [-config] <file>
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite files that already exist")
+	withConfig := fs.Bool("config", false, "restore bloog.yaml too")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: bloog restore [-force] [-config] <file>")
+	}
+
+	restored, skipped, err := restoreBackup(fs.Arg(0), *force, *withConfig)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	fmt.Printf("restored %d files from %s", restored, fs.Arg(0))
+	if skipped > 0 {
+		fmt.Printf(", skipped %d", skipped)
+	}
+	fmt.Println()
+	return nil
+}
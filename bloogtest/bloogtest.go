@@ -0,0 +1,121 @@
+// Package bloogtest runs a bloog site for tests, so content, templates and
+// themes can be checked against the pages they render:
+//
+//	srv, err := bloogtest.NewServer("markdown")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//	status, body, err := srv.Get("/my-first-blog-post")
+package bloogtest
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+	"github.com/gin-gonic/gin"
+)
+
+// Server is a site served over http for a test
+type Server struct {
+	*httptest.Server
+	dataDir string
+}
+
+// NewServer serves the markdown in contentDir with the default config and a
+// data directory of its own, removed on Close. options change the config
+// before the site loads. Like bloog itself it reads templates and static
+// files from the working directory, and as the config is global only one
+// can run at a time
+func NewServer(contentDir string, options ...func(*blog.Config)) (*Server, error) {
+	// no debug warnings or request logs in the test output
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	dataDir, err := os.MkdirTemp("", "bloogtest")
+	if err != nil {
+		return nil, err
+	}
+
+	ts := httptest.NewUnstartedServer(nil)
+	cfg := blog.DefaultConfig()
+	cfg.BaseURL = "http://" + ts.Listener.Addr().String()
+	cfg.DataDir = dataDir
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	handler, err := blog.NewHandler(contentDir, cfg)
+	if err != nil {
+		ts.Close()
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	ts.Config.Handler = handler
+	ts.Start()
+
+	return &Server{Server: ts, dataDir: dataDir}, nil
+}
+
+// Get fetches a page, returning its status and body
+func (s *Server) Get(path string) (int, string, error) {
+	resp, err := s.Client().Get(s.URL + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// Close stops the server and removes its data
+func (s *Server) Close() {
+	s.Server.Close()
+	os.RemoveAll(s.dataDir)
+}
+
+// go test -update rewrites the golden files from the current output. A
+// test package that defines -update itself shares it
+func init() {
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "rewrite bloogtest golden files")
+	}
+}
+
+func updating() bool {
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	update, _ := getter.Get().(bool)
+	return update
+}
+
+// Golden compares got with the golden file at path, or with -update writes
+// got to it, for checking rendered pages don't change by accident
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if updating() {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s doesn't match, check the change and run go test -update\ngot:\n%s", path, got)
+	}
+}
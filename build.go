@@ -0,0 +1,352 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// buildPage is one page of the static build: the route it's rendered from,
+// the file it's written to and the status the route should answer with
+type buildPage struct {
+	route  string
+	file   string
+	status int
+}
+
+// buildCommand renders every page through the router into a directory that
+// can be served by any static host: build [-out public] [-workers n]
+func buildCommand(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	out := fs.String("out", "public", "directory to write the site to")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of pages rendered in parallel")
+	minify := fs.Bool("minify", config().Minify, "minify html, css and js")
+	host := fs.String("host", config().Build.Host, "write redirect and header files for netlify or vercel")
+	precompress := fs.Bool("precompress", config().Assets.Precompress, "write .gz and .br variants of every file")
+	critical := fs.Bool("critical-css", config().CriticalCSS, "inline critical css and defer the stylesheet")
+	fs.Parse(args)
+
+	cfg := *config()
+	cfg.Minify = *minify
+	cfg.CriticalCSS = *critical
+
+	// a static host can't check logins, the pages would be rendered as 401s
+	if cfg.Private.Enabled {
+		fmt.Fprintln(os.Stderr, "warning: private mode only protects the server, the build will be public")
+		cfg.Private.Enabled = false
+	}
+	setConfig(cfg)
+
+	if config().Headless {
+		return errors.New("a headless site has no pages to build")
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+	start := time.Now()
+
+	stage := time.Now()
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	st, err := s.newSite(collectRenderErrors)
+	if err != nil {
+		return err
+	}
+	pages := buildPages(s)
+	fmt.Printf("load     %d posts in %v\n", len(s.allPosts()), since(stage))
+
+	stage = time.Now()
+	errs := renderPages(st.engine, *out, pages, *workers)
+	fmt.Printf("render   %d pages in %v\n", len(pages), since(stage))
+
+	stage = time.Now()
+	n, err := copyDir("static", filepath.Join(*out, "static"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("copying static files: %w", err))
+	}
+	if route := selfHostRoute(); config().Images.SelfHost && route != "" {
+		copied, err := copyDir(selfHostDir(), filepath.Join(*out, filepath.FromSlash(route)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("copying self hosted images: %w", err))
+		}
+		n += copied
+	}
+	fmt.Printf("static   %d files in %v\n", n, since(stage))
+
+	if err := writeHostFiles(*host, *out); err != nil {
+		errs = append(errs, fmt.Errorf("writing %s files: %w", *host, err))
+	}
+
+	if *precompress {
+		stage = time.Now()
+		n, err := precompressDir(*out)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("compressing: %w", err))
+		}
+		fmt.Printf("compress %d files in %v\n", n, since(stage))
+	}
+
+	if err := recordBuild(*out, len(pages), since(start), errs); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: recording the build: %v\n", err)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		return fmt.Errorf("build failed with %d errors", len(errs))
+	}
+
+	fmt.Printf("built %s in %v\n", *out, since(start))
+	return st.events.publish(Event{Type: EventSiteBuilt, Dir: *out})
+}
+
+// buildRecord is how the last static build went, for the admin dashboard
+type buildRecord struct {
+	Dir      string        `json:"dir"`
+	Finished time.Time     `json:"finished"`
+	Took     time.Duration `json:"took"`
+	Pages    int           `json:"pages"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+func recordBuild(dir string, pages int, took time.Duration, errs []error) error {
+	record := buildRecord{Dir: dir, Finished: time.Now(), Took: took, Pages: pages}
+	for _, err := range errs {
+		record.Errors = append(record.Errors, err.Error())
+	}
+	return saveJSON(filepath.Join(config().DataDir, "build.json"), record)
+}
+
+// buildPages lists the pages of the site, the home page, a page per post, the
+// not found page, the sitemap and the feeds
+func buildPages(s *server) []buildPage {
+	// the redirect's page sends readers on with a meta refresh
+	home := http.StatusOK
+	if config().Home.mode() == homeRedirect {
+		home = http.StatusFound
+	}
+	pages := []buildPage{
+		{route: "/", file: "index.html", status: home},
+		{route: "/404.html", file: "404.html", status: http.StatusNotFound},
+		{route: "/sitemap.xml", file: "sitemap.xml", status: http.StatusOK},
+		{route: "/feed.xml", file: "feed.xml", status: http.StatusOK},
+		{route: "/atom.xml", file: "atom.xml", status: http.StatusOK},
+		{route: "/llms.txt", file: "llms.txt", status: http.StatusOK},
+		{route: "/llms-full.txt", file: "llms-full.txt", status: http.StatusOK},
+		{route: "/llms.jsonl", file: "llms.jsonl", status: http.StatusOK},
+	}
+
+	if n := len(sitemapURLs(s.allPosts())); n > sitemapMaxURLs {
+		for i := 1; i <= sitemapPages(n); i++ {
+			pages = append(pages, buildPage{
+				route:  fmt.Sprintf("/sitemap/%d.xml", i),
+				file:   filepath.Join("sitemap", fmt.Sprintf("%d.xml", i)),
+				status: http.StatusOK,
+			})
+		}
+	}
+
+	pages = append(pages, buildPage{route: "/all", file: filepath.Join("all", "index.html"), status: http.StatusOK})
+	for _, category := range s.sidebarData().Categories {
+		pages = append(pages, buildPage{
+			route:  category.URL(),
+			file:   filepath.Join(filepath.FromSlash(category.URL()[1:]), "index.html"),
+			status: http.StatusOK,
+		}, buildPage{
+			route:  category.URL() + "/all",
+			file:   filepath.Join(filepath.FromSlash(category.URL()[1:]), "all", "index.html"),
+			status: http.StatusOK,
+		})
+	}
+
+	for _, post := range s.allPosts() {
+		if post.Slug == "" {
+			continue
+		}
+		// a static host can't check who's signed in
+		if post.restricted() {
+			fmt.Fprintf(os.Stderr, "warning: skipping %s, it has Access restrictions\n", post.URL())
+			continue
+		}
+		// each post gets its own directory so /slug works without .html
+		url := path.Clean(post.URL())
+		pages = append(pages, buildPage{
+			route:  post.URL(),
+			file:   filepath.Join(filepath.FromSlash(url), "index.html"),
+			status: http.StatusOK,
+		})
+	}
+
+	return pages
+}
+
+// renderPages renders pages across a pool of workers, printing progress as
+// it goes, and returns every page that failed
+func renderPages(r http.Handler, out string, pages []buildPage, workers int) []error {
+	jobs := make(chan buildPage)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		done int64
+	)
+
+	step := len(pages) / 10
+	if step < 1 {
+		step = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range jobs {
+				if err := renderPage(r, out, page); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+
+				n := atomic.AddInt64(&done, 1)
+				if n%int64(step) == 0 || n == int64(len(pages)) {
+					fmt.Printf("         %d/%d pages\n", n, len(pages))
+				}
+			}
+		}()
+	}
+
+	for _, page := range pages {
+		jobs <- page
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}
+
+type renderErrorsKey struct{}
+
+// collectRenderErrors hands the errors gin recorded for a request back to
+// renderPage, a template that fails halfway still answers with a 200
+func collectRenderErrors(c *gin.Context) {
+	c.Next()
+
+	if errs, ok := c.Request.Context().Value(renderErrorsKey{}).(*[]error); ok {
+		for _, err := range c.Errors {
+			*errs = append(*errs, err.Err)
+		}
+	}
+}
+
+// renderPage requests a single page from the router and writes it out
+func renderPage(r http.Handler, out string, page buildPage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%s: %v", page.route, p)
+		}
+	}()
+
+	var errs []error
+	req := httptest.NewRequest(http.MethodGet, page.route, nil)
+	req = req.WithContext(context.WithValue(req.Context(), renderErrorsKey{}, &errs))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %w", page.route, errors.Join(errs...))
+	}
+	if w.Code != page.status {
+		return fmt.Errorf("%s: expected status %d, got %d", page.route, page.status, w.Code)
+	}
+
+	file := filepath.Join(out, page.file)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, w.Body.Bytes(), 0644)
+}
+
+// copyDir copies every asset under src into dst and returns how many it copied
+func copyDir(src, dst string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if err := copyAsset(p, filepath.Join(dst, rel)); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// copyAsset copies a static file, minifying css and js when enabled
+func copyAsset(src, dst string) error {
+	var minifier func(string) string
+	switch filepath.Ext(src) {
+	case ".css":
+		minifier = minifyCSS
+	case ".js":
+		minifier = minifyJS
+	}
+	if !config().Minify || minifier == nil {
+		return copyFile(src, dst)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte(minifier(string(data))), 0644)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Millisecond)
+}
@@ -0,0 +1,194 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the file in a category's folder describing the category
+const categoryIndex = "_index.md"
+
+// categoryMeta is what a category's _index.md says about it
+type categoryMeta struct {
+	Name        string
+	Description string
+	// nil to go by the category's first post
+	Order *int
+	Intro template.HTML
+}
+
+// loadCategories reads markdown/<folder>/_index.md for every folder that
+// isn't a docs version, by the category name its Title gives, the folder's
+// name when there's none
+func loadCategories(dir string) (map[string]categoryMeta, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", categoryIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]bool)
+	for _, v := range config().Versions {
+		versions[v.Name] = true
+	}
+
+	categories := make(map[string]categoryMeta)
+	var errs []error
+	for _, path := range paths {
+		folder := filepath.Base(filepath.Dir(path))
+		if versions[folder] {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		meta, body, err := splitFrontmatter(string(content))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+
+		category := categoryMeta{Name: meta["Title"], Description: meta["Description"]}
+		if category.Name == "" {
+			category.Name = folder
+		}
+		if meta["Order"] != "" {
+			order, err := strconv.Atoi(meta["Order"])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid order %q", path, meta["Order"]))
+				continue
+			}
+			category.Order = &order
+		}
+		if strings.TrimSpace(body) != "" {
+			category.Intro = template.HTML(mdToHTML([]byte(body)))
+		}
+		categories[category.Name] = category
+	}
+	return categories, errors.Join(errs...)
+}
+
+// withCategories adds what the _index.md files say to a sidebar, their
+// order replacing the first post's
+func withCategories(sb SideBar, metas map[string]categoryMeta) SideBar {
+	categories := make([]Category, len(sb.Categories))
+	for i, category := range sb.Categories {
+		if meta, ok := metas[category.Name]; ok {
+			category.Description = meta.Description
+			category.Intro = meta.Intro
+			if meta.Order != nil {
+				category.Order = *meta.Order
+			}
+		}
+		categories[i] = category
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.Name < b.Name
+	})
+	return SideBar{Categories: categories}
+}
+
+// categoriesSignature changes whenever an _index.md is added, removed or
+// written
+func categoriesSignature(dir string) string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*", categoryIndex))
+	return depsSignature(dir, relativeTo(dir, paths))
+}
+
+func relativeTo(dir string, paths []string) []string {
+	rel := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if r, err := filepath.Rel(dir, path); err == nil {
+			rel = append(rel, r)
+		}
+	}
+	return rel
+}
+
+// URL is the category's landing page
+func (c Category) URL() string {
+	return "/categories/" + categorySlug(c.Name)
+}
+
+// categorySlug is name lowercased with spaces as hyphens, keeping letters
+// and numbers in any script so "日本語" gets a page of its own
+func categorySlug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// checkCategories finds categories whose landing page can't be reached:
+// a name with nothing to slug, or a url another category already has, like
+// "C" and "C++"
+func checkCategories(posts []BlogPost) []error {
+	var errs []error
+	seen := make(map[string]bool)
+	byURL := make(map[string]string)
+	for _, post := range posts {
+		name := post.Parent
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if categorySlug(name) == "" {
+			errs = append(errs, fmt.Errorf("%s: category %q has no letters or numbers for its url", postSource(post), name))
+			continue
+		}
+		url := Category{Name: name}.URL()
+		if other, ok := byURL[url]; ok {
+			errs = append(errs, fmt.Errorf("%s: category %q has the url %s already used by category %q", postSource(post), name, url, other))
+			continue
+		}
+		byURL[url] = name
+	}
+	return errs
+}
+
+// handleCategory serves a category's landing page: its description, the
+// intro from its _index.md and its pages in sidebar order
+func (s *server) handleCategory(c *gin.Context) {
+	sidebar := s.sidebar(c, "")
+	for _, category := range sidebar.Categories {
+		if category.URL() != c.Request.URL.Path {
+			continue
+		}
+		category.Pages = append([]SidebarPage(nil), category.Pages...)
+		sort.SliceStable(category.Pages, func(i, j int) bool {
+			return pinnedBefore(category.Pages[i].BlogPost, category.Pages[j].BlogPost)
+		})
+		s.html(c, http.StatusOK, "category.html", gin.H{
+			"Title":                   category.Name,
+			"Category":                category,
+			"SidebarData":             sidebar,
+			"MetaDescription":         category.Description,
+			"MetaPropertyTitle":       category.Name,
+			"MetaPropertyDescription": category.Description,
+			"MetaOgURL":               baseURL() + category.URL(),
+		})
+		return
+	}
+	s.notFound(c)
+}
@@ -0,0 +1,136 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cloudflare accepts at most this many urls per purge request
+const cloudflarePurgeBatch = 30
+
+func (c CDNConfig) enabled() bool {
+	return c.Provider != "" && c.APIToken != ""
+}
+
+// purgeURLs works out which public urls a content change made stale. When
+// the structure changed every page's sidebar did too, so nil means purge
+// everything
+func purgeURLs(changes contentChanges) []string {
+	if changes.Structural {
+		return nil
+	}
+
+	urls := []string{baseURL() + "/"}
+	for _, p := range changes.Paths {
+		urls = append(urls, baseURL()+p)
+	}
+	return urls
+}
+
+// purgeCDN asks the configured cdn to drop urls from its edge caches, or
+// everything when urls is nil
+func purgeCDN(cfg CDNConfig, urls []string) error {
+	switch cfg.Provider {
+	case "cloudflare":
+		return purgeCloudflare(cfg, urls)
+	case "fastly":
+		return purgeFastly(cfg, urls)
+	case "bunny":
+		return purgeBunny(cfg, urls)
+	default:
+		return fmt.Errorf("unknown cdn provider %q", cfg.Provider)
+	}
+}
+
+func purgeCloudflare(cfg CDNConfig, urls []string) error {
+	endpoint := "https://api.cloudflare.com/client/v4/zones/" + cfg.Zone + "/purge_cache"
+	headers := map[string]string{"Authorization": "Bearer " + cfg.APIToken}
+
+	if urls == nil {
+		return cdnRequest(http.MethodPost, endpoint, headers, map[string]interface{}{"purge_everything": true})
+	}
+
+	for start := 0; start < len(urls); start += cloudflarePurgeBatch {
+		end := start + cloudflarePurgeBatch
+		if end > len(urls) {
+			end = len(urls)
+		}
+		if err := cdnRequest(http.MethodPost, endpoint, headers, map[string]interface{}{"files": urls[start:end]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func purgeFastly(cfg CDNConfig, urls []string) error {
+	headers := map[string]string{"Fastly-Key": cfg.APIToken}
+
+	if urls == nil {
+		return cdnRequest(http.MethodPost, "https://api.fastly.com/service/"+cfg.Zone+"/purge_all", headers, nil)
+	}
+
+	// single url purges take the url without its scheme
+	for _, u := range urls {
+		target := strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+		if err := cdnRequest(http.MethodPost, "https://api.fastly.com/purge/"+target, headers, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func purgeBunny(cfg CDNConfig, urls []string) error {
+	headers := map[string]string{"AccessKey": cfg.APIToken}
+
+	if urls == nil {
+		return cdnRequest(http.MethodPost, "https://api.bunny.net/pullzone/"+cfg.Zone+"/purgeCache", headers, nil)
+	}
+
+	for _, u := range urls {
+		if err := cdnRequest(http.MethodPost, "https://api.bunny.net/purge?url="+url.QueryEscape(u), headers, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cdnRequest(method, endpoint string, headers map[string]string, payload interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cdn purge %s failed: %s: %s", endpoint, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package blog
+
+import (
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long the changelog is kept before git is asked again
+const changelogTTL = 5 * time.Minute
+
+// type(scope)!: subject
+var conventionalCommitRegexp = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// ChangelogEntry is one commit on the changelog page
+type ChangelogEntry struct {
+	Hash     string
+	Date     time.Time
+	Author   string
+	Type     string
+	Scope    string
+	Breaking bool
+	Subject  string
+	// the posts the commit touched
+	Posts []BlogPost
+}
+
+// ChangelogDay groups the entries committed on one day
+type ChangelogDay struct {
+	Date    time.Time
+	Entries []ChangelogEntry
+}
+
+type changelogCache struct {
+	mu      sync.Mutex
+	days    []ChangelogDay
+	expires time.Time
+}
+
+// readChangelog lists the commits touching the configured paths, keeping
+// only conventional commits of the configured types when there are any
+func readChangelog(cfg ChangelogConfig, posts []BlogPost, contentDir string) ([]ChangelogDay, error) {
+	limit := cfg.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	// git runs in the content's repository, which needn't be the one the
+	// server was started in. Configured paths are from the top of it
+	paths := []string{"."}
+	if len(cfg.Paths) > 0 {
+		paths = nil
+		for _, p := range cfg.Paths {
+			paths = append(paths, ":(top)"+p)
+		}
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--show-prefix")
+	cmd.Dir = contentDir
+	prefix, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// commits are separated by a \x01 line, followed by the files they touched
+	args := append([]string{"log", "-n", "500", "--format=%x01%H%x00%cI%x00%aN%x00%s", "--name-only", "--"}, paths...)
+	cmd = exec.Command("git", args...)
+	cmd.Dir = contentDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// --name-only lists files from the top of the repository too
+	bySource := make(map[string]BlogPost)
+	for _, post := range publicPosts(posts) {
+		bySource[strings.TrimSpace(string(prefix))+filepath.ToSlash(post.SourcePath)] = post
+	}
+
+	types := make(map[string]bool)
+	for _, t := range cfg.Types {
+		types[t] = true
+	}
+
+	var days []ChangelogDay
+	entries := 0
+	for _, commit := range strings.Split(string(out), "\x01") {
+		lines := strings.Split(strings.TrimSpace(commit), "\n")
+		fields := strings.Split(lines[0], "\x00")
+		if len(fields) != 4 {
+			continue
+		}
+
+		entry := ChangelogEntry{Hash: fields[0], Author: fields[2], Subject: fields[3]}
+		entry.Date, _ = time.Parse(time.RFC3339, fields[1])
+		if m := conventionalCommitRegexp.FindStringSubmatch(fields[3]); m != nil {
+			entry.Type, entry.Scope, entry.Breaking, entry.Subject = strings.ToLower(m[1]), m[2], m[3] == "!", m[4]
+		}
+		if len(types) > 0 && !types[entry.Type] {
+			continue
+		}
+
+		for _, file := range lines[1:] {
+			if post, ok := bySource[strings.TrimSpace(file)]; ok {
+				entry.Posts = append(entry.Posts, post)
+			}
+		}
+
+		y, m, d := entry.Date.Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, entry.Date.Location())
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(day) {
+			days = append(days, ChangelogDay{Date: day})
+		}
+		days[len(days)-1].Entries = append(days[len(days)-1].Entries, entry)
+
+		if entries++; entries >= limit {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+// changelogDays is the cached changelog, shared through redis so instances
+// deployed a few minutes apart don't disagree
+func (s *server) changelogDays() ([]ChangelogDay, error) {
+	s.changelog.mu.Lock()
+	defer s.changelog.mu.Unlock()
+
+	if time.Now().Before(s.changelog.expires) {
+		return s.changelog.days, nil
+	}
+
+	var days []ChangelogDay
+	if s.redis == nil || !s.redis.getJSON("changelog", &days) {
+		var err error
+		if days, err = readChangelog(config().Changelog, s.allPosts(), s.contentDir); err != nil {
+			return nil, err
+		}
+		if s.redis != nil {
+			s.redis.setJSON("changelog", days, changelogTTL)
+		}
+	}
+
+	s.changelog.days = days
+	s.changelog.expires = time.Now().Add(changelogTTL)
+	return days, nil
+}
+
+func (s *server) handleChangelog(c *gin.Context) {
+	days, err := s.changelogDays()
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s.html(c, http.StatusOK, "changelog.html", gin.H{
+		"Title":           "Changelog",
+		"Days":            days,
+		"SidebarData":     s.sidebar(c, ""),
+		"MetaDescription": "What changed recently",
+		"MetaOgURL":       baseURL() + "/changelog",
+	})
+}
@@ -0,0 +1,109 @@
+package blog
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// runCommand runs one of the bloog subcommands instead of the server
+func runCommand(name string, args []string) error {
+	switch name {
+	case "build":
+		return buildCommand(args)
+	case "deploy":
+		return deployCommand(args)
+	case "crosspost":
+		return crosspostCommand(args)
+	case "token":
+		return tokenCommand(args)
+	case "notion":
+		return notionCommand(args)
+	case "import":
+		return importCommand(args)
+	case "export":
+		return exportCommand(args)
+	case "check-links":
+		return checkLinksCommand(args)
+	case "seo":
+		return seoCommand(args)
+	case "stale":
+		return staleCommand(args)
+	case "not-found":
+		return notFoundCommand(args)
+	case "new":
+		return newCommand(args)
+	case "meta":
+		return metaCommand(args)
+	case "stats":
+		return statsCommand(args)
+	case "backup":
+		return backupCommand(args)
+	case "restore":
+		return restoreCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// tokenCommand manages api tokens: token create|list|revoke
+func tokenCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: bloog token create|list|revoke")
+	}
+
+	tokens, err := newTokenStore(filepath.Join(config().DataDir, "tokens.json"))
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ExitOnError)
+		name := fs.String("name", "", "what the token is for")
+		scopes := fs.String("scope", scopePostsWrite, "comma separated scopes: "+strings.Join(tokenScopes, ", "))
+		fs.Parse(args[1:])
+
+		if *name == "" {
+			return fmt.Errorf("-name is required")
+		}
+
+		secret, token, err := tokens.create(*name, splitList(*scopes))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created token %s (%s)\n", token.ID, token.Name)
+		fmt.Printf("secret, shown only once: %s\n", secret)
+
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tLAST USED")
+		for _, token := range tokens.list() {
+			lastUsed := "never"
+			if !token.LastUsed.IsZero() {
+				lastUsed = token.LastUsed.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, strings.Join(token.Scopes, ","),
+				token.CreatedAt.Format("2006-01-02"), lastUsed)
+		}
+		return w.Flush()
+
+	case "revoke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: bloog token revoke <id>")
+		}
+		if err := tokens.revoke(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked token %s\n", args[1])
+
+	default:
+		return fmt.Errorf("unknown token command %q", args[0])
+	}
+
+	return nil
+}
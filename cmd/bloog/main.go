@@ -0,0 +1,9 @@
+// Command bloog serves, builds and manages a bloog site, run from the site's
+// directory
+package main
+
+import blog "github.com/anuragcsangal/blog"
+
+func main() {
+	blog.Main()
+}
@@ -0,0 +1,162 @@
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/gomarkdown/markdown/ast"
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// the colour schemes code blocks and diagrams come in
+const (
+	schemeDark  = "dark"
+	schemeLight = "light"
+	schemeAuto  = "auto"
+)
+
+// the themes mermaid has built in
+var mermaidThemes = map[string]bool{"default": true, "neutral": true, "dark": true, "forest": true, "base": true}
+
+// colorScheme is the scheme pages are in, dark unless configured as the
+// stock stylesheet is
+func colorScheme() string {
+	if config().Render.ColorScheme == "" {
+		return schemeDark
+	}
+	return config().Render.ColorScheme
+}
+
+// pageColorScheme is the data-theme of a page's html tag, empty for auto
+// as the reader's prefers-color-scheme decides
+func pageColorScheme() string {
+	if scheme := colorScheme(); scheme != schemeAuto {
+		return scheme
+	}
+	return ""
+}
+
+// diagramSchemes are the schemes a diagram is drawn in, both when the
+// reader's settings pick between them
+func diagramSchemes() []string {
+	if scheme := colorScheme(); scheme != schemeAuto {
+		return []string{scheme}
+	}
+	return []string{schemeDark, schemeLight}
+}
+
+// mermaidTheme is the mermaid theme diagrams are drawn with in scheme
+func mermaidTheme(scheme string) string {
+	m := config().Render.Mermaid
+	if scheme == schemeLight {
+		if m.Light != "" {
+			return m.Light
+		}
+		return "default"
+	}
+	if m.Dark != "" {
+		return m.Dark
+	}
+	return "dark"
+}
+
+// checkColorSchemes reports a scheme or mermaid theme that doesn't exist
+func checkColorSchemes(cfg RenderConfig) error {
+	switch cfg.ColorScheme {
+	case "", schemeDark, schemeLight, schemeAuto:
+	default:
+		return fmt.Errorf("unknown render.color_scheme %q, expected dark, light or auto", cfg.ColorScheme)
+	}
+	for _, theme := range []string{cfg.Mermaid.Light, cfg.Mermaid.Dark} {
+		if theme != "" && !mermaidThemes[theme] {
+			return fmt.Errorf("unknown mermaid theme %q, expected default, neutral, dark, forest or base", theme)
+		}
+	}
+	return nil
+}
+
+// writeDiagram writes a mermaid diagram once for each scheme, each drawn
+// with that scheme's theme. The stylesheet hides the ones not in use
+func writeDiagram(w io.Writer, source []byte) {
+	io.WriteString(w, "<div class=\"diagram\">\n")
+	for _, scheme := range diagramSchemes() {
+		fmt.Fprintf(w, "<pre class=\"mermaid scheme-%s\">%%%%{init: {\"theme\": \"%s\"}}%%%%\n", scheme, mermaidTheme(scheme))
+		template.HTMLEscape(w, source)
+		io.WriteString(w, "</pre>\n")
+	}
+	io.WriteString(w, "</div>\n")
+}
+
+// mermaid draws ```mermaid code blocks as diagrams
+func mermaid(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok || string(block.Info) != "mermaid" {
+		return ast.GoToNext, false
+	}
+	writeDiagram(w, block.Literal)
+	return ast.GoToNext, true
+}
+
+var kindDiagram = gast.NewNodeKind("Diagram")
+
+// diagramNode is a mermaid diagram's source
+type diagramNode struct {
+	gast.BaseBlock
+	source []byte
+}
+
+func (n *diagramNode) Kind() gast.NodeKind {
+	return kindDiagram
+}
+
+func (n *diagramNode) Dump(source []byte, level int) {
+	gast.DumpHelper(n, source, level, nil, nil)
+}
+
+type diagramRenderer struct{}
+
+func (diagramRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindDiagram, func(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if entering {
+			writeDiagram(w, node.(*diagramNode).source)
+		}
+		return gast.WalkSkipChildren, nil
+	})
+}
+
+// goldmarkMermaid is the mermaid hook for goldmark
+func goldmarkMermaid(doc gast.Node, source []byte) {
+	blocks := goldmarkWalk(doc, func(node gast.Node) bool {
+		block, ok := node.(*gast.FencedCodeBlock)
+		return ok && string(block.Language(source)) == "mermaid"
+	})
+	for _, block := range blocks {
+		var b bytes.Buffer
+		lines := block.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			b.Write(line.Value(source))
+		}
+		block.Parent().ReplaceChild(block.Parent(), block, &diagramNode{source: b.Bytes()})
+	}
+}
+
+// mermaidScript loads mermaid to draw the diagrams, when render.mermaid.script
+// says where from and the mermaid hook is on
+func mermaidScript() template.HTML {
+	src := config().Render.Mermaid.Script
+	if src == "" {
+		return ""
+	}
+	for _, name := range config().Render.Hooks {
+		if name == "mermaid" {
+			return template.HTML(fmt.Sprintf(`<script type="module">import mermaid from "%s"; mermaid.initialize({startOnLoad: true});</script>`,
+				template.JSEscapeString(src)))
+		}
+	}
+	return ""
+}
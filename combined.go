@@ -0,0 +1,146 @@
+package blog
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a page's ids and the links to them, to move them into the page's own
+// part of a combined document
+var (
+	htmlID       = regexp.MustCompile(`\bid="([^"]*)"`)
+	fragmentLink = regexp.MustCompile(`\bhref="#([^"]*)"`)
+	pageLink     = regexp.MustCompile(`\bhref="(/[^"#]*)(?:#([^"]*))?"`)
+)
+
+// combinedSection is a category's pages in a combined document
+type combinedSection struct {
+	Name   string
+	Anchor string
+	Pages  []combinedPage
+}
+
+// combinedPage is one page of a combined document, its ids prefixed with
+// its anchor so they don't clash with the other pages'
+type combinedPage struct {
+	Title   string
+	Anchor  string
+	URL     string
+	Content template.HTML
+	Headers []combinedHeader
+}
+
+type combinedHeader struct {
+	Text   string
+	Anchor string
+}
+
+// combinedAnchor is the id a post's part of a combined document starts at
+func combinedAnchor(post BlogPost) string {
+	return "page-" + strings.ReplaceAll(post.Slug, "/", "-")
+}
+
+// combinedContent is a post's content with its ids and the links to them
+// prefixed, and links to the other pages in the document pointed at their
+// part of it
+func combinedContent(post BlogPost, anchors map[string]string) template.HTML {
+	anchor := combinedAnchor(post)
+	content := htmlID.ReplaceAllString(string(post.Content), `id="`+anchor+`--$1"`)
+	content = fragmentLink.ReplaceAllString(content, `href="#`+anchor+`--$1"`)
+	content = pageLink.ReplaceAllStringFunc(content, func(link string) string {
+		m := pageLink.FindStringSubmatch(link)
+		target, ok := anchors[m[1]]
+		if !ok {
+			return link
+		}
+		if m[2] != "" {
+			return `href="#` + target + `--` + m[2] + `"`
+		}
+		return `href="#` + target + `"`
+	})
+	return template.HTML(content)
+}
+
+// combinedSections are the categories' pages the viewer can read, in the
+// sidebar's order. Protected pages are left out, they only open on their
+// own page
+func combinedSections(categories []Category, session *Session) []combinedSection {
+	anchors := make(map[string]string)
+	for _, category := range categories {
+		for _, page := range category.Pages {
+			anchors[page.URL()] = combinedAnchor(page.BlogPost)
+		}
+	}
+
+	var sections []combinedSection
+	for _, category := range categories {
+		section := combinedSection{Name: category.Name, Anchor: "category-" + categorySlug(category.Name)}
+		for _, page := range category.Pages {
+			post := page.BlogPost
+			if post.Protected || !canAccess(session, post) {
+				continue
+			}
+			anchor := combinedAnchor(post)
+			combined := combinedPage{
+				Title:   post.Title,
+				Anchor:  anchor,
+				URL:     post.URL(),
+				Content: combinedContent(post, anchors),
+			}
+			// from the ids in the content as it's been prefixed, the
+			// only ones sure to be there
+			for _, entry := range createSidebarLinks(combined.Content) {
+				combined.Headers = append(combined.Headers, combinedHeader{Text: entry.Title, Anchor: entry.ID})
+			}
+			section.Pages = append(section.Pages, combined)
+		}
+		if len(section.Pages) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// handleAll serves every category's pages as one document, to print or
+// search through in one go
+func (s *server) handleAll(c *gin.Context) {
+	sidebar := s.sidebar(c, "")
+	s.html(c, http.StatusOK, "all.html", gin.H{
+		"Title":                   "All pages",
+		"Sections":                combinedSections(sidebar.Categories, s.sessions.get(c)),
+		"Site":                    true,
+		"SidebarData":             sidebar,
+		"MetaDescription":         "Every page on one page.",
+		"MetaPropertyTitle":       "All pages",
+		"MetaPropertyDescription": "Every page on one page.",
+		"MetaOgURL":               baseURL() + "/all",
+		"Robots":                  "noindex",
+	})
+}
+
+// handleCategoryAll serves a category's pages as one document
+func (s *server) handleCategoryAll(c *gin.Context) {
+	sidebar := s.sidebar(c, "")
+	for _, category := range sidebar.Categories {
+		if category.URL()+"/all" != c.Request.URL.Path {
+			continue
+		}
+		s.html(c, http.StatusOK, "all.html", gin.H{
+			"Title":                   category.Name,
+			"Category":                category,
+			"Sections":                combinedSections([]Category{category}, s.sessions.get(c)),
+			"SidebarData":             sidebar,
+			"MetaDescription":         category.Description,
+			"MetaPropertyTitle":       category.Name,
+			"MetaPropertyDescription": category.Description,
+			"MetaOgURL":               baseURL() + category.URL() + "/all",
+			"Robots":                  "noindex",
+		})
+		return
+	}
+	s.notFound(c)
+}
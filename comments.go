@@ -0,0 +1,211 @@
+package blog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	commentPending  = "pending"
+	commentApproved = "approved"
+	commentSpam     = "spam"
+)
+
+var errCommentNotFound = errors.New("comment not found")
+
+type Comment struct {
+	ID     string
+	Slug   string
+	Name   string
+	Body   string
+	Status string
+	// set when the commenter signed in with github
+	GitHubLogin string `json:",omitempty"`
+	AvatarURL   string `json:",omitempty"`
+	Maintainer  bool   `json:",omitempty"`
+	CreatedAt   time.Time
+}
+
+// commentStore keeps every comment in a json file, new comments wait in the
+// pending state until a moderator approves them
+type commentStore struct {
+	mu       sync.Mutex
+	path     string
+	Comments []Comment `json:"comments"`
+}
+
+func newCommentStore(path string) (*commentStore, error) {
+	s := &commentStore{path: path}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *commentStore) add(comment Comment) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment.ID = newID()
+	comment.CreatedAt = time.Now().UTC()
+	if comment.Status == "" {
+		comment.Status = commentPending
+	}
+	s.Comments = append(s.Comments, comment)
+
+	return comment, saveJSON(s.path, s)
+}
+
+// approved returns the approved comments on slug, oldest first
+func (s *commentStore) approved(slug string) []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var comments []Comment
+	for _, comment := range s.Comments {
+		if comment.Slug == slug && comment.Status == commentApproved {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+// withStatus returns every comment in status, newest first
+func (s *commentStore) withStatus(status string) []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var comments []Comment
+	for _, comment := range s.Comments {
+		if comment.Status == status {
+			comments = append(comments, comment)
+		}
+	}
+
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.After(comments[j].CreatedAt)
+	})
+	return comments
+}
+
+func (s *commentStore) setStatus(id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Comments {
+		if s.Comments[i].ID == id {
+			s.Comments[i].Status = status
+			return saveJSON(s.path, s)
+		}
+	}
+	return errCommentNotFound
+}
+
+func (s *commentStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Comments {
+		if s.Comments[i].ID == id {
+			s.Comments = append(s.Comments[:i], s.Comments[i+1:]...)
+			return saveJSON(s.path, s)
+		}
+	}
+	return errCommentNotFound
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *server) handleGetComments(c *gin.Context) {
+	slug := c.Param("slug")
+	if _, ok := s.readablePost(c, slug); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	list := s.comments.approved(slug)
+	if list == nil {
+		list = []Comment{}
+	}
+	c.JSON(http.StatusOK, gin.H{"slug": slug, "comments": list})
+}
+
+// handlePostComment queues a comment for moderation, unless it comes from a
+// maintainer signed in with github
+func (s *server) handlePostComment(c *gin.Context) {
+	slug := c.Param("slug")
+	post, ok := s.readablePost(c, slug)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	var body struct {
+		Name string `json:"name" form:"name"`
+		Body string `json:"body" form:"body"`
+	}
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Body = strings.TrimSpace(body.Body)
+
+	comment := Comment{Slug: slug, Name: body.Name, Body: body.Body}
+	if viewer := s.sessions.get(c); viewer != nil {
+		comment.Name = viewer.Name
+		comment.GitHubLogin = viewer.GitHubLogin
+		comment.AvatarURL = viewer.AvatarURL
+		// maintainers don't need their own comments moderated
+		if config().GitHub.isMaintainer(viewer.GitHubLogin) {
+			comment.Maintainer = true
+			comment.Status = commentApproved
+		}
+	}
+
+	if comment.Name == "" || comment.Body == "" || len(comment.Name) > 100 || len(comment.Body) > 5000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and body are required"})
+		return
+	}
+
+	if !s.commentLimiter.allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too Many Requests"})
+		return
+	}
+
+	comment, err := s.comments.add(comment)
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	if config().SMTP.enabled() && comment.Status == commentPending {
+		go func() {
+			subject := fmt.Sprintf("New comment on %q awaiting moderation", post.Title)
+			msg := fmt.Sprintf("%s wrote:\n\n%s\n\nModerate at %s/admin/comments\n", comment.Name, comment.Body, baseURL())
+			if err := sendMail(config().SMTP, subject, msg); err != nil {
+				log.Printf("Error sending comment notification: %v\n", err)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": comment.Status})
+}
@@ -0,0 +1,216 @@
+package blog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// file types worth compressing, images and woff2 fonts already are
+var compressibleExts = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".json": true, ".xml": true,
+	".svg": true, ".txt": true, ".map": true, ".ttf": true, ".otf": true, ".eot": true,
+}
+
+// smaller files don't gain enough to be worth a second request header
+const minCompressSize = 1024
+
+// compressedFile is a static file along with its gzip and brotli variants
+type compressedFile struct {
+	modTime time.Time
+	gz      []byte
+	br      []byte
+}
+
+// staticFiles serves a directory of assets, using the variants compressed
+// at startup whenever the client accepts them
+type staticFiles struct {
+	dir   string
+	files map[string]compressedFile
+	next  http.Handler
+}
+
+func compressible(name string, size int64) bool {
+	return compressibleExts[strings.ToLower(filepath.Ext(name))] && size >= minCompressSize
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func brotliBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newStaticFiles compresses every compressible file under dir up front
+func newStaticFiles(dir string) (*staticFiles, error) {
+	sf := &staticFiles{
+		dir:   dir,
+		files: make(map[string]compressedFile),
+		next:  http.FileServer(filesOnly{http.Dir(dir)}),
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !compressible(p, info.Size()) {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		file := compressedFile{modTime: info.ModTime()}
+		if file.gz, err = gzipBytes(data); err != nil {
+			return err
+		}
+		if file.br, err = brotliBytes(data); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sf.files["/"+filepath.ToSlash(rel)] = file
+		return nil
+	})
+
+	return sf, err
+}
+
+// filesOnly refuses to open directories, so they're never listed
+type filesOnly struct {
+	http.FileSystem
+}
+
+func (fs filesOnly) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
+
+// handler serves the files below prefix
+func (sf *staticFiles) handler(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := path.Clean("/" + strings.TrimPrefix(c.Request.URL.Path, prefix))
+		c.Header("Vary", "Accept-Encoding")
+
+		if file, ok := sf.files[name]; ok {
+			accept := c.GetHeader("Accept-Encoding")
+			var data []byte
+			switch {
+			case acceptsEncoding(accept, "br"):
+				c.Header("Content-Encoding", "br")
+				data = file.br
+			case acceptsEncoding(accept, "gzip"):
+				c.Header("Content-Encoding", "gzip")
+				data = file.gz
+			}
+
+			if data != nil {
+				if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+					c.Header("Content-Type", ctype)
+				}
+				http.ServeContent(c.Writer, c.Request, name, file.modTime, bytes.NewReader(data))
+				return
+			}
+		}
+
+		c.Request.URL.Path = name
+		sf.next.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), enc) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// precompressDir writes .gz and .br files next to every compressible file
+// under dir, for static hosts that serve them directly
+func precompressDir(dir string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !compressible(p, info.Size()) {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for ext, compress := range map[string]func([]byte) ([]byte, error){".gz": gzipBytes, ".br": brotliBytes} {
+			out, err := compress(data)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(p+ext, out, 0644); err != nil {
+				return err
+			}
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
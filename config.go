@@ -0,0 +1,605 @@
+package blog
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	BaseURL string `yaml:"base_url"`
+	DataDir string `yaml:"data_dir"`
+	Watch   bool   `yaml:"watch"`
+	Minify  bool   `yaml:"minify"`
+	// addresses of the reverse proxies in front of the server, whose
+	// X-Forwarded-For is believed
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// inline the css needed for the top of the page and load the rest later
+	CriticalCSS bool            `yaml:"critical_css"`
+	Algolia     AlgoliaConfig   `yaml:"algolia"`
+	Search      SearchConfig    `yaml:"search"`
+	Admin       AdminConfig     `yaml:"admin"`
+	Private     PrivateConfig   `yaml:"private"`
+	SMTP        SMTPConfig      `yaml:"smtp"`
+	GitHub      GitHubConfig    `yaml:"github"`
+	Hooks       HooksConfig     `yaml:"hooks"`
+	Assets      AssetsConfig    `yaml:"assets"`
+	CDN         CDNConfig       `yaml:"cdn"`
+	Redirects   []RedirectRule  `yaml:"redirects"`
+	Cache       []CacheRule     `yaml:"cache"`
+	Headers     []HeaderRule    `yaml:"headers"`
+	Build       BuildConfig     `yaml:"build"`
+	Deploy      DeployConfig    `yaml:"deploy"`
+	WebSub      WebSubConfig    `yaml:"websub"`
+	Repo        RepoConfig      `yaml:"repo"`
+	Changelog   ChangelogConfig `yaml:"changelog"`
+	// docs versions, each loaded from its own subdirectory of the content
+	Versions  []VersionConfig `yaml:"versions"`
+	Social    SocialConfig    `yaml:"social"`
+	CrossPost CrossPostConfig `yaml:"crosspost"`
+	Media     MediaConfig     `yaml:"media"`
+	Images    ImagesConfig    `yaml:"images"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Debug     DebugConfig     `yaml:"debug"`
+	Render    RenderConfig    `yaml:"render"`
+	Plugins   PluginsConfig   `yaml:"plugins"`
+	Webhooks  []WebhookConfig `yaml:"webhooks"`
+	Notion    NotionConfig    `yaml:"notion"`
+	Ask       AskConfig       `yaml:"ask"`
+	Links     LinksConfig     `yaml:"links"`
+	Publisher PublisherConfig `yaml:"publisher"`
+	Security  SecurityConfig  `yaml:"security"`
+	Humans    HumansConfig    `yaml:"humans"`
+	Stale     StaleConfig     `yaml:"stale"`
+	NotFound  NotFoundConfig  `yaml:"not_found"`
+	Analytics AnalyticsConfig `yaml:"analytics"`
+	// what / serves
+	Home HomeConfig `yaml:"home"`
+	// what happens to posts past their ExpiryDate, unpublish (the default)
+	// or archive: keep serving them with a banner, out of search engines
+	Expired string `yaml:"expired"`
+	// posts that look like copies of each other
+	Duplicates DuplicatesConfig `yaml:"duplicates"`
+	// added to every page's template data, what handlers set wins
+	TemplateData map[string]interface{} `yaml:"template_data"`
+	GeoIP        GeoIPConfig            `yaml:"geoip"`
+	// jobs run on a timetable
+	Schedule []ScheduleConfig `yaml:"schedule"`
+	// which of the environments this is, BLOOG_ENV overrides it
+	Environment  string                       `yaml:"environment"`
+	Environments map[string]EnvironmentConfig `yaml:"environments"`
+	// "date" serves dated posts at /YYYY/MM/slug, otherwise /slug
+	Permalinks string `yaml:"permalinks"`
+	// start with broken posts, plugins and backends left out rather than
+	// not at all
+	Degraded bool `yaml:"degraded"`
+	// show template and content errors in the browser, for working on the
+	// site locally. Implies degraded
+	Dev bool `yaml:"dev"`
+	// serve only the json api and feeds, no pages or admin area
+	Headless bool `yaml:"headless"`
+}
+
+// EnvironmentConfig is what differs between deploys of the same site, a
+// staging one showing drafts that search engines mustn't find say
+type EnvironmentConfig struct {
+	// replaces base_url
+	BaseURL string `yaml:"base_url"`
+	// serve posts marked Draft: true
+	Drafts bool `yaml:"drafts"`
+	// ask search engines to leave every page out
+	NoIndex bool `yaml:"noindex"`
+	// include the analytics snippet, on unless false
+	Analytics *bool `yaml:"analytics"`
+	// added to every page, over the site's template_data
+	TemplateData map[string]interface{} `yaml:"template_data"`
+}
+
+// HomeConfig is what the home page is
+type HomeConfig struct {
+	// page shows index.md (the default), list the newest posts and
+	// redirect sends readers to slug
+	Mode string `yaml:"mode"`
+	// the post page mode shows instead of index.md, or redirect goes to
+	Slug string `yaml:"slug"`
+	// posts on each page of the list, 10 by default
+	PerPage int `yaml:"per_page"`
+}
+
+// DuplicatesConfig is how posts that look like copies are found and
+// handled, they're always reported
+type DuplicatesConfig struct {
+	// how much of two bodies must be the same, 0 to 1, 0.9 by default
+	Similarity float64 `yaml:"similarity"`
+	// point a copy's canonical link at the original
+	Canonicalize bool `yaml:"canonicalize"`
+}
+
+// AnalyticsConfig is the analytics snippet added to every page
+type AnalyticsConfig struct {
+	// the site's domain as plausible knows it
+	Plausible string `yaml:"plausible"`
+	// a google analytics measurement id, G-XXXXXXX
+	Google string `yaml:"google"`
+	// any other snippet, added to the head as it is
+	HTML string `yaml:"html"`
+}
+
+// RepoConfig is where the site's source lives, for edit links
+type RepoConfig struct {
+	// e.g. https://github.com/anuragcsangal/bloog
+	URL    string `yaml:"url"`
+	Branch string `yaml:"branch"`
+	// the markdown directory within the repository
+	ContentDir string `yaml:"content_dir"`
+}
+
+// ChangelogConfig controls the /changelog page built from git history
+type ChangelogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// paths whose commits are listed, from the top of the content's
+	// repository, defaults to the content directory
+	Paths []string `yaml:"paths"`
+	// conventional commit types to list (feat, fix, docs...), empty for all
+	Types []string `yaml:"types"`
+	Limit int      `yaml:"limit"`
+}
+
+type VersionConfig struct {
+	// the subdirectory, also the url prefix
+	Name string `yaml:"name"`
+	// where /latest points
+	Latest bool `yaml:"latest"`
+}
+
+// SocialConfig is where new posts are announced
+type SocialConfig struct {
+	// text/template with .Title, .URL, .Description, .Tags and .Hashtags
+	Message  string         `yaml:"message"`
+	Mastodon MastodonConfig `yaml:"mastodon"`
+	Bluesky  BlueskyConfig  `yaml:"bluesky"`
+	X        XConfig        `yaml:"x"`
+}
+
+type MastodonConfig struct {
+	// the instance, e.g. https://mastodon.social
+	Server string `yaml:"server"`
+	// an access token with the write:statuses scope
+	Token string `yaml:"token"`
+}
+
+type BlueskyConfig struct {
+	// the pds, defaults to https://bsky.social
+	Server      string `yaml:"server"`
+	Handle      string `yaml:"handle"`
+	AppPassword string `yaml:"app_password"`
+}
+
+type XConfig struct {
+	// the app's consumer keys and the account's access token, for oauth 1.0a
+	APIKey       string `yaml:"api_key"`
+	APISecret    string `yaml:"api_secret"`
+	AccessToken  string `yaml:"access_token"`
+	AccessSecret string `yaml:"access_secret"`
+}
+
+// CrossPostConfig holds the credentials bloog crosspost pushes posts with
+type CrossPostConfig struct {
+	DevTo    DevToConfig    `yaml:"devto"`
+	Hashnode HashnodeConfig `yaml:"hashnode"`
+}
+
+type DevToConfig struct {
+	APIKey string `yaml:"api_key"`
+}
+
+type HashnodeConfig struct {
+	// a personal access token from the developer settings
+	Token         string `yaml:"token"`
+	PublicationID string `yaml:"publication_id"`
+}
+
+// MediaConfig is where images uploaded in the admin area are stored
+type MediaConfig struct {
+	// local (the default) or s3
+	Storage string `yaml:"storage"`
+	// for local storage, defaults to static/media
+	Dir string `yaml:"dir"`
+	// for s3 storage, the bucket's public url or a cdn in front of it
+	URL string   `yaml:"url"`
+	S3  S3Config `yaml:"s3"`
+}
+
+// ScheduleConfig runs Job every so often, or at the times of a cron
+// expression
+type ScheduleConfig struct {
+	// pull, reload or purge-sessions, or one added with RegisterJob
+	Job   string        `yaml:"job"`
+	Every time.Duration `yaml:"every"`
+	// minute hour day month weekday, "0 3 * * *" for 3am every day
+	Cron string `yaml:"cron"`
+}
+
+// GeoIPConfig looks up where readers are, for templates and /api/geo
+type GeoIPConfig struct {
+	// path of a MaxMind DB, GeoLite2 or GeoIP2, country or city
+	Database string `yaml:"database"`
+}
+
+// RedisConfig shares sessions, rate limits, view counts and rendered content
+// between instances, url like redis://:password@host:6379/0
+type RedisConfig struct {
+	URL string `yaml:"url"`
+	// put in front of every key, bloog: by default
+	Prefix string `yaml:"prefix"`
+}
+
+// RenderConfig picks the markdown engine and turns on render hooks by
+// name, in order
+type RenderConfig struct {
+	// gomarkdown, the default, or goldmark
+	Engine string   `yaml:"engine"`
+	Hooks  []string `yaml:"hooks"`
+	// added to every table by the table-class hook
+	TableClass string `yaml:"table_class"`
+	// classes of the div the table-wrap hook puts tables in, table-wrap
+	// unless set
+	TableWrap string `yaml:"table_wrap"`
+	// dark, the default as the stock stylesheet is, light, or auto to
+	// follow the reader's prefers-color-scheme
+	ColorScheme string        `yaml:"color_scheme"`
+	Mermaid     MermaidConfig `yaml:"mermaid"`
+}
+
+// MermaidConfig is how the mermaid hook's diagrams are drawn
+type MermaidConfig struct {
+	// mermaid themes for each colour scheme, default and dark unless set
+	Light string `yaml:"light"`
+	Dark  string `yaml:"dark"`
+	// url of mermaid's esm module, loaded on every page when set
+	Script string `yaml:"script"`
+}
+
+// PluginsConfig loads every lua script in Dir as a plugin
+type PluginsConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// WebhookConfig posts content events to URL as json, all of them unless
+// Events lists the types wanted
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events"`
+	// signs the body like github does, in X-Hub-Signature-256
+	Secret string `yaml:"secret"`
+}
+
+// DebugConfig serves pprof and runtime stats under /debug
+type DebugConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// networks allowed without signing in. When empty, localhost, unless
+	// there's a proxy in front that makes every request come from it
+	Allow []string `yaml:"allow"`
+	// sent as a bearer token, for profiling without a browser session
+	Token string `yaml:"token"`
+}
+
+// ImagesConfig rewrites image urls in posts through an image cdn
+type ImagesConfig struct {
+	// imgproxy, cloudinary or template
+	CDN string `yaml:"cdn"`
+	// imgproxy's url, or the cloudinary cloud name
+	URL string `yaml:"url"`
+	// hex encoded imgproxy signing key and salt, unsigned urls without
+	Key  string `yaml:"key"`
+	Salt string `yaml:"salt"`
+	// for the template cdn, e.g. https://cdn.example.com/{{.Width}}/{{.URL}}
+	Template string `yaml:"template"`
+	// the preset used when an image doesn't name one
+	Preset  string                 `yaml:"preset"`
+	Presets map[string]ImagePreset `yaml:"presets"`
+	// add a srcset of every preset with a width
+	Srcset bool `yaml:"srcset"`
+	// download images from other sites into static/remote and use the
+	// copies
+	SelfHost bool `yaml:"self_host"`
+	// where the copies go, and their url when the directory isn't under
+	// static/
+	SelfHostDir string `yaml:"self_host_dir"`
+	SelfHostURL string `yaml:"self_host_url"`
+}
+
+type ImagePreset struct {
+	Width   int `yaml:"width"`
+	Height  int `yaml:"height"`
+	Quality int `yaml:"quality"`
+}
+
+type WebSubConfig struct {
+	// advertised in the feeds and notified when posts are published
+	Hub string `yaml:"hub"`
+}
+
+type DeployConfig struct {
+	// s3 or gh-pages
+	Target  string        `yaml:"target"`
+	S3      S3Config      `yaml:"s3"`
+	GHPages GHPagesConfig `yaml:"gh_pages"`
+}
+
+type S3Config struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+	// for s3 compatible stores like r2 or minio
+	Endpoint        string `yaml:"endpoint"`
+	Prefix          string `yaml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+type GHPagesConfig struct {
+	// defaults to the origin remote of the current repository
+	Remote string `yaml:"remote"`
+	Branch string `yaml:"branch"`
+}
+
+// RedirectRule sends From to To, From can end in * and To can use :splat
+// for whatever it matched
+type RedirectRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// defaults to 301
+	Status int `yaml:"status"`
+}
+
+// CacheRule sets the Cache-Control header for paths matching Path
+type CacheRule struct {
+	Path    string `yaml:"path"`
+	Control string `yaml:"control"`
+}
+
+// HeaderRule adds Values as response headers on paths matching Path
+type HeaderRule struct {
+	Path   string            `yaml:"path"`
+	Values map[string]string `yaml:"values"`
+}
+
+type BuildConfig struct {
+	// netlify or vercel, writes that host's redirect and header files
+	Host string `yaml:"host"`
+}
+
+type CDNConfig struct {
+	// cloudflare, fastly or bunny
+	Provider string `yaml:"provider"`
+	// the cloudflare zone id, fastly service id or bunny pull zone id
+	Zone     string `yaml:"zone"`
+	APIToken string `yaml:"api_token"`
+}
+
+type AssetsConfig struct {
+	// emit preload and prefetch hints in every page
+	Hints bool `yaml:"hints"`
+	// what to preload, defaults to the stylesheet and its fonts
+	Preload []string `yaml:"preload"`
+	// send the hints as Link headers too
+	LinkHeaders bool `yaml:"link_headers"`
+	// gzip and brotli static files once instead of on every request
+	Precompress bool `yaml:"precompress"`
+}
+
+type HooksConfig struct {
+	Secret string `yaml:"secret"`
+	// run git pull in the content directory before rebuilding
+	Pull bool `yaml:"pull"`
+}
+
+type GitHubConfig struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Maintainers  []string `yaml:"maintainers"`
+}
+
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// PrivateConfig puts the whole site behind a login, for internal docs
+type PrivateConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Realm   string `yaml:"realm"`
+}
+
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+type SearchConfig struct {
+	Backend       string              `yaml:"backend"`
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	Semantic      SemanticConfig      `yaml:"semantic"`
+}
+
+// SemanticConfig is the embedding provider behind /api/semantic-search
+type SemanticConfig struct {
+	// openai, for its api or any server speaking it, or ollama
+	Provider string `yaml:"provider"`
+	// the api's base url, for a local server or proxy
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+	Model  string `yaml:"model"`
+	// searches per visitor per hour, each is a call to the provider
+	RateLimit int `yaml:"rate_limit"`
+}
+
+type ElasticsearchConfig struct {
+	URL      string `yaml:"url"`
+	Index    string `yaml:"index"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// LinksConfig is for bloog check-links
+type LinksConfig struct {
+	// external urls never checked, prefixes where * matches anything, for
+	// sites that turn away bots
+	Ignore []string `yaml:"ignore"`
+}
+
+// PublisherConfig is who the site belongs to, for the structured data on
+// every page
+type PublisherConfig struct {
+	// "organization" or "person"
+	Type string `yaml:"type"`
+	Name string `yaml:"name"`
+	// defaults to base_url
+	URL  string `yaml:"url"`
+	Logo string `yaml:"logo"`
+	// profiles elsewhere, github, mastodon, linkedin...
+	SameAs []string `yaml:"same_as"`
+}
+
+// SecurityConfig is served as /.well-known/security.txt
+type SecurityConfig struct {
+	// mailto:, https:// or tel: uris to report vulnerabilities to
+	Contact []string `yaml:"contact"`
+	// when the file stops being valid, a year from now when unset
+	Expires time.Time `yaml:"expires"`
+	// urls of the disclosure policy, a pgp key and a hall of fame
+	Policy             string   `yaml:"policy"`
+	Encryption         string   `yaml:"encryption"`
+	Acknowledgments    string   `yaml:"acknowledgments"`
+	PreferredLanguages []string `yaml:"preferred_languages"`
+	Hiring             string   `yaml:"hiring"`
+}
+
+// HumansConfig is served as /humans.txt
+type HumansConfig struct {
+	Team []HumanConfig `yaml:"team"`
+	// people and projects to thank
+	Thanks []string `yaml:"thanks"`
+}
+
+// NotFoundConfig is what the not found page suggests instead
+type NotFoundConfig struct {
+	// pages with urls a typo away, 3 unless set, -1 for none
+	Suggestions int `yaml:"suggestions"`
+	// the most viewed pages, 5 unless set, -1 for none
+	Popular int `yaml:"popular"`
+}
+
+// StaleConfig says when pages that haven't changed may be outdated
+type StaleConfig struct {
+	// months without a change, 0 for never
+	After int `yaml:"after"`
+	// months for sidebar sections that go out of date faster or slower
+	Sections map[string]int `yaml:"sections"`
+}
+
+type HumanConfig struct {
+	Name string `yaml:"name"`
+	// e.g. Author, Editor, Design, defaults to Author
+	Role     string `yaml:"role"`
+	Contact  string `yaml:"contact"`
+	Location string `yaml:"location"`
+}
+
+// AskConfig is the language model behind /api/ask, answering from the
+// chunks semantic search finds
+type AskConfig struct {
+	// openai, for its api or any server speaking it, anthropic or ollama
+	Provider string `yaml:"provider"`
+	URL      string `yaml:"url"`
+	APIKey   string `yaml:"api_key"`
+	Model    string `yaml:"model"`
+	// how many chunks the model answers from, 5 unless set
+	Sources int `yaml:"sources"`
+	// questions per visitor per hour, 20 unless set
+	RateLimit int `yaml:"rate_limit"`
+}
+
+// NotionConfig syncs the pages of a notion database into the content
+type NotionConfig struct {
+	// an internal integration's secret, with the database shared with it
+	Token    string `yaml:"token"`
+	Database string `yaml:"database"`
+	// how often to sync, e.g. 15m, otherwise only on start and the webhook
+	Interval time.Duration `yaml:"interval"`
+	// the token notion sent when the webhook was set up, which it signs
+	// with. Other callers of the webhook use the hooks secret
+	VerificationToken string `yaml:"verification_token"`
+	// notion property names to metadata keys, for the ones not named the
+	// same, e.g. "Published on": Date
+	Properties map[string]string `yaml:"properties"`
+}
+
+type AlgoliaConfig struct {
+	AppID  string `yaml:"app_id"`
+	APIKey string `yaml:"api_key"`
+	Index  string `yaml:"index"`
+}
+
+// currentConfig is the config in use. A reload swaps in a new one rather
+// than changing it, so it can be read from anywhere without a lock
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	setConfig(DefaultConfig())
+}
+
+// config is the config in use, never to be changed through
+func config() *Config {
+	return currentConfig.Load()
+}
+
+func setConfig(cfg Config) {
+	currentConfig.Store(&cfg)
+}
+
+// baseURL is where the site is served, the start of every absolute link
+func baseURL() string {
+	return config().BaseURL
+}
+
+// loadConfig reads the yaml config at path, a missing file just means defaults
+func loadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	// allow secrets to be kept in the environment rather than the file
+	content = []byte(os.ExpandEnv(string(content)))
+
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return cfg, err
+	}
+
+	// a broken post shows its error rather than stopping the server
+	if cfg.Dev {
+		cfg.Degraded = true
+	}
+	return cfg, applyEnvironment(&cfg)
+}
+
+func configPath() string {
+	if path := os.Getenv("BLOOG_CONFIG"); path != "" {
+		return path
+	}
+	return "bloog.yaml"
+}
@@ -0,0 +1,199 @@
+package blog
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// site is what's built from a config: the router and what its pages and
+// the event subscribers go by. A reload builds a new one and swaps it in
+// whole, nothing in one changes once it's serving but the templates
+type site struct {
+	engine    *gin.Engine
+	events    *eventBus
+	templates atomic.Pointer[pageTemplates]
+	// inlined into every page when critical_css is on
+	criticalCSS template.CSS
+	preloads    []resourceHint
+}
+
+// Instance renders with the site's templates, as they are when the
+// request gets to them
+func (st *site) Instance(name string, data any) render.Render {
+	return st.templates.Load().Instance(name, data)
+}
+
+// liveRouter serves through the current site, swapped when the config is
+// reloaded. Requests in flight finish on the site they started on
+type liveRouter struct {
+	current atomic.Pointer[site]
+}
+
+func (l *liveRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.current.Load().engine.ServeHTTP(w, r)
+}
+
+// current is the site being served
+func (s *server) current() *site {
+	return s.live.current.Load()
+}
+
+// newSite builds a site from the config, its router using middleware
+// before anything else
+func (s *server) newSite(middleware ...gin.HandlerFunc) (*site, error) {
+	st := &site{events: newEventBus()}
+	s.subscribeBuiltins(st.events)
+	s.subscribePlugins(st.events)
+
+	r := gin.New()
+	// X-Forwarded-For is only believed from trusted_proxies, none by default,
+	// so it can't fake the address rate limits and GeoIP go by
+	if err := r.SetTrustedProxies(config().TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+	r.Use(middleware...)
+	if err := s.routes(r, st); err != nil {
+		return nil, err
+	}
+	st.engine = r
+	return st, nil
+}
+
+// serverSite is a site for serving, logging every request
+func (s *server) serverSite() (*site, error) {
+	return s.newSite(gin.LoggerWithFormatter(logRequest), gin.Recovery())
+}
+
+// listenAddr is where the server listens, on $PORT like gin's Run
+func listenAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// reloadOnHangup re-reads the config every time the process gets a SIGHUP
+func (s *server) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := loadConfig(configPath())
+		if err != nil {
+			log.Printf("Error reloading config, keeping the old one: %v\n", err)
+			continue
+		}
+		if err := s.applyConfig(cfg); err != nil {
+			log.Printf("Error reloading config, keeping the old one: %v\n", err)
+		}
+	}
+}
+
+// restartNeeded lists the settings that changed but are only read at
+// startup, like the stores opened by newServer
+func restartNeeded(old, cfg Config) []string {
+	var keys []string
+	if old.DataDir != cfg.DataDir {
+		keys = append(keys, "data_dir")
+	}
+	if old.Watch != cfg.Watch {
+		keys = append(keys, "watch")
+	}
+	if old.Search.Backend != cfg.Search.Backend || !reflect.DeepEqual(old.Search.Elasticsearch, cfg.Search.Elasticsearch) {
+		keys = append(keys, "search")
+	}
+	if !reflect.DeepEqual(old.Search.Semantic, cfg.Search.Semantic) {
+		keys = append(keys, "search.semantic")
+	}
+	if !reflect.DeepEqual(old.Media, cfg.Media) {
+		keys = append(keys, "media")
+	}
+	if old.Redis != cfg.Redis {
+		keys = append(keys, "redis")
+	}
+	if !reflect.DeepEqual(old.Versions, cfg.Versions) {
+		keys = append(keys, "versions")
+	}
+	if old.Plugins != cfg.Plugins {
+		keys = append(keys, "plugins")
+	}
+	if old.GeoIP != cfg.GeoIP {
+		keys = append(keys, "geoip")
+	}
+	if !reflect.DeepEqual(old.Schedule, cfg.Schedule) {
+		keys = append(keys, "schedule")
+	}
+	if !reflect.DeepEqual(old.Notion, cfg.Notion) {
+		keys = append(keys, "notion")
+	}
+	if !reflect.DeepEqual(old.Ask, cfg.Ask) {
+		keys = append(keys, "ask")
+	}
+	return keys
+}
+
+// applyConfig swaps in a new config, rebuilding the site (redirects,
+// headers, templates, event subscribers and everything else wired from it)
+// and re-rendering the content, since posts can depend on it too. A config
+// the site can't be built from, templates that don't parse say, leaves the
+// old one in place
+func (s *server) applyConfig(cfg Config) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	old := *config()
+	// the site is built from the global config
+	setConfig(cfg)
+	st, err := s.serverSite()
+	if err != nil {
+		setConfig(old)
+		return err
+	}
+	for _, key := range restartNeeded(old, cfg) {
+		log.Printf("Warning: %s changed, restart for it to take effect\n", key)
+	}
+
+	s.live.current.Store(st)
+
+	s.reloadMu.Lock()
+	s.cache = s.newContentCache()
+	for v := range s.versionCaches {
+		s.versionCaches[v] = s.newContentCache()
+	}
+	s.llms.clear()
+	s.reloadMu.Unlock()
+
+	changes, err := s.reload()
+	if err != nil {
+		log.Printf("Error reloading content: %v\n", err)
+		return nil
+	}
+	log.Printf("Reloaded config: %s\n", changes)
+	return nil
+}
+
+// handleReloadConfig checks the config file and applies it, reporting what
+// still needs a restart
+func (s *server) handleReloadConfig(c *gin.Context) {
+	cfg, err := loadConfig(configPath())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	restart := restartNeeded(*config(), cfg)
+	if err := s.applyConfig(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"restart": restart})
+}
@@ -0,0 +1,411 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contentCache remembers the parsed post for every markdown file, so a
+// reload only re-parses the files that actually changed
+type contentCache struct {
+	files map[string]cachedFile
+	// shared with other instances, nil when there's only this one
+	rendered renderCache
+	// what plugins make of a file before it's parsed
+	plugins []*plugin
+}
+
+// renderCache holds parsed posts by the hash of their expanded markdown
+type renderCache interface {
+	rendered(hash string) (BlogPost, bool)
+	storeRendered(hash string, post BlogPost)
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	// other files the post is rendered from, relative to the content dir,
+	// and their mtimes when it was
+	deps    []string
+	depsSig string
+	post    BlogPost
+}
+
+// contentChanges is what a reload changed, by slug. Structural is set when
+// anything the sidebar and listings depend on changed, not just a body
+type contentChanges struct {
+	Added      []string `json:"added"`
+	Updated    []string `json:"updated"`
+	Removed    []string `json:"removed"`
+	Structural bool     `json:"structural"`
+	// urls whose pages changed
+	Paths []string `json:"paths"`
+	// in degraded mode, the files left out and why
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+func newContentCache() *contentCache {
+	return &contentCache{files: make(map[string]cachedFile)}
+}
+
+func (c contentChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+func (c contentChanges) String() string {
+	s := fmt.Sprintf("%d added, %d updated, %d removed", len(c.Added), len(c.Updated), len(c.Removed))
+	if len(c.Skipped) > 0 {
+		s += fmt.Sprintf(", %d skipped", len(c.Skipped))
+	}
+	return s
+}
+
+// flattenErrors is the errors joined into err, however deep
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+	return errs
+}
+
+// skippedFiles is the load errors, one per file that didn't load
+func skippedFiles(err error) []string {
+	errs := flattenErrors(err)
+	if len(errs) == 0 {
+		return nil
+	}
+	skipped := make([]string, len(errs))
+	for i, err := range errs {
+		skipped[i] = err.Error()
+	}
+	return skipped
+}
+
+// load reads every markdown file in dir, reusing the cached post when the
+// file's mtime and size, or failing that its hash, are unchanged
+func (cc *contentCache) load(dir string) ([]BlogPost, error) {
+	var posts []BlogPost
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// a broken file is reported and left out, the rest still load. It's
+	// not cached, so it's tried again on the next reload
+	var errs []error
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".md") {
+			continue
+		}
+		seen[file.Name()] = true
+		fileError := func(err error) error {
+			return fmt.Errorf("%s: %w", filepath.Join(dir, file.Name()), err)
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			errs = append(errs, fileError(err))
+			continue
+		}
+
+		cached, ok := cc.files[file.Name()]
+		depsChanged := ok && cached.depsSig != depsSignature(dir, cached.deps)
+		if ok && !depsChanged && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			posts = append(posts, cached.post)
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			errs = append(errs, fileError(err))
+			continue
+		}
+
+		hash := sha256.Sum256(content)
+		if !ok || depsChanged || cached.hash != hash {
+			// a sample that can't be included doesn't keep the post out
+			expanded, included, err := expandCodeIncludes(dir, content)
+			if err != nil {
+				log.Printf("Warning: %v\n", fileError(err))
+			}
+			if expanded, err = transformContent(cc.plugins, file.Name(), expanded); err != nil {
+				errs = append(errs, fileError(err))
+				continue
+			}
+			if config().Images.SelfHost {
+				expanded = selfHostImages(expanded)
+			}
+			post, err := cc.parse(expanded)
+			if err != nil {
+				errs = append(errs, fileError(err))
+				continue
+			}
+			cached.deps = included
+			if post.OpenAPI != "" {
+				cached.deps = append(cached.deps, post.OpenAPI)
+				path, err := contentFile(dir, post.OpenAPI)
+				if err != nil {
+					errs = append(errs, fileError(err))
+					continue
+				}
+				reference, headers, err := renderOpenAPI(path)
+				if err != nil {
+					errs = append(errs, fileError(err))
+					continue
+				}
+				post.Content += reference
+				post.Headers = append(post.Headers, headers...)
+			}
+			postDefaults(&post, file.Name())
+			post.SourcePath = file.Name()
+			post.LastModified, post.Contributors = gitHistory(dir, file.Name(), info.ModTime())
+			cached.post = post
+		}
+
+		cc.files[file.Name()] = cachedFile{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			hash:    hash,
+			deps:    cached.deps,
+			depsSig: depsSignature(dir, cached.deps),
+			post:    cached.post,
+		}
+		posts = append(posts, cached.post)
+	}
+
+	for name := range cc.files {
+		if !seen[name] {
+			delete(cc.files, name)
+		}
+	}
+
+	return posts, errors.Join(errs...)
+}
+
+// parse renders a post, or takes it from the shared render cache when
+// another instance already has
+func (cc *contentCache) parse(content []byte) (BlogPost, error) {
+	if cc.rendered == nil {
+		return parseMarkdownFile(content)
+	}
+
+	// posts rendered under another config (an image cdn, say) don't count
+	settings, _ := json.Marshal([]interface{}{baseURL(), config().Images, config().Render})
+	hash := sha256Hex(append(settings, content...))
+	if post, ok := cc.rendered.rendered(hash); ok {
+		return post, nil
+	}
+	post, err := parseMarkdownFile(content)
+	if err == nil {
+		cc.rendered.storeRendered(hash, post)
+	}
+	return post, err
+}
+
+// gitHistory asks git when a file was last committed and who has committed
+// to it, falling back to its mtime and nobody for uncommitted files or
+// content outside of a repository
+func gitHistory(dir, name string, modTime time.Time) (time.Time, []Contributor) {
+	cmd := exec.Command("git", "log", "--format=%cI%x00%aN%x00%aE", "--", name)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return modTime, nil
+	}
+
+	lastModified := modTime
+	var contributors []Contributor
+	byEmail := make(map[string]int)
+	for i, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 {
+			continue
+		}
+		if i == 0 {
+			if committed, err := time.Parse(time.RFC3339, fields[0]); err == nil {
+				lastModified = committed
+			}
+		}
+
+		email := strings.ToLower(fields[2])
+		if n, ok := byEmail[email]; ok {
+			contributors[n].Commits++
+			continue
+		}
+		byEmail[email] = len(contributors)
+		contributors = append(contributors, newContributor(fields[1], email))
+	}
+
+	sort.SliceStable(contributors, func(i, j int) bool {
+		return contributors[i].Commits > contributors[j].Commits
+	})
+	return lastModified, contributors
+}
+
+// diffPosts works out which slugs were added, updated or removed
+func diffPosts(old map[string]BlogPost, posts []BlogPost) contentChanges {
+	var changes contentChanges
+
+	current := make(map[string]bool)
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+		current[post.Slug] = true
+
+		before, ok := old[post.Slug]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, post.Slug)
+			changes.Paths = append(changes.Paths, post.URL())
+			changes.Structural = true
+		case !reflect.DeepEqual(before, post):
+			changes.Updated = append(changes.Updated, post.Slug)
+			changes.Paths = append(changes.Paths, post.URL())
+			if before.URL() != post.URL() {
+				changes.Paths = append(changes.Paths, before.URL())
+			}
+			if structuralKey(before) != structuralKey(post) {
+				changes.Structural = true
+			}
+		}
+	}
+
+	for slug := range old {
+		if !current[slug] {
+			changes.Removed = append(changes.Removed, slug)
+			changes.Paths = append(changes.Paths, old[slug].URL())
+			changes.Structural = true
+		}
+	}
+
+	return changes
+}
+
+// structuralKey covers the metadata that sidebars and listings are built from
+func structuralKey(post BlogPost) string {
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%s", post.Title, post.Parent, post.Order,
+		strings.Join(post.Tags, ","), post.SourcePath, post.URL())
+}
+
+// dirSignature summarises the names, sizes and mtimes of the markdown files
+// in dir, cheap enough to poll
+func dirSignature(dir string) (string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var sig strings.Builder
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".md") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d;", file.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return sig.String(), nil
+}
+
+// depsSignature is the mtimes of a post's dependencies, missing files
+// included so they're picked up once they appear
+func depsSignature(dir string, deps []string) string {
+	var sig strings.Builder
+	for _, dep := range deps {
+		var mod int64
+		if info, err := os.Stat(filepath.Join(dir, dep)); err == nil {
+			mod = info.ModTime().UnixNano()
+		}
+		fmt.Fprintf(&sig, "%s:%d;", dep, mod)
+	}
+	return sig.String()
+}
+
+// signature covers the dependencies of every cached post
+func (cc *contentCache) signature(dir string) string {
+	names := make([]string, 0, len(cc.files))
+	for name := range cc.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sig strings.Builder
+	for _, name := range names {
+		sig.WriteString(depsSignature(dir, cc.files[name].deps))
+	}
+	return sig.String()
+}
+
+// contentSignature covers the content directory and every version's, along
+// with the files the posts include
+func (s *server) contentSignature() (string, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	sig, err := dirSignature(s.contentDir)
+	if err != nil {
+		return "", err
+	}
+	sig += s.cache.signature(s.contentDir)
+	sig += categoriesSignature(s.contentDir)
+	for _, v := range config().Versions {
+		dir := filepath.Join(s.contentDir, v.Name)
+		versionSig, err := dirSignature(dir)
+		if err != nil {
+			return "", err
+		}
+		sig += v.Name + "/" + versionSig
+		if cache, ok := s.versionCaches[v.Name]; ok {
+			sig += cache.signature(dir)
+		}
+	}
+	return sig, nil
+}
+
+// watch polls the content directory and reloads whenever it changes
+func (s *server) watch(interval time.Duration) {
+	last, _ := s.contentSignature()
+
+	for range time.Tick(interval) {
+		sig, err := s.contentSignature()
+		if err != nil {
+			log.Printf("Error watching content: %v\n", err)
+			continue
+		}
+		if sig == last {
+			continue
+		}
+		last = sig
+
+		start := time.Now()
+		changes, err := s.reload()
+		if err != nil {
+			log.Printf("Error reloading content: %v\n", err)
+			continue
+		}
+		log.Printf("Reloaded content in %v: %s\n", time.Since(start), changes)
+	}
+}
@@ -0,0 +1,126 @@
+package blog
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the templates that make up the top of every page, their selectors are the
+// ones worth inlining
+var criticalTemplates = []string{"header.html", "sidebar.html", "layout.html", "index.html"}
+
+// markdown content fills the rest of the first screen
+var contentTags = []string{"p", "a", "h1", "h2", "h3", "ul", "ol", "li", "code", "pre", "strong", "em", "img"}
+
+var (
+	templateActionRegexp = regexp.MustCompile(`\{\{.*?\}\}`)
+	classAttrRegexp      = regexp.MustCompile(`class="([^"]*)"`)
+	idAttrRegexp         = regexp.MustCompile(`id="([^"]*)"`)
+	tagNameRegexp        = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)`)
+	pseudoRegexp         = regexp.MustCompile(`::?[\w-]+(\([^)]*\))?|\[[^\]]*\]`)
+	compoundRegexp       = regexp.MustCompile(`[\s>+~]+`)
+	selectorRegexp       = regexp.MustCompile(`[.#]?[\w-]+`)
+)
+
+// criticalCSS pulls the rules needed to render the top of the main templates
+// out of the stylesheet, so they can be inlined and the full stylesheet
+// loaded without blocking the first paint
+func criticalCSS(stylesheet, templateDir string) (template.CSS, error) {
+	css, err := os.ReadFile(stylesheet)
+	if err != nil {
+		return "", err
+	}
+
+	used := map[string]bool{"*": true}
+	for _, tag := range contentTags {
+		used[tag] = true
+	}
+	for _, name := range criticalTemplates {
+		data, err := os.ReadFile(filepath.Join(templateDir, name))
+		if err != nil {
+			return "", err
+		}
+		markup := templateActionRegexp.ReplaceAllString(string(data), " ")
+
+		for _, m := range classAttrRegexp.FindAllStringSubmatch(markup, -1) {
+			for _, class := range strings.Fields(m[1]) {
+				used["."+class] = true
+			}
+		}
+		for _, m := range idAttrRegexp.FindAllStringSubmatch(markup, -1) {
+			used["#"+m[1]] = true
+		}
+		for _, m := range tagNameRegexp.FindAllStringSubmatch(markup, -1) {
+			used[strings.ToLower(m[1])] = true
+		}
+	}
+
+	return template.CSS(filterCSS(minifyCSS(string(css)), used)), nil
+}
+
+// filterCSS keeps the rules with at least one selector made only of used
+// parts, along with font faces, recursing into media queries
+func filterCSS(css string, used map[string]bool) string {
+	var out strings.Builder
+	for len(css) > 0 {
+		open := strings.IndexByte(css, '{')
+		if open == -1 {
+			break
+		}
+		end := matchingBrace(css, open)
+		prelude, body := strings.TrimSpace(css[:open]), css[open+1:end]
+		css = css[min(end+1, len(css)):]
+
+		switch {
+		case strings.HasPrefix(prelude, "@media"):
+			if inner := filterCSS(body, used); inner != "" {
+				out.WriteString(prelude + "{" + inner + "}")
+			}
+		case strings.HasPrefix(prelude, "@font-face"):
+			out.WriteString(prelude + "{" + body + "}")
+		case strings.HasPrefix(prelude, "@"):
+			// keyframes and the like aren't needed for the first paint
+		default:
+			for _, selector := range strings.Split(prelude, ",") {
+				if selectorUsed(selector, used) {
+					out.WriteString(prelude + "{" + body + "}")
+					break
+				}
+			}
+		}
+	}
+	return out.String()
+}
+
+func selectorUsed(selector string, used map[string]bool) bool {
+	selector = pseudoRegexp.ReplaceAllString(selector, "")
+	for _, compound := range compoundRegexp.Split(strings.TrimSpace(selector), -1) {
+		for _, part := range selectorRegexp.FindAllString(compound, -1) {
+			if !used[strings.ToLower(part)] && !used[part] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or
+// the end of css if it's never closed
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
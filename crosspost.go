@@ -0,0 +1,316 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	devToArticlesURL = "https://dev.to/api/articles"
+	hashnodeAPIURL   = "https://gql.hashnode.com"
+)
+
+// crossPost is a post's copy on another site
+type crossPost struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// of what was last pushed, so unchanged posts are skipped
+	Hash string `json:"hash"`
+}
+
+// crossPostPayload is what's pushed, the same for every site
+type crossPostPayload struct {
+	Title       string
+	Description string
+	Markdown    string
+	Canonical   string
+	Tags        []string
+}
+
+type crossPoster interface {
+	create(p crossPostPayload) (crossPost, error)
+	update(id string, p crossPostPayload) (crossPost, error)
+}
+
+// crosspostCommand pushes every post with CrossPost metadata to the sites it
+// names, creating it there the first time and updating it after:
+// crosspost [-dry-run]
+func crosspostCommand(args []string) error {
+	fs := flag.NewFlagSet("crosspost", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "show what would be pushed without pushing it")
+	fs.Parse(args)
+
+	sites := map[string]crossPoster{}
+	if config().CrossPost.DevTo.APIKey != "" {
+		sites["devto"] = config().CrossPost.DevTo
+	}
+	if config().CrossPost.Hashnode.Token != "" {
+		sites["hashnode"] = config().CrossPost.Hashnode
+	}
+	if len(sites) == 0 {
+		return fmt.Errorf("no cross-posting sites configured, set crosspost.devto or crosspost.hashnode")
+	}
+
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return err
+	}
+
+	storePath := filepath.Join(config().DataDir, "crosspost.json")
+	// slug -> site -> copy
+	pushed := make(map[string]map[string]crossPost)
+	if err := loadJSON(storePath, &pushed); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, post := range s.allPosts() {
+		if len(post.CrossPost) == 0 || post.restricted() || post.Protected {
+			continue
+		}
+
+		markdown, err := s.postMarkdown(post)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", post.Slug, err))
+			continue
+		}
+		payload := crossPostPayload{
+			Title:       post.Title,
+			Description: post.Description,
+			Markdown:    markdown,
+			Canonical:   baseURL() + post.URL(),
+			Tags:        post.Tags,
+		}
+		hash := sha256Hex([]byte(payload.Title + "\x00" + payload.Description + "\x00" +
+			strings.Join(payload.Tags, ",") + "\x00" + payload.Markdown))
+
+		for _, name := range crossPostSites(post, sites) {
+			existing, ok := pushed[post.Slug][name]
+			if ok && existing.Hash == hash {
+				continue
+			}
+
+			action := "creating"
+			if ok {
+				action = "updating"
+			}
+			fmt.Printf("%s %s on %s\n", action, post.Slug, name)
+			if *dryRun {
+				continue
+			}
+
+			var remote crossPost
+			if ok {
+				remote, err = sites[name].update(existing.ID, payload)
+			} else {
+				remote, err = sites[name].create(payload)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s on %s: %w", post.Slug, name, err))
+				continue
+			}
+			remote.Hash = hash
+
+			if pushed[post.Slug] == nil {
+				pushed[post.Slug] = make(map[string]crossPost)
+			}
+			pushed[post.Slug][name] = remote
+			// saved as we go, so a failure part way doesn't duplicate posts next time
+			if err := saveJSON(storePath, pushed); err != nil {
+				return err
+			}
+			fmt.Printf("  %s\n", remote.URL)
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cross-posting failed for %d posts", len(errs))
+	}
+	return nil
+}
+
+// crossPostSites is which of the configured sites a post goes to,
+// "CrossPost: true" means all of them
+func crossPostSites(post BlogPost, sites map[string]crossPoster) []string {
+	var names []string
+	for _, name := range post.CrossPost {
+		name = strings.ToLower(strings.ReplaceAll(name, ".", ""))
+		if name == "true" || name == "all" {
+			names = nil
+			for _, all := range []string{"devto", "hashnode"} {
+				if sites[all] != nil {
+					names = append(names, all)
+				}
+			}
+			return names
+		}
+		if sites[name] == nil {
+			fmt.Fprintf(os.Stderr, "warning: %s wants cross-posting to %s, which isn't configured\n", post.Slug, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+var rootRelativeLink = regexp.MustCompile(`\]\((/[^)\s]*)`)
+
+// postMarkdown is the post's markdown for another site, with site relative
+// links made absolute so they work there
+func (s *server) postMarkdown(post BlogPost) (string, error) {
+	body, err := s.sourceMarkdown(post)
+	if err != nil {
+		return "", err
+	}
+	return rootRelativeLink.ReplaceAllString(body, "]("+baseURL()+"$1"), nil
+}
+
+// errPostProtected is returned instead of a protected post's markdown, which
+// would give away what its password is there to keep
+var errPostProtected = errors.New("the post is protected")
+
+// sourceMarkdown is the post's markdown without its metadata, with code
+// includes and plugin shortcodes expanded
+func (s *server) sourceMarkdown(post BlogPost) (string, error) {
+	if post.Protected {
+		return "", errPostProtected
+	}
+	path := filepath.Join(s.contentDir, post.SourcePath)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// includes that fail are left as the same note the page has
+	content, _, _ = expandCodeIncludes(filepath.Dir(path), content)
+	if content, err = transformContent(s.plugins, post.SourcePath, content); err != nil {
+		return "", err
+	}
+
+	_, body, err := splitFrontmatter(string(content))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(body), nil
+}
+
+func (d DevToConfig) create(p crossPostPayload) (crossPost, error) {
+	return d.send(http.MethodPost, devToArticlesURL, p)
+}
+
+func (d DevToConfig) update(id string, p crossPostPayload) (crossPost, error) {
+	return d.send(http.MethodPut, devToArticlesURL+"/"+id, p)
+}
+
+func (d DevToConfig) send(method, endpoint string, p crossPostPayload) (crossPost, error) {
+	// dev.to takes at most four tags, lowercase letters and numbers only
+	var tags []string
+	for _, tag := range p.Tags {
+		tag = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(tag))
+		if tag != "" && len(tags) < 4 {
+			tags = append(tags, tag)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"article": map[string]interface{}{
+			"title":         p.Title,
+			"description":   p.Description,
+			"body_markdown": p.Markdown,
+			"canonical_url": p.Canonical,
+			"tags":          tags,
+			"published":     true,
+		},
+	})
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return crossPost{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", d.APIKey)
+
+	var article struct {
+		ID  int    `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := jsonRequest(req, &article); err != nil {
+		return crossPost{}, err
+	}
+	return crossPost{ID: strconv.Itoa(article.ID), URL: article.URL}, nil
+}
+
+func (h HashnodeConfig) create(p crossPostPayload) (crossPost, error) {
+	input := h.input(p)
+	input["publicationId"] = h.PublicationID
+	return h.send("mutation($input: PublishPostInput!) { publishPost(input: $input) { post { id url } } }", "publishPost", input)
+}
+
+func (h HashnodeConfig) update(id string, p crossPostPayload) (crossPost, error) {
+	input := h.input(p)
+	input["id"] = id
+	return h.send("mutation($input: UpdatePostInput!) { updatePost(input: $input) { post { id url } } }", "updatePost", input)
+}
+
+func (h HashnodeConfig) input(p crossPostPayload) map[string]interface{} {
+	var tags []map[string]string
+	for _, tag := range p.Tags {
+		tags = append(tags, map[string]string{"name": tag, "slug": sanitizeHeaderForID(tag)})
+	}
+
+	input := map[string]interface{}{
+		"title":              p.Title,
+		"contentMarkdown":    p.Markdown,
+		"originalArticleURL": p.Canonical,
+		"tags":               tags,
+	}
+	if p.Description != "" {
+		input["subtitle"] = p.Description
+	}
+	return input
+}
+
+func (h HashnodeConfig) send(query, field string, input map[string]interface{}) (crossPost, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": map[string]interface{}{"input": input},
+	})
+	req, err := http.NewRequest(http.MethodPost, hashnodeAPIURL, bytes.NewReader(body))
+	if err != nil {
+		return crossPost{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", h.Token)
+
+	var result struct {
+		Data map[string]struct {
+			Post crossPost `json:"post"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := jsonRequest(req, &result); err != nil {
+		return crossPost{}, err
+	}
+	// graphql reports failures with a 200
+	if len(result.Errors) > 0 {
+		return crossPost{}, fmt.Errorf("hashnode: %s", result.Errors[0].Message)
+	}
+	return result.Data[field].Post, nil
+}
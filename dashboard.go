@@ -0,0 +1,234 @@
+package blog
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many recent edits and popular posts the dashboard lists
+const dashboardListSize = 10
+
+// contentCounts is how much of what there is on the site
+type contentCounts struct {
+	Pages      int
+	Restricted int
+	Dated      int
+	Sections   int
+	Tags       int
+	Versions   int
+	Stale      int
+}
+
+// postViews is a post and how often it was viewed
+type postViews struct {
+	Post  BlogPost
+	Views int
+}
+
+func countContent(posts []BlogPost) contentCounts {
+	var counts contentCounts
+	sections := make(map[string]bool)
+	tags := make(map[string]bool)
+	versions := make(map[string]bool)
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+		counts.Pages++
+		if post.restricted() {
+			counts.Restricted++
+		}
+		if !post.Date.IsZero() {
+			counts.Dated++
+		}
+		if post.Parent != "" {
+			sections[post.Parent] = true
+		}
+		for _, tag := range post.Tags {
+			tags[tag] = true
+		}
+		if post.Version != "" {
+			versions[post.Version] = true
+		}
+		if post.Stale() {
+			counts.Stale++
+		}
+	}
+	counts.Sections, counts.Tags, counts.Versions = len(sections), len(tags), len(versions)
+	return counts
+}
+
+// recentEdits are the posts that changed last, newest first
+func recentEdits(posts []BlogPost, n int) []BlogPost {
+	recent := make([]BlogPost, 0, len(posts))
+	for _, post := range posts {
+		if post.Slug != "" {
+			recent = append(recent, post)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].LastModified.After(recent[j].LastModified)
+	})
+	if len(recent) > n {
+		recent = recent[:n]
+	}
+	return recent
+}
+
+// brokenSiteLinks finds links to this site that lead nowhere, without
+// rendering anything, which would count as views. A link is fine when a
+// post, a route, a static file or a redirect answers it. bloog check-links
+// renders the pages and checks #fragments too
+func (s *server) brokenSiteLinks(routes gin.RoutesInfo, posts []BlogPost) []brokenLink {
+	resolves := func(path string) bool {
+		if _, ok := s.postByURL(path); ok {
+			return true
+		}
+		// flat links to posts that moved to dated urls
+		if _, ok := s.post(strings.TrimPrefix(path, "/")); ok {
+			return true
+		}
+		if rest, ok := strings.CutPrefix(path, "/static/"); ok {
+			_, err := os.Stat(filepath.Join("static", filepath.FromSlash(rest)))
+			return err == nil
+		}
+		for _, rule := range config().Redirects {
+			if _, ok := matchPath(rule.From, path); ok {
+				return true
+			}
+		}
+		for _, route := range routes {
+			if route.Method == http.MethodGet && routeMatches(route.Path, path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var broken []brokenLink
+	for _, post := range posts {
+		for _, link := range postLinks(post) {
+			if isInternal(link) && !resolves(link.Path) {
+				broken = append(broken, brokenLink{Post: postSource(post), URL: link.Path, Status: http.StatusNotFound})
+			}
+		}
+	}
+	return broken
+}
+
+// cachedBrokenLinks are the external links bloog check-links -external
+// last found broken, with when the oldest of them was checked
+func cachedBrokenLinks(posts []BlogPost) ([]brokenLink, time.Time, error) {
+	cache := make(map[string]linkStatus)
+	if err := loadJSON(filepath.Join(config().DataDir, "links.json"), &cache); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var broken []brokenLink
+	var checked time.Time
+	for _, post := range posts {
+		for _, link := range postLinks(post) {
+			link.Fragment = ""
+			status, ok := cache[link.String()]
+			if !ok {
+				continue
+			}
+			if checked.IsZero() || status.Checked.Before(checked) {
+				checked = status.Checked
+			}
+			if status.broken() {
+				broken = append(broken, brokenLink{Post: postSource(post), URL: link.String(), Status: status.Status, Error: status.Error})
+			}
+		}
+	}
+	return broken, checked, nil
+}
+
+// handleDashboard shows how the site is doing on one page: what's on it,
+// what changed, what's read, what's broken and how it was last built
+func (s *server) handleDashboard(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		posts := s.allPosts()
+		public := publicPosts(posts)
+
+		var popular []postViews
+		totalViews := 0
+		for _, post := range posts {
+			totalViews += s.views.count(post.Slug)
+		}
+		for _, post := range s.views.popular(posts, dashboardListSize) {
+			popular = append(popular, postViews{Post: post, Views: s.views.count(post.Slug)})
+		}
+
+		broken := s.brokenSiteLinks(r.Routes(), public)
+		external, externalChecked, err := cachedBrokenLinks(public)
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+		}
+		broken = append(broken, external...)
+
+		misses := s.misses.list()
+		if len(misses) > dashboardListSize {
+			misses = misses[:dashboardListSize]
+		}
+
+		searches, unanswered := s.searches.list(false), s.searches.list(true)
+		if len(searches) > dashboardListSize {
+			searches = searches[:dashboardListSize]
+		}
+		if len(unanswered) > dashboardListSize {
+			unanswered = unanswered[:dashboardListSize]
+		}
+
+		subscribers := s.subscribers.list()
+		totalSubscribers := 0
+		for _, subscriber := range subscribers {
+			totalSubscribers += subscriber.Count
+		}
+
+		var build *buildRecord
+		var record buildRecord
+		if err := loadJSON(filepath.Join(config().DataDir, "build.json"), &record); err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+		} else if !record.Finished.IsZero() {
+			build = &record
+		}
+
+		s.mu.RLock()
+		loaded, loadErr := s.loaded, s.loadErr
+		s.mu.RUnlock()
+		var problems []string
+		for _, p := range s.report.problems {
+			problems = append(problems, p.severity.String()+": "+p.message)
+		}
+		if loadErr != nil {
+			problems = append(problems, "reloading content: "+loadErr.Error())
+		}
+
+		s.html(c, http.StatusOK, "admin-dashboard.html", gin.H{
+			"Title":            "Dashboard",
+			"Session":          c.MustGet("session"),
+			"Counts":           countContent(posts),
+			"Recent":           recentEdits(posts, dashboardListSize),
+			"TotalViews":       totalViews,
+			"Popular":          popular,
+			"Broken":           broken,
+			"Misses":           misses,
+			"Searches":         searches,
+			"Unanswered":       unanswered,
+			"ExternalChecked":  externalChecked,
+			"Subscribers":      subscribers,
+			"TotalSubscribers": totalSubscribers,
+			"Build":            build,
+			"Loaded":           loaded,
+			"Problems":         problems,
+		})
+	}
+}
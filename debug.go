@@ -0,0 +1,100 @@
+package blog
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// expvar panics on publishing a name twice, and routes are rebuilt on
+// every config reload
+var publishVars sync.Once
+
+// debugRoutes serves the pprof profiles under /debug/pprof, runtime stats
+// at /debug/vars and social previews at /debug/preview, to requests from
+// the allowed networks, with the debug token or from signed in admins
+func (s *server) debugRoutes(r *gin.Engine) {
+	publishVars.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() interface{} {
+			return runtime.NumGoroutine()
+		}))
+		expvar.Publish("posts", expvar.Func(func() interface{} {
+			return len(s.allPosts())
+		}))
+	})
+
+	debug := r.Group("/debug", s.debugAccess(config().Debug))
+	debug.GET("/vars", gin.WrapH(expvar.Handler()))
+	debug.GET("/pprof/", gin.WrapF(pprof.Index))
+	debug.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	// heap, goroutine, allocs and the other named profiles
+	debug.GET("/pprof/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+	if !config().Headless {
+		debug.GET("/preview/*slug", s.handlePreview(r))
+	}
+}
+
+func (s *server) debugAccess(cfg DebugConfig) gin.HandlerFunc {
+	allow := cfg.Allow
+	// behind a proxy on the same machine everything comes from localhost
+	loopback := len(allow) == 0 && len(config().TrustedProxies) == 0
+	var networks []*net.IPNet
+	for _, cidr := range allow {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return func(c *gin.Context) {
+		// the address of the connection, forwarded headers are easily faked
+		if ip := net.ParseIP(c.RemoteIP()); ip != nil {
+			if loopback && ip.IsLoopback() && !forwarded(c.Request) {
+				c.Next()
+				return
+			}
+			for _, network := range networks {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		if auth := c.GetHeader("Authorization"); cfg.Token != "" && strings.HasPrefix(auth, "Bearer ") {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(cfg.Token)) == 1 {
+				c.Next()
+				return
+			}
+		}
+
+		if session := s.sessions.get(c); session != nil && roleAtLeast(session.Role, roleAdmin) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+	}
+}
+
+// forwarded is whether a request came through a proxy, which an
+// unconfigured one on the same machine still gives away
+func forwarded(r *http.Request) bool {
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"} {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package blog
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// deployCommand builds the site and publishes it:
+// deploy [-target s3|gh-pages] [-out public] [-dry-run]
+func deployCommand(args []string) error {
+	fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+	target := fs.String("target", config().Deploy.Target, "where to deploy, s3 or gh-pages")
+	out := fs.String("out", "public", "directory to build the site into")
+	dryRun := fs.Bool("dry-run", false, "show what would change without changing it")
+	fs.Parse(args)
+
+	if err := buildCommand([]string{"-out", *out}); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var err error
+	switch *target {
+	case "s3":
+		err = deployS3(config().Deploy.S3, *out, *dryRun)
+	case "gh-pages":
+		err = deployGHPages(config().Deploy.GHPages, *out, *dryRun)
+	case "":
+		return fmt.Errorf("no deploy target, set deploy.target or pass -target")
+	default:
+		return fmt.Errorf("unknown deploy target %q", *target)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the hub fetches the feeds and works out what's new itself
+	if config().WebSub.Hub != "" && !*dryRun {
+		if err := notifyHub(config().WebSub.Hub); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("deployed in %v\n", since(start))
+	return nil
+}
+
+// deployS3 uploads the files whose content differs from the bucket and
+// removes the ones that are no longer built
+func deployS3(cfg S3Config, dir string, dryRun bool) error {
+	if cfg.Bucket == "" {
+		return fmt.Errorf("deploy.s3.bucket is required")
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	client := newS3Client(cfg)
+	remote, err := client.list(prefix)
+	if err != nil {
+		return err
+	}
+
+	uploaded, unchanged := 0, 0
+	err = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		// single part uploads have the md5 of the content as their etag
+		sum := md5.Sum(data)
+		obj, exists := remote[key]
+		delete(remote, key)
+		if exists && obj.ETag == hex.EncodeToString(sum[:]) {
+			unchanged++
+			return nil
+		}
+
+		fmt.Printf("upload   %s\n", key)
+		uploaded++
+		if dryRun {
+			return nil
+		}
+		return client.put(key, data, deployHeaders(filepath.ToSlash(rel)))
+	})
+	if err != nil {
+		return err
+	}
+
+	// whatever is left in the bucket wasn't part of this build
+	for key := range remote {
+		fmt.Printf("delete   %s\n", key)
+		if dryRun {
+			continue
+		}
+		if err := client.delete(key); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("s3       %d uploaded, %d unchanged, %d deleted\n", uploaded, unchanged, len(remote))
+	return nil
+}
+
+// deployHeaders picks the content type and cache rule for a built file,
+// matched against the url it's served at
+func deployHeaders(rel string) map[string]string {
+	ctype := mime.TypeByExtension(path.Ext(rel))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	headers := map[string]string{"Content-Type": ctype}
+
+	urlPath := "/" + strings.TrimSuffix(rel, "index.html")
+	for _, rule := range config().Cache {
+		if _, ok := matchPath(rule.Path, urlPath); ok {
+			headers["Cache-Control"] = rule.Control
+			break
+		}
+	}
+	return headers
+}
+
+// deployGHPages commits the build to the pages branch and pushes it, git
+// works out what changed. github pages doesn't support custom headers, so
+// the cache rules don't apply there
+func deployGHPages(cfg GHPagesConfig, dir string, dryRun bool) error {
+	branch := cfg.Branch
+	if branch == "" {
+		branch = "gh-pages"
+	}
+	remote := cfg.Remote
+	if remote == "" {
+		url, err := exec.Command("git", "remote", "get-url", "origin").Output()
+		if err != nil {
+			return fmt.Errorf("no deploy.gh_pages.remote and no origin remote: %w", err)
+		}
+		remote = strings.TrimSpace(string(url))
+	}
+
+	work, err := os.MkdirTemp("", "bloog-deploy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	// start from the current branch if it exists, otherwise an orphan one
+	if err := git("", "clone", "--quiet", "--depth", "1", "--branch", branch, remote, work); err != nil {
+		if err := git(work, "init", "--quiet"); err != nil {
+			return err
+		}
+		if err := git(work, "checkout", "--quiet", "--orphan", branch); err != nil {
+			return err
+		}
+		if err := git(work, "remote", "add", "origin", remote); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(work)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(work, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	if _, err := copyDir(dir, work); err != nil {
+		return err
+	}
+	// otherwise pages runs the files through jekyll
+	if err := os.WriteFile(filepath.Join(work, ".nojekyll"), nil, 0644); err != nil {
+		return err
+	}
+
+	if err := git(work, "add", "-A"); err != nil {
+		return err
+	}
+	if git(work, "diff", "--cached", "--quiet") == nil {
+		fmt.Println("gh-pages nothing changed")
+		return nil
+	}
+
+	if dryRun {
+		cmd := exec.Command("git", "diff", "--cached", "--stat")
+		cmd.Dir = work
+		cmd.Stdout = os.Stdout
+		return cmd.Run()
+	}
+
+	if err := git(work, "commit", "--quiet", "-m", "Deploy "+time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return git(work, "push", "--quiet", "origin", branch)
+}
+
+func git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package blog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// how many lines either side of an error's line the dev error page shows
+const diagnosticContext = 4
+
+// diagnostic is an error and, when it can be told, the file and line it's
+// about with the lines around it
+type diagnostic struct {
+	Message string
+	File    string
+	Line    int
+	Context []contextLine
+	// for panics, where it happened
+	Stack string
+}
+
+type contextLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+var (
+	// template: layout.html:25:14: executing "layout.html" at <.Foo>: ...
+	templateErrorLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+	// markdown/post.md: frontmatter has no closing ---
+	contentErrorLocation = regexp.MustCompile(`^(\S+\.md): `)
+	errorLineNumber      = regexp.MustCompile(`\bline (\d+)`)
+)
+
+// sourceContext is the lines of path around line
+func sourceContext(path string, line int) []contextLine {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []contextLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan() && n <= line+diagnosticContext; n++ {
+		if n >= line-diagnosticContext {
+			lines = append(lines, contextLine{Number: n, Text: scanner.Text(), Current: n == line})
+		}
+	}
+	return lines
+}
+
+// templateDiagnostic finds the template and line an execution error is at
+func templateDiagnostic(err error) diagnostic {
+	d := diagnostic{Message: err.Error()}
+	if m := templateErrorLocation.FindStringSubmatch(d.Message); m != nil {
+		d.File = filepath.Join("templates", m[1])
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Context = sourceContext(d.File, d.Line)
+	}
+	return d
+}
+
+// contentDiagnostics splits the errors loading the content by file. Line
+// numbers in frontmatter errors count from the line after the opening
+// delimiter, so they're moved down one
+func contentDiagnostics(err error) []diagnostic {
+	var diagnostics []diagnostic
+	for _, err := range flattenErrors(err) {
+		d := diagnostic{Message: err.Error()}
+		if m := contentErrorLocation.FindStringSubmatch(d.Message); m != nil {
+			d.File = m[1]
+			d.Line = 1
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				d.Line, _ = decodeErr.Position()
+				d.Line++
+			} else if n := errorLineNumber.FindStringSubmatch(d.Message); n != nil {
+				d.Line, _ = strconv.Atoi(n[1])
+				if strings.Contains(d.Message, "frontmatter") {
+					d.Line++
+				}
+			}
+			d.Context = sourceContext(d.File, d.Line)
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// the dev error page is made here rather than from templates/, which may be
+// what's broken
+var devErrorPage = template.Must(template.New("dev-error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>{{ .Title }}</title>
+<style>
+body { margin: 0; padding: 24px; background: #181c1f; color: #eee; font-family: system-ui, sans-serif; }
+{{ template "dev-error-style" }}
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+{{ template "dev-diagnostics" .Diagnostics }}
+<p class="dev-note">Shown because <code>dev: true</code> is set in bloog.yaml. Reference {{ .Reference }}.</p>
+</body>
+</html>
+{{ define "dev-error-style" }}
+.dev-diagnostic { margin: 16px 0; padding: 12px 16px; border-left: 4px solid #f76a8d; background: #1e2124; color: #eee; font-family: system-ui, sans-serif; text-align: left; }
+.dev-diagnostic h2 { margin: 0 0 8px; font-size: 15px; font-family: monospace; color: #f76a8d; }
+.dev-diagnostic p { margin: 0 0 8px; font-family: monospace; white-space: pre-wrap; }
+.dev-diagnostic pre { margin: 0; padding: 8px; background: #111; overflow-x: auto; font-size: 13px; }
+.dev-diagnostic .current { display: inline-block; width: 100%; background: #5c2331; }
+.dev-note { color: gray; font-size: 13px; }
+{{ end }}
+{{ define "dev-diagnostics" }}{{ range . }}
+<section class="dev-diagnostic">
+{{ if .File }}<h2>{{ .File }}{{ if .Line }}:{{ .Line }}{{ end }}</h2>{{ end }}
+<p>{{ .Message }}</p>
+{{ with .Context }}<pre>{{ range . }}<span{{ if .Current }} class="current"{{ end }}>{{ printf "%4d" .Number }}  {{ .Text }}</span>
+{{ end }}</pre>{{ end }}
+{{ with .Stack }}<pre>{{ . }}</pre>{{ end }}
+</section>
+{{ end }}{{ end }}`))
+
+// devError answers a failed page with what went wrong and where, instead
+// of the 500 page
+func devError(c *gin.Context, ref string, d diagnostic) {
+	c.Writer.Header().Del("Content-Type")
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") || config().Headless {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": d.Message, "file": d.File, "line": d.Line, "reference": ref})
+		return
+	}
+	var b bytes.Buffer
+	err := devErrorPage.Execute(&b, map[string]interface{}{
+		"Title":       "Error rendering " + c.Request.URL.Path,
+		"Diagnostics": []diagnostic{d},
+		"Reference":   ref,
+	})
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+	}
+	c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", b.Bytes())
+}
+
+// devErrorsOverlay is the errors as a block to put at the top of every
+// page while they last
+func devErrorsOverlay(diagnostics []diagnostic) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<div class="dev-overlay" style="position: fixed; top: 0; left: 0; right: 0; max-height: 50vh; overflow-y: auto; z-index: 1000; padding: 0 16px; background: rgba(24, 28, 31, 0.97); box-shadow: 0 2px 12px #000;"><style>`)
+	devErrorPage.ExecuteTemplate(&b, "dev-error-style", nil)
+	b.WriteString(`</style>`)
+	if err := devErrorPage.ExecuteTemplate(&b, "dev-diagnostics", diagnostics); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+	}
+	b.WriteString(`<p class="dev-note">These didn't load, shown because <code>dev: true</code> is set in bloog.yaml.</p></div>`)
+	return b.Bytes()
+}
+
+// withDevErrors adds the templates' and content's load errors to the top
+// of a page's body, in dev mode
+func (s *server) withDevErrors(page []byte) []byte {
+	s.mu.RLock()
+	loadErr, templateErr := s.loadErr, s.templateErr
+	s.mu.RUnlock()
+
+	var diagnostics []diagnostic
+	if templateErr != nil {
+		diagnostics = append(diagnostics, templateDiagnostic(templateErr))
+	}
+	if loadErr != nil {
+		diagnostics = append(diagnostics, contentDiagnostics(loadErr)...)
+	}
+	if len(diagnostics) == 0 {
+		return page
+	}
+	i := bytes.Index(page, []byte("<body"))
+	if i < 0 {
+		return page
+	}
+	end := bytes.IndexByte(page[i:], '>')
+	if end < 0 {
+		return page
+	}
+	at := i + end + 1
+	return append(append(append([]byte{}, page[:at]...), devErrorsOverlay(diagnostics)...), page[at:]...)
+}
@@ -0,0 +1,500 @@
+package blog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xmlNode is any element of a docx part. Only local names are kept, w:p
+// and a:blip are just p and blip
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []xmlNode  `xml:",any"`
+	Text    string     `xml:",chardata"`
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// find is the first descendant named name
+func (n *xmlNode) find(name string) *xmlNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+		if found := n.Nodes[i].find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// on reports whether a toggle property like w:b is set, <w:b w:val="0"/>
+// turns it off
+func (n *xmlNode) on(name string) bool {
+	prop := n.child(name)
+	if prop == nil {
+		return false
+	}
+	val := prop.attr("val")
+	return val != "0" && val != "false" && val != "none"
+}
+
+// docx is an opened Word document, or a Google Docs download as one
+type docx struct {
+	files map[string]*zip.File
+	// relationship ids to their targets: media files and link urls
+	rels map[string]string
+	// list ids and levels to whether they're numbered rather than bulleted
+	numbered map[string]map[string]bool
+
+	// the images found, numbered in order, to store once converted
+	images []docxImage
+	// where each list is counting, reset by anything that isn't a list item
+	counters map[string]int
+}
+
+type docxImage struct {
+	part string
+	alt  string
+}
+
+func openDocx(r *zip.Reader) (*docx, error) {
+	d := &docx{
+		files:    make(map[string]*zip.File),
+		rels:     make(map[string]string),
+		numbered: make(map[string]map[string]bool),
+		counters: make(map[string]int),
+	}
+	for _, f := range r.File {
+		d.files[f.Name] = f
+	}
+	if d.files["word/document.xml"] == nil {
+		return nil, errors.New("not a docx file, there's no word/document.xml")
+	}
+
+	if rels, err := d.part("word/_rels/document.xml.rels"); err == nil {
+		for _, rel := range rels.Nodes {
+			target := rel.attr("Target")
+			if rel.attr("TargetMode") != "External" {
+				target = path.Join("word", target)
+			}
+			d.rels[rel.attr("Id")] = target
+		}
+	}
+
+	// numbering.xml says which lists are numbered, through abstract lists
+	// several lists can share
+	if numbering, err := d.part("word/numbering.xml"); err == nil {
+		abstract := make(map[string]map[string]bool)
+		for _, n := range numbering.Nodes {
+			if n.XMLName.Local != "abstractNum" {
+				continue
+			}
+			levels := make(map[string]bool)
+			for _, lvl := range n.Nodes {
+				if lvl.XMLName.Local == "lvl" {
+					format := lvl.child("numFmt")
+					levels[lvl.attr("ilvl")] = format != nil && format.attr("val") != "bullet" && format.attr("val") != "none"
+				}
+			}
+			abstract[n.attr("abstractNumId")] = levels
+		}
+		for _, n := range numbering.Nodes {
+			if n.XMLName.Local == "num" {
+				if id := n.child("abstractNumId"); id != nil {
+					d.numbered[n.attr("numId")] = abstract[id.attr("val")]
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+func (d *docx) read(name string) ([]byte, error) {
+	f, ok := d.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxUploadSize+1))
+}
+
+func (d *docx) part(name string) (*xmlNode, error) {
+	data, err := d.read(name)
+	if err != nil {
+		return nil, err
+	}
+	var n xmlNode
+	if err := xml.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &n, nil
+}
+
+// markdown converts the document body, returning the title if the
+// document has one in the Title style
+func (d *docx) markdown() (title, content string, err error) {
+	doc, err := d.part("word/document.xml")
+	if err != nil {
+		return "", "", err
+	}
+	body := doc.child("body")
+	if body == nil {
+		return "", "", errors.New("word/document.xml has no body")
+	}
+
+	var b strings.Builder
+	// the list being written, "" between lists
+	inList := ""
+	for i := range body.Nodes {
+		block := &body.Nodes[i]
+		switch block.XMLName.Local {
+		case "p":
+			style, list, level := d.paragraphStyle(block)
+			text := strings.TrimSpace(d.runs(block))
+			if text == "" {
+				continue
+			}
+			if style == "Title" && title == "" {
+				title = text
+				continue
+			}
+
+			if list != "" {
+				// a different list right after one needs a gap to start anew
+				if inList != list && b.Len() > 0 {
+					b.WriteString("\n")
+				}
+				inList = list
+				b.WriteString(d.listItem(list, level, text))
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			inList = ""
+			d.counters = make(map[string]int)
+			b.WriteString(paragraphMarkdown(style, text))
+
+		case "tbl":
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			inList = ""
+			b.WriteString(d.table(block))
+		}
+	}
+	return title, b.String(), nil
+}
+
+// paragraphStyle is a paragraph's style id and, for list items, its list
+// and level
+func (d *docx) paragraphStyle(p *xmlNode) (style, list string, level int) {
+	props := p.child("pPr")
+	if props == nil {
+		return "", "", 0
+	}
+	if s := props.child("pStyle"); s != nil {
+		style = s.attr("val")
+	}
+	if num := props.child("numPr"); num != nil {
+		if id := num.child("numId"); id != nil && id.attr("val") != "0" {
+			list = id.attr("val")
+		}
+		if lvl := num.child("ilvl"); lvl != nil {
+			level, _ = strconv.Atoi(lvl.attr("val"))
+		}
+	}
+	// word's own list style, for lists numbered by style rather than directly
+	if list == "" && strings.HasPrefix(style, "ListBullet") {
+		list = "bullet"
+	}
+	return style, list, level
+}
+
+var headingStyle = regexp.MustCompile(`^(?:Heading|heading)\s?([1-6])$`)
+
+func paragraphMarkdown(style, text string) string {
+	if m := headingStyle.FindStringSubmatch(style); m != nil {
+		level, _ := strconv.Atoi(m[1])
+		return strings.Repeat("#", level) + " " + text + "\n"
+	}
+	switch style {
+	case "Subtitle":
+		return "_" + text + "_\n"
+	case "Quote", "IntenseQuote":
+		return indent(text+"\n", "> ")
+	}
+	return text + "\n"
+}
+
+func (d *docx) listItem(list string, level int, text string) string {
+	lvl := strconv.Itoa(level)
+	marker := "- "
+	if d.numbered[list][lvl] {
+		key := list + "/" + lvl
+		d.counters[key]++
+		marker = strconv.Itoa(d.counters[key]) + ". "
+	}
+	// a deeper level starts its count again next time it's entered
+	for deeper := level + 1; deeper < 9; deeper++ {
+		delete(d.counters, list+"/"+strconv.Itoa(deeper))
+	}
+	return strings.Repeat("   ", level) + marker + text + "\n"
+}
+
+// runs converts a paragraph's runs, links and images to inline markdown
+func (d *docx) runs(p *xmlNode) string {
+	var b strings.Builder
+	for i := range p.Nodes {
+		n := &p.Nodes[i]
+		switch n.XMLName.Local {
+		case "r":
+			b.WriteString(d.run(n))
+		case "hyperlink":
+			text := d.runs(n)
+			url := d.rels[n.attr("id")]
+			if anchor := n.attr("anchor"); url == "" && anchor != "" {
+				url = "#" + anchor
+			}
+			if url == "" || strings.TrimSpace(text) == "" {
+				b.WriteString(text)
+			} else {
+				b.WriteString("[" + text + "](" + url + ")")
+			}
+		case "ins", "smartTag", "sdt", "sdtContent", "fldSimple":
+			b.WriteString(d.runs(n))
+		}
+	}
+	return b.String()
+}
+
+var monospaceFonts = regexp.MustCompile(`(?i)courier|consolas|mono|menlo|monaco`)
+
+func (d *docx) run(r *xmlNode) string {
+	var b strings.Builder
+	for i := range r.Nodes {
+		n := &r.Nodes[i]
+		switch n.XMLName.Local {
+		case "t":
+			b.WriteString(n.Text)
+		case "tab":
+			b.WriteString(" ")
+		case "br", "cr":
+			b.WriteString("  \n")
+		case "drawing", "pict":
+			if blip := n.find("blip"); blip != nil {
+				b.WriteString(d.image(blip.attr("embed"), n))
+			} else if data := n.find("imagedata"); data != nil {
+				b.WriteString(d.image(data.attr("id"), n))
+			}
+		}
+	}
+	text := b.String()
+	if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "![") {
+		return text
+	}
+
+	props := r.child("rPr")
+	if props == nil {
+		return text
+	}
+	// emphasis goes inside the spaces around a run, "**bold **" isn't bold
+	lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	trail := text[len(strings.TrimRight(text, " ")):]
+	text = strings.TrimSpace(text)
+	if fonts := props.child("rFonts"); fonts != nil && monospaceFonts.MatchString(fonts.attr("ascii")) {
+		text = "`" + text + "`"
+	}
+	if props.on("b") {
+		text = "**" + text + "**"
+	}
+	if props.on("i") {
+		text = "_" + text + "_"
+	}
+	if props.on("strike") {
+		text = "~~" + text + "~~"
+	}
+	return lead + text + trail
+}
+
+// image records an embedded image and leaves a placeholder for its url,
+// filled in once it's stored
+func (d *docx) image(rel string, drawing *xmlNode) string {
+	target := d.rels[rel]
+	if target == "" {
+		return ""
+	}
+	alt := ""
+	if props := drawing.find("docPr"); props != nil {
+		alt = props.attr("descr")
+		if alt == "" {
+			alt = props.attr("title")
+		}
+	}
+	alt = strings.Join(strings.Fields(alt), " ")
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return "![" + alt + "](" + target + ")"
+	}
+	d.images = append(d.images, docxImage{part: target, alt: alt})
+	return fmt.Sprintf("![%s](docx-image:%d)", alt, len(d.images)-1)
+}
+
+// table converts a table, its first row the header. Markdown tables have
+// a line per row, so paragraphs within a cell are joined with <br>
+func (d *docx) table(tbl *xmlNode) string {
+	var b strings.Builder
+	first := true
+	for i := range tbl.Nodes {
+		row := &tbl.Nodes[i]
+		if row.XMLName.Local != "tr" {
+			continue
+		}
+		var cells []string
+		for j := range row.Nodes {
+			cell := &row.Nodes[j]
+			if cell.XMLName.Local != "tc" {
+				continue
+			}
+			var paragraphs []string
+			for k := range cell.Nodes {
+				if cell.Nodes[k].XMLName.Local == "p" {
+					if text := strings.TrimSpace(d.runs(&cell.Nodes[k])); text != "" {
+						paragraphs = append(paragraphs, text)
+					}
+				}
+			}
+			text := strings.Join(paragraphs, "<br>")
+			text = strings.ReplaceAll(strings.ReplaceAll(text, "  \n", "<br>"), "|", `\|`)
+			cells = append(cells, text)
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if first {
+			b.WriteString(strings.Repeat("| --- ", len(cells)) + "|\n")
+			first = false
+		}
+	}
+	return b.String()
+}
+
+var docxImageRef = regexp.MustCompile(`docx-image:(\d+)`)
+
+// importDocx converts a docx file to a post in dir, storing its images in
+// the media library, and returns the post's path. It won't replace a post
+// unless told to
+func importDocx(file, slug, dir string, force bool, media mediaStore) (string, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	d, err := openDocx(&zr.Reader)
+	if err != nil {
+		return "", err
+	}
+	title, content, err := d.markdown()
+	if err != nil {
+		return "", err
+	}
+	if title == "" {
+		title = headingTitle(content)
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if slug == "" {
+		slug = sanitizeHeaderForID(title)
+	}
+	if !slugRegexp.MatchString(slug) {
+		return "", fmt.Errorf("no usable slug from the title %q, pass one with -slug", title)
+	}
+	dest := filepath.Join(dir, slug+".md")
+	if _, err := os.Stat(dest); err == nil && !force {
+		return "", fmt.Errorf("%s already exists, pass -force to overwrite it or -slug to pick another", dest)
+	}
+
+	s := &server{media: media}
+	urls := make([]string, len(d.images))
+	for i, img := range d.images {
+		data, err := d.read(img.part)
+		if err != nil {
+			return "", err
+		}
+		name := slug + path.Ext(img.part)
+		if img.alt != "" {
+			name = img.alt + path.Ext(img.part)
+		}
+		stored, err := s.upload(name, bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", img.part, err)
+		}
+		urls[i] = stored.URL
+	}
+	content = docxImageRef.ReplaceAllStringFunc(content, func(ref string) string {
+		i, _ := strconv.Atoi(docxImageRef.FindStringSubmatch(ref)[1])
+		return urls[i]
+	})
+
+	// a stub for the writer to fill in the rest of
+	post := fmt.Sprintf("Title: %s\nSlug: %s\nDate: %s\n\n---\n\n%s",
+		title, slug, time.Now().Format("2006-01-02"), content)
+	return dest, writeFileAtomic(dest, []byte(post))
+}
+
+// importCommand converts documents from elsewhere into posts:
+// import docx <file.docx>
+func importCommand(args []string) error {
+	if len(args) == 0 || args[0] != "docx" {
+		return fmt.Errorf("usage: bloog import docx [-slug slug] [-force] <file.docx>")
+	}
+
+	fs := flag.NewFlagSet("import docx", flag.ExitOnError)
+	slug := fs.String("slug", "", "the post's slug, from its title unless set")
+	force := fs.Bool("force", false, "overwrite a post that already has the slug")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: bloog import docx [-slug slug] [-force] <file.docx>")
+	}
+
+	dest, err := importDocx(fs.Arg(0), *slug, "./markdown", *force, newMediaStore(config().Media))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	fmt.Printf("imported %s to %s\n", fs.Arg(0), dest)
+	return nil
+}
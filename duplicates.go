@@ -0,0 +1,142 @@
+package blog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// words in a row compared between posts, and how few words a post can have
+// before it's too short to call a copy
+const (
+	shingleSize         = 5
+	minDuplicateWords   = 30
+	defaultDuplicateSim = 0.9
+)
+
+// duplicate is a post that looks like a copy of another, indexes into the
+// posts found in
+type duplicate struct {
+	copy, original int
+	sameTitle      bool
+	// how much of the two bodies' text is the same, 0 to 1
+	similarity float64
+}
+
+func (d DuplicatesConfig) threshold() float64 {
+	if d.Similarity <= 0 || d.Similarity > 1 {
+		return defaultDuplicateSim
+	}
+	return d.Similarity
+}
+
+// shingles is the set of hashes of every run of shingleSize words
+func shingles(words []string) map[uint64]bool {
+	set := make(map[uint64]bool)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		set[h.Sum64()] = true
+	}
+	return set
+}
+
+// jaccard is how much two sets overlap
+func jaccard(a, b map[uint64]bool) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for h := range a {
+		if b[h] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// earlier reports whether a came first: by date, then last change, then
+// file name, so the original keeps its place however the copy was made
+func earlier(a, b BlogPost) bool {
+	if !a.Date.IsZero() && !b.Date.IsZero() && !a.Date.Equal(b.Date) {
+		return a.Date.Before(b.Date)
+	}
+	if !a.LastModified.Equal(b.LastModified) && !a.LastModified.IsZero() && !b.LastModified.IsZero() {
+		return a.LastModified.Before(b.LastModified)
+	}
+	return a.SourcePath < b.SourcePath
+}
+
+// findDuplicates finds posts with the same title or a body nearly the same
+// as another's, like copies left behind by a migration. Posts are only
+// compared within their docs version, where repeating a page is expected
+func findDuplicates(posts []BlogPost) []duplicate {
+	type fingerprint struct {
+		title string
+		words int
+		set   map[uint64]bool
+	}
+	prints := make([]fingerprint, len(posts))
+	for i, post := range posts {
+		words := tokenize(plainText(string(post.Content)))
+		prints[i] = fingerprint{title: strings.Join(tokenize(post.Title), " "), words: len(words)}
+		// an encrypted body is never like another
+		if !post.Protected && len(words) >= minDuplicateWords {
+			prints[i].set = shingles(words)
+		}
+	}
+
+	threshold := config().Duplicates.threshold()
+	var found []duplicate
+	for i := range posts {
+		for j := i + 1; j < len(posts); j++ {
+			if posts[i].Version != posts[j].Version {
+				continue
+			}
+			a, b := prints[i], prints[j]
+			d := duplicate{sameTitle: a.title != "" && a.title == b.title}
+			if a.set != nil && b.set != nil &&
+				// sets this different in size can't be similar enough
+				float64(min(a.words, b.words)) >= threshold*float64(max(a.words, b.words)) {
+				d.similarity = jaccard(a.set, b.set)
+			}
+			if !d.sameTitle && d.similarity < threshold {
+				continue
+			}
+			d.copy, d.original = j, i
+			if earlier(posts[j], posts[i]) {
+				d.copy, d.original = i, j
+			}
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// duplicateErrors describes the duplicates for the startup report
+func duplicateErrors(posts []BlogPost, duplicates []duplicate) []error {
+	var errs []error
+	for _, d := range duplicates {
+		copy, original := postSource(posts[d.copy]), postSource(posts[d.original])
+		switch {
+		case d.similarity >= config().Duplicates.threshold():
+			errs = append(errs, fmt.Errorf("%s: looks like a copy of %s, %.0f%% the same", copy, original, d.similarity*100))
+		case d.sameTitle:
+			errs = append(errs, fmt.Errorf("%s: has the same title as %s", copy, original))
+		}
+	}
+	return errs
+}
+
+// canonicalizeDuplicates points the copies' canonical links at the
+// originals, so search engines count them as one page. Only bodies that
+// are nearly the same count, a shared title alone doesn't
+func canonicalizeDuplicates(posts []BlogPost, duplicates []duplicate) {
+	threshold := config().Duplicates.threshold()
+	for _, d := range duplicates {
+		if d.similarity < threshold || posts[d.copy].Canonical != "" {
+			continue
+		}
+		posts[d.copy].Canonical = posts[d.original].URL()
+	}
+}
@@ -0,0 +1,375 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// elasticsearch is a searchBackend for an external Elasticsearch or
+// OpenSearch cluster, both speak the same subset of the REST API used here
+type elasticsearch struct {
+	cfg    ElasticsearchConfig
+	client *http.Client
+}
+
+type elasticsearchDoc struct {
+	Title       string                 `json:"title"`
+	Slug        string                 `json:"slug"`
+	Description string                 `json:"description"`
+	Headers     []string               `json:"headers"`
+	Content     string                 `json:"content"`
+	Sections    []elasticsearchSection `json:"sections"`
+}
+
+// elasticsearchSection is the text under one of a post's headings, nested
+// in its document so a match can say which section it's in
+type elasticsearchSection struct {
+	Anchor  string `json:"anchor"`
+	Heading string `json:"heading"`
+	Content string `json:"content"`
+}
+
+func newElasticsearch(cfg ElasticsearchConfig) *elasticsearch {
+	if cfg.URL == "" {
+		cfg.URL = "http://localhost:9200"
+	}
+	if cfg.Index == "" {
+		cfg.Index = "bloog"
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+
+	return &elasticsearch{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// index bulk loads every post into a new index, then moves the alias
+// searches go through over to it. Searches keep the old index until the new
+// one is complete, and a failed load leaves it in place
+func (es *elasticsearch) index(posts []BlogPost) error {
+	name := fmt.Sprintf("%s-%d", es.cfg.Index, time.Now().UnixNano())
+	mapping := `{"mappings":{"properties":{` +
+		`"title":{"type":"text"},` +
+		`"slug":{"type":"keyword"},` +
+		`"description":{"type":"text"},` +
+		`"headers":{"type":"text"},` +
+		`"content":{"type":"text"},` +
+		`"sections":{"type":"nested","properties":{` +
+		`"anchor":{"type":"keyword","index":false},` +
+		`"heading":{"type":"text"},` +
+		`"content":{"type":"text"}}}}}}`
+	if err := es.expectOK(http.MethodPut, "/"+name, "application/json", []byte(mapping)); err != nil {
+		return err
+	}
+	if err := es.load(name, posts); err != nil {
+		es.drop(name)
+		return err
+	}
+
+	old, err := es.aliased()
+	if err != nil {
+		es.drop(name)
+		return err
+	}
+	actions := []interface{}{
+		map[string]interface{}{"add": map[string]string{"index": name, "alias": es.cfg.Index}},
+	}
+	for _, index := range old {
+		if index == es.cfg.Index {
+			// an index from before the alias, it has to go for the alias
+			// to take its name
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]string{"index": index}})
+		} else {
+			actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": index, "alias": es.cfg.Index}})
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"actions": actions})
+	if err := es.expectOK(http.MethodPost, "/_aliases", "application/json", body); err != nil {
+		es.drop(name)
+		return err
+	}
+
+	for _, index := range old {
+		if index != es.cfg.Index {
+			es.drop(index)
+		}
+	}
+	return nil
+}
+
+// load bulk loads the posts into the index
+func (es *elasticsearch) load(index string, posts []BlogPost) error {
+	var bulk bytes.Buffer
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+
+		action, _ := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_id": post.Slug},
+		})
+		var sections []elasticsearchSection
+		for _, section := range splitSections(string(post.Content)) {
+			sections = append(sections, elasticsearchSection{Anchor: section.anchor, Heading: section.heading, Content: section.text})
+		}
+		doc, err := json.Marshal(elasticsearchDoc{
+			Title:       post.Title,
+			Slug:        post.Slug,
+			Description: post.Description,
+			Headers:     post.Headers,
+			Content:     plainText(string(post.Content)),
+			Sections:    sections,
+		})
+		if err != nil {
+			return err
+		}
+
+		bulk.Write(action)
+		bulk.WriteByte('\n')
+		bulk.Write(doc)
+		bulk.WriteByte('\n')
+	}
+
+	if bulk.Len() == 0 {
+		return nil
+	}
+
+	return es.expectOK(http.MethodPost, "/"+index+"/_bulk?refresh=true", "application/x-ndjson", bulk.Bytes())
+}
+
+// aliased is the indexes the alias points at, or the index of that name
+// when it's one made before the alias
+func (es *elasticsearch) aliased() ([]string, error) {
+	resp, err := es.do(http.MethodGet, "/_alias/"+es.cfg.Index, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		head, err := es.do(http.MethodHead, "/"+es.cfg.Index, "application/json", nil)
+		if err != nil {
+			return nil, err
+		}
+		head.Body.Close()
+		if head.StatusCode == http.StatusOK {
+			return []string{es.cfg.Index}, nil
+		}
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("elasticsearch alias lookup failed: %s: %s", resp.Status, msg)
+	}
+
+	var indexes map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&indexes); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range indexes {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// drop deletes an index that isn't needed anymore, a failure only leaves
+// it taking up space
+func (es *elasticsearch) drop(index string) {
+	if err := es.expectOK(http.MethodDelete, "/"+index, "application/json", nil); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+	}
+}
+
+func (es *elasticsearch) search(query string, offset, limit int) ([]SearchResult, int, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, 0, nil
+	}
+
+	highlight := func(fields map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"encoder":   "html",
+			"pre_tags":  []string{"<mark>"},
+			"post_tags": []string{"</mark>"},
+			"fields":    fields,
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"from": offset,
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":    query,
+						"fields":   []string{"title^10", "headers^5", "description^3", "content"},
+						"operator": "and",
+					},
+				},
+				// picks the section that matches best, without changing
+				// which pages match
+				"should": map[string]interface{}{
+					"nested": map[string]interface{}{
+						"path":       "sections",
+						"score_mode": "max",
+						"query": map[string]interface{}{
+							"multi_match": map[string]interface{}{
+								"query":  query,
+								"fields": []string{"sections.heading^5", "sections.content"},
+							},
+						},
+						"inner_hits": map[string]interface{}{
+							"size": 1,
+							"highlight": highlight(map[string]interface{}{
+								"sections.heading": map[string]interface{}{"number_of_fragments": 0},
+								"sections.content": map[string]interface{}{"fragment_size": 160, "number_of_fragments": 1},
+							}),
+						},
+					},
+				},
+			},
+		},
+		"highlight": highlight(map[string]interface{}{
+			"title":   map[string]interface{}{"number_of_fragments": 0},
+			"content": map[string]interface{}{"fragment_size": 160, "number_of_fragments": 1},
+		}),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := es.do(http.MethodPost, "/"+es.cfg.Index+"/_search", "application/json", body)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("elasticsearch search failed: %s: %s", resp.Status, msg)
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score     float64             `json:"_score"`
+				Source    elasticsearchDoc    `json:"_source"`
+				Highlight map[string][]string `json:"highlight"`
+				InnerHits struct {
+					Sections struct {
+						Hits struct {
+							Hits []struct {
+								Source    elasticsearchSection `json:"_source"`
+								Highlight map[string][]string  `json:"highlight"`
+							} `json:"hits"`
+						} `json:"hits"`
+					} `json:"sections"`
+				} `json:"inner_hits"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+
+	var results []SearchResult
+	for _, hit := range result.Hits.Hits {
+		// the html encoder escapes the source text, so highlights are safe
+		title := template.HTML(template.HTMLEscapeString(hit.Source.Title))
+		if h := hit.Highlight["title"]; len(h) > 0 {
+			title = template.HTML(h[0])
+		}
+
+		snippet := template.HTML(template.HTMLEscapeString(truncateWords(hit.Source.Content, 160)))
+		if h := hit.Highlight["content"]; len(h) > 0 {
+			snippet = template.HTML("&hellip;" + h[0] + "&hellip;")
+		}
+
+		// the best section, when one matched, links to its heading
+		var heading template.HTML
+		anchor := ""
+		if sections := hit.InnerHits.Sections.Hits.Hits; len(sections) > 0 {
+			section := sections[0]
+			anchor = section.Source.Anchor
+			heading = template.HTML(template.HTMLEscapeString(section.Source.Heading))
+			if h := section.Highlight["sections.heading"]; len(h) > 0 {
+				heading = template.HTML(h[0])
+			}
+			if h := section.Highlight["sections.content"]; len(h) > 0 {
+				snippet = template.HTML("&hellip;" + h[0] + "&hellip;")
+			} else if section.Source.Content != "" {
+				snippet = template.HTML(template.HTMLEscapeString(truncateWords(section.Source.Content, 160)))
+			}
+		}
+
+		results = append(results, SearchResult{
+			Title:   title,
+			Slug:    hit.Source.Slug,
+			Heading: heading,
+			Anchor:  anchor,
+			Snippet: snippet,
+			Score:   int(hit.Score * 100),
+		})
+	}
+
+	return results, result.Hits.Total.Value, nil
+}
+
+func (es *elasticsearch) do(method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, es.cfg.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if es.cfg.Username != "" {
+		req.SetBasicAuth(es.cfg.Username, es.cfg.Password)
+	}
+
+	return es.client.Do(req)
+}
+
+func (es *elasticsearch) expectOK(method, path, contentType string, body []byte) error {
+	resp, err := es.do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("elasticsearch %s %s failed: %s: %s", method, path, resp.Status, msg)
+	}
+
+	// bulk requests succeed as a whole even when single items fail
+	var bulk struct {
+		Errors bool `json:"errors"`
+	}
+	if json.Unmarshal(msg, &bulk) == nil && bulk.Errors {
+		return fmt.Errorf("elasticsearch %s %s reported item errors: %s", method, path, msg)
+	}
+
+	return nil
+}
+
+// truncateWords shortens text to about n bytes without splitting a word
+func truncateWords(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	cut := strings.LastIndex(text[:n], " ")
+	if cut <= 0 {
+		cut = n
+	}
+	return text[:cut] + "..."
+}
@@ -0,0 +1,117 @@
+package blog
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyEnvironment picks the environment, BLOOG_ENV or the config's, and
+// applies what it overrides in the rest of the config
+func applyEnvironment(cfg *Config) error {
+	if name := os.Getenv("BLOOG_ENV"); name != "" {
+		cfg.Environment = name
+	}
+	if cfg.Environment == "" {
+		return nil
+	}
+	env, ok := cfg.Environments[cfg.Environment]
+	if !ok {
+		var names []string
+		for name := range cfg.Environments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("environment %q isn't one of the environments in the config (%s)", cfg.Environment, strings.Join(names, ", "))
+	}
+	if env.BaseURL != "" {
+		cfg.BaseURL = env.BaseURL
+	}
+	return nil
+}
+
+// environment is the settings of the environment the site runs as, none
+// for a site without environments
+func environment() EnvironmentConfig {
+	return config().Environments[config().Environment]
+}
+
+// withoutDrafts leaves out the posts marked Draft, unless the environment
+// shows them
+func withoutDrafts(posts []BlogPost) []BlogPost {
+	if environment().Drafts {
+		return posts
+	}
+	var published []BlogPost
+	for _, post := range posts {
+		if !post.Draft {
+			published = append(published, post)
+		}
+	}
+	return published
+}
+
+// robotsDirectives is what search engines are told about the post, the
+// environment's noindex winning over the post's own directives. Archived
+// posts are noindex
+func robotsDirectives(post BlogPost) string {
+	if robots := siteRobots(); robots != "" {
+		return robots
+	}
+	if post.Expired() && !post.noindexDirective() {
+		return strings.Join(append([]string{"noindex"}, post.Robots...), ", ")
+	}
+	return strings.Join(post.Robots, ", ")
+}
+
+// siteRobots is the robots directive for every page when the environment
+// is kept out of search engines
+func siteRobots() string {
+	if environment().NoIndex {
+		return "noindex, nofollow"
+	}
+	return ""
+}
+
+// noIndexSite asks search engines to leave every response alone, files and
+// feeds included
+func noIndexSite(c *gin.Context) {
+	c.Header("X-Robots-Tag", siteRobots())
+	c.Next()
+}
+
+func (a AnalyticsConfig) enabled() bool {
+	return a.Plausible != "" || a.Google != "" || a.HTML != ""
+}
+
+// analyticsHTML is the analytics snippet for the head of every page, unless
+// the environment turns it off, told which variant the page is when it's
+// one of a post's variants
+func analyticsHTML(variants ...*Variant) template.HTML {
+	a := config().Analytics
+	if enabled := environment().Analytics; !a.enabled() || enabled != nil && !*enabled {
+		return ""
+	}
+	var variant *Variant
+	if len(variants) > 0 {
+		variant = variants[0]
+	}
+	props, params, script := variantAnalytics(variant)
+	var b strings.Builder
+	if a.Plausible != "" {
+		fmt.Fprintf(&b, `<script defer data-domain="%s"%s src="https://plausible.io/js/script.js"></script>`+"\n",
+			template.HTMLEscapeString(a.Plausible), props)
+	}
+	if a.Google != "" {
+		id := template.JSEscapeString(a.Google)
+		fmt.Fprintf(&b, `<script async src="https://www.googletagmanager.com/gtag/js?id=%s"></script>`+"\n", template.URLQueryEscaper(a.Google))
+		fmt.Fprintf(&b, "<script>window.dataLayer = window.dataLayer || []; function gtag(){dataLayer.push(arguments);} gtag('js', new Date()); gtag('config', '%s'%s);</script>\n", id, params)
+	}
+	b.WriteString(script)
+	b.WriteString(a.HTML)
+	return template.HTML(b.String())
+}
@@ -0,0 +1,125 @@
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageBuffer holds back html until the handler is done, so a template that
+// fails halfway can still be swapped for the error page
+type pageBuffer struct {
+	gin.ResponseWriter
+	buf *bytes.Buffer
+}
+
+func (w *pageBuffer) Write(data []byte) (int, error) {
+	if w.buf == nil && !w.ResponseWriter.Written() &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.buf = &bytes.Buffer{}
+	}
+	if w.buf != nil {
+		return w.buf.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *pageBuffer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// recoverPages answers panics and failed templates with the 500 page
+// instead of gin's bare response or half a page. The page quotes the
+// request id, logged with the details, as a reference
+func (s *server) recoverPages(c *gin.Context) {
+	w := &pageBuffer{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		// a client that went away can't be shown anything
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+		ref := requestID(c)
+		log.Printf("Error %s: panic serving %s %s: %v\n%s", ref, c.Request.Method, c.Request.URL.Path, p, debug.Stack())
+		// static builds report it with the page's other errors
+		c.Error(fmt.Errorf("panic: %v", p))
+		c.Abort()
+		if config().Dev && s.discard(c, w) {
+			devError(c, ref, diagnostic{Message: fmt.Sprintf("panic: %v", p), Stack: string(debug.Stack())})
+			return
+		}
+		s.serverError(c, w, ref)
+	}()
+
+	c.Next()
+
+	if w.buf == nil {
+		return
+	}
+	if len(c.Errors) > 0 {
+		ref := requestID(c)
+		log.Printf("Error %s: rendering %s: %v\n", ref, c.Request.URL.Path, c.Errors.Last().Err)
+		if config().Dev && s.discard(c, w) {
+			devError(c, ref, templateDiagnostic(c.Errors.Last().Err))
+			return
+		}
+		s.serverError(c, w, ref)
+		return
+	}
+	if config().Dev {
+		w.ResponseWriter.Write(s.withDevErrors(w.buf.Bytes()))
+		return
+	}
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// discard drops whatever was held back, reporting whether there's still
+// time to send something else
+func (s *server) discard(c *gin.Context, w *pageBuffer) bool {
+	w.buf = nil
+	c.Writer = w.ResponseWriter
+	return !w.ResponseWriter.Written()
+}
+
+// serverError replaces whatever was held back with the 500 page, or json
+// for api requests and headless sites
+func (s *server) serverError(c *gin.Context, w *pageBuffer, ref string) {
+	if !s.discard(c, w) {
+		// too late, part of the response is already out
+		return
+	}
+	c.Writer.Header().Del("Content-Type")
+	if config().Headless || strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "reference": ref})
+		return
+	}
+
+	// the error page is held back too, in case it's the sidebar or a
+	// partial that's broken
+	page := &pageBuffer{ResponseWriter: w.ResponseWriter}
+	c.Writer = page
+	errs := len(c.Errors)
+	s.html(c, http.StatusInternalServerError, "500.html", gin.H{
+		"Title":       "Something went wrong",
+		"SidebarData": s.sidebar(c, ""),
+		"Reference":   ref,
+	})
+	c.Writer = w.ResponseWriter
+	if len(c.Errors) > errs || page.buf == nil {
+		c.Writer.Header().Del("Content-Type")
+		c.Data(http.StatusInternalServerError, "text/plain; charset=utf-8",
+			[]byte("Internal Server Error, reference "+ref+"\n"))
+		return
+	}
+	w.ResponseWriter.Write(page.buf.Bytes())
+}
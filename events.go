@@ -0,0 +1,272 @@
+package blog
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the events published as content changes
+const (
+	// every reload that changed something, and the first load
+	EventContentLoaded = "content.loaded"
+	EventPostAdded     = "post.added"
+	EventPostUpdated   = "post.updated"
+	EventPostRemoved   = "post.removed"
+	// a static build finished writing Dir
+	EventSiteBuilt = "site.built"
+)
+
+// Event is something that happened to the site's content
+type Event struct {
+	Type string `json:"type"`
+	// the post for post events, only the slug for removals
+	Slug string    `json:"slug,omitempty"`
+	Post *BlogPost `json:"-"`
+	// every post and what changed, for content.loaded
+	Posts   []BlogPost     `json:"-"`
+	Changes contentChanges `json:"-"`
+	// the changes without restricted posts, for anything sent elsewhere
+	PublicChanges contentChanges `json:"-"`
+	// set on the removal of a post only some readers could see
+	Restricted bool `json:"-"`
+	// set on the load at startup, when nothing is really new
+	Initial bool      `json:"initial,omitempty"`
+	Dir     string    `json:"dir,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// a subscription to every event type
+const anyEvent = "*"
+
+type eventHandler func(Event) error
+
+// eventBus hands events to whatever subscribed to their type, in the order
+// they subscribed. Handlers run synchronously, anything slow or talking to
+// the network should go in a goroutine of its own
+type eventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]eventHandler
+}
+
+// extensions subscribed from an init func, every server gets them
+var extensionHandlers = map[string][]eventHandler{}
+
+// Subscribe calls fn with every event of a type, or every event at all with
+// "*". Call it from an init func in a file of your own
+func Subscribe(eventType string, fn func(Event) error) {
+	extensionHandlers[eventType] = append(extensionHandlers[eventType], fn)
+}
+
+func newEventBus() *eventBus {
+	b := &eventBus{handlers: make(map[string][]eventHandler)}
+	for eventType, handlers := range extensionHandlers {
+		b.handlers[eventType] = append(b.handlers[eventType], handlers...)
+	}
+	return b
+}
+
+func (b *eventBus) subscribe(eventType string, fn eventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], fn)
+}
+
+// publish runs the handlers for the event, returning their errors
+func (b *eventBus) publish(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	handlers := append(append([]eventHandler(nil), b.handlers[e.Type]...), b.handlers[anyEvent]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishChanges publishes a reload, as a whole and post by post
+func (b *eventBus) publishChanges(posts []BlogPost, changes, public contentChanges, initial bool) error {
+	err := b.publish(Event{Type: EventContentLoaded, Posts: posts, Changes: changes, PublicChanges: public, Initial: initial})
+	if initial {
+		return err
+	}
+
+	bySlug := make(map[string]*BlogPost)
+	for i := range posts {
+		bySlug[posts[i].Slug] = &posts[i]
+	}
+	errs := []error{err}
+	for _, slug := range changes.Added {
+		errs = append(errs, b.publish(Event{Type: EventPostAdded, Slug: slug, Post: bySlug[slug]}))
+	}
+	for _, slug := range changes.Updated {
+		errs = append(errs, b.publish(Event{Type: EventPostUpdated, Slug: slug, Post: bySlug[slug]}))
+	}
+	publicRemoved := make(map[string]bool)
+	for _, slug := range public.Removed {
+		publicRemoved[slug] = true
+	}
+	for _, slug := range changes.Removed {
+		errs = append(errs, b.publish(Event{Type: EventPostRemoved, Slug: slug, Restricted: !publicRemoved[slug]}))
+	}
+	return errors.Join(errs...)
+}
+
+// subscribeBuiltins wires up the parts of the server that follow content
+// changes: the search indexes, feed hub, announcements and edge caches
+func (s *server) subscribeBuiltins(events *eventBus) {
+	// what the subscribers go by, the config the site was built from
+	cfg := config()
+
+	events.subscribe(EventContentLoaded, func(e Event) error {
+		s.quick.index(publicPosts(currentPosts(e.Posts)))
+		return s.search.index(publicPosts(currentPosts(e.Posts)))
+	})
+
+	// embedding calls a remote api, the old index serves until it's done
+	if s.semantic != nil {
+		events.subscribe(EventContentLoaded, func(e Event) error {
+			go s.indexSemantic(s.semantic.generation.Add(1), e.Posts)
+			return nil
+		})
+	}
+
+	// push the posts to algolia in the background, it's not needed to serve.
+	// A command's copy of the content isn't what the site is serving
+	if cfg.Algolia.enabled() && !s.offline {
+		events.subscribe(EventContentLoaded, func(e Event) error {
+			go func() {
+				if err := syncAlgolia(cfg.Algolia, publicPosts(currentPosts(e.Posts))); err != nil {
+					log.Printf("Error syncing algolia index: %v\n", err)
+				}
+			}()
+			return nil
+		})
+	}
+
+	// new posts are pushed to feed subscribers through the hub
+	if cfg.WebSub.Hub != "" {
+		events.subscribe(EventContentLoaded, func(e Event) error {
+			if !e.Initial && len(e.Changes.Added) > 0 {
+				go func() {
+					if err := notifyHub(cfg.WebSub.Hub); err != nil {
+						log.Printf("Error notifying websub hub: %v\n", err)
+					}
+				}()
+			}
+			return nil
+		})
+	}
+
+	if cfg.Social.enabled() {
+		events.subscribe(EventContentLoaded, func(e Event) error {
+			if !e.Initial && len(e.Changes.Added) > 0 {
+				go s.announce(e.Changes.Added)
+			}
+			return nil
+		})
+	}
+
+	// edge caches only need telling about changes after startup
+	if cfg.CDN.enabled() {
+		events.subscribe(EventContentLoaded, func(e Event) error {
+			if !e.Initial {
+				go func() {
+					if err := purgeCDN(cfg.CDN, purgeURLs(e.Changes)); err != nil {
+						log.Printf("Error purging cdn: %v\n", err)
+					}
+				}()
+			}
+			return nil
+		})
+	}
+
+	for _, hook := range cfg.Webhooks {
+		hook := hook
+		for _, eventType := range hook.eventTypes() {
+			events.subscribe(eventType, func(e Event) error {
+				if e.Initial {
+					return nil
+				}
+				// a build exits as soon as it's done, it can't wait around
+				if e.Type == EventSiteBuilt {
+					return hook.send(e)
+				}
+				go func() {
+					if err := hook.send(e); err != nil {
+						log.Printf("Error sending %s webhook: %v\n", e.Type, err)
+					}
+				}()
+				return nil
+			})
+		}
+	}
+}
+
+func (w WebhookConfig) eventTypes() []string {
+	if len(w.Events) == 0 {
+		return []string{anyEvent}
+	}
+	return w.Events
+}
+
+// webhookPost is what's sent about a post, not the whole thing
+type webhookPost struct {
+	Title string   `json:"title"`
+	URL   string   `json:"url"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// send posts the event as json, signed like github signs its webhooks when
+// there's a secret
+func (w WebhookConfig) send(e Event) error {
+	payload := struct {
+		Event
+		Post    *webhookPost    `json:"post,omitempty"`
+		Changes *contentChanges `json:"changes,omitempty"`
+	}{Event: e}
+	if e.Restricted {
+		return nil
+	}
+	if e.Type == EventContentLoaded {
+		payload.Changes = &e.PublicChanges
+	}
+	if e.Post != nil {
+		if e.Post.restricted() {
+			return nil
+		}
+		payload.Post = &webhookPost{Title: e.Post.Title, URL: baseURL() + e.Post.URL(), Tags: e.Post.Tags}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bloog-Event", e.Type)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return jsonRequest(req, nil)
+}
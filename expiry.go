@@ -0,0 +1,83 @@
+package blog
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// what happens to a post after its ExpiryDate
+const (
+	expiredUnpublish = "unpublish"
+	expiredArchive   = "archive"
+)
+
+func expiredMode() string {
+	if config().Expired == "" {
+		return expiredUnpublish
+	}
+	return config().Expired
+}
+
+func checkExpired(mode string) error {
+	switch mode {
+	case "", expiredUnpublish, expiredArchive:
+		return nil
+	}
+	return fmt.Errorf("expired: unknown mode %q, expected unpublish or archive", mode)
+}
+
+// Expired reports whether the post's ExpiryDate has passed
+func (p BlogPost) Expired() bool {
+	return !p.ExpiryDate.IsZero() && !p.ExpiryDate.After(time.Now())
+}
+
+// withoutExpired leaves out the posts past their ExpiryDate, unless they're
+// kept as archived
+func withoutExpired(posts []BlogPost) []BlogPost {
+	if expiredMode() == expiredArchive {
+		return posts
+	}
+	var current []BlogPost
+	for _, post := range posts {
+		if !post.Expired() {
+			current = append(current, post)
+		}
+	}
+	return current
+}
+
+// scheduleExpiry reloads the content when the next post expires, so it's
+// taken down on time rather than at the next change. Called with reloadMu
+// held
+func (s *server) scheduleExpiry(posts []BlogPost) {
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	if expiredMode() != expiredUnpublish {
+		return
+	}
+
+	var next time.Time
+	for _, post := range posts {
+		if post.ExpiryDate.IsZero() || post.Expired() {
+			continue
+		}
+		if next.IsZero() || post.ExpiryDate.Before(next) {
+			next = post.ExpiryDate
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+
+	s.expiry = time.AfterFunc(time.Until(next), func() {
+		changes, err := s.reload()
+		if err != nil {
+			log.Printf("Error reloading content: %v\n", err)
+			return
+		}
+		log.Printf("Unpublished expired posts: %s\n", changes)
+	})
+}
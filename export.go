@@ -0,0 +1,197 @@
+package blog
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hugoFrontmatter is a post's metadata as hugo names it
+type hugoFrontmatter struct {
+	Title       string    `yaml:"title"`
+	Date        time.Time `yaml:"date,omitempty"`
+	Slug        string    `yaml:"slug,omitempty"`
+	URL         string    `yaml:"url"`
+	Aliases     []string  `yaml:"aliases,omitempty"`
+	Description string    `yaml:"description,omitempty"`
+	Weight      int       `yaml:"weight,omitempty"`
+	Tags        []string  `yaml:"tags,omitempty"`
+	Categories  []string  `yaml:"categories,omitempty"`
+	Images      []string  `yaml:"images,omitempty"`
+}
+
+// jekyllFrontmatter is a post's metadata as jekyll names it, the aliases
+// are for the jekyll-redirect-from plugin
+type jekyllFrontmatter struct {
+	Layout       string    `yaml:"layout"`
+	Title        string    `yaml:"title"`
+	Date         time.Time `yaml:"date,omitempty"`
+	Permalink    string    `yaml:"permalink"`
+	RedirectFrom []string  `yaml:"redirect_from,omitempty"`
+	Description  string    `yaml:"description,omitempty"`
+	Order        int       `yaml:"order,omitempty"`
+	Tags         []string  `yaml:"tags,omitempty"`
+	Categories   []string  `yaml:"categories,omitempty"`
+	// jekyll-seo-tag's og:image
+	Image string `yaml:"image,omitempty"`
+}
+
+// exportPath is where a post goes in a hugo or jekyll site. Dated posts
+// are blog posts to both, the rest are pages. Their urls are pinned in the
+// frontmatter either way, so links keep working
+func exportPath(format string, post BlogPost) string {
+	home := post.SourcePath == "index.md"
+	slug := filepath.FromSlash(post.Slug)
+	switch format {
+	case "hugo":
+		if home {
+			return filepath.Join("content", "_index.md")
+		}
+		if !post.Date.IsZero() {
+			return filepath.Join("content", "posts", slug+".md")
+		}
+		return filepath.Join("content", slug+".md")
+	default:
+		if home {
+			return "index.md"
+		}
+		if !post.Date.IsZero() {
+			return filepath.Join("_posts", post.Date.Format("2006-01-02")+"-"+filepath.Base(slug)+".md")
+		}
+		return slug + ".md"
+	}
+}
+
+// exportFrontmatter is a post's metadata for a hugo or jekyll site
+func exportFrontmatter(format string, post BlogPost) interface{} {
+	url, aliases := post.URL(), []string(nil)
+	// the home page is served at / and its slug
+	if post.SourcePath == "index.md" {
+		url, aliases = "/", []string{post.URL()}
+	}
+	description := post.Description
+	// a made up one would only go stale in the exported frontmatter
+	if description == "" && !post.MetaDescriptionDerived {
+		description = post.MetaDescription
+	}
+	var categories []string
+	if post.Parent != "" {
+		categories = []string{post.Parent}
+	}
+	var images []string
+	if post.Image != "" {
+		images = []string{post.Image}
+	}
+
+	if format == "hugo" {
+		return hugoFrontmatter{
+			Title: post.Title, Date: post.Date, Slug: filepath.Base(post.Slug), URL: url, Aliases: aliases,
+			Description: description, Weight: post.Order, Tags: post.Tags, Categories: categories,
+			Images: images,
+		}
+	}
+	layout := "page"
+	if !post.Date.IsZero() {
+		layout = "post"
+	}
+	return jekyllFrontmatter{
+		Layout: layout, Title: post.Title, Date: post.Date, Permalink: url, RedirectFrom: aliases,
+		Description: description, Order: post.Order, Tags: post.Tags, Categories: categories,
+		Image: post.Image,
+	}
+}
+
+// exportConfig is a starting config for the exported site. Posts can have
+// html in them, which hugo leaves out unless it's told not to
+func exportConfig(format string) (string, []byte) {
+	if format == "hugo" {
+		return "hugo.yaml", []byte(fmt.Sprintf("baseURL: %s\ntaxonomies:\n  tag: tags\n  category: categories\nmarkup:\n  goldmark:\n    renderer:\n      unsafe: true\n", baseURL()+"/"))
+	}
+	return "_config.yml", []byte(fmt.Sprintf("url: %s\nplugins:\n  - jekyll-redirect-from\n", baseURL()))
+}
+
+// exportSite writes every post as a hugo or jekyll site in out, with the
+// static files copied where they'll keep their /static urls. Restricted
+// posts are left out, neither has a way to keep them restricted, unless
+// withRestricted is set. Protected posts always are
+func (s *server) exportSite(format, out string, withRestricted bool) (int, error) {
+	var errs []error
+	n := 0
+	for _, post := range s.allPosts() {
+		if post.Slug == "" {
+			continue
+		}
+		if post.Protected {
+			fmt.Fprintf(os.Stderr, "skipping %s, it's protected\n", postSource(post))
+			continue
+		}
+		if post.restricted() {
+			if !withRestricted {
+				fmt.Fprintf(os.Stderr, "skipping %s, it's restricted\n", postSource(post))
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "warning: %s is restricted, %s will publish it to everyone\n", postSource(post), format)
+		}
+
+		body, err := s.sourceMarkdown(post)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", postSource(post), err))
+			continue
+		}
+		meta, err := yaml.Marshal(exportFrontmatter(format, post))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", postSource(post), err))
+			continue
+		}
+
+		file := filepath.Join(out, exportPath(format, post))
+		if err := writeFileAtomic(file, []byte("---\n"+string(meta)+"---\n\n"+body+"\n")); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+
+	static := filepath.Join(out, "static")
+	if format == "hugo" {
+		// hugo serves its static dir at the root
+		static = filepath.Join(out, "static", "static")
+	}
+	if _, err := copyDir("static", static); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+
+	name, cfg := exportConfig(format)
+	if _, err := os.Stat(filepath.Join(out, name)); errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, writeFileAtomic(filepath.Join(out, name), cfg))
+	}
+	return n, errors.Join(errs...)
+}
+
+// exportCommand writes the content out as a hugo or jekyll site:
+// export hugo|jekyll [-out dir] [-restricted]
+func exportCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "hugo" && args[0] != "jekyll") {
+		return fmt.Errorf("usage: bloog export hugo|jekyll [-out dir] [-restricted]")
+	}
+	format := args[0]
+
+	fs := flag.NewFlagSet("export "+format, flag.ExitOnError)
+	out := fs.String("out", format, "directory to write the site to")
+	withRestricted := fs.Bool("restricted", false, "export restricted posts too, they'll be public")
+	fs.Parse(args[1:])
+
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	n, err := s.exportSite(format, *out, *withRestricted)
+	fmt.Printf("exported %d posts to %s\n", n, strings.TrimSuffix(*out, "/"))
+	return err
+}
@@ -0,0 +1,64 @@
+package blog
+
+import (
+	"net"
+	"time"
+)
+
+// internals for the tests in blog_test
+var MdToHTML = mdToHTML
+
+// WithRender renders with render settings for the length of fn
+func WithRender(render RenderConfig, fn func()) {
+	defer setConfig(*config())
+	cfg := *config()
+	cfg.Render = render
+	setConfig(cfg)
+	fn()
+}
+
+var MinifyCSS = minifyCSS
+
+var Snippet = snippet
+
+// WithBaseURL serves the site from base for the length of fn
+func WithBaseURL(base string, fn func()) {
+	defer setConfig(*config())
+	cfg := *config()
+	cfg.BaseURL = base
+	setConfig(cfg)
+	fn()
+}
+
+// NextCron is the first time after t the cron expression matches
+func NextCron(expr string, t time.Time) (time.Time, error) {
+	spec, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return spec.next(t), nil
+}
+
+var (
+	SplitList         = splitList
+	ParseDate         = parseDate
+	ContentFile       = contentFile
+	DefaultTitle      = defaultTitle
+	ParseMarkdownFile = parseMarkdownFile
+)
+
+// LookupGeo finds ip in the MaxMind DB at path, as its raw record and as
+// the Geo the middleware would set
+func LookupGeo(path, ip string) (Geo, map[string]interface{}, error) {
+	db, err := openGeoDB(path)
+	if err != nil {
+		return Geo{}, nil, err
+	}
+	record, err := db.lookup(net.ParseIP(ip))
+	return db.geo(net.ParseIP(ip)), record, err
+}
+
+// GeoRecords are a search tree node's left and right records
+func GeoRecords(tree []byte, recordSize, node uint) (uint, uint) {
+	return (&geoDB{tree: tree, recordSize: recordSize}).records(node)
+}
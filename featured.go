@@ -0,0 +1,34 @@
+package blog
+
+import (
+	"sort"
+)
+
+// pinnedBefore reports whether a is pinned above b: featured posts come
+// before the rest, lowest Weight first. Anything else keeps its place
+func pinnedBefore(a, b BlogPost) bool {
+	if a.Featured != b.Featured {
+		return a.Featured
+	}
+	return a.Featured && a.Weight < b.Weight
+}
+
+// pinFeatured moves the featured posts to the front of a listing
+func pinFeatured(posts []BlogPost) []BlogPost {
+	pinned := append([]BlogPost(nil), posts...)
+	sort.SliceStable(pinned, func(i, j int) bool {
+		return pinnedBefore(pinned[i], pinned[j])
+	})
+	return pinned
+}
+
+// featuredPosts is the public posts marked Featured, lowest Weight first
+func featuredPosts(posts []BlogPost) []BlogPost {
+	var featured []BlogPost
+	for _, post := range publicPosts(posts) {
+		if post.Featured && post.Slug != "" {
+			featured = append(featured, post)
+		}
+	}
+	return pinFeatured(featured)
+}
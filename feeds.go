@@ -0,0 +1,356 @@
+package blog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many of the latest posts the feeds carry
+const feedSize = 20
+
+const (
+	atomNS  = "http://www.w3.org/2005/Atom"
+	mediaNS = "http://search.yahoo.com/mrss/"
+)
+
+type rssLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// mediaContent is media rss' thumbnail, which more readers show than
+// enclosures
+type mediaContent struct {
+	URL    string `xml:"url,attr"`
+	Medium string `xml:"medium,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+	Media       *mediaContent `xml:"media:content"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	AtomNS  string   `xml:"xmlns:atom,attr"`
+	MediaNS string   `xml:"xmlns:media,attr"`
+	Channel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Links       []rssLink `xml:"atom:link"`
+		Items       []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Summary string      `xml:"summary,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// published is when a post counts as published for feeds, its Date or
+// failing that when it last changed
+func published(post BlogPost) time.Time {
+	if !post.Date.IsZero() {
+		return post.Date
+	}
+	return post.LastModified
+}
+
+// imageEnclosure is the type and size of a post's feature image for the
+// feeds. The size is only known for images under /static, 0 says so, and
+// the type is application/octet-stream when the extension doesn't give it
+func imageEnclosure(post BlogPost) (string, int64) {
+	typ := "application/octet-stream"
+	u, err := url.Parse(post.ImageURL())
+	if err != nil {
+		return typ, 0
+	}
+	if t := mime.TypeByExtension(path.Ext(u.Path)); t != "" {
+		typ = t
+	}
+	site, err := url.Parse(baseURL())
+	if err != nil || u.Host != site.Host {
+		return typ, 0
+	}
+	if rest, ok := strings.CutPrefix(u.Path, strings.TrimRight(site.Path, "/")+"/static/"); ok {
+		if info, err := os.Stat(filepath.Join("static", filepath.FromSlash(rest))); err == nil {
+			return typ, info.Size()
+		}
+	}
+	return typ, 0
+}
+
+// feedPosts returns the featured posts and then the newest
+func feedPosts(posts []BlogPost) []BlogPost {
+	var latest []BlogPost
+	for _, post := range publicPosts(currentPosts(posts)) {
+		if post.Slug != "" {
+			latest = append(latest, post)
+		}
+	}
+
+	sort.SliceStable(latest, func(i, j int) bool {
+		return published(latest[i]).After(published(latest[j]))
+	})
+	latest = pinFeatured(latest)
+
+	if len(latest) > feedSize {
+		latest = latest[:feedSize]
+	}
+	return latest
+}
+
+func siteTitle() string {
+	if host, err := url.Parse(baseURL()); err == nil && host.Host != "" {
+		return host.Host
+	}
+	return baseURL()
+}
+
+// feedLink is a feed in the head of every page, for browsers and feed
+// readers to find
+type feedLink struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+// feedLinks are the site's feeds, once there's a post to put in them
+func (s *server) feedLinks() []feedLink {
+	for _, post := range publicPosts(s.allPosts()) {
+		if post.Slug != "" {
+			return []feedLink{
+				{Type: "application/rss+xml", Title: siteTitle() + " (RSS)", Href: baseURL() + "/feed.xml"},
+				{Type: "application/atom+xml", Title: siteTitle() + " (Atom)", Href: baseURL() + "/atom.xml"},
+			}
+		}
+	}
+	return nil
+}
+
+// subscriberCount is how feed aggregators say how many of their users
+// follow a feed, in their user agent: "Feedly/1.0 (+https://feedly.com; 42
+// subscribers)"
+var subscriberCount = regexp.MustCompile(`(?i)(\d+)\s+(?:subscribers|readers)`)
+
+// FeedSubscribers is what an aggregator last said about a feed
+type FeedSubscribers struct {
+	Fetcher string
+	Feed    string
+	Count   int
+	Seen    time.Time
+}
+
+// the most aggregator and feed pairs kept, anyone can send a user agent
+// claiming to be one
+const maxFeedFetchers = 500
+
+// feedFetchers keeps the subscriber counts aggregators report, saved to
+// path so they outlast a restart
+type feedFetchers struct {
+	*counterStore[FeedSubscribers]
+}
+
+func newFeedFetchers(path string) (*feedFetchers, error) {
+	// the one that's gone longest without fetching makes room, however many
+	// subscribers it had
+	store, err := newCounterStore(path, "subscriber counts", maxFeedFetchers, func(subscribers FeedSubscribers) (int, time.Time) {
+		return 0, subscribers.Seen
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feedFetchers{store}, nil
+}
+
+func (f *feedFetchers) record(feed, userAgent string) {
+	m := subscriberCount.FindStringSubmatch(userAgent)
+	if m == nil {
+		return
+	}
+	count, _ := strconv.Atoi(m[1])
+	fetcher := strings.TrimSpace(strings.FieldsFunc(userAgent, func(r rune) bool { return r == '/' || r == '(' })[0])
+
+	f.update(fetcher+" "+feed, func(subscribers *FeedSubscribers) {
+		*subscribers = FeedSubscribers{Fetcher: fetcher, Feed: feed, Count: count, Seen: time.Now()}
+	})
+}
+
+// list is every aggregator's count, biggest first
+func (f *feedFetchers) list() []FeedSubscribers {
+	list := f.all()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Fetcher+list[i].Feed < list[j].Fetcher+list[j].Feed
+	})
+	return list
+}
+
+// feedLinkHeaders advertises the hub and the feed's own url, which is how
+// websub subscribers discover where to subscribe
+func feedLinkHeaders(c *gin.Context, self string) {
+	if config().WebSub.Hub == "" {
+		return
+	}
+	c.Writer.Header().Add("Link", "<"+config().WebSub.Hub+">; rel=hub")
+	c.Writer.Header().Add("Link", "<"+self+">; rel=self")
+}
+
+func (s *server) handleRSS(c *gin.Context) {
+	self := baseURL() + "/feed.xml"
+
+	feed := rssFeed{Version: "2.0", AtomNS: atomNS, MediaNS: mediaNS}
+	feed.Channel.Title = siteTitle()
+	feed.Channel.Link = baseURL() + "/"
+	feed.Channel.Description = "Latest posts from " + siteTitle()
+	feed.Channel.Links = []rssLink{{Href: self, Rel: "self", Type: "application/rss+xml"}}
+	if config().WebSub.Hub != "" {
+		feed.Channel.Links = append(feed.Channel.Links, rssLink{Href: config().WebSub.Hub, Rel: "hub"})
+	}
+
+	for _, post := range feedPosts(s.allPosts()) {
+		link := baseURL() + post.URL()
+		item := rssItem{
+			Title:       post.Title,
+			Link:        link,
+			GUID:        link,
+			Description: string(post.Content),
+		}
+		if t := published(post); !t.IsZero() {
+			item.PubDate = t.Format(time.RFC1123Z)
+		}
+		if post.Image != "" {
+			typ, length := imageEnclosure(post)
+			item.Enclosure = &rssEnclosure{URL: post.ImageURL(), Length: length, Type: typ}
+			item.Media = &mediaContent{URL: post.ImageURL(), Medium: "image", Type: typ}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	if !s.offline {
+		s.subscribers.record("/feed.xml", c.Request.UserAgent())
+	}
+	feedLinkHeaders(c, self)
+	writeXMLType(c, "application/rss+xml; charset=utf-8", feed)
+}
+
+func (s *server) handleAtom(c *gin.Context) {
+	self := baseURL() + "/atom.xml"
+	posts := feedPosts(s.allPosts())
+
+	feed := atomFeed{
+		NS:    atomNS,
+		Title: siteTitle(),
+		ID:    baseURL() + "/",
+		Links: []atomLink{
+			{Href: baseURL() + "/"},
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	if config().WebSub.Hub != "" {
+		feed.Links = append(feed.Links, atomLink{Href: config().WebSub.Hub, Rel: "hub"})
+	}
+
+	var updated time.Time
+	for _, post := range posts {
+		link := baseURL() + post.URL()
+		if post.LastModified.After(updated) {
+			updated = post.LastModified
+		}
+		links := []atomLink{{Href: link}}
+		if post.Image != "" {
+			typ, length := imageEnclosure(post)
+			links = append(links, atomLink{Href: post.ImageURL(), Rel: "enclosure", Type: typ, Length: length})
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   post.Title,
+			ID:      link,
+			Links:   links,
+			Updated: post.LastModified.UTC().Format(time.RFC3339),
+			Summary: post.Description,
+			Content: atomContent{Type: "html", Body: string(post.Content)},
+		})
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	if !s.offline {
+		s.subscribers.record("/atom.xml", c.Request.UserAgent())
+	}
+	feedLinkHeaders(c, self)
+	writeXMLType(c, "application/atom+xml; charset=utf-8", feed)
+}
+
+// notifyHub tells the websub hub the feeds changed, it fetches them itself
+// and pushes the new entries to subscribers
+func notifyHub(hub string) error {
+	form := url.Values{"hub.mode": {"publish"}, "hub.url": {baseURL() + "/feed.xml", baseURL() + "/atom.xml"}}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(hub, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("websub hub notification failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package blog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// the metadata keys posts use, toml and json frontmatter can spell them
+// title, meta_description or metaDescription
+var metaKeys = []string{
+	"Title", "Slug", "Parent", "Description", "Order", "Date", "Tags", "Access",
+	"OpenAPI", "CrossPost", "MetaDescription", "MetaPropertyTitle",
+	"MetaPropertyDescription", "MetaOgURL", "Image", "Priority", "ChangeFreq", "Robots", "Draft", "Password",
+	"Featured", "Weight", "ExpiryDate",
+}
+
+// hugo's names for the same things
+var metaAliases = map[string]string{
+	// hugo themes' feature image, the first of images
+	"images":        "Image",
+	"featuredimage": "Image",
+}
+
+var metaLine = regexp.MustCompile(`^\w+:`)
+
+// splitFrontmatter separates a markdown file's metadata from its content.
+// Only a block at the very start is metadata: "Key: value" lines ended by
+// a --- line, a yaml block between --- lines, or like hugo a toml block
+// between +++ lines or a json object. Any other --- is a horizontal rule,
+// and a file without metadata is all content
+func splitFrontmatter(content string) (map[string]string, string, error) {
+	// deal with rouge \r's
+	content = strings.ReplaceAll(content, "\r", "")
+
+	switch {
+	case strings.HasPrefix(content, "+++\n"):
+		block, body, ok := delimitedBlock(content[len("+++\n"):], "+++")
+		if !ok {
+			return nil, "", errors.New("toml frontmatter has no closing +++")
+		}
+		var fields map[string]interface{}
+		if err := toml.Unmarshal([]byte(block), &fields); err != nil {
+			return nil, "", fmt.Errorf("toml frontmatter: %w", err)
+		}
+		return metaFields(fields), body, nil
+
+	case strings.HasPrefix(content, "---\n"):
+		block, body, ok := delimitedBlock(content[len("---\n"):], "---")
+		if !ok {
+			return nil, "", errors.New("frontmatter has no closing ---")
+		}
+		var fields map[string]interface{}
+		if err := yaml.Unmarshal([]byte(block), &fields); err != nil {
+			// not quite yaml, "Title: Go: the good parts" say, read it
+			// as plain lines
+			return parseMetaData(block), body, nil
+		}
+		return metaFields(fields), body, nil
+
+	case strings.HasPrefix(strings.TrimLeft(content, " \n"), "{"):
+		dec := json.NewDecoder(strings.NewReader(content))
+		var fields map[string]interface{}
+		if err := dec.Decode(&fields); err != nil {
+			return nil, "", fmt.Errorf("json frontmatter: %w", err)
+		}
+		return metaFields(fields), content[dec.InputOffset():], nil
+	}
+
+	// "Key: value" lines up to the first --- line, when the file starts
+	// with one
+	first := strings.TrimSpace(content)
+	if metaLine.MatchString(first) {
+		if block, body, ok := delimitedBlock(content, "---"); ok {
+			return parseMetaData(block), body, nil
+		}
+	}
+	return map[string]string{}, content, nil
+}
+
+// delimitedBlock splits content at the first line that's just delim
+func delimitedBlock(content, delim string) (string, string, bool) {
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if strings.TrimSpace(line) == delim {
+			return content[:offset], content[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return "", "", false
+}
+
+var firstHeading = regexp.MustCompile(`(?m)^#\s+(.+)`)
+
+// headingTitle is the text of the first top level heading, for posts
+// without a title
+func headingTitle(md string) string {
+	if m := firstHeading.FindStringSubmatch(md); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+// postDefaults fills in what a file without metadata leaves out from its
+// name, the slug and the title when there's no heading to take it from
+func postDefaults(post *BlogPost, name string) {
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if base == "" {
+		return
+	}
+	if post.Slug == "" {
+		post.Slug = sanitizeHeaderForID(strings.ReplaceAll(base, "_", "-"))
+	}
+	if post.Title == "" {
+		post.Title = defaultTitle(name)
+	}
+}
+
+// defaultTitle is the title of a post from file name, for posts without one
+func defaultTitle(name string) string {
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if base == "" {
+		return ""
+	}
+	title := strings.NewReplacer("-", " ", "_", " ").Replace(base)
+	first, size := utf8.DecodeRuneInString(title)
+	return string(unicode.ToUpper(first)) + title[size:]
+}
+
+// metaFields converts toml or json fields to metadata as the "Key: value"
+// lines would have it, lists comma separated
+func metaFields(fields map[string]interface{}) map[string]string {
+	canonical := make(map[string]string)
+	for _, key := range metaKeys {
+		canonical[strings.ToLower(key)] = key
+	}
+
+	meta := make(map[string]string)
+	for key, value := range fields {
+		name := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+		if alias, ok := metaAliases[name]; ok {
+			key = alias
+		} else if name == "weight" && key != "Weight" {
+			// hugo's weight, read leniently, unlike bloog's own Weight
+			key = "weight"
+		} else if known, ok := canonical[name]; ok {
+			key = known
+		}
+		meta[key] = metaValue(value)
+	}
+	return meta
+}
+
+func metaValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case toml.LocalDate:
+		return v.String()
+	case toml.LocalDateTime:
+		return v.AsTime(time.UTC).Format(time.RFC3339)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = metaValue(item)
+		}
+		return strings.Join(items, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,46 @@
+package blog_test
+
+import (
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestDefaultTitle(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"getting-started.md", "Getting started"},
+		{"docs/my_first_post.md", "My first post"},
+		{"élan-vital.md", "Élan vital"},
+		{"ñandú.md", "Ñandú"},
+		{"日本語.md", "日本語"},
+		{".md", ""},
+	} {
+		if got := blog.DefaultTitle(tt.in); got != tt.want {
+			t.Errorf("DefaultTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWeight(t *testing.T) {
+	for _, tt := range []struct {
+		in            string
+		order, weight int
+	}{
+		{"---\nweight: 2\n---\n", 2, 2},
+		{"+++\nweight = 1.5\n+++\n", 9999, 0},
+		{"---\nOrder: 1\nweight: 3\n---\n", 1, 3},
+		{"---\nWeight: 4\n---\n", 9999, 4},
+	} {
+		post, err := blog.ParseMarkdownFile([]byte(tt.in))
+		if err != nil {
+			t.Errorf("ParseMarkdownFile(%q): %v", tt.in, err)
+			continue
+		}
+		if post.Order != tt.order || post.Weight != tt.weight {
+			t.Errorf("ParseMarkdownFile(%q): Order %d, Weight %d, want %d, %d", tt.in, post.Order, post.Weight, tt.order, tt.weight)
+		}
+	}
+	if _, err := blog.ParseMarkdownFile([]byte("---\nWeight: 1.5\n---\n")); err == nil {
+		t.Error("ParseMarkdownFile: no error for Weight 1.5")
+	}
+}
@@ -0,0 +1,311 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Geo is where a request came from, as far as the GeoIP database knows.
+// Region is the first subdivision, a state or province, and only in city
+// databases
+type Geo struct {
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	Region      string `json:"region,omitempty"`
+	RegionName  string `json:"region_name,omitempty"`
+}
+
+// the context key the middleware keeps a request's Geo under
+const geoKey = "geo"
+
+// mmdbMetadataStart marks the start of a MaxMind DB's metadata, at the end
+// of the file
+var mmdbMetadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// geoDB reads a MaxMind DB (GeoLite2 or GeoIP2, country or city) held in
+// memory: a binary tree on the address's bits whose leaves point into a
+// data section of maps, strings and numbers
+type geoDB struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// where IPv4 addresses start in an IPv6 tree, after 96 zero bits
+	ipv4Start uint
+}
+
+func openGeoDB(path string) (*geoDB, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	at := bytes.LastIndex(file, mmdbMetadataStart)
+	if at < 0 {
+		return nil, fmt.Errorf("%s isn't a MaxMind DB", path)
+	}
+	metadata, _, err := (&geoDB{data: file[at+len(mmdbMetadataStart):]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading metadata: %w", path, err)
+	}
+	fields, _ := metadata.(map[string]interface{})
+	db := &geoDB{
+		nodeCount:  mmdbUint(fields["node_count"]),
+		recordSize: mmdbUint(fields["record_size"]),
+		ipVersion:  mmdbUint(fields["ip_version"]),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	// the data section follows 16 zero bytes after the tree
+	if treeSize+16 > uint(at) {
+		return nil, fmt.Errorf("%s: the search tree is bigger than the file", path)
+	}
+	db.tree = file[:treeSize]
+	db.data = file[treeSize+16 : at]
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start, _ = db.records(db.ipv4Start)
+		}
+	}
+	return db, nil
+}
+
+func mmdbUint(v interface{}) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case uint32:
+		return uint(n)
+	case uint16:
+		return uint(n)
+	}
+	return 0
+}
+
+// records are a node's left and right records
+func (db *geoDB) records(node uint) (uint, uint) {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]),
+			uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b)), uint(binary.BigEndian.Uint32(b[4:]))
+	}
+}
+
+// lookup finds the record for ip, nil when the database has none
+func (db *geoDB) lookup(ip net.IP) (map[string]interface{}, error) {
+	bits := ip.To16()
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		left, right := db.records(node)
+		if bits[i/8]&(0x80>>(i%8)) == 0 {
+			node = left
+		} else {
+			node = right
+		}
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	record, _, err := db.decode(node - db.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := record.(map[string]interface{})
+	return fields, nil
+}
+
+var errGeoDBCorrupt = errors.New("corrupt MaxMind DB data")
+
+// decode reads the value at offset in the data section and returns it with
+// the offset after it
+func (db *geoDB) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(db.data)) {
+		return nil, 0, errGeoDBCorrupt
+	}
+	ctrl := db.data[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	// pointers to values elsewhere, to share repeated ones
+	if kind == 1 {
+		size := uint(ctrl>>3)&3 + 1
+		if offset+size > uint(len(db.data)) {
+			return nil, 0, errGeoDBCorrupt
+		}
+		p := uint(ctrl & 7)
+		if size == 4 {
+			p = 0
+		}
+		for _, b := range db.data[offset : offset+size] {
+			p = p<<8 | uint(b)
+		}
+		p += [...]uint{0, 2048, 526336, 0}[size-1]
+		value, _, err := db.decode(p)
+		return value, offset + size, err
+	}
+
+	if kind == 0 {
+		if offset >= uint(len(db.data)) {
+			return nil, 0, errGeoDBCorrupt
+		}
+		kind = 7 + uint(db.data[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(db.data)) {
+			return nil, 0, errGeoDBCorrupt
+		}
+		extra := uint(0)
+		for _, b := range db.data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		size = [...]uint{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := db.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		list := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			offset = next
+		}
+		return list, offset, nil
+	case 14: // boolean, the size is the value
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(db.data)) {
+		return nil, 0, errGeoDBCorrupt
+	}
+	b := db.data[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2: // utf-8 string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errGeoDBCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errGeoDBCorrupt
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case 5, 6, 9, 8: // uint16, uint32, uint64, int32
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if kind == 8 {
+			return int32(n), offset, nil
+		}
+		return n, offset, nil
+	case 4, 10: // bytes, uint128
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", errGeoDBCorrupt, kind)
+}
+
+// geo is where ip is, the zero Geo when the database doesn't know
+func (db *geoDB) geo(ip net.IP) Geo {
+	record, err := db.lookup(ip)
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+	}
+	var g Geo
+	if country, ok := record["country"].(map[string]interface{}); ok {
+		g.Country, g.CountryName = geoNames(country)
+	}
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if region, ok := subdivisions[0].(map[string]interface{}); ok {
+			g.Region, g.RegionName = geoNames(region)
+		}
+	}
+	return g
+}
+
+// geoNames are a country's or region's iso code and english name
+func geoNames(place map[string]interface{}) (string, string) {
+	code, _ := place["iso_code"].(string)
+	names, _ := place["names"].(map[string]interface{})
+	name, _ := names["en"].(string)
+	return code, name
+}
+
+// geoIP looks up where each request came from, for templates as .Geo and
+// /api/geo
+func (s *server) geoIP(c *gin.Context) {
+	if ip := net.ParseIP(c.ClientIP()); ip != nil {
+		c.Set(geoKey, s.geo.geo(ip))
+	}
+	c.Next()
+}
+
+// requestGeo is where the request came from, nil without a GeoIP database
+func requestGeo(c *gin.Context) *Geo {
+	if g, ok := c.Get(geoKey); ok {
+		geo := g.(Geo)
+		return &geo
+	}
+	return nil
+}
+
+// handleGeo tells the visitor where the site thinks they are
+func handleGeo(c *gin.Context) {
+	geo := requestGeo(c)
+	if geo == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "GeoIP isn't configured"})
+		return
+	}
+	c.JSON(http.StatusOK, geo)
+}
@@ -0,0 +1,350 @@
+package blog_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+// mmdb builds a MaxMind DB's data section a value at a time
+type mmdb struct{ data []byte }
+
+// uint128 is a MaxMind DB uint128, kept as its bytes
+type uint128 []byte
+
+// pointer is a pointer to offset, in the given number of bytes
+type pointer struct{ offset, size int }
+
+// ctrl writes the control byte for a value of kind and size, with the
+// extended type and size bytes that follow it
+func (m *mmdb) ctrl(kind, size int) {
+	first := kind
+	if kind > 7 {
+		first = 0
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		first = first<<5 | size
+	case size < 285:
+		first, extra = first<<5|29, []byte{byte(size - 29)}
+	case size < 65821:
+		n := size - 285
+		first, extra = first<<5|30, []byte{byte(n >> 8), byte(n)}
+	default:
+		n := size - 65821
+		first, extra = first<<5|31, []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	m.data = append(m.data, byte(first))
+	if kind > 7 {
+		m.data = append(m.data, byte(kind-7))
+	}
+	m.data = append(m.data, extra...)
+}
+
+// put writes v and returns its offset
+func (m *mmdb) put(v interface{}) int {
+	offset := len(m.data)
+	number := func(kind int, n uint64) {
+		var b []byte
+		for ; n > 0; n >>= 8 {
+			b = append([]byte{byte(n)}, b...)
+		}
+		m.ctrl(kind, len(b))
+		m.data = append(m.data, b...)
+	}
+	switch v := v.(type) {
+	case pointer:
+		p := v.offset - [...]int{0, 2048, 526336, 0}[v.size-1]
+		if v.size == 4 {
+			m.data = append(m.data, 1<<5|3<<3)
+		} else {
+			m.data = append(m.data, byte(1<<5|(v.size-1)<<3|p>>(8*v.size)&7))
+		}
+		for i := v.size - 1; i >= 0; i-- {
+			m.data = append(m.data, byte(p>>(8*i)))
+		}
+	case string:
+		m.ctrl(2, len(v))
+		m.data = append(m.data, v...)
+	case float64:
+		m.ctrl(3, 8)
+		m.data = binary.BigEndian.AppendUint64(m.data, math.Float64bits(v))
+	case []byte:
+		m.ctrl(4, len(v))
+		m.data = append(m.data, v...)
+	case uint16:
+		number(5, uint64(v))
+	case uint32:
+		number(6, uint64(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		m.ctrl(7, len(v))
+		for _, key := range keys {
+			m.put(key)
+			m.put(v[key])
+		}
+	case int32:
+		m.ctrl(8, 4)
+		m.data = binary.BigEndian.AppendUint32(m.data, uint32(v))
+	case uint64:
+		number(9, v)
+	case uint128:
+		m.ctrl(10, len(v))
+		m.data = append(m.data, v...)
+	case []interface{}:
+		m.ctrl(11, len(v))
+		for _, item := range v {
+			m.put(item)
+		}
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		m.ctrl(14, size)
+	case float32:
+		m.ctrl(15, 4)
+		m.data = binary.BigEndian.AppendUint32(m.data, math.Float32bits(v))
+	default:
+		panic("mmdb: can't write a " + reflect.TypeOf(v).String())
+	}
+	return offset
+}
+
+// tree is a search tree, its nodes' records either a node, a negative
+// data offset less one or 0 for nothing, as the root is never a child
+type tree [][2]int
+
+func (t *tree) insert(ip net.IP, bits, offset int) {
+	if len(*t) == 0 {
+		*t = append(*t, [2]int{})
+	}
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(ip[i/8]>>(7-i%8)) & 1
+		if i == bits-1 {
+			(*t)[node][bit] = -offset - 1
+			break
+		}
+		if (*t)[node][bit] <= 0 {
+			*t = append(*t, [2]int{})
+			(*t)[node][bit] = len(*t) - 1
+		}
+		node = (*t)[node][bit]
+	}
+}
+
+// writeGeoDB writes a MaxMind DB with t and the data section m to a file
+func writeGeoDB(t *testing.T, nodes tree, m *mmdb, recordSize, ipVersion int) string {
+	t.Helper()
+	count := len(nodes)
+	value := func(record int) uint32 {
+		switch {
+		case record > 0:
+			return uint32(record)
+		case record < 0:
+			return uint32(count + 16 - record - 1)
+		}
+		return uint32(count)
+	}
+
+	var file []byte
+	for _, node := range nodes {
+		left, right := value(node[0]), value(node[1])
+		switch recordSize {
+		case 24:
+			file = append(file, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			file = append(file, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0xf),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			file = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(file, left), right)
+		}
+	}
+	file = append(file, make([]byte, 16)...)
+	file = append(file, m.data...)
+	file = append(file, "\xab\xcd\xefMaxMind.com"...)
+	metadata := &mmdb{}
+	metadata.put(map[string]interface{}{
+		"database_type": "Test",
+		"node_count":    uint32(count),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+	})
+	file = append(file, metadata.data...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGeoDB(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			m := &mmdb{}
+			germany := m.put(map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}},
+				"subdivisions": []interface{}{
+					map[string]interface{}{"iso_code": "BE", "names": map[string]interface{}{"en": "Berlin"}},
+				},
+			})
+			france := m.put(map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "FR", "names": map[string]interface{}{"en": "France"}},
+			})
+
+			var nodes tree
+			if ipVersion == 4 {
+				nodes.insert(net.ParseIP("1.2.3.0").To4(), 24, germany)
+			} else {
+				// ipv4 addresses are under ::/96 in an ipv6 tree
+				nodes.insert(net.ParseIP("::1.2.3.0"), 96+24, germany)
+				nodes.insert(net.ParseIP("2001:db8::"), 32, france)
+			}
+			path := writeGeoDB(t, nodes, m, recordSize, ipVersion)
+
+			lookups := []struct {
+				ip   string
+				want blog.Geo
+			}{
+				{"1.2.3.4", blog.Geo{Country: "DE", CountryName: "Germany", Region: "BE", RegionName: "Berlin"}},
+				{"1.2.4.4", blog.Geo{}},
+				{"2001:db8::1", blog.Geo{}},
+			}
+			if ipVersion == 6 {
+				lookups[2].want = blog.Geo{Country: "FR", CountryName: "France"}
+			}
+			for _, tt := range lookups {
+				got, _, err := blog.LookupGeo(path, tt.ip)
+				if err != nil || got != tt.want {
+					t.Errorf("%d bit records, ipv%d: LookupGeo(%s) = %+v, %v, want %+v", recordSize, ipVersion, tt.ip, got, err, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestGeoDBTypes(t *testing.T) {
+	m := &mmdb{}
+	en := m.put("en")
+	m.put(bytes.Repeat([]byte{1}, 3000))
+	germany := m.put("Germany")
+	m.put(bytes.Repeat([]byte{2}, 530000))
+	berlin := m.put(map[string]interface{}{"en": "Berlin"})
+	long := m.put(string(bytes.Repeat([]byte("a"), 300)))
+
+	record := len(m.data)
+	m.ctrl(7, 12)
+	m.put("country")
+	m.ctrl(7, 2)
+	m.put("iso_code")
+	m.put("DE")
+	m.put("names")
+	m.ctrl(7, 1)
+	m.put(pointer{en, 1})
+	m.put(pointer{germany, 2})
+	m.put("subdivisions")
+	m.ctrl(11, 1)
+	m.ctrl(7, 2)
+	m.put("iso_code")
+	m.put("BE")
+	m.put("names")
+	m.put(pointer{berlin, 3})
+	m.put("long")
+	m.put(pointer{long, 4})
+	m.put("medium")
+	m.put(string(bytes.Repeat([]byte("b"), 40)))
+	m.put("double")
+	m.put(52.52)
+	m.put("float")
+	m.put(float32(13.4))
+	m.put("uint16")
+	m.put(uint16(443))
+	m.put("uint32")
+	m.put(uint32(1 << 30))
+	m.put("int32")
+	m.put(int32(-5))
+	m.put("uint64")
+	m.put(uint64(1 << 40))
+	m.put("uint128")
+	m.put(uint128{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	m.put("flags")
+	m.put([]interface{}{true, false})
+
+	var nodes tree
+	nodes.insert(net.ParseIP("1.2.3.0").To4(), 24, record)
+	path := writeGeoDB(t, nodes, m, 24, 4)
+
+	geo, got, err := blog.LookupGeo(path, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}},
+		"subdivisions": []interface{}{
+			map[string]interface{}{"iso_code": "BE", "names": map[string]interface{}{"en": "Berlin"}},
+		},
+		"long":    string(bytes.Repeat([]byte("a"), 300)),
+		"medium":  string(bytes.Repeat([]byte("b"), 40)),
+		"double":  52.52,
+		"float":   float32(13.4),
+		"uint16":  uint64(443),
+		"uint32":  uint64(1 << 30),
+		"int32":   int32(-5),
+		"uint64":  uint64(1 << 40),
+		"uint128": []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		"flags":   []interface{}{true, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record = %#v, want %#v", got, want)
+	}
+	if want := (blog.Geo{Country: "DE", CountryName: "Germany", Region: "BE", RegionName: "Berlin"}); geo != want {
+		t.Errorf("Geo = %+v, want %+v", geo, want)
+	}
+}
+
+func TestGeoDBCorrupt(t *testing.T) {
+	m := &mmdb{}
+	// a map that says it has more entries than there are
+	record := m.put(map[string]interface{}{"country": "DE"})
+	m.data[record] = 7<<5 | 5
+
+	var nodes tree
+	nodes.insert(net.ParseIP("1.2.3.0").To4(), 24, record)
+	path := writeGeoDB(t, nodes, m, 24, 4)
+	if _, _, err := blog.LookupGeo(path, "1.2.3.4"); err == nil {
+		t.Error("LookupGeo: no error for a truncated record")
+	}
+}
+
+func TestGeoRecords(t *testing.T) {
+	for _, tt := range []struct {
+		recordSize  uint
+		tree        []byte
+		left, right uint
+	}{
+		{24, []byte{0, 0, 0, 0, 0, 0, 0x12, 0x34, 0x56, 0x65, 0x43, 0x21}, 0x123456, 0x654321},
+		// the middle byte has the top nibble of each record
+		{28, []byte{0, 0, 0, 0, 0, 0, 0, 0x12, 0x34, 0x56, 0xab, 0x65, 0x43, 0x21}, 0xa123456, 0xb654321},
+		{32, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0xf1, 0x23, 0x45, 0x67, 0xf7, 0x65, 0x43, 0x21}, 0xf1234567, 0xf7654321},
+	} {
+		left, right := blog.GeoRecords(tt.tree, tt.recordSize, 1)
+		if left != tt.left || right != tt.right {
+			t.Errorf("%d bit records = %#x, %#x, want %#x, %#x", tt.recordSize, left, right, tt.left, tt.right)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package blog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oauthStateCookie = "bloog_oauth_state"
+
+var githubClient = &http.Client{Timeout: 15 * time.Second}
+
+type githubUser struct {
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+func (g GitHubConfig) enabled() bool {
+	return g.ClientID != "" && g.ClientSecret != ""
+}
+
+func (g GitHubConfig) isMaintainer(login string) bool {
+	for _, m := range g.Maintainers {
+		if strings.EqualFold(m, login) {
+			return true
+		}
+	}
+	return false
+}
+
+func githubAuthorizeURL(cfg GitHubConfig, state string) string {
+	q := url.Values{}
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", baseURL()+"/auth/github/callback")
+	q.Set("scope", "read:user")
+	q.Set("state", state)
+
+	return "https://github.com/login/oauth/authorize?" + q.Encode()
+}
+
+// githubExchange trades the oauth code for a token and looks up its user
+func githubExchange(cfg GitHubConfig, code string) (githubUser, error) {
+	var user githubUser
+
+	form := url.Values{}
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	form.Set("code", code)
+
+	req, err := http.NewRequest(http.MethodPost, "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return user, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return user, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return user, err
+	}
+	if token.AccessToken == "" {
+		return user, fmt.Errorf("github token exchange failed: %s", token.Error)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+	if err != nil {
+		return user, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err = githubClient.Do(req)
+	if err != nil {
+		return user, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return user, fmt.Errorf("github user lookup failed: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	return user, err
+}
+
+// localPath only lets through same site paths, to avoid open redirects
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+func (s *server) handleGitHubLogin(c *gin.Context) {
+	state := newID()
+	setCookie(c, oauthStateCookie, state+"|"+localPath(c.Query("return")), 600)
+	c.Redirect(http.StatusFound, githubAuthorizeURL(config().GitHub, state))
+}
+
+func (s *server) handleGitHubCallback(c *gin.Context) {
+	cookie, err := c.Cookie(oauthStateCookie)
+	state, returnPath, _ := strings.Cut(cookie, "|")
+	if err != nil || state == "" || state != c.Query("state") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+		return
+	}
+	setCookie(c, oauthStateCookie, "", -1)
+
+	user, err := githubExchange(config().GitHub, c.Query("code"))
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "GitHub sign in failed"})
+		return
+	}
+
+	name := user.Name
+	if name == "" {
+		name = user.Login
+	}
+	s.sessions.start(c, Session{GitHubLogin: user.Login, Name: name, AvatarURL: user.AvatarURL})
+
+	c.Redirect(http.StatusFound, localPath(returnPath))
+}
@@ -0,0 +1,278 @@
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	gparser "github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	ghtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// goldmarkEngine renders with goldmark, commonmark and github flavoured
+// markdown as github renders it, set up to match gomarkdown's output where
+// the site depends on it: raw html, smart punctuation, heading ids the
+// sidebar can link to and external links opening in a new tab
+type goldmarkEngine struct{}
+
+func (goldmarkEngine) render(md []byte) []byte {
+	var transformers []util.PrioritizedValue
+	for i, hook := range activeHooks() {
+		if hook.Goldmark != nil {
+			transformers = append(transformers, util.Prioritized(goldmarkHook(hook.Goldmark), 1000+i))
+		}
+	}
+	transformers = append(transformers, util.Prioritized(goldmarkHook(externalLinks), 2000))
+
+	gm := goldmark.New(
+		goldmark.WithExtensions(extension.GFM, extension.DefinitionList, extension.Footnote, extension.Typographer),
+		goldmark.WithParserOptions(gparser.WithAutoHeadingID(), gparser.WithASTTransformers(transformers...)),
+		goldmark.WithRendererOptions(
+			ghtml.WithUnsafe(),
+			ghtml.WithXHTML(),
+			renderer.WithNodeRenderers(util.Prioritized(figureRenderer{}, 500), util.Prioritized(divRenderer{}, 500), util.Prioritized(diagramRenderer{}, 500)),
+		),
+	)
+
+	var buf bytes.Buffer
+	ctx := gparser.NewContext(gparser.WithIDs(&headingIDs{used: make(map[string]bool)}))
+	if err := gm.Convert(md, &buf, gparser.WithContext(ctx)); err != nil {
+		log.Printf("Error rendering markdown: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+// goldmarkHook runs a hook's goldmark func as an ast transformer
+type goldmarkHook func(doc gast.Node, source []byte)
+
+func (fn goldmarkHook) Transform(doc *gast.Document, reader text.Reader, pc gparser.Context) {
+	fn(doc, reader.Source())
+}
+
+// headingIDs makes the same ids as the sidebar's links, with a number on
+// repeats
+type headingIDs struct {
+	used map[string]bool
+}
+
+func (ids *headingIDs) Generate(value []byte, kind gast.NodeKind) []byte {
+	id := sanitizeHeaderForID(string(value))
+	if id == "" {
+		id = "heading"
+	}
+	unique := id
+	for i := 1; ids.used[unique]; i++ {
+		unique = id + "-" + strconv.Itoa(i)
+	}
+	ids.used[unique] = true
+	return []byte(unique)
+}
+
+func (ids *headingIDs) Put(value []byte) {
+	ids.used[string(value)] = true
+}
+
+// externalLinks opens links off the site in a new tab, like gomarkdown's
+// HrefTargetBlank
+func externalLinks(doc gast.Node, source []byte) {
+	gast.Walk(doc, func(node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if link, ok := node.(*gast.Link); ok && entering {
+			dest := string(link.Destination)
+			if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+				link.SetAttributeString("target", []byte("_blank"))
+			}
+		}
+		return gast.WalkContinue, nil
+	})
+}
+
+// goldmarkWalk collects the nodes fn picks before any are changed, as
+// walking a tree while moving its nodes around skips some
+func goldmarkWalk(doc gast.Node, fn func(gast.Node) bool) []gast.Node {
+	var nodes []gast.Node
+	gast.Walk(doc, func(node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if entering && fn(node) {
+			nodes = append(nodes, node)
+		}
+		return gast.WalkContinue, nil
+	})
+	return nodes
+}
+
+var kindFigure = gast.NewNodeKind("Figure")
+
+// figureNode is an image with a caption, goldmark has no figures of its own
+type figureNode struct {
+	gast.BaseBlock
+	caption []byte
+}
+
+func (n *figureNode) Kind() gast.NodeKind {
+	return kindFigure
+}
+
+func (n *figureNode) Dump(source []byte, level int) {
+	gast.DumpHelper(n, source, level, map[string]string{"Caption": string(n.caption)}, nil)
+}
+
+type figureRenderer struct{}
+
+func (figureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindFigure, func(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if entering {
+			w.WriteString("<figure>")
+		} else {
+			fmt.Fprintf(w, "<figcaption>%s</figcaption>\n</figure>\n", html.EscapeString(string(node.(*figureNode).caption)))
+		}
+		return gast.WalkContinue, nil
+	})
+}
+
+// goldmarkFigures is the figures hook for goldmark
+func goldmarkFigures(doc gast.Node, source []byte) {
+	paragraphs := goldmarkWalk(doc, func(node gast.Node) bool {
+		return node.Kind() == gast.KindParagraph
+	})
+	for _, p := range paragraphs {
+		img, ok := p.FirstChild().(*gast.Image)
+		if !ok || p.ChildCount() != 1 || len(img.Title) == 0 {
+			continue
+		}
+		figure := &figureNode{caption: img.Title}
+		p.Parent().ReplaceChild(p.Parent(), p, figure)
+		figure.AppendChild(figure, img)
+	}
+}
+
+// goldmarkTableClass is the table-class hook for goldmark
+func goldmarkTableClass(doc gast.Node, source []byte) {
+	if config().Render.TableClass == "" {
+		return
+	}
+	for _, table := range goldmarkWalk(doc, func(node gast.Node) bool { return node.Kind() == east.KindTable }) {
+		table.SetAttributeString("class", []byte(config().Render.TableClass))
+	}
+}
+
+var kindDiv = gast.NewNodeKind("Div")
+
+// divNode wraps its children in a div with classes
+type divNode struct {
+	gast.BaseBlock
+	class string
+}
+
+func (n *divNode) Kind() gast.NodeKind {
+	return kindDiv
+}
+
+func (n *divNode) Dump(source []byte, level int) {
+	gast.DumpHelper(n, source, level, map[string]string{"Class": n.class}, nil)
+}
+
+type divRenderer struct{}
+
+func (divRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindDiv, func(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if entering {
+			fmt.Fprintf(w, "<div class=\"%s\">\n", html.EscapeString(node.(*divNode).class))
+		} else {
+			w.WriteString("</div>\n")
+		}
+		return gast.WalkContinue, nil
+	})
+}
+
+// goldmarkTableWrap is the table-wrap hook for goldmark
+func goldmarkTableWrap(doc gast.Node, source []byte) {
+	for _, table := range goldmarkWalk(doc, func(node gast.Node) bool { return node.Kind() == east.KindTable }) {
+		div := &divNode{class: tableWrapClasses()}
+		table.Parent().ReplaceChild(table.Parent(), table, div)
+		div.AppendChild(div, table)
+	}
+}
+
+// goldmarkCallouts is the callouts hook for goldmark. The marker is parsed
+// as a few bits of text ("[", "!NOTE", "]"), so it's matched in the source
+// and the text covering it dropped
+func goldmarkCallouts(doc gast.Node, source []byte) {
+	quotes := goldmarkWalk(doc, func(node gast.Node) bool { return node.Kind() == gast.KindBlockquote })
+	for _, quote := range quotes {
+		p, ok := quote.FirstChild().(*gast.Paragraph)
+		if !ok || p.Lines().Len() == 0 {
+			continue
+		}
+		line := p.Lines().At(0)
+
+		for _, kind := range []string{"note", "tip", "important", "warning", "caution"} {
+			marker := []byte("[!" + strings.ToUpper(kind) + "]")
+			if !bytes.HasPrefix(bytes.TrimSpace(line.Value(source)), marker) {
+				continue
+			}
+			end := line.Start + bytes.Index(line.Value(source), marker) + len(marker)
+			for child := p.FirstChild(); child != nil; {
+				next := child.NextSibling()
+				t, ok := child.(*gast.Text)
+				if !ok || t.Segment.Start >= end {
+					break
+				}
+				if t.Segment.Stop <= end {
+					p.RemoveChild(p, t)
+				} else {
+					t.Segment = t.Segment.WithStart(end)
+				}
+				child = next
+			}
+			// the rest of the first line, up to the break
+			if t, ok := p.FirstChild().(*gast.Text); ok && len(bytes.TrimSpace(t.Segment.Value(source))) == 0 {
+				p.RemoveChild(p, t)
+			}
+			if p.ChildCount() == 0 {
+				quote.RemoveChild(quote, p)
+			}
+
+			quote.SetAttributeString("class", []byte("callout callout-"+kind))
+			title := gast.NewParagraph()
+			title.SetAttributeString("class", []byte("callout-title"))
+			title.AppendChild(title, gast.NewString([]byte(strings.ToUpper(kind[:1])+kind[1:])))
+			if quote.FirstChild() == nil {
+				quote.AppendChild(quote, title)
+			} else {
+				quote.InsertBefore(quote, quote.FirstChild(), title)
+			}
+			break
+		}
+	}
+}
+
+// goldmarkImages is the image-cdn hook for goldmark
+func goldmarkImages(doc gast.Node, source []byte) {
+	for _, node := range goldmarkWalk(doc, func(node gast.Node) bool { return node.Kind() == gast.KindImage }) {
+		img := node.(*gast.Image)
+		src, fragment, _ := strings.Cut(string(img.Destination), "#")
+		preset, ok := config().Images.Presets[fragment]
+		if !ok {
+			preset = config().Images.Presets[config().Images.Preset]
+		}
+		rewritten, ok := imageCDNURL(config().Images, src, preset)
+		if !ok {
+			continue
+		}
+		img.Destination = []byte(rewritten)
+		if config().Images.Srcset {
+			if srcset := imageSrcset(config().Images, src); srcset != "" {
+				img.SetAttributeString("srcset", []byte(srcset))
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package blog
+
+import (
+	"errors"
+	"net/http"
+)
+
+// DefaultConfig is what a site without a bloog.yaml runs with
+func DefaultConfig() Config {
+	return Config{
+		BaseURL: "http://localhost:8080",
+		DataDir: "./data",
+	}
+}
+
+// NewHandler loads the markdown in contentDir and returns the handler that
+// serves it, for tests and for embedding the site in another server. The
+// config is global, so there's one site per process. Templates and static
+// files are read from the working directory, as when serving
+func NewHandler(contentDir string, cfg Config) (http.Handler, error) {
+	if err := applyEnvironment(&cfg); err != nil {
+		return nil, err
+	}
+	setConfig(cfg)
+
+	s, err := newServer(contentDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	return s.live, nil
+}
+
+// start checks the posts against the site's routes
+func (s *server) start() error {
+	s.report.add(problemWarning, errors.Join(shadowedPosts(s.current().engine.Routes(), s.allPosts())...))
+	return nil
+}
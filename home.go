@@ -0,0 +1,141 @@
+package blog
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// posts on each page of the home page's listing unless home.per_page says
+const homePostsPerPage = 10
+
+// the home page modes
+const (
+	homePage     = "page"
+	homeList     = "list"
+	homeRedirect = "redirect"
+)
+
+func (h HomeConfig) mode() string {
+	if h.Mode == "" {
+		return homePage
+	}
+	return h.Mode
+}
+
+func (h HomeConfig) perPage() int {
+	if h.PerPage < 1 {
+		return homePostsPerPage
+	}
+	return h.PerPage
+}
+
+func checkHome(h HomeConfig) error {
+	switch h.mode() {
+	case homePage, homeList:
+		return nil
+	case homeRedirect:
+		if h.Slug == "" {
+			return fmt.Errorf("home: mode redirect needs a slug to redirect to")
+		}
+		return nil
+	}
+	return fmt.Errorf("home: unknown mode %q, expected page, list or redirect", h.Mode)
+}
+
+// homePost is the post the home page shows, home.slug or index.md
+func (s *server) homePost() (BlogPost, bool) {
+	if config().Home.Slug != "" {
+		return s.post(config().Home.Slug)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.home == nil {
+		return BlogPost{}, false
+	}
+	return *s.home, true
+}
+
+// newestFirst is the public posts by date, most recent first, falling back
+// to the last change for posts without one, with the featured ones pinned
+// above the rest
+func newestFirst(posts []BlogPost) []BlogPost {
+	var listed []BlogPost
+	for _, post := range publicPosts(posts) {
+		if post.Slug != "" && post.SourcePath != "index.md" && !post.noindex() {
+			listed = append(listed, post)
+		}
+	}
+	when := func(p BlogPost) int64 {
+		if !p.Date.IsZero() {
+			return p.Date.Unix()
+		}
+		return p.LastModified.Unix()
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		return when(listed[i]) > when(listed[j])
+	})
+	return pinFeatured(listed)
+}
+
+// redirectPage is sent with the home page's redirect, so a static build's
+// index.html still sends readers on
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta http-equiv="refresh" content="0; url={{ . }}">
+<link rel="canonical" href="{{ . }}">
+<title>Redirecting</title>
+</head>
+<body><a href="{{ . }}">Continue to {{ . }}</a></body>
+</html>
+`))
+
+// handleIndex serves /, the home post, a listing of the newest posts or a
+// redirect to another page, as home.mode says
+func (s *server) handleIndex(c *gin.Context) {
+	switch config().Home.mode() {
+	case homeRedirect:
+		post, ok := s.post(config().Home.Slug)
+		if !ok {
+			s.notFound(c)
+			return
+		}
+		c.Header("Location", post.URL())
+		c.Status(http.StatusFound)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		redirectPage.Execute(c.Writer, post.URL())
+
+	case homeList:
+		posts := newestFirst(s.allPosts())
+		page, _ := strconv.Atoi(c.Query("page"))
+		pagination := paginate(len(posts), page, config().Home.perPage())
+		start := (pagination.Page - 1) * config().Home.perPage()
+		end := min(start+config().Home.perPage(), len(posts))
+
+		s.html(c, http.StatusOK, "list.html", gin.H{
+			"Title":                   siteTitle(),
+			"Posts":                   posts[start:end],
+			"Pagination":              pagination,
+			"SidebarData":             s.sidebar(c, ""),
+			"MetaDescription":         "Latest posts from " + siteTitle(),
+			"MetaPropertyTitle":       siteTitle(),
+			"MetaPropertyDescription": "Latest posts from " + siteTitle(),
+			"MetaOgURL":               baseURL() + "/",
+		})
+
+	default:
+		post, ok := s.homePost()
+		if !ok {
+			s.notFound(c)
+			return
+		}
+		s.renderPost(c, post, "index.html", true)
+	}
+}
@@ -0,0 +1,70 @@
+package blog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifyHookSecret accepts either a github style X-Hub-Signature-256 hmac of
+// the body, or the secret itself as a bearer token for simpler senders
+func verifyHookSecret(secret string, body []byte, signature, authorization string) bool {
+	if secret == "" {
+		return false
+	}
+
+	if strings.HasPrefix(signature, "sha256=") {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return token != authorization && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// handleRebuildHook re-reads the content directory, optionally pulling it
+// from git first, so ci or a cms can ping the server after publishing
+func (s *server) handleRebuildHook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if !verifyHookSecret(config().Hooks.Secret, body, c.GetHeader("X-Hub-Signature-256"), c.GetHeader("Authorization")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// github sends a ping when the webhook is first set up
+	if c.GetHeader("X-GitHub-Event") == "ping" {
+		c.JSON(http.StatusOK, gin.H{"status": "pong"})
+		return
+	}
+
+	if config().Hooks.Pull {
+		if err := s.pullContent(); err != nil {
+			log.Printf("Error pulling content: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "git pull failed"})
+			return
+		}
+	}
+
+	changes, err := s.reload()
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "rebuilt", "posts": len(s.allPosts()), "skipped": changes.Skipped})
+}
@@ -0,0 +1,124 @@
+package blog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeHostFiles writes the config files a static host needs to redirect
+// and cache the same way the live server does
+func writeHostFiles(host, out string) error {
+	switch host {
+	case "":
+		return nil
+	case "netlify":
+		return writeNetlifyFiles(out)
+	case "vercel":
+		return writeVercelConfig(out)
+	default:
+		return fmt.Errorf("unknown host %q, expected netlify or vercel", host)
+	}
+}
+
+// writeNetlifyFiles writes _redirects and _headers, which use the same *
+// and :splat syntax as bloog.yaml
+func writeNetlifyFiles(out string) error {
+	var redirects strings.Builder
+	for _, rule := range config().Redirects {
+		fmt.Fprintf(&redirects, "%s %s %d\n", rule.From, rule.To, rule.code())
+	}
+	if err := os.WriteFile(filepath.Join(out, "_redirects"), []byte(redirects.String()), 0644); err != nil {
+		return err
+	}
+
+	var headers strings.Builder
+	for _, rule := range config().Cache {
+		fmt.Fprintf(&headers, "%s\n  Cache-Control: %s\n", rule.Path, rule.Control)
+	}
+	for _, rule := range config().Headers {
+		fmt.Fprintf(&headers, "%s\n", rule.Path)
+		for _, name := range sortedKeys(rule.Values) {
+			fmt.Fprintf(&headers, "  %s: %s\n", name, rule.Values[name])
+		}
+	}
+	return os.WriteFile(filepath.Join(out, "_headers"), []byte(headers.String()), 0644)
+}
+
+type vercelRedirect struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	StatusCode  int    `json:"statusCode"`
+}
+
+type vercelHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type vercelHeaders struct {
+	Source  string         `json:"source"`
+	Headers []vercelHeader `json:"headers"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// vercelSource turns a trailing * into vercel's named wildcard segment
+func vercelSource(pattern string) string {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return prefix + ":splat*"
+	}
+	return pattern
+}
+
+// writeVercelConfig writes vercel.json with the redirect and cache rules
+func writeVercelConfig(out string) error {
+	cfg := struct {
+		Redirects []vercelRedirect `json:"redirects"`
+		Headers   []vercelHeaders  `json:"headers"`
+	}{
+		Redirects: []vercelRedirect{},
+		Headers:   []vercelHeaders{},
+	}
+
+	for _, rule := range config().Redirects {
+		cfg.Redirects = append(cfg.Redirects, vercelRedirect{
+			Source:      vercelSource(rule.From),
+			Destination: strings.ReplaceAll(rule.To, ":splat", ":splat*"),
+			StatusCode:  rule.code(),
+		})
+	}
+	// later vercel header rules override earlier ones, bloog's first match
+	// wins, so they go in reverse
+	for i := len(config().Cache) - 1; i >= 0; i-- {
+		rule := config().Cache[i]
+		cfg.Headers = append(cfg.Headers, vercelHeaders{
+			Source:  vercelSource(rule.Path),
+			Headers: []vercelHeader{{Key: "Cache-Control", Value: rule.Control}},
+		})
+	}
+
+	for _, rule := range config().Headers {
+		headers := vercelHeaders{Source: vercelSource(rule.Path), Headers: []vercelHeader{}}
+		for _, name := range sortedKeys(rule.Values) {
+			headers.Headers = append(headers.Headers, vercelHeader{Key: name, Value: rule.Values[name]})
+		}
+		cfg.Headers = append(cfg.Headers, headers)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(out, "vercel.json"), data, 0644)
+}
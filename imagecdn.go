@@ -0,0 +1,166 @@
+package blog
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+func (c ImagesConfig) enabled() bool {
+	return c.CDN != ""
+}
+
+// imageRenderHook sends every markdown image through the image cdn, picking
+// the preset named in the url's fragment (![alt](/photo.jpg#small)) or the
+// default one, with a srcset of every sized preset if configured
+func imageRenderHook(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	img, ok := node.(*ast.Image)
+	if !ok || !entering {
+		return ast.GoToNext, false
+	}
+
+	src, fragment, _ := strings.Cut(string(img.Destination), "#")
+	preset, ok := config().Images.Presets[fragment]
+	if !ok {
+		preset = config().Images.Presets[config().Images.Preset]
+	}
+
+	rewritten, ok := imageCDNURL(config().Images, src, preset)
+	if !ok {
+		return ast.GoToNext, false
+	}
+	img.Destination = []byte(rewritten)
+
+	if config().Images.Srcset {
+		if srcset := imageSrcset(config().Images, src); srcset != "" {
+			if img.Attribute == nil {
+				img.Attribute = &ast.Attribute{}
+			}
+			if img.Attrs == nil {
+				img.Attrs = make(map[string][]byte)
+			}
+			img.Attrs["srcset"] = []byte(srcset)
+		}
+	}
+
+	return ast.GoToNext, false
+}
+
+// imageSrcset lists the image at every preset with a width, narrowest first
+func imageSrcset(cfg ImagesConfig, src string) string {
+	var presets []ImagePreset
+	for _, preset := range cfg.Presets {
+		if preset.Width > 0 {
+			presets = append(presets, preset)
+		}
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Width < presets[j].Width
+	})
+
+	var candidates []string
+	for _, preset := range presets {
+		if u, ok := imageCDNURL(cfg, src, preset); ok {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", u, preset.Width))
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// imageCDNURL is src as served by the configured cdn, reporting false for
+// images it shouldn't touch, like svgs and data urls
+func imageCDNURL(cfg ImagesConfig, src string, preset ImagePreset) (string, bool) {
+	if strings.HasPrefix(src, "data:") || strings.EqualFold(path.Ext(strings.SplitN(src, "?", 2)[0]), ".svg") {
+		return "", false
+	}
+
+	// the cdn fetches the original, so it needs a full url
+	source := src
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		source = baseURL() + "/" + strings.TrimPrefix(src, "/")
+	}
+
+	switch cfg.CDN {
+	case "imgproxy":
+		return imgproxyURL(cfg, source, preset), true
+	case "cloudinary":
+		return cloudinaryURL(cfg, source, preset), true
+	case "template":
+		u, err := templateImageURL(cfg, source, preset)
+		return u, err == nil
+	default:
+		return "", false
+	}
+}
+
+// imgproxyURL builds a processing url, signed when a key and salt are set
+func imgproxyURL(cfg ImagesConfig, source string, preset ImagePreset) string {
+	p := fmt.Sprintf("/rs:fit:%d:%d", preset.Width, preset.Height)
+	if preset.Quality > 0 {
+		p += fmt.Sprintf("/q:%d", preset.Quality)
+	}
+	p += "/plain/" + url.QueryEscape(source)
+
+	signature := "insecure"
+	key, keyErr := hex.DecodeString(cfg.Key)
+	salt, saltErr := hex.DecodeString(cfg.Salt)
+	if cfg.Key != "" && keyErr == nil && saltErr == nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(salt)
+		mac.Write([]byte(p))
+		signature = base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	return strings.TrimRight(cfg.URL, "/") + "/" + signature + p
+}
+
+// cloudinaryURL builds a fetch url, cfg.URL being the cloud name
+func cloudinaryURL(cfg ImagesConfig, source string, preset ImagePreset) string {
+	transforms := []string{"f_auto"}
+	if preset.Quality > 0 {
+		transforms = append(transforms, fmt.Sprintf("q_%d", preset.Quality))
+	} else {
+		transforms = append(transforms, "q_auto")
+	}
+	if preset.Width > 0 {
+		transforms = append(transforms, fmt.Sprintf("w_%d", preset.Width))
+	}
+	if preset.Height > 0 {
+		transforms = append(transforms, fmt.Sprintf("h_%d", preset.Height))
+	}
+	if preset.Width > 0 || preset.Height > 0 {
+		transforms = append(transforms, "c_limit")
+	}
+
+	return fmt.Sprintf("https://res.cloudinary.com/%s/image/fetch/%s/%s", cfg.URL, strings.Join(transforms, ","), source)
+}
+
+// templateImageURL is for any other service, cfg.Template being a url
+// template with .URL (escaped), .RawURL, .Width, .Height and .Quality
+func templateImageURL(cfg ImagesConfig, source string, preset ImagePreset) (string, error) {
+	tmpl, err := template.New("image").Parse(cfg.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, map[string]interface{}{
+		"URL":     url.QueryEscape(source),
+		"RawURL":  source,
+		"Width":   preset.Width,
+		"Height":  preset.Height,
+		"Quality": preset.Quality,
+	})
+	return b.String(), err
+}
@@ -0,0 +1,247 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const thumbnailWidth = 400
+
+var errUnsupportedImage = errors.New("unsupported image type, upload a jpeg, png, gif or webp")
+
+// imageExts maps the sniffed content types of supported uploads to the
+// extension they're stored with
+var imageExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// cleanImage strips the metadata (camera, location, comments) from an
+// uploaded image without re-encoding it. Jpegs that relied on their exif
+// orientation are rotated for real first, since stripping it would leave
+// them sideways
+func cleanImage(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		orientation := jpegOrientation(data)
+		data = stripJPEG(data)
+		if orientation > 1 {
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			var b bytes.Buffer
+			if err := jpeg.Encode(&b, orient(img, orientation), &jpeg.Options{Quality: 90}); err != nil {
+				return nil, err
+			}
+			data = b.Bytes()
+		}
+		return data, nil
+	case "image/png":
+		return stripPNG(data), nil
+	case "image/webp":
+		return stripWebP(data), nil
+	case "image/gif":
+		return data, nil
+	default:
+		return nil, errUnsupportedImage
+	}
+}
+
+// thumbnail scales an image down to thumbnailWidth, returning nil when it's
+// already small enough. Jpegs stay jpegs, everything else becomes a png to
+// keep transparency
+func thumbnail(data []byte, contentType string) ([]byte, string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= thumbnailWidth {
+		return nil, "", nil
+	}
+
+	height := bounds.Dy() * thumbnailWidth / bounds.Dx()
+	thumb := image.NewNRGBA(image.Rect(0, 0, thumbnailWidth, height))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+
+	var b bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&b, thumb, &jpeg.Options{Quality: 80})
+		return b.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&b, thumb)
+	return b.Bytes(), ".png", err
+}
+
+// stripJPEG drops the app1 (exif, xmp), app13 (iptc) and comment segments
+func stripJPEG(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+
+	out := append([]byte{}, data[:2]...)
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		// the image data runs from the start of scan to the end
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if end > len(data) {
+			return data
+		}
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return append(out, data[i:]...)
+}
+
+// jpegOrientation reads the exif orientation tag, 1 (as is) if there's none
+func jpegOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA {
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if end > len(data) {
+			break
+		}
+		segment := data[i+4 : end]
+		if data[i+1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(tiff[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// orient applies an exif orientation, so the pixels are the right way up
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = b.Dx()-1-x, y
+			case 3:
+				dx, dy = b.Dx()-1-x, b.Dy()-1-y
+			case 4:
+				dx, dy = x, b.Dy()-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = b.Dy()-1-y, x
+			case 7:
+				dx, dy = b.Dy()-1-y, b.Dx()-1-x
+			case 8:
+				dx, dy = y, b.Dx()-1-x
+			default:
+				dx, dy = x, y
+			}
+			out.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// stripPNG drops the text, time and exif chunks
+func stripPNG(data []byte) []byte {
+	if len(data) < 8 {
+		return data
+	}
+
+	out := append([]byte{}, data[:8]...)
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return data
+		}
+		switch string(data[i+4 : i+8]) {
+		case "tEXt", "zTXt", "iTXt", "tIME", "eXIf":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// stripWebP drops the exif and xmp chunks, clearing their flags in the
+// extended header
+func stripWebP(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+
+	out := append([]byte{}, data[:12]...)
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return data
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			chunk[8] &^= 0x08 | 0x04
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
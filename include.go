@@ -0,0 +1,131 @@
+package blog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// codeDirective matches {{code "examples/main.go" lines=10-30 lang=go}}
+var codeDirective = regexp.MustCompile(`\{\{\s*code\s+"([^"]+)"([^}]*)\}\}`)
+
+// expandCodeIncludes replaces every code directive in content with a fenced
+// block holding the named file, or the given lines of it, so samples are
+// always the real thing. The included paths are returned so the post can be
+// re-rendered when they change. A file that can't be included leaves a note
+// in its place, the errors are returned along with the rest of the post
+func expandCodeIncludes(dir string, content []byte) ([]byte, []string, error) {
+	var included []string
+	var errs []error
+
+	expanded := codeDirective.ReplaceAllFunc(content, func(match []byte) []byte {
+		parts := codeDirective.FindSubmatch(match)
+		name := string(parts[1])
+
+		path, err := contentFile(dir, name)
+		if err == nil {
+			included = append(included, name)
+			var block []byte
+			if block, err = codeBlock(path, string(parts[2])); err == nil {
+				return block
+			}
+		}
+		errs = append(errs, fmt.Errorf("including %s: %w", name, err))
+		return []byte(fmt.Sprintf("\n> Couldn't include `%s`\n", name))
+	})
+
+	return expanded, included, errors.Join(errs...)
+}
+
+// contentFile is the path of name in dir, refusing names that reach outside
+// of it, through .. or a symlink, so a post can't publish the config or data
+func contentFile(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%s is outside the content directory", name)
+	}
+	path := filepath.Join(dir, name)
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside the content directory", name)
+	}
+	return path, nil
+}
+
+// codeBlock fences the file at path, options are space separated key=value
+// pairs of lines (a 1-based inclusive range like 10-30, or a single line)
+// and lang, which defaults to the file's extension
+func codeBlock(path, options string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	lines := strings.Split(strings.TrimRight(string(src), "\n"), "\n")
+
+	for _, option := range strings.Fields(options) {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "lang":
+			lang = value
+		case "lines":
+			from, to, err := lineRange(value, len(lines))
+			if err != nil {
+				return nil, err
+			}
+			lines = lines[from-1 : to]
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	code := strings.Join(lines, "\n")
+
+	// the fence has to be longer than any run of backticks in the code
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	var b bytes.Buffer
+	// on lines of its own, a directive can sit at the end of a sentence
+	fmt.Fprintf(&b, "\n%s%s\n%s\n%s\n", fence, lang, code, fence)
+	return b.Bytes(), nil
+}
+
+// lineRange parses "10-30", "10-" or "10" against a file of n lines
+func lineRange(value string, n int) (int, int, error) {
+	fromText, toText, isRange := strings.Cut(value, "-")
+
+	from, err := strconv.Atoi(fromText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad line range %q", value)
+	}
+	to := from
+	if isRange {
+		to = n
+		if toText != "" {
+			if to, err = strconv.Atoi(toText); err != nil {
+				return 0, 0, fmt.Errorf("bad line range %q", value)
+			}
+		}
+	}
+
+	if from < 1 || to < from || to > n {
+		return 0, 0, fmt.Errorf("line range %q outside of the file's %d lines", value, n)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,35 @@
+package blog_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestContentFile(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "markdown")
+	for _, path := range []string{"markdown/code/main.go", "bloog.yaml"} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "bloog.yaml"), filepath.Join(content, "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blog.ContentFile(content, "code/main.go"); err != nil {
+		t.Errorf("code/main.go: %v", err)
+	}
+	for _, name := range []string{"../bloog.yaml", "code/../../bloog.yaml", filepath.Join(dir, "bloog.yaml"), "config.yaml", "missing.go"} {
+		if _, err := blog.ContentFile(content, name); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package blog
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"strings"
+)
+
+func (p PublisherConfig) enabled() bool {
+	return p.Name != ""
+}
+
+// absoluteURL puts the base url in front of site paths
+func absoluteURL(u string) string {
+	if strings.HasPrefix(u, "/") {
+		return baseURL() + u
+	}
+	return u
+}
+
+// publisherData is the Organization or Person the site belongs to
+func publisherData(p PublisherConfig) map[string]interface{} {
+	url := p.URL
+	if url == "" {
+		url = baseURL() + "/"
+	}
+	data := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "Organization",
+		"name":     p.Name,
+		"url":      url,
+	}
+	if strings.EqualFold(p.Type, "person") {
+		data["@type"] = "Person"
+		if p.Logo != "" {
+			data["image"] = absoluteURL(p.Logo)
+		}
+	} else if p.Logo != "" {
+		data["logo"] = absoluteURL(p.Logo)
+	}
+	if len(p.SameAs) > 0 {
+		data["sameAs"] = p.SameAs
+	}
+	return data
+}
+
+// breadcrumbData is the trail from the home page through the post's
+// sidebar section to the post
+func breadcrumbData(post BlogPost, sidebar SideBar) map[string]interface{} {
+	type crumb struct{ name, url string }
+	crumbs := []crumb{{"Home", baseURL() + "/"}}
+	for _, category := range sidebar.Categories {
+		if category.Name == post.Parent && len(category.Pages) > 0 {
+			crumbs = append(crumbs, crumb{category.Name, baseURL() + category.URL()})
+			break
+		}
+	}
+	crumbs = append(crumbs, crumb{post.Title, baseURL() + post.URL()})
+
+	items := make([]map[string]interface{}, len(crumbs))
+	for i, c := range crumbs {
+		items[i] = map[string]interface{}{
+			"@type":    "ListItem",
+			"position": i + 1,
+			"name":     c.name,
+			"item":     c.url,
+		}
+	}
+	return map[string]interface{}{
+		"@context":        "https://schema.org",
+		"@type":           "BreadcrumbList",
+		"itemListElement": items,
+	}
+}
+
+// structuredData is the json-ld blocks for a page's head: who publishes
+// the site, and where the page sits in it
+func (s *server) structuredData(post BlogPost, home bool) []template.JS {
+	var blocks []interface{}
+	if config().Publisher.enabled() {
+		blocks = append(blocks, publisherData(config().Publisher))
+	}
+	if !home && post.Parent != "" {
+		blocks = append(blocks, breadcrumbData(post, s.sidebarFor(post.Version)))
+	}
+
+	scripts := make([]template.JS, 0, len(blocks))
+	for _, block := range blocks {
+		// < and > come out escaped, so nothing in a title ends the script
+		data, err := json.Marshal(block)
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			continue
+		}
+		scripts = append(scripts, template.JS(data))
+	}
+	return scripts
+}
@@ -0,0 +1,326 @@
+package blog
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var linkAttr = regexp.MustCompile(`(?i)\s(?:href|src)="([^"]+)"`)
+
+// brokenLink is a link in a post that didn't work
+type brokenLink struct {
+	Post   string `json:"post"`
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// linkStatus is what checking an external link found, cached between runs
+type linkStatus struct {
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+func (l linkStatus) broken() bool {
+	// a rate limit says nothing about whether the page is there
+	return l.Error != "" || (l.Status >= 400 && l.Status != http.StatusTooManyRequests)
+}
+
+// postLinks are the urls a post's rendered content links to, resolved
+// against the post's own url
+func postLinks(post BlogPost) []*url.URL {
+	base, _ := url.Parse(baseURL() + post.URL())
+	seen := make(map[string]bool)
+	var links []*url.URL
+	for _, m := range linkAttr.FindAllStringSubmatch(string(post.Content), -1) {
+		ref, err := url.Parse(strings.TrimSpace(unescapeAttr(m[1])))
+		if err != nil {
+			continue
+		}
+		link := base.ResolveReference(ref)
+		if link.Scheme != "http" && link.Scheme != "https" {
+			continue
+		}
+		if !seen[link.String()] {
+			seen[link.String()] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func unescapeAttr(s string) string {
+	return strings.NewReplacer("&amp;", "&", "&#39;", "'", "&quot;", `"`).Replace(s)
+}
+
+// isInternal reports whether a link points at this site
+func isInternal(link *url.URL) bool {
+	site, err := url.Parse(baseURL())
+	return err == nil && strings.EqualFold(link.Host, site.Host)
+}
+
+// linkIgnored matches a link against the ignore list, url prefixes where
+// * matches anything
+func linkIgnored(link string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if pattern == "" {
+			continue
+		}
+		re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		if ok, _ := regexp.MatchString(re, link); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInternal requests every site link from the router itself, so pages,
+// static files, redirects and plugin routes all count, and looks for the
+// heading a #fragment points to
+func checkInternal(r http.Handler, posts []BlogPost) []brokenLink {
+	type page struct {
+		status int
+		body   string
+	}
+	pages := make(map[string]page)
+	fetch := func(path string) page {
+		if p, ok := pages[path]; ok {
+			return p
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		p := page{status: w.Code, body: w.Body.String()}
+		pages[path] = p
+		return p
+	}
+
+	var broken []brokenLink
+	for _, post := range posts {
+		for _, link := range postLinks(post) {
+			if !isInternal(link) {
+				continue
+			}
+			p := fetch(link.RequestURI())
+			if p.status == http.StatusNotFound || p.status >= 500 {
+				broken = append(broken, brokenLink{Post: postSource(post), URL: link.RequestURI(), Status: p.status})
+				continue
+			}
+			if link.Fragment != "" && p.status == http.StatusOK && !strings.Contains(p.body, `id="`+link.Fragment+`"`) {
+				broken = append(broken, brokenLink{Post: postSource(post), URL: link.RequestURI() + "#" + link.Fragment, Error: "no such heading"})
+			}
+		}
+	}
+	return broken
+}
+
+// linkChecker checks external links, a few at a time and fewer still per
+// host so nobody's server is hammered
+type linkChecker struct {
+	client  *http.Client
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func (lc *linkChecker) hostSlot(host string) chan struct{} {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	slot, ok := lc.hosts[host]
+	if !ok {
+		slot = make(chan struct{}, lc.perHost)
+		lc.hosts[host] = slot
+	}
+	return slot
+}
+
+// check asks for the page, with a HEAD first since it's cheaper, falling
+// back to a GET for servers that don't answer HEADs properly
+func (lc *linkChecker) check(link string) linkStatus {
+	u, _ := url.Parse(link)
+	slot := lc.hostSlot(u.Host)
+	slot <- struct{}{}
+	defer func() { <-slot }()
+
+	status := linkStatus{Checked: time.Now()}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, link, nil)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+		req.Header.Set("User-Agent", "bloog-link-checker (+"+baseURL()+")")
+		resp, err := lc.client.Do(req)
+		if err != nil {
+			status.Error = err.Error()
+			continue
+		}
+		resp.Body.Close()
+		status.Status, status.Error = resp.StatusCode, ""
+		if method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed ||
+			resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotImplemented) {
+			continue
+		}
+		break
+	}
+	return status
+}
+
+// checkExternal checks every outbound link not checked within maxAge,
+// caching what it finds in cachePath
+func checkExternal(posts []BlogPost, ignore []string, concurrency int, maxAge time.Duration, cachePath string) ([]brokenLink, int, error) {
+	cache := make(map[string]linkStatus)
+	if err := loadJSON(cachePath, &cache); err != nil {
+		return nil, 0, err
+	}
+
+	usedBy := make(map[string][]string)
+	for _, post := range posts {
+		for _, link := range postLinks(post) {
+			link.Fragment = ""
+			if isInternal(link) || linkIgnored(link.String(), ignore) {
+				continue
+			}
+			usedBy[link.String()] = append(usedBy[link.String()], postSource(post))
+		}
+	}
+
+	var stale []string
+	for link := range usedBy {
+		if cached, ok := cache[link]; !ok || time.Since(cached.Checked) > maxAge {
+			stale = append(stale, link)
+		}
+	}
+
+	lc := &linkChecker{
+		client:  &http.Client{Timeout: 15 * time.Second},
+		perHost: 2,
+		hosts:   make(map[string]chan struct{}),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, link := range stale {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(link string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			status := lc.check(link)
+			mu.Lock()
+			cache[link] = status
+			mu.Unlock()
+		}(link)
+	}
+	wg.Wait()
+
+	// links no post uses anymore are dropped from the cache
+	for link := range cache {
+		if _, ok := usedBy[link]; !ok {
+			delete(cache, link)
+		}
+	}
+
+	var broken []brokenLink
+	for link, sources := range usedBy {
+		if status := cache[link]; status.broken() {
+			for _, source := range sources {
+				broken = append(broken, brokenLink{Post: source, URL: link, Status: status.Status, Error: status.Error})
+			}
+		}
+	}
+	return broken, len(stale), saveJSON(cachePath, cache)
+}
+
+// checkLinksCommand reports links in posts that lead nowhere:
+// check-links [-external] [-json]
+func checkLinksCommand(args []string) error {
+	fs := flag.NewFlagSet("check-links", flag.ExitOnError)
+	external := fs.Bool("external", false, "check links to other sites too")
+	concurrency := fs.Int("concurrency", 8, "external links checked at once")
+	maxAge := fs.Duration("max-age", 24*time.Hour, "recheck external links last checked longer ago than this")
+	ignore := fs.String("ignore", "", "comma separated url prefixes to skip, * matches anything")
+	asJSON := fs.Bool("json", false, "print the report as json")
+	fs.Parse(args)
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	st, err := s.newSite()
+	if err != nil {
+		return err
+	}
+	posts := publicPosts(s.allPosts())
+
+	broken := checkInternal(st.engine, posts)
+	checked := 0
+	if *external {
+		ignored := append(append([]string(nil), config().Links.Ignore...), splitList(*ignore)...)
+		var externalBroken []brokenLink
+		externalBroken, checked, err = checkExternal(posts, ignored, *concurrency, *maxAge,
+			filepath.Join(config().DataDir, "links.json"))
+		if err != nil {
+			return err
+		}
+		broken = append(broken, externalBroken...)
+	}
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].Post != broken[j].Post {
+			return broken[i].Post < broken[j].Post
+		}
+		return broken[i].URL < broken[j].URL
+	})
+
+	if *asJSON {
+		if broken == nil {
+			broken = []brokenLink{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(broken); err != nil {
+			return err
+		}
+	} else {
+		if *external {
+			fmt.Printf("checked %d external links, the rest were checked within %v\n", checked, *maxAge)
+		}
+		if len(broken) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "POST\tLINK\tPROBLEM")
+			for _, link := range broken {
+				problem := link.Error
+				if problem == "" {
+					problem = fmt.Sprintf("%d %s", link.Status, http.StatusText(link.Status))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", link.Post, link.URL, problem)
+			}
+			w.Flush()
+		}
+	}
+
+	if len(broken) > 0 {
+		return fmt.Errorf("%d broken link(s)", len(broken))
+	}
+	if !*asJSON {
+		fmt.Println("no broken links")
+	}
+	return nil
+}
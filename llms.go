@@ -0,0 +1,272 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stdhtml "html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chunks are split further at paragraphs when a section is longer than
+// this, so each fits comfortably in an embedding model's context
+const maxChunkSize = 4000
+
+// contentChunk is a section of a post, a heading and what's under it
+type contentChunk struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`
+	Title   string   `json:"title"`
+	Heading string   `json:"heading,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Content string   `json:"content"`
+}
+
+// llmsPosts are the posts the llms files describe, the ones anyone can read
+func llmsPosts(posts []BlogPost) []BlogPost {
+	var listed []BlogPost
+	for _, post := range publicPosts(posts) {
+		// a protected post's text is only for those with its password
+		if post.Slug != "" && !post.Protected {
+			listed = append(listed, post)
+		}
+	}
+	return listed
+}
+
+// llmsCache is the llms files as of the last reload, each built on the
+// first request for it since, as the full ones render every post
+type llmsCache struct {
+	mu     sync.Mutex
+	loaded time.Time
+	files  map[string][]byte
+}
+
+// llmsFile is the cached file name, built with build when the content has
+// changed since it last was
+func (s *server) llmsFile(name string, build func() []byte) []byte {
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+
+	s.llms.mu.Lock()
+	defer s.llms.mu.Unlock()
+	if s.llms.files == nil || !s.llms.loaded.Equal(loaded) {
+		s.llms.files = make(map[string][]byte)
+		s.llms.loaded = loaded
+	}
+	if file, ok := s.llms.files[name]; ok {
+		return file
+	}
+	file := build()
+	s.llms.files[name] = file
+	return file
+}
+
+// clear drops the cached files, for a config reload that changes what's in
+// them without changing the content
+func (l *llmsCache) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = nil
+}
+
+// handleLLMs serves /llms.txt, an index of the site in markdown for
+// language models: https://llmstxt.org
+func (s *server) handleLLMs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", s.llmsFile("llms.txt", s.llmsIndex))
+}
+
+func (s *server) llmsIndex() []byte {
+	posts := llmsPosts(s.allPosts())
+
+	var b strings.Builder
+	b.WriteString("# " + siteTitle() + "\n\n")
+	for _, post := range posts {
+		if post.SourcePath != "index.md" {
+			continue
+		}
+		summary := post.Description
+		if summary == "" {
+			summary = post.MetaDescription
+		}
+		if summary != "" {
+			b.WriteString("> " + summary + "\n\n")
+		}
+	}
+
+	// pages by their sidebar section, then the dated posts
+	var sections []string
+	bySection := make(map[string][]BlogPost)
+	for _, post := range posts {
+		section := post.Parent
+		if section == "" && !post.Date.IsZero() {
+			section = "Posts"
+		} else if section == "" {
+			section = "Pages"
+		}
+		if _, ok := bySection[section]; !ok {
+			sections = append(sections, section)
+		}
+		bySection[section] = append(bySection[section], post)
+	}
+	for _, section := range sections {
+		b.WriteString("## " + section + "\n\n")
+		for _, post := range bySection[section] {
+			b.WriteString("- [" + post.Title + "](" + baseURL() + post.URL() + ")")
+			if post.Description != "" {
+				b.WriteString(": " + post.Description)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Optional\n\n")
+	b.WriteString("- [Full content](" + baseURL() + "/llms-full.txt): every page in one file\n")
+	b.WriteString("- [Chunks](" + baseURL() + "/llms.jsonl): every page split at its headings, one json object per line\n")
+	return []byte(b.String())
+}
+
+// handleLLMsFull serves /llms-full.txt, the markdown of every page
+func (s *server) handleLLMsFull(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", s.llmsFile("llms-full.txt", s.llmsFull))
+}
+
+func (s *server) llmsFull() []byte {
+	var b strings.Builder
+	b.WriteString("# " + siteTitle() + "\n")
+	for _, post := range llmsPosts(s.allPosts()) {
+		body, err := s.postMarkdown(post)
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(&b, "\n---\n\n# %s\n\nSource: %s%s\n\n%s\n", post.Title, baseURL(), post.URL(), body)
+	}
+	return []byte(b.String())
+}
+
+// handleLLMsChunks serves /llms.jsonl, every page in chunks ready for a
+// retrieval pipeline to embed
+func (s *server) handleLLMsChunks(c *gin.Context) {
+	c.Data(http.StatusOK, "application/jsonl; charset=utf-8", s.llmsFile("llms.jsonl", s.llmsChunks))
+}
+
+func (s *server) llmsChunks() []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, post := range llmsPosts(s.allPosts()) {
+		chunks, err := s.contentChunks(post)
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			continue
+		}
+		for _, chunk := range chunks {
+			enc.Encode(chunk)
+		}
+	}
+	return buf.Bytes()
+}
+
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+
+// contentChunks splits a post's markdown at its headings, leaving out
+// headings in code blocks
+func (s *server) contentChunks(post BlogPost) ([]contentChunk, error) {
+	body, err := s.postMarkdown(post)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []contentChunk
+	heading, anchor := "", ""
+	var section []string
+	// the ids the page gives its headings, which only rendering knows:
+	// repeats get numbered and punctuation is dropped its own way
+	rendered := tocHeading.FindAllStringSubmatch(string(post.Content), -1)
+	flush := func() {
+		content := strings.TrimSpace(strings.Join(section, "\n"))
+		section = nil
+		if content == "" {
+			return
+		}
+		for i, part := range splitChunk(content, maxChunkSize) {
+			chunk := contentChunk{
+				ID:      post.Slug,
+				URL:     baseURL() + post.URL(),
+				Title:   post.Title,
+				Heading: heading,
+				Tags:    post.Tags,
+				Content: part,
+			}
+			if anchor != "" {
+				chunk.ID += "#" + anchor
+				chunk.URL += "#" + anchor
+			} else if heading != "" {
+				chunk.ID += "#" + sanitizeHeaderForID(heading)
+			}
+			if i > 0 {
+				chunk.ID += fmt.Sprintf("/%d", i+1)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	fenced := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			fenced = !fenced
+		}
+		if m := markdownHeading.FindStringSubmatch(line); m != nil && !fenced {
+			flush()
+			heading, anchor = m[2], ""
+			// the next rendered heading with the same text, one that isn't
+			// found is linked to the top of the page rather than nowhere
+			text := plainText(string(mdToHTML([]byte(heading))))
+			for i, h := range rendered {
+				if plainText(h[3]) == text {
+					anchor = stdhtml.UnescapeString(h[2])
+					rendered = rendered[i+1:]
+					break
+				}
+			}
+			continue
+		}
+		section = append(section, line)
+	}
+	flush()
+	return chunks, nil
+}
+
+// splitChunk splits content at blank lines into parts of at most max
+// bytes, as near as paragraphs allow
+func splitChunk(content string, max int) []string {
+	if len(content) <= max {
+		return []string{content}
+	}
+	var parts []string
+	var part strings.Builder
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		if part.Len() > 0 && part.Len()+len(paragraph)+2 > max {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		if part.Len() > 0 {
+			part.WriteString("\n\n")
+		}
+		part.WriteString(paragraph)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
@@ -1,16 +1,19 @@
-package main
+package blog
 
 import (
 	"errors"
 	"fmt"
+	stdhtml "html"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gomarkdown/markdown"
@@ -26,10 +29,49 @@ type BlogPost struct {
 	Description             string
 	Order                   int
 	Headers                 []string
+	Tags                    []string
 	MetaDescription         string
 	MetaPropertyTitle       string
 	MetaPropertyDescription string
 	MetaOgURL               string
+	// feature image, shown when the page is shared and in feed readers
+	Image string
+	// set when MetaDescription was made up from the Description or content
+	MetaDescriptionDerived bool
+	// path of the markdown file, relative to the content directory
+	SourcePath string
+	// sites to cross-post to, devto, hashnode or true for all configured
+	CrossPost []string
+	// OpenAPI spec rendered below the content, relative to the content dir
+	OpenAPI string
+	// the docs version the post belongs to, empty for unversioned content
+	Version string
+	// publish date from the Date metadata, zero when there isn't one
+	Date time.Time
+	// last commit touching the file, or its mtime outside of git
+	LastModified time.Time
+	// commit authors of the source file, most commits first
+	Contributors []Contributor
+	// who can read the post, "members" or role names, empty for everyone
+	Access []string
+	// sitemap hints, empty means the defaults
+	Priority   string
+	ChangeFreq string
+	// directives for search engines, noindex, nofollow and the like
+	Robots []string
+	// only served in environments that show drafts
+	Draft bool
+	// the content is encrypted, for readers with the password to decrypt
+	Protected bool
+	// url of the post this one is a copy of, set by duplicates.canonicalize
+	Canonical string
+	// pinned to the top of listings and feeds, lowest Weight first
+	Featured bool
+	Weight   int
+	// when the post is unpublished, or archived, zero for never
+	ExpiryDate time.Time
+	// the post's variant files by label, pricing.b.md as b
+	Variants map[string]BlogPost
 }
 
 type SideBar struct {
@@ -38,221 +80,368 @@ type SideBar struct {
 
 type Category struct {
 	Name  string
-	Pages []BlogPost
+	Pages []SidebarPage
 	Order int
+	// from the category's _index.md, if it has one
+	Description string
+	Intro       template.HTML
+	// holds the page being served
+	IsActive bool
+	// should be shown expanded: holds the page being served, or every
+	// category when the page isn't in the sidebar
+	IsOpen bool
 }
 
-var BaseURL = "http://localhost:8080"
-
-func main() {
-	gin.SetMode(gin.ReleaseMode)
-
-	r := gin.Default()
+// SidebarPage is a post in the sidebar, with whether it's the one served
+type SidebarPage struct {
+	BlogPost
+	IsActive bool
+}
 
-	// sidebar data
-	sidebarData, err := loadSidebarData("./markdown")
-	if err != nil {
-		log.Fatal(err)
+// activeFor is a copy of the sidebar with the page slug and its category
+// marked active and only that category open. A slug that isn't in the
+// sidebar leaves every category open
+func (sb SideBar) activeFor(slug string) SideBar {
+	found := false
+	categories := make([]Category, len(sb.Categories))
+	for i, category := range sb.Categories {
+		category.Pages = append([]SidebarPage(nil), category.Pages...)
+		for j := range category.Pages {
+			if slug != "" && category.Pages[j].Slug == slug {
+				category.Pages[j].IsActive = true
+				category.IsActive = true
+				found = true
+			}
+		}
+		categories[i] = category
 	}
+	if found {
+		for i := range categories {
+			categories[i].IsOpen = categories[i].IsActive
+		}
+	}
+	return SideBar{Categories: categories}
+}
 
-	// register the sidebar template as a partial
-	r.SetFuncMap(template.FuncMap{
-		"loadSidebar": func() SideBar {
-			return sidebarData
-		},
-		"dict": dict,
-	})
-
-	// load in the templates
-	r.LoadHTMLGlob("templates/*")
+// markdownDir is the content the server and the commands working on it use
+const markdownDir = "./markdown"
 
-	// serve static assets
-	r.Static("/static", "./static")
+// Main runs the bloog command, serving the site in the working directory or
+// running one of the subcommands
+func Main() {
+	gin.SetMode(gin.ReleaseMode)
 
-	// load and parse markdown files
-	posts, err := loadMarkdownPosts("./markdown")
+	cfg, err := loadConfig(configPath())
 	if err != nil {
-		log.Fatal(err)
+		printReport(configProblem(err))
+		os.Exit(1)
 	}
+	setConfig(cfg)
 
-	// single route for the home page
-	r.GET("/", func(c *gin.Context) {
-		indexPath := "./markdown/index.md"
-		indexContent, err := os.ReadFile(indexPath)
-		if err != nil {
-			log.Printf("Error occured during operation: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return
-		}
-
-		post, err := parseMarkdownFile(indexContent)
-		if err != nil {
-			log.Printf("Error occured during operation: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		sidebarLinks := createSidebarLinks(post.Headers)
-
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"Title":                   post.Title,
-			"Content":                 post.Content,
-			"SidebarData":             sidebarData,
-			"Headers":                 post.Headers,
-			"SidebarLinks":            sidebarLinks,
-			"CurrentSlug":             post.Slug,
-			"MetaDescription":         post.MetaDescription,
-			"MetaPropertyTitle":       post.MetaPropertyTitle,
-			"MetaPropertyDescription": post.MetaPropertyDescription,
-			"MetaOgURL":               post.MetaOgURL,
-		})
-	})
+	s, err := newServer(markdownDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	// routes for each blog post, based off of slug following the /
-	for _, post := range posts {
-		localPost := post
-		if localPost.Slug != "" {
-			sidebarLinks := createSidebarLinks(localPost.Headers)
-			r.GET("/"+localPost.Slug, func(c *gin.Context) {
-				c.HTML(http.StatusOK, "layout.html", gin.H{
-					"Title":                   localPost.Title,
-					"Content":                 localPost.Content,
-					"SidebarData":             sidebarData,
-					"Headers":                 localPost.Headers,
-					"Description":             localPost.Description,
-					"SidebarLinks":            sidebarLinks,
-					"CurrentSlug":             localPost.Slug,
-					"MetaDescription":         localPost.MetaDescription,
-					"MetaPropertyTitle":       localPost.MetaPropertyTitle,
-					"MetaPropertyDescription": localPost.MetaPropertyDescription,
-					"MetaOgURL":               localPost.MetaOgURL,
-				})
-			})
-		} else {
-			log.Printf("Warning: Post title '%s' has an empty slug and will not be accessible via unique URL.\n", localPost.Title)
-		}
+	if config().Watch {
+		go s.watch(time.Second)
+	}
+	if config().Dev && !config().Headless {
+		go s.watchTemplates(time.Second)
+	}
+	if config().Notion.enabled() && config().Notion.Interval > 0 {
+		go s.syncNotionEvery(config().Notion.Interval)
 	}
+	s.runSchedule(config().Schedule)
 
-	r.NoRoute(func(c *gin.Context) {
-		c.HTML(http.StatusNotFound, "404.html", gin.H{
-			"Title": "Page Not Found",
-		})
-	})
+	if err := s.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(s.report.problems) > 0 {
+		printReport(s.report)
+	}
+	go s.reloadOnHangup()
 
-	r.Run()
+	log.Fatal(http.ListenAndServe(listenAddr(), s.live))
 }
 
 func loadMarkdownPosts(dir string) ([]BlogPost, error) {
-	var posts []BlogPost
-	files, err := os.ReadDir(dir)
+	return newContentCache().load(dir)
+}
+
+func parseMarkdownFile(content []byte) (BlogPost, error) {
+	meta, mdContent, err := splitFrontmatter(string(content))
 	if err != nil {
-		return nil, err
+		return BlogPost{}, err
 	}
 
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".md") {
-			path := dir + "/" + file.Name()
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return nil, err
-			}
+	htmlContent := mdToHTML([]byte(mdContent))
+	headers := extractHeaders([]byte(mdContent))
 
-			post, err := parseMarkdownFile(content)
-			if err != nil {
-				return nil, err
-			}
+	// hugo orders pages by weight
+	orderValue := meta["Order"]
+	if orderValue == "" {
+		orderValue = meta["weight"]
+	}
+	order, err := strconv.Atoi(orderValue)
+	if err != nil {
+		order = 9999 // set this to a high number in case of err
+	}
 
-			posts = append(posts, post)
+	var date time.Time
+	if meta["Date"] != "" {
+		if date, err = parseDate(meta["Date"]); err != nil {
+			return BlogPost{}, err
 		}
 	}
 
-	return posts, nil
-}
-
-func parseMarkdownFile(content []byte) (BlogPost, error) {
-	sections := strings.SplitN(string(content), "---", 2)
-	if len(sections) < 2 {
-		return BlogPost{}, errors.New("invalid markdown format")
+	title := meta["Title"]
+	if title == "" {
+		title = headingTitle(mdContent)
 	}
 
-	metadata := sections[0]
-	mdContent := sections[1]
-
-	// deal with rouge \r's
-	metadata = strings.ReplaceAll(metadata, "\r", "")
-	mdContent = strings.ReplaceAll(mdContent, "\r", "")
-
-	title, slug, parent, description, order, metaDescriptionStr,
-		metaPropertyTitleStr, metaPropertyDescriptionStr,
-		metaOgURLStr := parseMetaData(metadata)
-
-	htmlContent := mdToHTML([]byte(mdContent))
-	headers := extractHeaders([]byte(mdContent))
+	// a list of images, as hugo has them, starts with the feature image
+	var image string
+	if images := splitList(meta["Image"]); len(images) > 0 {
+		image = images[0]
+	}
 
-	return BlogPost{
+	post := BlogPost{
 		Title:                   title,
-		Slug:                    slug,
-		Parent:                  parent,
-		Description:             description,
+		Slug:                    meta["Slug"],
+		Parent:                  meta["Parent"],
+		Description:             meta["Description"],
 		Content:                 template.HTML(htmlContent),
 		Headers:                 headers,
 		Order:                   order,
-		MetaDescription:         metaDescriptionStr,
-		MetaPropertyTitle:       metaPropertyTitleStr,
-		MetaPropertyDescription: metaPropertyDescriptionStr,
-		MetaOgURL:               metaOgURLStr,
-	}, nil
+		Date:                    date,
+		Tags:                    splitList(meta["Tags"]),
+		Access:                  splitList(meta["Access"]),
+		OpenAPI:                 meta["OpenAPI"],
+		CrossPost:               splitList(meta["CrossPost"]),
+		MetaDescription:         meta["MetaDescription"],
+		MetaPropertyTitle:       meta["MetaPropertyTitle"],
+		MetaPropertyDescription: meta["MetaPropertyDescription"],
+		MetaOgURL:               meta["MetaOgURL"],
+		Priority:                meta["Priority"],
+		ChangeFreq:              meta["ChangeFreq"],
+		Image:                   image,
+		Robots:                  splitList(meta["Robots"]),
+	}
+	post.Draft, _ = strconv.ParseBool(meta["Draft"])
+	if meta["ExpiryDate"] != "" {
+		if post.ExpiryDate, err = parseDate(meta["ExpiryDate"]); err != nil {
+			return BlogPost{}, err
+		}
+	}
+	post.Featured, _ = strconv.ParseBool(meta["Featured"])
+	if meta["Weight"] != "" {
+		if post.Weight, err = strconv.Atoi(meta["Weight"]); err != nil {
+			return BlogPost{}, fmt.Errorf("invalid weight %q, expected a whole number", meta["Weight"])
+		}
+	} else {
+		// hugo allows fractional weights, those are left unset
+		post.Weight, _ = strconv.Atoi(meta["weight"])
+	}
+	if meta["Password"] != "" {
+		if err := protectPost(&post, protectPassword(meta["Password"])); err != nil {
+			return BlogPost{}, err
+		}
+	}
+
+	// pages without a description of their own still get one
+	if post.MetaDescription == "" {
+		post.MetaDescription = post.Description
+		if post.MetaDescription == "" {
+			post.MetaDescription = contentSummary(post.Content, metaDescriptionLength)
+		}
+		post.MetaDescriptionDerived = post.MetaDescription != ""
+	}
+	if post.MetaPropertyDescription == "" {
+		post.MetaPropertyDescription = post.MetaDescription
+	}
+	return post, nil
 }
 
-func parseMetaData(metadata string) (
-	title string,
-	slug string,
-	parent string,
-	description string,
-	order int,
-	metaDescription string,
-	metaPropertyTitle string,
-	metaPropertyDescription string,
-	metaOgURL string,
-) {
+// about as much of a description as search results show
+const metaDescriptionLength = 155
+
+var paragraphTag = regexp.MustCompile(`(?is)<p[\s>](.*?)</p>`)
+
+// contentSummary is the start of the content's paragraphs as plain text,
+// cut at a word within max characters
+func contentSummary(content template.HTML, max int) string {
+	var paragraphs []string
+	for _, p := range paragraphTag.FindAllStringSubmatch(string(content), -1) {
+		paragraphs = append(paragraphs, p[1])
+	}
+	text := strings.Join(paragraphs, " ")
+	if text == "" {
+		text = string(content)
+	}
+	text = strings.Join(strings.Fields(stdhtml.UnescapeString(tagRegexp.ReplaceAllString(text, " "))), " ")
+
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ",.;:") + "…"
+}
+
+// parseMetaData collects the "Key: value" lines of the metadata section
+func parseMetaData(metadata string) map[string]string {
 	re := regexp.MustCompile(`(?m)^(\w+):\s*(.+)`)
 	matches := re.FindAllStringSubmatch(metadata, -1)
 
 	metaDataMap := make(map[string]string)
 	for _, match := range matches {
 		if len(match) == 3 {
-			metaDataMap[match[1]] = match[2]
+			metaDataMap[match[1]] = strings.TrimSpace(match[2])
+		}
+	}
+
+	return metaDataMap
+}
+
+// URL is the path the post is served at, dated posts can live under their
+// year and month while pages in the sidebar keep flat slugs
+func (p BlogPost) URL() string {
+	if config().Permalinks == "date" && p.Version == "" && p.Parent == "" && !p.Date.IsZero() {
+		return fmt.Sprintf("/%04d/%02d/%s", p.Date.Year(), int(p.Date.Month()), p.Slug)
+	}
+	return "/" + p.Slug
+}
+
+// OgURL is the address shared links point to, the MetaOgURL when the post
+// sets one and otherwise its url on the site
+func (p BlogPost) OgURL() string {
+	if p.MetaOgURL != "" {
+		return p.MetaOgURL
+	}
+	if p.SourcePath == "index.md" {
+		return baseURL() + "/"
+	}
+	return baseURL() + p.URL()
+}
+
+// noindex reports whether search engines are asked to leave the post out,
+// which archived posts are
+func (p BlogPost) noindex() bool {
+	return p.Expired() || p.noindexDirective()
+}
+
+// noindexDirective reports whether the post's Robots say noindex
+func (p BlogPost) noindexDirective() bool {
+	for _, directive := range p.Robots {
+		if strings.EqualFold(directive, "noindex") || strings.EqualFold(directive, "none") {
+			return true
 		}
 	}
+	return false
+}
 
-	title = metaDataMap["Title"]
-	slug = metaDataMap["Slug"]
-	parent = metaDataMap["Parent"]
-	description = metaDataMap["Description"]
-	orderStr := metaDataMap["Order"]
-	metaDescriptionStr := metaDataMap["MetaDescription"]
-	metaPropertyTitleStr := metaDataMap["MetaPropertyTitle"]
-	metaPropertyDescriptionStr := metaDataMap["MetaPropertyDescription"]
-	metaOgURLStr := metaDataMap["MetaOgURL"]
+// CanonicalURL is the absolute url of the post this one is a copy of, if
+// it's one
+func (p BlogPost) CanonicalURL() string {
+	if p.Canonical == "" {
+		return ""
+	}
+	return absoluteURL(p.Canonical)
+}
 
-	order, err := strconv.Atoi(orderStr)
+// ImageURL is the absolute url of the post's feature image, if it has one.
+// A relative Image is relative to the post, like a link in its markdown
+func (p BlogPost) ImageURL() string {
+	if p.Image == "" {
+		return ""
+	}
+	base, err := url.Parse(baseURL() + p.URL())
 	if err != nil {
-		order = 9999 // set this to a high number in case of err
+		return absoluteURL(p.Image)
+	}
+	ref, err := url.Parse(p.Image)
+	if err != nil {
+		return absoluteURL(p.Image)
 	}
+	return base.ResolveReference(ref).String()
+}
 
-	return title, slug, parent, description, order, metaDescriptionStr,
-		metaPropertyTitleStr, metaPropertyDescriptionStr, metaOgURLStr
+// parseDate accepts a plain date or a full timestamp
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
+
+// splitList turns a comma separated metadata value into its trimmed items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// markdownEngine turns markdown into html, render.engine picks which
+type markdownEngine interface {
+	render(md []byte) []byte
+}
+
+var engines = map[string]markdownEngine{
+	"gomarkdown": gomarkdownEngine{},
+	"goldmark":   goldmarkEngine{},
+}
+
+// engine is the configured markdown engine, gomarkdown unless set
+func engine() markdownEngine {
+	if e, ok := engines[config().Render.Engine]; ok {
+		return e
+	}
+	return engines["gomarkdown"]
 }
 
 func mdToHTML(md []byte) []byte {
+	return engine().render(md)
+}
+
+type gomarkdownEngine struct{}
+
+func (gomarkdownEngine) render(md []byte) []byte {
 	extensions := parser.CommonExtensions | parser.AutoHeadingIDs
 	parser := parser.NewWithExtensions(extensions)
 
+	doc := parser.Parse(md)
+
+	active := activeHooks()
+	for _, hook := range active {
+		if hook.AST != nil {
+			hook.AST(doc)
+		}
+	}
+
 	opts := html.RendererOptions{
-		Flags: html.CommonFlags | html.HrefTargetBlank,
+		Flags:          html.CommonFlags | html.HrefTargetBlank,
+		RenderNodeHook: renderHook(active),
 	}
 	renderer := html.NewRenderer(opts)
-	doc := parser.Parse(md)
 
 	output := markdown.Render(doc, renderer)
 
@@ -273,25 +462,21 @@ func extractHeaders(content []byte) []string {
 	return headers
 }
 
-func loadSidebarData(dir string) (SideBar, error) {
+func buildSidebarData(posts []BlogPost) SideBar {
 	var sidebar SideBar
 	categoriesMap := make(map[string]*Category)
 
-	posts, err := loadMarkdownPosts(dir)
-	if err != nil {
-		return sidebar, err
-	}
-
 	for _, post := range posts {
 		if post.Parent != "" {
 			if _, exists := categoriesMap[post.Parent]; !exists {
 				categoriesMap[post.Parent] = &Category{
-					Name:  post.Parent,
-					Pages: []BlogPost{post},
-					Order: post.Order,
+					Name:   post.Parent,
+					Pages:  []SidebarPage{{BlogPost: post}},
+					Order:  post.Order,
+					IsOpen: true,
 				}
 			} else {
-				categoriesMap[post.Parent].Pages = append(categoriesMap[post.Parent].Pages, post)
+				categoriesMap[post.Parent].Pages = append(categoriesMap[post.Parent].Pages, SidebarPage{BlogPost: post})
 			}
 		}
 	}
@@ -301,22 +486,54 @@ func loadSidebarData(dir string) (SideBar, error) {
 		sidebar.Categories = append(sidebar.Categories, *cat)
 	}
 
-	// sort categories by order
+	// sort categories by order, then name so ties don't move around
 	sort.Slice(sidebar.Categories, func(i, j int) bool {
-		return sidebar.Categories[i].Order < sidebar.Categories[j].Order
+		a, b := sidebar.Categories[i], sidebar.Categories[j]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.Name < b.Name
 	})
 
-	return sidebar, nil
+	return sidebar
+}
+
+// TOCEntry is a section of a page for its contents, with the id its
+// heading was rendered with and the sections under it
+type TOCEntry struct {
+	Title string `json:"title"`
+	ID    string `json:"id"`
+	// 2 for an h2 through to 6
+	Level    int        `json:"level"`
+	Children []TOCEntry `json:"children,omitempty"`
+}
+
+// a rendered h2 to h6, its level and its id
+var tocHeading = regexp.MustCompile(`(?s)<h([2-6])\s[^>]*\bid="([^"]*)"[^>]*>(.*?)</h[2-6]>`)
+
+// createSidebarLinks is the page's sections, read from the rendered content
+// so the ids are the ones the headings got rather than guessed from the
+// markdown. Each heading is under the last one before it of a higher level
+func createSidebarLinks(content template.HTML) []TOCEntry {
+	var headings []TOCEntry
+	for _, m := range tocHeading.FindAllStringSubmatch(string(content), -1) {
+		level, _ := strconv.Atoi(m[1])
+		headings = append(headings, TOCEntry{Title: plainText(m[3]), ID: stdhtml.UnescapeString(m[2]), Level: level})
+	}
+	toc, _ := nestTOC(headings, 0)
+	return toc
 }
 
-func createSidebarLinks(headers []string) template.HTML {
-	var linksHTML string
-	for _, header := range headers {
-		sanitizedHeader := sanitizeHeaderForID(header)
-		link := fmt.Sprintf(`<li><a href="#%s">%s</a></li>`, sanitizedHeader, header)
-		linksHTML += link
+// nestTOC takes the headings deeper than level off the front of headings,
+// each with the deeper ones after it as its children, and returns the rest
+func nestTOC(headings []TOCEntry, level int) ([]TOCEntry, []TOCEntry) {
+	var entries []TOCEntry
+	for len(headings) > 0 && headings[0].Level > level {
+		entry := headings[0]
+		entry.Children, headings = nestTOC(headings[1:], entry.Level)
+		entries = append(entries, entry)
 	}
-	return template.HTML(linksHTML)
+	return entries, headings
 }
 
 func sanitizeHeaderForID(header string) string {
@@ -0,0 +1,64 @@
+package blog_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestSplitList(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"go", []string{"go"}},
+		{"go, web ,  ,testing,", []string{"go", "web", "testing"}},
+	} {
+		if got := blog.SplitList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-03-01 09:30", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)},
+		{"2024-03-01T09:30:00Z", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)},
+	} {
+		got, err := blog.ParseDate(tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseDate(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "01/03/2024", "2024-13-01"} {
+		if _, err := blog.ParseDate(in); err == nil {
+			t.Errorf("ParseDate(%q): no error", in)
+		}
+	}
+}
+
+func TestImageURL(t *testing.T) {
+	for _, tt := range []struct {
+		image string
+		want  string
+	}{
+		{"", ""},
+		{"/static/cover.png", "https://example.com/static/cover.png"},
+		{"cover.png", "https://example.com/cover.png"},
+		{"https://cdn.example.org/cover.png", "https://cdn.example.org/cover.png"},
+	} {
+		post := blog.BlogPost{Slug: "post", Image: tt.image}
+		blog.WithBaseURL("https://example.com", func() {
+			if got := post.ImageURL(); got != tt.want {
+				t.Errorf("ImageURL() with Image %q = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
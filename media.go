@@ -0,0 +1,364 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxUploadSize = 20 << 20
+
+var errUploadTooLarge = fmt.Errorf("image is over the %dMB upload limit", maxUploadSize>>20)
+
+// thumbnails sit next to their image, photo.jpg gets photo.thumb.jpg
+const thumbSuffix = ".thumb"
+
+// MediaFile is an uploaded image as the library lists it
+type MediaFile struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Thumbnail string    `json:"thumbnail"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Markdown  string    `json:"markdown"`
+}
+
+// mediaStore is where uploads are kept, names are slash separated paths
+// like 2024/05/photo.jpg
+type mediaStore interface {
+	put(name string, data []byte) error
+	exists(name string) bool
+	delete(name string) error
+	list() ([]MediaFile, error)
+	url(name string) string
+}
+
+func newMediaStore(cfg MediaConfig) mediaStore {
+	if cfg.Storage == "s3" {
+		prefix := strings.Trim(cfg.S3.Prefix, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		return &s3Media{client: newS3Client(cfg.S3), prefix: prefix, baseURL: strings.TrimRight(cfg.URL, "/")}
+	}
+
+	dir := mediaDir(cfg)
+	baseURL := strings.TrimRight(cfg.URL, "/")
+	if baseURL == "" {
+		baseURL = "/" + filepath.ToSlash(filepath.Clean(dir))
+	}
+	return &localMedia{dir: dir, baseURL: baseURL}
+}
+
+// mediaDir is where uploads go when they're kept on disk
+func mediaDir(cfg MediaConfig) string {
+	if cfg.Dir == "" {
+		return "static/media"
+	}
+	return cfg.Dir
+}
+
+// localMedia keeps uploads on disk, under static/ so they're served
+type localMedia struct {
+	dir     string
+	baseURL string
+}
+
+func (m *localMedia) put(name string, data []byte) error {
+	return writeFileAtomic(filepath.Join(m.dir, filepath.FromSlash(name)), data)
+}
+
+func (m *localMedia) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(m.dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func (m *localMedia) delete(name string) error {
+	return os.Remove(filepath.Join(m.dir, filepath.FromSlash(name)))
+}
+
+func (m *localMedia) url(name string) string {
+	return m.baseURL + "/" + name
+}
+
+func (m *localMedia) list() ([]MediaFile, error) {
+	var files []MediaFile
+	err := filepath.WalkDir(m.dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, MediaFile{Name: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return libraryFiles(m, files), err
+}
+
+// s3Media keeps uploads in a bucket, served from its public url or a cdn
+type s3Media struct {
+	client  *s3Client
+	prefix  string
+	baseURL string
+}
+
+func (m *s3Media) put(name string, data []byte) error {
+	headers := map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		headers["Content-Type"] = ctype
+	}
+	return m.client.put(m.prefix+name, data, headers)
+}
+
+func (m *s3Media) exists(name string) bool {
+	objects, err := m.client.list(m.prefix + name)
+	if err != nil {
+		return false
+	}
+	_, ok := objects[m.prefix+name]
+	return ok
+}
+
+func (m *s3Media) delete(name string) error {
+	return m.client.delete(m.prefix + name)
+}
+
+func (m *s3Media) url(name string) string {
+	return m.baseURL + "/" + m.prefix + name
+}
+
+func (m *s3Media) list() ([]MediaFile, error) {
+	objects, err := m.client.list(m.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []MediaFile
+	for key, obj := range objects {
+		files = append(files, MediaFile{Name: strings.TrimPrefix(key, m.prefix), Size: obj.Size, Modified: obj.LastModified})
+	}
+	return libraryFiles(m, files), nil
+}
+
+// libraryFiles pairs images with their thumbnails and fills in the urls,
+// newest first
+func libraryFiles(store mediaStore, all []MediaFile) []MediaFile {
+	thumbs := make(map[string]string)
+	var files []MediaFile
+	for _, file := range all {
+		if base, ok := thumbOf(file.Name); ok {
+			thumbs[base] = file.Name
+			continue
+		}
+		files = append(files, file)
+	}
+
+	for i, file := range files {
+		files[i].URL = store.url(file.Name)
+		files[i].Thumbnail = files[i].URL
+		if thumb, ok := thumbs[strings.TrimSuffix(file.Name, path.Ext(file.Name))]; ok {
+			files[i].Thumbnail = store.url(thumb)
+		}
+		files[i].Markdown = mediaMarkdown(file.Name, files[i].URL)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Modified.After(files[j].Modified)
+	})
+	return files
+}
+
+// thumbOf reports whether name is a thumbnail, and of which image (without
+// its extension)
+func thumbOf(name string) (string, bool) {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	if !strings.HasSuffix(base, thumbSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(base, thumbSuffix), true
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9\-_]+`)
+
+// mediaName picks a free name for an upload under the current month,
+// photo-2.jpg if photo.jpg is taken
+func mediaName(store mediaStore, filename, ext string, now time.Time) string {
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	base = strings.Trim(unsafeFileChars.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		base = "image"
+	}
+
+	dir := now.Format("2006/01") + "/"
+	name := dir + base + ext
+	for n := 2; store.exists(name); n++ {
+		name = fmt.Sprintf("%s%s-%d%s", dir, base, n, ext)
+	}
+	return name
+}
+
+// mediaMarkdown is the snippet to paste into a post, alt text from the name
+func mediaMarkdown(name, url string) string {
+	alt := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	alt = strings.NewReplacer("-", " ", "_", " ").Replace(alt)
+	return fmt.Sprintf("![%s](%s)", alt, url)
+}
+
+// upload cleans, stores and thumbnails one uploaded image
+func (s *server) upload(filename string, r io.Reader) (MediaFile, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxUploadSize+1))
+	if err != nil {
+		return MediaFile{}, err
+	}
+	if len(data) > maxUploadSize {
+		return MediaFile{}, fmt.Errorf("%s: %w", filename, errUploadTooLarge)
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExts[contentType]
+	if !ok {
+		return MediaFile{}, fmt.Errorf("%s: %w", filename, errUnsupportedImage)
+	}
+
+	if data, err = cleanImage(data, contentType); err != nil {
+		return MediaFile{}, err
+	}
+
+	name := mediaName(s.media, filename, ext, time.Now())
+	if err := s.media.put(name, data); err != nil {
+		return MediaFile{}, err
+	}
+
+	file := MediaFile{Name: name, URL: s.media.url(name), Size: int64(len(data)), Modified: time.Now()}
+	file.Thumbnail = file.URL
+	file.Markdown = mediaMarkdown(name, file.URL)
+
+	thumb, thumbExt, err := thumbnail(data, contentType)
+	if err != nil {
+		// still a usable upload, the library just shows the full image
+		log.Printf("Error making thumbnail for %s: %v\n", name, err)
+	}
+	if thumb != nil {
+		thumbName := strings.TrimSuffix(name, ext) + thumbSuffix + thumbExt
+		if err := s.media.put(thumbName, thumb); err != nil {
+			return MediaFile{}, err
+		}
+		file.Thumbnail = s.media.url(thumbName)
+	}
+
+	return file, nil
+}
+
+// mediaRoutes are the upload form and media library, for editors
+func (s *server) mediaRoutes(editor *gin.RouterGroup) {
+	editor.GET("/media", func(c *gin.Context) {
+		files, err := s.media.list()
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		s.html(c, http.StatusOK, "admin-media.html", gin.H{
+			"Title":   "Media",
+			"Session": c.MustGet("session"),
+			"Files":   files,
+			"Error":   c.Query("error"),
+		})
+	})
+
+	editor.POST("/media", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 5*maxUploadSize)
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+
+		var uploaded []MediaFile
+		var uploadErr error
+		for _, header := range form.File["file"] {
+			f, err := header.Open()
+			if err != nil {
+				uploadErr = err
+				break
+			}
+			file, err := s.upload(header.Filename, f)
+			f.Close()
+			if err != nil {
+				uploadErr = err
+				break
+			}
+			uploaded = append(uploaded, file)
+		}
+
+		if uploadErr != nil && !errors.Is(uploadErr, errUnsupportedImage) && !errors.Is(uploadErr, errUploadTooLarge) {
+			log.Printf("Error occured during operation: %v\n", uploadErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		// the drag and drop uploader wants the snippets back as json
+		if strings.Contains(c.GetHeader("Accept"), "application/json") {
+			if uploadErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": uploadErr.Error(), "files": uploaded})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"files": uploaded})
+			return
+		}
+
+		if uploadErr != nil {
+			c.Redirect(http.StatusSeeOther, "/admin/media?error="+strings.ReplaceAll(uploadErr.Error(), " ", "+"))
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/media")
+	})
+
+	editor.POST("/media/delete", func(c *gin.Context) {
+		name := path.Clean(c.PostForm("name"))
+		if name == "." || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "..") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+
+		if err := s.media.delete(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		// the thumbnail may be a png or a jpeg
+		base := strings.TrimSuffix(name, path.Ext(name)) + thumbSuffix
+		for _, ext := range []string{".jpg", ".png"} {
+			if s.media.exists(base + ext) {
+				if err := s.media.delete(base + ext); err != nil {
+					log.Printf("Error deleting thumbnail %s: %v\n", base+ext, err)
+				}
+			}
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/media")
+	})
+}
@@ -0,0 +1,304 @@
+package blog
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metadata that's a comma separated list, where filters match any item
+var listMetaKeys = map[string]bool{"Tags": true, "Access": true, "CrossPost": true}
+
+// metaName is the canonical name of a metadata key however it's spelled,
+// or the key itself when it isn't one posts use
+func metaName(key string) string {
+	name := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	if alias, ok := metaAliases[name]; ok {
+		return alias
+	}
+	for _, known := range metaKeys {
+		if strings.ToLower(known) == name {
+			return known
+		}
+	}
+	return key
+}
+
+// metaFilter picks posts by their metadata, key=value with category for
+// Parent and tag for Tags. An empty value picks posts without the key
+type metaFilter struct {
+	key, value string
+}
+
+type metaFilters []metaFilter
+
+func (f *metaFilters) String() string { return fmt.Sprint(*f) }
+
+func (f *metaFilters) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("%q isn't key=value", value)
+	}
+	switch key = strings.TrimSpace(key); strings.ToLower(key) {
+	case "category", "section":
+		key = "Parent"
+	case "tag":
+		key = "Tags"
+	default:
+		key = metaName(key)
+	}
+	*f = append(*f, metaFilter{key, strings.TrimSpace(val)})
+	return nil
+}
+
+// metaGet looks key up however the file spells it
+func metaGet(meta map[string]string, key string) string {
+	if value, ok := meta[key]; ok {
+		return value
+	}
+	for k, value := range meta {
+		if strings.EqualFold(metaName(k), key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// match reports whether the metadata passes every filter, ignoring case
+func (f metaFilters) match(meta map[string]string) bool {
+	for _, filter := range f {
+		value := metaGet(meta, filter.key)
+		if listMetaKeys[filter.key] && filter.value != "" {
+			found := false
+			for _, item := range splitList(value) {
+				found = found || strings.EqualFold(item, filter.value)
+			}
+			if !found {
+				return false
+			}
+		} else if !strings.EqualFold(value, filter.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// frontmatterKey finds a key at the start of a line in each frontmatter
+// style: "Key: value", yaml's key: value and toml's key = value
+var frontmatterKey = regexp.MustCompile(`^([\w-]+)\s*[:=]`)
+
+// editMeta sets field to value in a markdown file's frontmatter, or removes
+// it when value is nil, changing only the line it's on so comments, order
+// and quoting elsewhere stay as they were. Files without metadata get a
+// "Key: value" block
+func editMeta(content, field string, value *string) (string, error) {
+	if value != nil && strings.ContainsAny(*value, "\r\n") {
+		return "", errors.New("values have to fit on one line")
+	}
+	crlf := strings.Contains(content, "\r\n")
+	content = strings.ReplaceAll(content, "\r", "")
+
+	var start, delim string
+	switch {
+	case strings.HasPrefix(content, "+++\n"):
+		start, delim = "+++\n", "+++"
+	case strings.HasPrefix(content, "---\n"):
+		start, delim = "---\n", "---"
+	case strings.HasPrefix(strings.TrimLeft(content, " \n"), "{"):
+		return "", errors.New("json frontmatter can't be edited in place, change it by hand")
+	case metaLine.MatchString(strings.TrimSpace(content)):
+		if _, _, ok := delimitedBlock(content, "---"); ok {
+			delim = "---"
+		}
+	}
+
+	var block, body string
+	if delim != "" {
+		var ok bool
+		block, body, ok = delimitedBlock(content[len(start):], delim)
+		if !ok {
+			return "", fmt.Errorf("frontmatter has no closing %s", delim)
+		}
+	} else if value == nil {
+		return content, nil
+	} else {
+		// no metadata yet
+		start, delim, block, body = "", "---", "\n", "\n"+content
+	}
+
+	name := metaName(field)
+	// key is how the file already spells it, or the field for a new line
+	line := func(key string) string {
+		switch start {
+		case "+++\n":
+			return key + " = " + strconv.Quote(*value)
+		case "---\n":
+			quoted, _ := yaml.Marshal(*value)
+			return key + ": " + strings.TrimSpace(string(quoted))
+		default:
+			// these are read case sensitively
+			return metaName(key) + ": " + *value
+		}
+	}
+
+	lines := strings.SplitAfter(block, "\n")
+	var edited []string
+	found := false
+	for i := 0; i < len(lines); i++ {
+		m := frontmatterKey.FindStringSubmatch(lines[i])
+		if m == nil || !strings.EqualFold(metaName(m[1]), name) {
+			edited = append(edited, lines[i])
+			continue
+		}
+		// a yaml list or a toml array can carry on over indented lines
+		for i+1 < len(lines) && lines[i+1] != "" &&
+			(strings.HasPrefix(lines[i+1], " ") || strings.HasPrefix(lines[i+1], "\t") || strings.HasPrefix(lines[i+1], "]")) {
+			i++
+		}
+		if value != nil && !found {
+			edited = append(edited, line(m[1])+"\n")
+		}
+		found = true
+	}
+	if !found && value == nil {
+		return content, nil
+	}
+	if !found {
+		// after the last key, before any blank lines ending the block
+		at := len(edited)
+		for at > 0 && strings.TrimSpace(edited[at-1]) == "" {
+			at--
+		}
+		if at > 0 && !strings.HasSuffix(edited[at-1], "\n") {
+			edited[at-1] += "\n"
+		}
+		edited = append(edited[:at], append([]string{line(field) + "\n"}, edited[at:]...)...)
+	}
+
+	result := start + strings.Join(edited, "") + delim + "\n" + body
+	// "Key: value" lines only are metadata while there's at least one
+	if start == "" && strings.TrimSpace(strings.Join(edited, "")) == "" {
+		result = strings.TrimLeft(body, "\n")
+	}
+	if crlf {
+		result = strings.ReplaceAll(result, "\n", "\r\n")
+	}
+	return result, nil
+}
+
+// editMetaFiles applies editMeta to every markdown file under dir that the
+// filters pick, returning the files it changed. A file is only written when
+// it reads back with the new value and the same content
+func editMetaFiles(dir, field string, value *string, filters metaFilters, dryRun bool) ([]string, error) {
+	var changed []string
+	var errs []error
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".md") {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		meta, body, err := splitFrontmatter(string(data))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			return nil
+		}
+		if !filters.match(meta) {
+			return nil
+		}
+		// nothing to do, even in json frontmatter
+		current := metaGet(meta, metaName(field))
+		if value == nil && current == "" || value != nil && current != "" && current == *value {
+			return nil
+		}
+
+		edited, err := editMeta(string(data), field, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			return nil
+		}
+		if edited == string(data) {
+			return nil
+		}
+		newMeta, newBody, err := splitFrontmatter(edited)
+		want := ""
+		if value != nil {
+			want = *value
+		}
+		if err != nil || metaGet(newMeta, metaName(field)) != want || strings.TrimSpace(newBody) != strings.TrimSpace(body) {
+			errs = append(errs, fmt.Errorf("%s: editing the frontmatter would change more than %s, left alone", p, field))
+			return nil
+		}
+
+		changed = append(changed, p)
+		if dryRun {
+			return nil
+		}
+		return writeFileAtomic(p, []byte(edited))
+	})
+	if err != nil {
+		return changed, err
+	}
+	return changed, errors.Join(errs...)
+}
+
+// metaCommand edits frontmatter across many posts at once, for migrations
+// like adding a field every page now needs:
+// meta set <field> <value> [-where key=value]... [-dry-run]
+// meta unset <field> [-where key=value]... [-dry-run]
+func metaCommand(args []string) error {
+	usage := fmt.Errorf("usage: bloog meta set <field> <value> | unset <field> [-where key=value]... [-dry-run]")
+	if len(args) == 0 || (args[0] != "set" && args[0] != "unset") {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("meta "+args[0], flag.ExitOnError)
+	var filters metaFilters
+	fs.Var(&filters, "where", "only posts whose key has the value, category=Guides or tag=go, repeatable")
+	dryRun := fs.Bool("dry-run", false, "list the posts that would change without changing them")
+	// flags can come before or after the field and value
+	var positional []string
+	rest := args[1:]
+	for {
+		fs.Parse(rest)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+
+	var field string
+	var value *string
+	switch {
+	case args[0] == "set" && len(positional) == 2:
+		field, value = positional[0], &positional[1]
+	case args[0] == "unset" && len(positional) == 1:
+		field = positional[0]
+	default:
+		return usage
+	}
+	if !frontmatterKey.MatchString(field + ":") {
+		return fmt.Errorf("%q isn't a field name", field)
+	}
+
+	changed, err := editMetaFiles("./markdown", field, value, filters, *dryRun)
+	for _, p := range changed {
+		fmt.Println(p)
+	}
+	verb := "changed"
+	if *dryRun {
+		verb = "would change"
+	}
+	fmt.Printf("%s %d post(s)\n", verb, len(changed))
+	return err
+}
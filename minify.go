@@ -0,0 +1,191 @@
+package blog
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the minifiers here are deliberately conservative, they only strip
+// comments and whitespace that can't change how a page renders
+
+var (
+	whitespaceRegexp  = regexp.MustCompile(`\s+`)
+	htmlCommentRegexp = regexp.MustCompile(`<!--[\s\S]*?-->`)
+	// whitespace around block level tags never renders
+	blockTagRegexp = regexp.MustCompile(`(?i)\s*(</?(?:!doctype|html|head|body|meta|link|title|div|p|ul|ol|li|nav|main|aside|header|footer|section|article|h[1-6]|table|thead|tbody|tr|td|th|form|blockquote|hr|br)\b[^>]*>)\s*`)
+	cssSpaceRegexp = regexp.MustCompile(`\s*([{};,>])\s*`)
+)
+
+// raw elements keep their whitespace, scripts and styles get their own minifier
+var rawTags = []string{"pre", "textarea", "script", "style"}
+
+func minifyHTML(src []byte) []byte {
+	var out bytes.Buffer
+	s := string(src)
+	lower := strings.ToLower(s)
+
+	for {
+		start, tag := -1, ""
+		for _, t := range rawTags {
+			if i := indexTag(lower, "<"+t); i >= 0 && (start == -1 || i < start) {
+				start, tag = i, t
+			}
+		}
+		if start == -1 {
+			out.WriteString(minifyMarkup(s))
+			return out.Bytes()
+		}
+
+		out.WriteString(minifyMarkup(s[:start]))
+
+		end := strings.Index(lower[start:], "</"+tag)
+		if end == -1 {
+			// unclosed, leave the rest alone
+			out.WriteString(s[start:])
+			return out.Bytes()
+		}
+		end += start
+
+		open := strings.IndexByte(s[start:end], '>')
+		if open == -1 {
+			out.WriteString(s[start:end])
+		} else {
+			open += start + 1
+			out.WriteString(s[start:open])
+			switch tag {
+			case "script":
+				out.WriteString(minifyJS(s[open:end]))
+			case "style":
+				out.WriteString(minifyCSS(s[open:end]))
+			default:
+				out.WriteString(s[open:end])
+			}
+		}
+
+		s, lower = s[end:], lower[end:]
+	}
+}
+
+// indexTag finds an opening tag by name without matching longer names,
+// "<p" shouldn't find "<path"
+func indexTag(s, open string) int {
+	from := 0
+	for {
+		i := strings.Index(s[from:], open)
+		if i == -1 {
+			return -1
+		}
+		i += from
+		next := i + len(open)
+		if next >= len(s) || s[next] == '>' || s[next] == ' ' || s[next] == '\t' || s[next] == '\n' || s[next] == '\r' || s[next] == '/' {
+			return i
+		}
+		from = next
+	}
+}
+
+func minifyMarkup(s string) string {
+	s = htmlCommentRegexp.ReplaceAllStringFunc(s, func(comment string) string {
+		// conditional comments are instructions, not comments
+		if strings.HasPrefix(comment, "<!--[if") {
+			return comment
+		}
+		return ""
+	})
+	s = whitespaceRegexp.ReplaceAllString(s, " ")
+	return blockTagRegexp.ReplaceAllString(s, "$1")
+}
+
+// minifyCSS drops comments and the whitespace around punctuation, leaving
+// quoted strings such as content values as they are
+func minifyCSS(s string) string {
+	var out, plain strings.Builder
+	flush := func() {
+		p := whitespaceRegexp.ReplaceAllString(plain.String(), " ")
+		p = cssSpaceRegexp.ReplaceAllString(p, "$1")
+		p = strings.ReplaceAll(p, ": ", ":")
+		out.WriteString(strings.ReplaceAll(p, ";}", "}"))
+		plain.Reset()
+	}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				i = len(s)
+				break
+			}
+			i += end + 3
+			// a comment still separates what's either side of it
+			plain.WriteByte(' ')
+		case s[i] == '"' || s[i] == '\'':
+			flush()
+			end := i + 1
+			for end < len(s) && s[end] != s[i] && s[end] != '\n' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				end = len(s) - 1
+			}
+			out.WriteString(s[i : end+1])
+			i = end
+		default:
+			plain.WriteByte(s[i])
+		}
+	}
+	flush()
+	return strings.TrimSpace(out.String())
+}
+
+// minifyJS only trims lines and drops blank and comment lines, anything
+// cleverer needs a real parser because of automatic semicolon insertion
+func minifyJS(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// minifyResponses minifies html responses on their way out
+func minifyResponses(c *gin.Context) {
+	w := &minifyWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	if w.buf != nil {
+		w.ResponseWriter.Write(minifyHTML(w.buf.Bytes()))
+	}
+}
+
+// minifyWriter holds back html bodies until the handler is done so they can
+// be minified as a whole, everything else passes straight through
+type minifyWriter struct {
+	gin.ResponseWriter
+	buf *bytes.Buffer
+}
+
+func (w *minifyWriter) Write(data []byte) (int, error) {
+	if w.buf == nil && !w.ResponseWriter.Written() &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.buf = &bytes.Buffer{}
+	}
+	if w.buf != nil {
+		return w.buf.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *minifyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,24 @@
+package blog_test
+
+import (
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestMinifyCSS(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"a {\n  color: red;\n}\n", "a{color:red}"},
+		{"/* note */ a > b , c { margin: 0 }", "a>b,c{margin:0}"},
+		// strings keep their spacing, and what looks like a comment in them
+		{`a::before { content: "a:  b ; }"; }`, `a::before{content:"a:  b ; }"}`},
+		{`a { content: '/* x */'; font-family: "Fira  Code", monospace; }`, `a{content:'/* x */';font-family:"Fira  Code",monospace}`},
+		{`a { content: "say \"hi:  there\"" }`, `a{content:"say \"hi:  there\""}`},
+		// a descendant pseudo class isn't the same selector without the space
+		{"div :first-child { color: red }", "div :first-child{color:red}"},
+	} {
+		if got := blog.MinifyCSS(tt.in); got != tt.want {
+			t.Errorf("MinifyCSS(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package blog
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// archetypes are starter posts for bloog new, archetypes/<kind>.md,
+// with archetypes/default.md used when no kind is asked for
+const archetypeDir = "archetypes"
+
+// what bloog new starts a post from when there's no archetypes/default.md
+const defaultArchetype = `Title: {{ .Title }}
+Slug: {{ .Slug }}
+{{ with .Section }}Parent: {{ . }}
+{{ end }}Date: {{ .Date }}
+
+---
+
+`
+
+// archetypeData is what archetypes can use: {{ .Title }}, {{ .Slug }},
+// {{ .Section }}, {{ .Kind }} and {{ .Date }}, as well as the theme
+// functions
+type archetypeData struct {
+	Title   string
+	Slug    string
+	Section string
+	Kind    string
+	// YYYY-MM-DD, today
+	Date string
+	Now  time.Time
+}
+
+// archetypeKinds are the kinds there are archetypes for
+func archetypeKinds(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+	var kinds []string
+	for _, match := range matches {
+		kinds = append(kinds, strings.TrimSuffix(filepath.Base(match), ".md"))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// archetype reads the archetype for kind, the default one for ""
+func archetype(dir, kind string) (string, error) {
+	name := kind
+	if name == "" {
+		name = "default"
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".md"))
+	if errors.Is(err, os.ErrNotExist) {
+		if kind == "" {
+			return defaultArchetype, nil
+		}
+		kinds := archetypeKinds(dir)
+		if len(kinds) == 0 {
+			return "", fmt.Errorf("no archetype %q, there's no %s directory with any", kind, dir)
+		}
+		return "", fmt.Errorf("no archetype %q, there are: %s", kind, strings.Join(kinds, ", "))
+	}
+	return string(data), err
+}
+
+// newPost writes a post made from an archetype to dir, returning its path
+func newPost(archetypes, dir string, data archetypeData, force bool) (string, error) {
+	if data.Slug == "" {
+		data.Slug = sanitizeHeaderForID(data.Title)
+	}
+	if !slugRegexp.MatchString(data.Slug) {
+		return "", fmt.Errorf("no usable slug from the title %q, pass one with -slug", data.Title)
+	}
+	dest := filepath.Join(dir, data.Slug+".md")
+	if _, err := os.Stat(dest); err == nil && !force {
+		return "", fmt.Errorf("%s already exists, pass -force to overwrite it or -slug to pick another", dest)
+	}
+
+	text, err := archetype(archetypes, data.Kind)
+	if err != nil {
+		return "", err
+	}
+	name := data.Kind
+	if name == "" {
+		name = "default"
+	}
+	tmpl, err := template.New(name).Funcs(themeFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("archetype %s: %w", name, err)
+	}
+	var post bytes.Buffer
+	if err := tmpl.Execute(&post, data); err != nil {
+		return "", fmt.Errorf("archetype %s: %w", name, err)
+	}
+	// a broken archetype shouldn't leave a post the server can't load
+	if _, _, err := splitFrontmatter(post.String()); err != nil {
+		return "", fmt.Errorf("archetype %s makes a post with bad frontmatter: %w", name, err)
+	}
+	return dest, writeFileAtomic(dest, post.Bytes())
+}
+
+// newCommand starts a post from an archetype, so posts of a kind all have
+// the same frontmatter and sections to fill in:
+// new [-kind kind] [-section section] [-slug slug] [-force] <title>
+func newCommand(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	kind := fs.String("kind", "", "the archetype to start from, archetypes/<kind>.md")
+	section := fs.String("section", "", "the sidebar section the post goes in")
+	slug := fs.String("slug", "", "the post's slug, from its title unless set")
+	force := fs.Bool("force", false, "overwrite a post that already has the slug")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: bloog new [-kind kind] [-section section] [-slug slug] [-force] <title>")
+	}
+
+	now := time.Now()
+	dest, err := newPost(archetypeDir, "./markdown", archetypeData{
+		Title:   strings.Join(fs.Args(), " "),
+		Slug:    *slug,
+		Section: *section,
+		Kind:    *kind,
+		Date:    now.Format("2006-01-02"),
+		Now:     now,
+	}, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s\n", dest)
+	return nil
+}
@@ -0,0 +1,214 @@
+package blog
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many paths the not found log keeps, so scanners probing for
+// wp-login.php and friends can't grow it forever
+const maxMissedPaths = 1000
+
+// how much of a path is compared with the posts' urls, edit distance takes
+// time in the product of their lengths
+const maxNearMissPath = 100
+
+// how much of a path and referer the not found log keeps, a long url
+// shouldn't cost a long entry
+const maxMissedURL = 300
+
+// missedPath is a url that was asked for and isn't there
+type missedPath struct {
+	Path    string    `json:"path"`
+	Count   int       `json:"count"`
+	Referer string    `json:"referer,omitempty"`
+	Last    time.Time `json:"last"`
+}
+
+// notFoundLog counts requests for pages that don't exist, persisted to a
+// json file, so common typos and old links can be given redirects
+type notFoundLog struct {
+	*counterStore[missedPath]
+}
+
+func newNotFoundLog(path string) (*notFoundLog, error) {
+	store, err := newCounterStore(path, "the not found log", maxMissedPaths, func(miss missedPath) (int, time.Time) {
+		return miss.Count, miss.Last
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &notFoundLog{store}, nil
+}
+
+func (l *notFoundLog) record(path, referer string) {
+	path, referer = clipURL(path), clipURL(referer)
+	l.update(path, func(miss *missedPath) {
+		miss.Path = path
+		miss.Count++
+		miss.Last = time.Now()
+		// the latest referer from another site says where the bad link is
+		if referer != "" && !strings.HasPrefix(referer, baseURL()) {
+			miss.Referer = referer
+		}
+	})
+}
+
+// clipURL cuts url to maxMissedURL without splitting a rune
+func clipURL(url string) string {
+	if len(url) > maxMissedURL {
+		url = strings.ToValidUTF8(url[:maxMissedURL], "")
+	}
+	return url
+}
+
+// list is every missed path, most asked for first
+func (l *notFoundLog) list() []missedPath {
+	list := l.all()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// editDistance is the number of single character edits turning a into b
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+// nearMisses are the public posts whose url or slug is a typo away from
+// path, or starts with its last part, closest first
+func (s *server) nearMisses(path string, n int) []BlogPost {
+	path = strings.Trim(strings.ToLower(path), "/")
+	if runes := []rune(path); len(runes) > maxNearMissPath {
+		path = string(runes[:maxNearMissPath])
+	}
+	last := path[strings.LastIndex(path, "/")+1:]
+	if path == "" || n < 1 {
+		return nil
+	}
+
+	type match struct {
+		post     BlogPost
+		distance int
+	}
+	var matches []match
+	for _, post := range publicPosts(s.allPosts()) {
+		if post.Slug == "" {
+			continue
+		}
+		slug := strings.ToLower(post.Slug)
+		distance := min(editDistance(path, strings.Trim(strings.ToLower(post.URL()), "/")), editDistance(last, slug))
+		if len(last) >= 3 && strings.HasPrefix(slug, last) {
+			distance = min(distance, 1)
+		}
+		// longer slugs are allowed more typos
+		if distance <= max(2, len(slug)/4) {
+			matches = append(matches, match{post, distance})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var posts []BlogPost
+	for i := 0; i < len(matches) && i < n; i++ {
+		posts = append(posts, matches[i].post)
+	}
+	return posts
+}
+
+// notFound answers with the not found page, suggesting what the visitor
+// may have meant and the most read pages, and logs the miss
+func (s *server) notFound(c *gin.Context) {
+	if c.Request.Method == http.MethodGet && !s.offline {
+		s.misses.record(c.Request.URL.Path, c.Request.Referer())
+	}
+
+	suggestions, popular := config().NotFound.Suggestions, config().NotFound.Popular
+	if suggestions == 0 {
+		suggestions = 3
+	}
+	if popular == 0 {
+		popular = 5
+	}
+	var popularPosts []BlogPost
+	if popular > 0 {
+		popularPosts = s.views.popular(publicPosts(s.allPosts()), popular)
+	}
+
+	s.html(c, http.StatusNotFound, "404.html", gin.H{
+		"Title":       "Page Not Found",
+		"SidebarData": s.sidebar(c, ""),
+		"Suggestions": s.nearMisses(c.Request.URL.Path, suggestions),
+		"Popular":     popularPosts,
+	})
+}
+
+// notFoundCommand lists the urls visitors asked for that don't exist, the
+// most asked for first, to find typos and old links worth a redirect:
+// not-found [-json] [-min n]
+func notFoundCommand(args []string) error {
+	fs := flag.NewFlagSet("not-found", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	minCount := fs.Int("min", 1, "only list paths asked for at least this often")
+	fs.Parse(args)
+
+	misses, err := newNotFoundLog(filepath.Join(config().DataDir, "404s.json"))
+	if err != nil {
+		return err
+	}
+	var list []missedPath
+	for _, miss := range misses.list() {
+		if miss.Count >= *minCount {
+			list = append(list, miss)
+		}
+	}
+
+	if *asJSON {
+		if list == nil {
+			list = []missedPath{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	if len(list) == 0 {
+		fmt.Println("no missing pages asked for")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tPATH\tLAST\tREFERER")
+	for _, miss := range list {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", miss.Count, miss.Path, miss.Last.Format("2006-01-02"), miss.Referer)
+	}
+	return w.Flush()
+}
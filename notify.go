@@ -0,0 +1,32 @@
+package blog
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+func (s SMTPConfig) enabled() bool {
+	return s.Host != "" && s.From != "" && len(s.To) > 0
+}
+
+// sendMail sends a plain text email through the configured smtp server
+func sendMail(cfg SMTPConfig, subject, body string) error {
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	msg := "From: " + cfg.From + "\r\n" +
+		"To: " + strings.Join(cfg.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", cfg.Host, port), auth, cfg.From, cfg.To, []byte(msg))
+}
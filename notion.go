@@ -0,0 +1,601 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	notionAPI     = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+)
+
+func (n NotionConfig) enabled() bool {
+	return n.Token != "" && n.Database != ""
+}
+
+// notionText is a run of notion rich text
+type notionText struct {
+	PlainText   string  `json:"plain_text"`
+	Href        *string `json:"href"`
+	Annotations struct {
+		Bold          bool `json:"bold"`
+		Italic        bool `json:"italic"`
+		Strikethrough bool `json:"strikethrough"`
+		Code          bool `json:"code"`
+	} `json:"annotations"`
+}
+
+type notionFile struct {
+	Type     string `json:"type"`
+	External struct {
+		URL string `json:"url"`
+	} `json:"external"`
+	File struct {
+		URL string `json:"url"`
+	} `json:"file"`
+}
+
+// notionBlock is a block of a page, with the fields of every type this
+// converts. Notion puts them under a key named after the type, so they're
+// decoded from there
+type notionBlock struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	HasChildren bool   `json:"has_children"`
+
+	content notionContent
+}
+
+type notionContent struct {
+	RichText []notionText `json:"rich_text"`
+	Language string       `json:"language"`
+	Checked  bool         `json:"checked"`
+	Caption  []notionText `json:"caption"`
+	// bookmarks, embeds and linked videos
+	URL string `json:"url"`
+	notionFile
+	// table rows
+	Cells [][]notionText `json:"cells"`
+	Icon  struct {
+		Emoji string `json:"emoji"`
+	} `json:"icon"`
+}
+
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	type header notionBlock
+	if err := json.Unmarshal(data, (*header)(b)); err != nil {
+		return err
+	}
+	if content, ok := raw[b.Type]; ok {
+		return json.Unmarshal(content, &b.content)
+	}
+	return nil
+}
+
+type notionProperty struct {
+	Type     string       `json:"type"`
+	Title    []notionText `json:"title"`
+	RichText []notionText `json:"rich_text"`
+	Number   *float64     `json:"number"`
+	Checkbox bool         `json:"checkbox"`
+	URL      string       `json:"url"`
+	Select   *struct {
+		Name string `json:"name"`
+	} `json:"select"`
+	MultiSelect []struct {
+		Name string `json:"name"`
+	} `json:"multi_select"`
+	Date *struct {
+		Start string `json:"start"`
+	} `json:"date"`
+}
+
+type notionPage struct {
+	ID         string                    `json:"id"`
+	Properties map[string]notionProperty `json:"properties"`
+}
+
+// notionList is a page of results from any of notion's list endpoints
+type notionList struct {
+	Results    json.RawMessage `json:"results"`
+	HasMore    bool            `json:"has_more"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+func (n NotionConfig) request(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, notionAPI+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.Token)
+	req.Header.Set("Notion-Version", notionVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return jsonRequest(req, out)
+}
+
+// pages lists every page in the database
+func (n NotionConfig) pages() ([]notionPage, error) {
+	var pages []notionPage
+	body := map[string]interface{}{"page_size": 100}
+	for {
+		var list notionList
+		if err := n.request(http.MethodPost, "/databases/"+n.Database+"/query", body, &list); err != nil {
+			return nil, err
+		}
+		var results []notionPage
+		if err := json.Unmarshal(list.Results, &results); err != nil {
+			return nil, err
+		}
+		pages = append(pages, results...)
+		if !list.HasMore {
+			return pages, nil
+		}
+		body["start_cursor"] = list.NextCursor
+	}
+}
+
+// children lists a block's children, a page's content when it's the page
+func (n NotionConfig) children(id string) ([]notionBlock, error) {
+	var blocks []notionBlock
+	cursor := ""
+	for {
+		path := "/blocks/" + id + "/children?page_size=100"
+		if cursor != "" {
+			path += "&start_cursor=" + cursor
+		}
+		var list notionList
+		if err := n.request(http.MethodGet, path, nil, &list); err != nil {
+			return nil, err
+		}
+		var results []notionBlock
+		if err := json.Unmarshal(list.Results, &results); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, results...)
+		if !list.HasMore {
+			return blocks, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// metadata maps a page's properties to post metadata: the title property
+// is the Title, the rest go by properties in the config or else by name,
+// so a "Tags" multi select becomes Tags
+func (n NotionConfig) metadata(page notionPage) map[string]string {
+	canonical := make(map[string]string)
+	for _, key := range metaKeys {
+		canonical[strings.ToLower(key)] = key
+	}
+
+	meta := make(map[string]string)
+	for name, prop := range page.Properties {
+		key, ok := n.Properties[name]
+		if !ok && prop.Type == "title" {
+			key, ok = "Title", true
+		}
+		if !ok {
+			key, ok = canonical[strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name))]
+		}
+		if !ok {
+			continue
+		}
+
+		var value string
+		switch prop.Type {
+		case "title":
+			value = notionPlain(prop.Title)
+		case "rich_text":
+			value = notionPlain(prop.RichText)
+		case "number":
+			if prop.Number != nil {
+				value = metaValue(*prop.Number)
+			}
+		case "checkbox":
+			value = metaValue(prop.Checkbox)
+		case "url":
+			value = prop.URL
+		case "select":
+			if prop.Select != nil {
+				value = prop.Select.Name
+			}
+		case "multi_select":
+			var names []string
+			for _, option := range prop.MultiSelect {
+				names = append(names, option.Name)
+			}
+			value = strings.Join(names, ", ")
+		case "date":
+			if prop.Date != nil {
+				value = prop.Date.Start
+			}
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			meta[key] = value
+		}
+	}
+
+	if meta["Slug"] == "" {
+		meta["Slug"] = sanitizeHeaderForID(meta["Title"])
+	}
+	return meta
+}
+
+func notionPlain(texts []notionText) string {
+	var b strings.Builder
+	for _, t := range texts {
+		b.WriteString(t.PlainText)
+	}
+	return b.String()
+}
+
+// notionRich converts notion rich text to inline markdown
+func notionRich(texts []notionText) string {
+	var b strings.Builder
+	for _, t := range texts {
+		s := t.PlainText
+		if strings.TrimSpace(s) == "" {
+			b.WriteString(s)
+			continue
+		}
+		a := t.Annotations
+		if a.Code {
+			s = "`" + s + "`"
+		}
+		if a.Bold {
+			s = "**" + s + "**"
+		}
+		if a.Italic {
+			s = "_" + s + "_"
+		}
+		if a.Strikethrough {
+			s = "~~" + s + "~~"
+		}
+		if t.Href != nil {
+			s = "[" + s + "](" + *t.Href + ")"
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// markdown converts a page, or a block's children, to markdown
+func (n NotionConfig) markdown(id string) (string, error) {
+	blocks, err := n.children(id)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	number := 0
+	for i, block := range blocks {
+		c := block.content
+		var children string
+		if block.HasChildren && block.Type != "table" {
+			if children, err = n.markdown(block.ID); err != nil {
+				return "", err
+			}
+		}
+
+		// list items run together, everything else is its own paragraph
+		list := block.Type == "bulleted_list_item" || block.Type == "numbered_list_item" || block.Type == "to_do"
+		if i > 0 && !(list && isNotionListItem(blocks[i-1].Type)) {
+			b.WriteString("\n")
+		}
+		if block.Type == "numbered_list_item" {
+			number++
+		} else {
+			number = 0
+		}
+
+		switch block.Type {
+		case "paragraph":
+			b.WriteString(notionRich(c.RichText) + "\n")
+		case "heading_1":
+			b.WriteString("# " + notionRich(c.RichText) + "\n")
+		case "heading_2":
+			b.WriteString("## " + notionRich(c.RichText) + "\n")
+		case "heading_3":
+			b.WriteString("### " + notionRich(c.RichText) + "\n")
+		case "bulleted_list_item":
+			b.WriteString("- " + notionRich(c.RichText) + "\n" + indent(children, "  "))
+		case "numbered_list_item":
+			b.WriteString(fmt.Sprintf("%d. ", number) + notionRich(c.RichText) + "\n" + indent(children, "   "))
+		case "to_do":
+			check := " "
+			if c.Checked {
+				check = "x"
+			}
+			b.WriteString("- [" + check + "] " + notionRich(c.RichText) + "\n" + indent(children, "  "))
+		case "quote":
+			b.WriteString(indent(notionRich(c.RichText)+"\n"+children, "> "))
+		case "callout":
+			// as a github alert, styled by the callouts hook
+			b.WriteString(indent("[!NOTE]\n"+strings.TrimSpace(c.Icon.Emoji+" "+notionRich(c.RichText))+"\n"+children, "> "))
+		case "toggle":
+			b.WriteString("<details>\n<summary>" + notionRich(c.RichText) + "</summary>\n\n" + children + "\n</details>\n")
+		case "code":
+			b.WriteString("```" + c.Language + "\n" + notionPlain(c.RichText) + "\n```\n")
+		case "divider":
+			b.WriteString("---\n")
+		case "image":
+			src := c.External.URL
+			if c.Type == "file" {
+				// notion's own file urls expire after an hour, keep a copy
+				if src, err = selfHostImage(c.File.URL); err != nil {
+					return "", fmt.Errorf("image %s: %w", block.ID, err)
+				}
+			}
+			b.WriteString("![" + notionPlain(c.Caption) + "](" + src + ")\n")
+		case "bookmark", "embed", "video", "link_preview":
+			url := c.URL
+			if url == "" {
+				url = c.External.URL
+			}
+			label := notionPlain(c.Caption)
+			if label == "" {
+				label = url
+			}
+			b.WriteString("[" + label + "](" + url + ")\n")
+		case "table":
+			table, err := n.table(block.ID)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(table)
+		case "child_page", "child_database", "unsupported":
+			// not content of this page
+		default:
+			if len(c.RichText) > 0 {
+				b.WriteString(notionRich(c.RichText) + "\n")
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func isNotionListItem(blockType string) bool {
+	return blockType == "bulleted_list_item" || blockType == "numbered_list_item" || blockType == "to_do"
+}
+
+// table converts a table block's rows to a markdown table, the first row
+// is the header
+func (n NotionConfig) table(id string) (string, error) {
+	rows, err := n.children(id)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, row := range rows {
+		var cells []string
+		for _, cell := range row.content.Cells {
+			cells = append(cells, strings.ReplaceAll(notionRich(cell), "|", `\|`))
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString(strings.Repeat("| --- ", len(cells)) + "|\n")
+		}
+	}
+	return b.String(), nil
+}
+
+func indent(s, prefix string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// notionMarkdown is the markdown file a page is written to, with its metadata
+// in the usual "Key: value" lines and the page's id to find it again
+func notionMarkdown(id string, meta map[string]string, content string) []byte {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("NotionID: " + id + "\n")
+	for _, key := range keys {
+		b.WriteString(key + ": " + strings.ReplaceAll(meta[key], "\n", " ") + "\n")
+	}
+	b.WriteString("\n---\n\n" + content)
+	return []byte(b.String())
+}
+
+// notionID is the id of the page a file was synced from, "" for a file
+// that wasn't, or doesn't exist
+func notionID(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	meta, _, err := splitFrontmatter(string(content))
+	if err != nil {
+		return ""
+	}
+	return meta["NotionID"]
+}
+
+// syncNotion writes every page of the database into dir as markdown, and
+// removes the files of pages that have since been deleted. Only files
+// written for the same page are replaced or removed, a page whose slug is
+// taken by another post is reported instead. It reports whether any file
+// changed
+func syncNotion(cfg NotionConfig, dir string) (bool, error) {
+	pages, err := cfg.pages()
+	if err != nil {
+		return false, err
+	}
+
+	// the files an earlier sync wrote, by page id
+	synced := make(map[string]string)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+	for _, file := range files {
+		if id := notionID(file); id != "" {
+			synced[id] = file
+		}
+	}
+
+	changed := false
+	var errs []error
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		seen[page.ID] = true
+		meta := cfg.metadata(page)
+		if !slugRegexp.MatchString(meta["Slug"]) {
+			errs = append(errs, fmt.Errorf("notion page %s: no usable slug from %q", page.ID, meta["Title"]))
+			continue
+		}
+		content, err := cfg.markdown(page.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notion page %s: %w", page.ID, err))
+			continue
+		}
+
+		path := filepath.Join(dir, meta["Slug"]+".md")
+		if _, err := os.Stat(path); err == nil && notionID(path) != page.ID {
+			errs = append(errs, fmt.Errorf("notion page %s: %s is another post", page.ID, path))
+			continue
+		}
+		file := notionMarkdown(page.ID, meta, content)
+		if existing, err := os.ReadFile(path); err != nil || !bytes.Equal(existing, file) {
+			if err := writeFileAtomic(path, file); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			changed = true
+		}
+
+		// a renamed page moves to its new slug, once it's there
+		if old, ok := synced[page.ID]; ok && old != path {
+			if err := os.Remove(old); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			changed = true
+		}
+	}
+
+	for id, file := range synced {
+		if !seen[id] && notionID(file) == id {
+			if err := os.Remove(file); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			changed = true
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// syncNotion syncs the database and reloads the content if it changed,
+// one sync at a time
+func (s *server) syncNotion() {
+	s.notionMu.Lock()
+	defer s.notionMu.Unlock()
+
+	changed, err := syncNotion(config().Notion, s.contentDir)
+	if err != nil {
+		log.Printf("Error syncing notion: %v\n", err)
+	}
+	if !changed {
+		return
+	}
+	changes, err := s.reload()
+	if err != nil {
+		log.Printf("Error reloading content: %v\n", err)
+		return
+	}
+	log.Printf("Synced notion: %s\n", changes)
+}
+
+// syncNotionEvery syncs on startup and then every interval
+func (s *server) syncNotionEvery(interval time.Duration) {
+	s.syncNotion()
+	for range time.Tick(interval) {
+		s.syncNotion()
+	}
+}
+
+// handleNotionHook syncs when notion, or anything else knowing the hooks
+// secret, says the database changed. Notion's own webhooks first send a
+// verification token to paste back into notion, and sign with it after, so
+// their signature is checked against notion.verification_token
+func (s *server) handleNotionHook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	var verification struct {
+		Token string `json:"verification_token"`
+	}
+	if json.Unmarshal(body, &verification) == nil && verification.Token != "" {
+		log.Printf("Notion webhook verification token: %s\n", verification.Token)
+		c.JSON(http.StatusOK, gin.H{"status": "received"})
+		return
+	}
+
+	verified := false
+	if signature := c.GetHeader("X-Notion-Signature"); signature != "" {
+		verified = verifyHookSecret(config().Notion.VerificationToken, body, signature, "")
+	} else {
+		verified = verifyHookSecret(config().Hooks.Secret, body, c.GetHeader("X-Hub-Signature-256"), c.GetHeader("Authorization"))
+	}
+	if !verified {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	go s.syncNotion()
+	c.JSON(http.StatusAccepted, gin.H{"status": "syncing"})
+}
+
+// notionCommand syncs the notion database into the content once
+func notionCommand(args []string) error {
+	if !config().Notion.enabled() {
+		return errors.New("notion isn't configured, set notion.token and notion.database")
+	}
+	changed, err := syncNotion(config().Notion, markdownDir)
+	if changed {
+		fmt.Println("synced notion, content changed")
+	} else if err == nil {
+		fmt.Println("synced notion, nothing changed")
+	}
+	return err
+}
@@ -0,0 +1,417 @@
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the subset of an OpenAPI 3 document the reference pages render, yaml.v3
+// reads json specs just as well
+type openAPISpec struct {
+	Info struct {
+		Title       string `yaml:"title"`
+		Version     string `yaml:"version"`
+		Description string `yaml:"description"`
+	} `yaml:"info"`
+	Servers []struct {
+		URL         string `yaml:"url"`
+		Description string `yaml:"description"`
+	} `yaml:"servers"`
+	Tags []struct {
+		Name        string `yaml:"name"`
+		Description string `yaml:"description"`
+	} `yaml:"tags"`
+	Paths      map[string]openAPIPathItem `yaml:"paths"`
+	Components struct {
+		Schemas    map[string]*openAPISchema   `yaml:"schemas"`
+		Parameters map[string]openAPIParameter `yaml:"parameters"`
+	} `yaml:"components"`
+}
+
+type openAPIPathItem struct {
+	Parameters []openAPIParameter `yaml:"parameters"`
+	Get        *openAPIOperation  `yaml:"get"`
+	Put        *openAPIOperation  `yaml:"put"`
+	Post       *openAPIOperation  `yaml:"post"`
+	Patch      *openAPIOperation  `yaml:"patch"`
+	Delete     *openAPIOperation  `yaml:"delete"`
+	Head       *openAPIOperation  `yaml:"head"`
+	Options    *openAPIOperation  `yaml:"options"`
+}
+
+type openAPIOperation struct {
+	OperationID string             `yaml:"operationId"`
+	Summary     string             `yaml:"summary"`
+	Description string             `yaml:"description"`
+	Tags        []string           `yaml:"tags"`
+	Deprecated  bool               `yaml:"deprecated"`
+	Parameters  []openAPIParameter `yaml:"parameters"`
+	RequestBody *struct {
+		Description string                      `yaml:"description"`
+		Required    bool                        `yaml:"required"`
+		Content     map[string]openAPIMediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]struct {
+		Description string                      `yaml:"description"`
+		Content     map[string]openAPIMediaType `yaml:"content"`
+	} `yaml:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref         string         `yaml:"$ref"`
+	Name        string         `yaml:"name"`
+	In          string         `yaml:"in"`
+	Description string         `yaml:"description"`
+	Required    bool           `yaml:"required"`
+	Schema      *openAPISchema `yaml:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `yaml:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `yaml:"$ref"`
+	Type        string                    `yaml:"type"`
+	Format      string                    `yaml:"format"`
+	Description string                    `yaml:"description"`
+	Enum        []interface{}             `yaml:"enum"`
+	Items       *openAPISchema            `yaml:"items"`
+	Properties  map[string]*openAPISchema `yaml:"properties"`
+	Required    []string                  `yaml:"required"`
+}
+
+// the shapes handed to openAPITemplate
+type apiSection struct {
+	Name        string
+	ID          string
+	Description template.HTML
+	Operations  []apiOperation
+}
+
+type apiOperation struct {
+	ID          string
+	Method      string
+	Path        string
+	Summary     string
+	Description template.HTML
+	Deprecated  bool
+	Parameters  []apiField
+	Body        []apiBody
+	Responses   []apiResponse
+}
+
+type apiField struct {
+	Name        string
+	In          string
+	Type        template.HTML
+	Required    bool
+	Description template.HTML
+}
+
+type apiBody struct {
+	ContentType string
+	Type        template.HTML
+}
+
+type apiResponse struct {
+	Status      string
+	Description template.HTML
+	Bodies      []apiBody
+}
+
+type apiSchema struct {
+	Name        string
+	ID          string
+	Description template.HTML
+	Fields      []apiField
+}
+
+var openAPITemplate = template.Must(template.New("openapi").Parse(`<div class="api-reference">
+{{ with .Servers }}<ul class="api-servers">{{ range . }}<li><code>{{ .URL }}</code> {{ .Description }}</li>{{ end }}</ul>{{ end }}
+{{ range .Sections }}
+<h2 id="{{ .ID }}">{{ .Name }}</h2>
+{{ .Description }}
+{{ range .Operations }}
+<div class="api-operation{{ if .Deprecated }} deprecated{{ end }}" id="{{ .ID }}">
+<h3><span class="api-method api-{{ .Method }}">{{ .Method }}</span> <code>{{ .Path }}</code></h3>
+{{ with .Summary }}<p class="api-summary">{{ . }}</p>{{ end }}
+{{ .Description }}
+{{ with .Parameters }}<h4>Parameters</h4>
+<table class="api-fields"><tr><th>Name</th><th>In</th><th>Type</th><th>Description</th></tr>
+{{ range . }}<tr><td><code>{{ .Name }}</code>{{ if .Required }} <span class="api-required">required</span>{{ end }}</td><td>{{ .In }}</td><td>{{ .Type }}</td><td>{{ .Description }}</td></tr>
+{{ end }}</table>{{ end }}
+{{ with .Body }}<h4>Request body</h4>
+<ul>{{ range . }}<li><code>{{ .ContentType }}</code> {{ .Type }}</li>{{ end }}</ul>{{ end }}
+{{ with .Responses }}<h4>Responses</h4>
+<table class="api-fields"><tr><th>Status</th><th>Description</th><th>Body</th></tr>
+{{ range . }}<tr><td><code>{{ .Status }}</code></td><td>{{ .Description }}</td><td>{{ range .Bodies }}<code>{{ .ContentType }}</code> {{ .Type }}<br>{{ end }}</td></tr>
+{{ end }}</table>{{ end }}
+</div>
+{{ end }}
+{{ end }}
+{{ with .Schemas }}
+<h2 id="schemas">Schemas</h2>
+{{ range . }}
+<div class="api-schema" id="{{ .ID }}">
+<h3>{{ .Name }}</h3>
+{{ .Description }}
+{{ with .Fields }}<table class="api-fields"><tr><th>Field</th><th>Type</th><th>Description</th></tr>
+{{ range . }}<tr><td><code>{{ .Name }}</code>{{ if .Required }} <span class="api-required">required</span>{{ end }}</td><td>{{ .Type }}</td><td>{{ .Description }}</td></tr>
+{{ end }}</table>{{ end }}
+</div>
+{{ end }}
+{{ end }}
+</div>`))
+
+// renderOpenAPI turns the spec at path into reference html, one section per
+// tag, and returns the section names for the page's headers
+func renderOpenAPI(path string) (template.HTML, []string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var spec openAPISpec
+	if err := yaml.Unmarshal(raw, &spec); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+
+	sections := spec.sections()
+	schemas := spec.schemas()
+
+	var headers []string
+	for _, section := range sections {
+		headers = append(headers, section.Name)
+	}
+	if len(schemas) > 0 {
+		headers = append(headers, "Schemas")
+	}
+
+	var buf bytes.Buffer
+	err = openAPITemplate.Execute(&buf, map[string]interface{}{
+		"Servers":  spec.Servers,
+		"Sections": sections,
+		"Schemas":  schemas,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return template.HTML(buf.String()), headers, nil
+}
+
+// sections groups the operations by their first tag, declared tags first in
+// the order given and the rest alphabetically
+func (spec *openAPISpec) sections() []apiSection {
+	byTag := make(map[string]*apiSection)
+	var order []string
+	for _, tag := range spec.Tags {
+		byTag[tag.Name] = &apiSection{
+			Name:        tag.Name,
+			ID:          sanitizeHeaderForID(tag.Name),
+			Description: markdownHTML(tag.Description),
+		}
+		order = append(order, tag.Name)
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for p := range spec.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var undeclared []string
+	for _, p := range paths {
+		item := spec.Paths[p]
+		for _, method := range []struct {
+			name string
+			op   *openAPIOperation
+		}{
+			{"get", item.Get}, {"post", item.Post}, {"put", item.Put}, {"patch", item.Patch},
+			{"delete", item.Delete}, {"head", item.Head}, {"options", item.Options},
+		} {
+			if method.op == nil {
+				continue
+			}
+
+			tag := "Endpoints"
+			if len(method.op.Tags) > 0 {
+				tag = method.op.Tags[0]
+			}
+			if byTag[tag] == nil {
+				byTag[tag] = &apiSection{Name: tag, ID: sanitizeHeaderForID(tag)}
+				undeclared = append(undeclared, tag)
+			}
+
+			params := append(append([]openAPIParameter{}, item.Parameters...), method.op.Parameters...)
+			byTag[tag].Operations = append(byTag[tag].Operations, spec.operation(method.name, p, method.op, params))
+		}
+	}
+	sort.Strings(undeclared)
+
+	var sections []apiSection
+	for _, tag := range append(order, undeclared...) {
+		if len(byTag[tag].Operations) > 0 {
+			sections = append(sections, *byTag[tag])
+		}
+	}
+	return sections
+}
+
+func (spec *openAPISpec) operation(method, path string, op *openAPIOperation, params []openAPIParameter) apiOperation {
+	id := op.OperationID
+	if id == "" {
+		id = method + "-" + path
+	}
+
+	out := apiOperation{
+		ID:          sanitizeHeaderForID(id),
+		Method:      strings.ToUpper(method),
+		Path:        path,
+		Summary:     op.Summary,
+		Description: markdownHTML(op.Description),
+		Deprecated:  op.Deprecated,
+	}
+
+	for _, param := range params {
+		if param.Ref != "" {
+			param = spec.Components.Parameters[refName(param.Ref)]
+		}
+		out.Parameters = append(out.Parameters, apiField{
+			Name:        param.Name,
+			In:          param.In,
+			Type:        schemaType(param.Schema),
+			Required:    param.Required,
+			Description: markdownHTML(param.Description),
+		})
+	}
+
+	if op.RequestBody != nil {
+		out.Body = mediaTypes(op.RequestBody.Content)
+	}
+
+	statuses := make([]string, 0, len(op.Responses))
+	for status := range op.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		resp := op.Responses[status]
+		out.Responses = append(out.Responses, apiResponse{
+			Status:      status,
+			Description: markdownHTML(resp.Description),
+			Bodies:      mediaTypes(resp.Content),
+		})
+	}
+
+	return out
+}
+
+func (spec *openAPISpec) schemas() []apiSchema {
+	names := make([]string, 0, len(spec.Components.Schemas))
+	for name := range spec.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schemas []apiSchema
+	for _, name := range names {
+		schema := spec.Components.Schemas[name]
+		if schema == nil {
+			continue
+		}
+
+		required := make(map[string]bool)
+		for _, field := range schema.Required {
+			required[field] = true
+		}
+
+		fields := make([]string, 0, len(schema.Properties))
+		for field := range schema.Properties {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		out := apiSchema{
+			Name:        name,
+			ID:          "schema-" + sanitizeHeaderForID(name),
+			Description: markdownHTML(schema.Description),
+		}
+		for _, field := range fields {
+			prop := schema.Properties[field]
+			var description string
+			if prop != nil {
+				description = prop.Description
+			}
+			out.Fields = append(out.Fields, apiField{
+				Name:        field,
+				Type:        schemaType(prop),
+				Required:    required[field],
+				Description: markdownHTML(description),
+			})
+		}
+		schemas = append(schemas, out)
+	}
+	return schemas
+}
+
+// mediaTypes lists a content map by content type
+func mediaTypes(content map[string]openAPIMediaType) []apiBody {
+	types := make([]string, 0, len(content))
+	for contentType := range content {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+
+	var bodies []apiBody
+	for _, contentType := range types {
+		bodies = append(bodies, apiBody{ContentType: contentType, Type: schemaType(content[contentType].Schema)})
+	}
+	return bodies
+}
+
+// schemaType is a short human readable type, "array of Pet" and the like,
+// with references linked to their schema
+func schemaType(schema *openAPISchema) template.HTML {
+	switch {
+	case schema == nil:
+		return ""
+	case schema.Ref != "":
+		name := refName(schema.Ref)
+		return template.HTML(fmt.Sprintf(`<a href="#schema-%s">%s</a>`,
+			sanitizeHeaderForID(name), template.HTMLEscapeString(name)))
+	case schema.Type == "array":
+		return "array of " + schemaType(schema.Items)
+	case schema.Format != "":
+		return template.HTML(template.HTMLEscapeString(schema.Type + " (" + schema.Format + ")"))
+	case len(schema.Enum) > 0:
+		var values []string
+		for _, v := range schema.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		return template.HTML(template.HTMLEscapeString(schema.Type + ": " + strings.Join(values, ", ")))
+	default:
+		return template.HTML(template.HTMLEscapeString(schema.Type))
+	}
+}
+
+// refName is the last part of a local reference like #/components/schemas/Pet
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// markdownHTML renders the commonmark descriptions specs allow
+func markdownHTML(text string) template.HTML {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return template.HTML(mdToHTML([]byte(text)))
+}
@@ -0,0 +1,461 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// plugin is a lua script from the plugins directory, registering what it
+// adds through the bloog module when it's loaded. Lua states aren't safe to
+// share between goroutines, so calls into a plugin take turns
+type plugin struct {
+	name string
+
+	mu sync.Mutex
+	L  *lua.LState
+
+	transforms []*lua.LFunction
+	shortcodes map[string]*lua.LFunction
+	funcs      map[string]*lua.LFunction
+	routes     []pluginRoute
+	// event type to handlers
+	events map[string][]*lua.LFunction
+	// template data for every page
+	data []*lua.LFunction
+}
+
+// pluginTimeout is how long a plugin gets to load, and for each call, before
+// it's stopped
+const pluginTimeout = 5 * time.Second
+
+// newPluginState is a lua state with only the libraries that can't reach
+// outside of it: no io, os, package or debug, and no loading other files
+func newPluginState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+type pluginRoute struct {
+	method  string
+	path    string
+	handler *lua.LFunction
+}
+
+// loadPlugins runs every .lua file in dir, in name order. A plugin that
+// fails to load is left out and reported with the others
+func loadPlugins(dir string, posts func() []BlogPost) ([]*plugin, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("plugins directory %s doesn't exist", dir)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var plugins []*plugin
+	var errs []error
+	for _, file := range files {
+		p := &plugin{
+			name:       strings.TrimSuffix(filepath.Base(file), ".lua"),
+			L:          newPluginState(),
+			shortcodes: make(map[string]*lua.LFunction),
+			funcs:      make(map[string]*lua.LFunction),
+			events:     make(map[string][]*lua.LFunction),
+		}
+		p.L.SetGlobal("bloog", p.module(posts))
+		ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+		p.L.SetContext(ctx)
+		err := p.L.DoFile(file)
+		p.L.RemoveContext()
+		cancel()
+		if err != nil {
+			p.L.Close()
+			// lua errors carry a stack trace, the first line says what's wrong
+			msg, _, _ := strings.Cut(err.Error(), "\n")
+			errs = append(errs, fmt.Errorf("plugin %s: %s", p.name, msg))
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, errors.Join(errs...)
+}
+
+// module is the bloog table plugins register themselves with:
+//
+//	bloog.transform(function(source, file) return source end)
+//	bloog.shortcode("youtube", function(args) return "<iframe ...>" end)
+//	bloog.func("shout", function(s) return s:upper() end)
+//	bloog.route("GET", "/hello", function(req) return {status = 200, body = "hi"} end)
+//	bloog.on("post.added", function(event) print(event.url) end)
+//	bloog.data(function(req) return {beta = req.query.beta == "1"} end)
+//	bloog.posts()
+func (p *plugin) module(posts func() []BlogPost) *lua.LTable {
+	return p.L.SetFuncs(p.L.NewTable(), map[string]lua.LGFunction{
+		"transform": func(L *lua.LState) int {
+			p.transforms = append(p.transforms, L.CheckFunction(1))
+			return 0
+		},
+		"shortcode": func(L *lua.LState) int {
+			p.shortcodes[L.CheckString(1)] = L.CheckFunction(2)
+			return 0
+		},
+		"func": func(L *lua.LState) int {
+			p.funcs[L.CheckString(1)] = L.CheckFunction(2)
+			return 0
+		},
+		"route": func(L *lua.LState) int {
+			p.routes = append(p.routes, pluginRoute{
+				method:  strings.ToUpper(L.CheckString(1)),
+				path:    L.CheckString(2),
+				handler: L.CheckFunction(3),
+			})
+			return 0
+		},
+		"data": func(L *lua.LState) int {
+			p.data = append(p.data, L.CheckFunction(1))
+			return 0
+		},
+		"on": func(L *lua.LState) int {
+			eventType := L.CheckString(1)
+			p.events[eventType] = append(p.events[eventType], L.CheckFunction(2))
+			return 0
+		},
+		"posts": func(L *lua.LState) int {
+			list := L.NewTable()
+			for _, post := range publicPosts(posts()) {
+				list.Append(toLua(L, map[string]interface{}{
+					"title":       post.Title,
+					"slug":        post.Slug,
+					"url":         post.URL(),
+					"description": post.Description,
+					"parent":      post.Parent,
+					"tags":        post.Tags,
+					"date":        dateOrEmpty(post),
+				}))
+			}
+			L.Push(list)
+			return 1
+		},
+	})
+}
+
+// subscribePlugins passes events on to the plugins that asked for them
+func (s *server) subscribePlugins(events *eventBus) {
+	for _, p := range s.plugins {
+		for eventType, handlers := range p.events {
+			for _, fn := range handlers {
+				p, fn := p, fn
+				events.subscribe(eventType, func(e Event) error {
+					event := map[string]interface{}{
+						"type":    e.Type,
+						"slug":    e.Slug,
+						"initial": e.Initial,
+						"dir":     e.Dir,
+					}
+					if e.Post != nil {
+						event["title"] = e.Post.Title
+						event["url"] = e.Post.URL()
+					}
+					_, err := p.call(fn, event)
+					return err
+				})
+			}
+		}
+	}
+}
+
+func dateOrEmpty(post BlogPost) string {
+	if post.Date.IsZero() {
+		return ""
+	}
+	return post.Date.Format("2006-01-02")
+}
+
+// call runs fn with args and returns its first result
+func (p *plugin) call(fn *lua.LFunction, args ...interface{}) (lua.LValue, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// a plugin stuck in a loop would hold up every request that uses it
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	p.L.SetContext(ctx)
+	defer p.L.RemoveContext()
+
+	values := make([]lua.LValue, len(args))
+	for i, arg := range args {
+		values[i] = toLua(p.L, arg)
+	}
+	if err := p.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, values...); err != nil {
+		return lua.LNil, fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+	ret := p.L.Get(-1)
+	p.L.Pop(1)
+	return ret, nil
+}
+
+// {{< name arg key=value key="quoted value" >}}
+var (
+	shortcodePattern = regexp.MustCompile(`\{\{<\s*([\w-]+)((?:\s+[^>]*?)?)\s*>\}\}`)
+	shortcodeArg     = regexp.MustCompile(`([\w-]+)=(?:"([^"]*)"|(\S+))|"([^"]*)"|(\S+)`)
+)
+
+// transformContent runs a markdown file's source through every plugin's
+// transforms, then expands the shortcodes they registered
+func transformContent(plugins []*plugin, file string, content []byte) ([]byte, error) {
+	source := string(content)
+	for _, p := range plugins {
+		for _, fn := range p.transforms {
+			ret, err := p.call(fn, source, file)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := ret.(lua.LString)
+			if !ok {
+				return nil, fmt.Errorf("plugin %s: transform returned a %s, not a string", p.name, ret.Type())
+			}
+			source = string(s)
+		}
+	}
+
+	var shortcodeErr error
+	source = shortcodePattern.ReplaceAllStringFunc(source, func(match string) string {
+		parts := shortcodePattern.FindStringSubmatch(match)
+		for _, p := range plugins {
+			fn, ok := p.shortcodes[parts[1]]
+			if !ok {
+				continue
+			}
+			ret, err := p.call(fn, parseShortcodeArgs(parts[2]))
+			if err != nil {
+				shortcodeErr = err
+				return match
+			}
+			return lua.LVAsString(ret)
+		}
+		// not ours, leave it for whoever wrote it
+		return match
+	})
+	return []byte(source), shortcodeErr
+}
+
+// shortcodeArgs are a shortcode's arguments, a lua table that's both a
+// list of the positional ones (args[1]) and has the named ones (args.id)
+type shortcodeArgs struct {
+	positional []string
+	named      map[string]string
+}
+
+func parseShortcodeArgs(raw string) shortcodeArgs {
+	args := shortcodeArgs{named: make(map[string]string)}
+	for _, m := range shortcodeArg.FindAllStringSubmatch(raw, -1) {
+		if m[1] != "" {
+			args.named[m[1]] = m[2] + m[3]
+		} else {
+			args.positional = append(args.positional, m[4]+m[5])
+		}
+	}
+	return args
+}
+
+// pluginFuncs are the template funcs plugins registered, their results
+// used as html
+func pluginFuncs(plugins []*plugin) template.FuncMap {
+	funcs := template.FuncMap{}
+	for _, p := range plugins {
+		for name, fn := range p.funcs {
+			p, fn := p, fn
+			funcs[name] = func(args ...interface{}) (template.HTML, error) {
+				ret, err := p.call(fn, args...)
+				return template.HTML(lua.LVAsString(ret)), err
+			}
+		}
+	}
+	return funcs
+}
+
+// pluginRoutes registers the plugins' routes. A handler returns the body
+// as a string, or a table with status, body, content_type, headers or json
+func pluginRoutes(r *gin.Engine, plugins []*plugin) {
+	for _, p := range plugins {
+		for _, route := range p.routes {
+			registerPluginRoute(r, p, route)
+		}
+	}
+}
+
+func registerPluginRoute(r *gin.Engine, p *plugin, route pluginRoute) {
+	// gin panics on routes that clash with the built in ones
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Error adding route %s %s from plugin %s: %v\n", route.method, route.path, p.name, err)
+		}
+	}()
+
+	r.Handle(route.method, route.path, func(c *gin.Context) {
+		body, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		req := pluginRequest(c)
+		req["body"] = string(body)
+
+		ret, err := p.call(route.handler, req)
+		if err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		response, ok := ret.(*lua.LTable)
+		if !ok {
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(lua.LVAsString(ret)))
+			return
+		}
+
+		status := http.StatusOK
+		if n, ok := response.RawGetString("status").(lua.LNumber); ok {
+			status = int(n)
+		}
+		if headers, ok := response.RawGetString("headers").(*lua.LTable); ok {
+			headers.ForEach(func(k, v lua.LValue) {
+				c.Header(k.String(), v.String())
+			})
+		}
+		if json := response.RawGetString("json"); json != lua.LNil {
+			c.JSON(status, fromLua(json))
+			return
+		}
+		contentType := "text/html; charset=utf-8"
+		if ct, ok := response.RawGetString("content_type").(lua.LString); ok {
+			contentType = string(ct)
+		}
+		c.Data(status, contentType, []byte(lua.LVAsString(response.RawGetString("body"))))
+	})
+}
+
+// pluginRequest is the request as plugins' handlers get it, without the body
+func pluginRequest(c *gin.Context) map[string]interface{} {
+	query := make(map[string]interface{})
+	for key := range c.Request.URL.Query() {
+		query[key] = c.Query(key)
+	}
+	params := make(map[string]interface{})
+	for _, param := range c.Params {
+		params[param.Key] = param.Value
+	}
+	return map[string]interface{}{
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"query":  query,
+		"params": params,
+	}
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return v
+	case string:
+		return lua.LString(v)
+	case template.HTML:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []string:
+		t := L.NewTable()
+		for _, s := range v {
+			t.Append(lua.LString(s))
+		}
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case shortcodeArgs:
+		t := toLua(L, v.positional).(*lua.LTable)
+		for key, value := range v.named {
+			t.RawSetString(key, lua.LString(value))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.NewTable()
+		for key, item := range v {
+			t.RawSetString(key, toLua(L, item))
+		}
+		return t
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// fromLua converts a lua value for encoding as json, tables with only
+// numbered keys become lists
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, fromLua(v.RawGetInt(i)))
+			}
+			return list
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(key, value lua.LValue) {
+			m[key.String()] = fromLua(value)
+		})
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package blog
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ldJSONTag = regexp.MustCompile(`(?is)<script[^>]*type="application/ld\+json"[^>]*>(.*?)</script>`)
+
+// socialTag is a meta tag a page emits for whoever shares it
+type socialTag struct {
+	Key     string
+	Content string
+}
+
+// socialCard is what a link to a page looks like when it's shared, worked
+// out the way the sites showing it do: og: tags first, then twitter: tags,
+// then the title and description
+type socialCard struct {
+	Title       string
+	Description string
+	Image       string
+	Site        string
+	Large       bool
+}
+
+// socialTags are the og:, twitter: and description meta tags of a rendered
+// page, in the order the page has them
+func socialTags(page string) []socialTag {
+	var tags []socialTag
+	for _, tag := range metaTag.FindAllString(page, -1) {
+		var key, content string
+		for _, attr := range metaAttr.FindAllStringSubmatch(tag, -1) {
+			if strings.EqualFold(attr[1], "content") {
+				content = html.UnescapeString(attr[2])
+			} else {
+				key = strings.ToLower(attr[2])
+			}
+		}
+		if key == "description" || strings.HasPrefix(key, "og:") || strings.HasPrefix(key, "twitter:") {
+			tags = append(tags, socialTag{Key: key, Content: content})
+		}
+	}
+	return tags
+}
+
+// ldJSON are the page's json-ld blocks, indented when they parse
+func ldJSON(page string) []string {
+	var blocks []string
+	for _, m := range ldJSONTag.FindAllStringSubmatch(page, -1) {
+		block := strings.TrimSpace(m[1])
+		var v interface{}
+		if err := json.Unmarshal([]byte(block), &v); err == nil {
+			if indented, err := json.MarshalIndent(v, "", "  "); err == nil {
+				block = string(indented)
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func previewCard(page string, tags []socialTag) socialCard {
+	values := make(map[string]string)
+	for _, tag := range tags {
+		if _, ok := values[tag.Key]; !ok && tag.Content != "" {
+			values[tag.Key] = tag.Content
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if v := values[key]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	card := socialCard{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		Image:       first("og:image", "twitter:image"),
+		Large:       values["twitter:card"] == "summary_large_image",
+	}
+	if card.Title == "" {
+		if t := titleTag.FindStringSubmatch(page); t != nil {
+			card.Title = strings.TrimSpace(html.UnescapeString(t[1]))
+		}
+	}
+	site := first("og:url")
+	if site == "" {
+		site = baseURL()
+	}
+	if u, err := url.Parse(site); err == nil && u.Host != "" {
+		card.Site = strings.TrimPrefix(u.Host, "www.")
+	}
+	return card
+}
+
+// handlePreview renders a page and shows the metadata it gives sites that
+// link to it, with a mock-up of the card they'd show:
+// /debug/preview/<slug>
+func (s *server) handlePreview(r http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := strings.Trim(c.Param("slug"), "/")
+		post, ok := s.post(slug)
+		if !ok {
+			s.html(c, http.StatusNotFound, "404.html", gin.H{"Title": "Page Not Found"})
+			return
+		}
+		path := post.URL()
+		if post.SourcePath == "index.md" {
+			path = "/"
+		}
+
+		// with the viewer's cookies, so restricted pages render for admins
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Cookie", c.GetHeader("Cookie"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		page := w.Body.String()
+		tags := socialTags(page)
+
+		s.html(c, http.StatusOK, "debug-preview.html", gin.H{
+			"Title":  "Preview of " + post.Title,
+			"Path":   path,
+			"Status": w.Code,
+			"Tags":   tags,
+			"LDJSON": ldJSON(page),
+			"Card":   previewCard(page, tags),
+		})
+	}
+}
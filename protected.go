@@ -0,0 +1,93 @@
+package blog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// how hard deriving the key from a password is made, the browser does the
+// same work once per visit
+const protectIterations = 200000
+
+// protectedPage is what a password protected post shows instead of its
+// content until the script in static/js/protected.js decrypts it
+var protectedPage = template.Must(template.New("protected").Parse(`<div class="protected-page" data-payload="{{ .Payload }}" data-iterations="{{ .Iterations }}">
+<p>This page is protected. Enter the password to read it.</p>
+<form class="protected-form">
+<input type="password" aria-label="Password" placeholder="Password" required>
+<button type="submit">Unlock</button>
+<p class="protected-error" hidden>That's not the password.</p>
+</form>
+</div>
+<script defer src="/static/js/protected.js"></script>
+`))
+
+// protectPassword is the password set by the Password metadata, read from
+// the environment when it's $NAME so it needn't be committed
+func protectPassword(value string) string {
+	if name, ok := strings.CutPrefix(value, "$"); ok {
+		return os.Getenv(name)
+	}
+	return value
+}
+
+// encryptContent encrypts html with AES-GCM under a key derived from the
+// password with PBKDF2-SHA256, as salt, nonce and ciphertext in base64,
+// which is what the browser's WebCrypto can undo
+func encryptContent(html []byte, password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, protectIterations, 32, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := append(append(salt, nonce...), gcm.Seal(nil, nonce, html, nil)...)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// protectPost swaps the post's content for its encrypted form, leaving
+// nothing readable behind for search, feeds or the page's headings
+func protectPost(post *BlogPost, password string) error {
+	if password == "" {
+		return fmt.Errorf("the password for %q is empty", post.Title)
+	}
+	payload, err := encryptContent([]byte(post.Content), password)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := protectedPage.Execute(&b, map[string]interface{}{"Payload": payload, "Iterations": protectIterations}); err != nil {
+		return err
+	}
+	post.Content = template.HTML(b.String())
+	post.Headers = nil
+	// rather than a summary of the form
+	if post.MetaDescription == "" && post.Description == "" {
+		post.MetaDescription = "This page is protected."
+		post.MetaDescriptionDerived = true
+	}
+	post.Protected = true
+	return nil
+}
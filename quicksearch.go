@@ -0,0 +1,137 @@
+package blog
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many results the quick search returns unless limit says, and at most
+const (
+	quickSearchResults    = 8
+	maxQuickSearchResults = 20
+)
+
+// QuickResult is a page the cmd-K palette can jump to
+type QuickResult struct {
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+	URL   string `json:"url"`
+}
+
+type quickEntry struct {
+	result QuickResult
+	title  string
+	// the title's and slug's words, lowercase
+	titleWords []string
+	slugWords  []string
+}
+
+// quickIndex matches the start of words in titles and slugs, nothing more,
+// so it answers as fast as someone can type
+type quickIndex struct {
+	mu      sync.RWMutex
+	entries []quickEntry
+}
+
+func (q *quickIndex) index(posts []BlogPost) {
+	var entries []quickEntry
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+		title := strings.ToLower(post.Title)
+		entries = append(entries, quickEntry{
+			result:     QuickResult{Title: post.Title, Slug: post.Slug, URL: post.URL()},
+			title:      title,
+			titleWords: tokenize(title),
+			slugWords:  tokenize(post.Slug),
+		})
+	}
+
+	q.mu.Lock()
+	q.entries = entries
+	q.mu.Unlock()
+}
+
+// hasPrefixes reports whether every term starts one of the words
+func hasPrefixes(words, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// search finds the pages where every term starts a word of the title or
+// slug. Titles starting with the query come first, then other title
+// matches, then slug matches, shorter titles first within each
+func (q *quickIndex) search(query string, limit int) []QuickResult {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []QuickResult{}
+	}
+	query = strings.Join(terms, " ")
+
+	type match struct {
+		entry *quickEntry
+		rank  int
+	}
+	var matches []match
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	for i := range q.entries {
+		entry := &q.entries[i]
+		switch {
+		case strings.HasPrefix(strings.Join(entry.titleWords, " "), query):
+			matches = append(matches, match{entry, 0})
+		case hasPrefixes(entry.titleWords, terms):
+			matches = append(matches, match{entry, 1})
+		case hasPrefixes(entry.slugWords, terms):
+			matches = append(matches, match{entry, 2})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if len(a.entry.title) != len(b.entry.title) {
+			return len(a.entry.title) < len(b.entry.title)
+		}
+		return a.entry.title < b.entry.title
+	})
+
+	results := []QuickResult{}
+	for i := 0; i < len(matches) && i < limit; i++ {
+		results = append(results, matches[i].entry.result)
+	}
+	return results
+}
+
+// handleQuickSearch answers the cmd-K palette: titles and urls of the pages
+// whose title or slug words start with what's typed, /api/quick-search?q=dep
+func (s *server) handleQuickSearch(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit < 1 {
+		limit = quickSearchResults
+	}
+	limit = min(limit, maxQuickSearchResults)
+
+	// the same few letters get typed over and over
+	c.Header("Cache-Control", "public, max-age=60")
+	c.JSON(http.StatusOK, gin.H{"results": s.quick.search(c.Query("q"), limit)})
+}
@@ -0,0 +1,45 @@
+package blog
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter decides whether another event for key is allowed right now
+type limiter interface {
+	allow(key string) bool
+}
+
+// rateLimiter allows up to limit events per key in each fixed window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	counts map[string]int
+	reset  time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+		reset:  time.Now().Add(window),
+	}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.After(l.reset) {
+		l.counts = make(map[string]int)
+		l.reset = now.Add(l.window)
+	}
+
+	if l.counts[key] >= l.limit {
+		return false
+	}
+	l.counts[key]++
+	return true
+}
@@ -0,0 +1,165 @@
+package blog
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how often the salt visitors' ips are hashed with changes, each visitor
+// counts once per reaction until then
+const reactionSaltLifetime = 24 * time.Hour
+
+var reactionKinds = []string{"like", "heart", "thumbsup"}
+
+var reactionEmoji = map[string]string{
+	"like":     "\u2b50",
+	"heart":    "\u2764\ufe0f",
+	"thumbsup": "\U0001F44D",
+}
+
+type ReactionCount struct {
+	Kind  string
+	Emoji string
+	Count int
+}
+
+// reactionStore keeps reaction counts per slug along with salted hashes of
+// who reacted, so each visitor only counts once per reaction. The salt is
+// only ever in memory and changes daily, so the hashes can't be turned back
+// into ips and aren't saved
+type reactionStore struct {
+	mu     sync.Mutex
+	path   string
+	Counts map[string]map[string]int `json:"counts"`
+	voters map[string]bool
+	salt   []byte
+	salted time.Time
+}
+
+func newReactionStore(path string) (*reactionStore, error) {
+	s := &reactionStore{
+		path:   path,
+		Counts: make(map[string]map[string]int),
+	}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func validReaction(kind string) bool {
+	for _, k := range reactionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// react records the reaction for the visitor ip, returning the new counts
+// and whether it was counted rather than a repeat
+func (s *reactionStore) react(slug, kind, ip string) (map[string]int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.salted) > reactionSaltLifetime {
+		s.salt = make([]byte, 32)
+		if _, err := rand.Read(s.salt); err != nil {
+			return nil, false, err
+		}
+		s.salted = time.Now()
+		s.voters = make(map[string]bool)
+	}
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(slug + "\x00" + kind + "\x00" + ip))
+	voter := hex.EncodeToString(mac.Sum(nil))
+
+	if s.voters[voter] {
+		return s.countsLocked(slug), false, nil
+	}
+
+	if s.Counts[slug] == nil {
+		s.Counts[slug] = make(map[string]int)
+	}
+	s.Counts[slug][kind]++
+	s.voters[voter] = true
+
+	if err := saveJSON(s.path, s); err != nil {
+		return nil, false, err
+	}
+
+	return s.countsLocked(slug), true, nil
+}
+
+// counts returns every reaction kind for slug, including the zero ones
+func (s *reactionStore) counts(slug string) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.countsLocked(slug)
+}
+
+func (s *reactionStore) countsLocked(slug string) map[string]int {
+	counts := make(map[string]int, len(reactionKinds))
+	for _, kind := range reactionKinds {
+		counts[kind] = s.Counts[slug][kind]
+	}
+	return counts
+}
+
+// list returns the counts for slug in display order, for templates
+func (s *reactionStore) list(slug string) []ReactionCount {
+	counts := s.counts(slug)
+
+	var list []ReactionCount
+	for _, kind := range reactionKinds {
+		list = append(list, ReactionCount{Kind: kind, Emoji: reactionEmoji[kind], Count: counts[kind]})
+	}
+	return list
+}
+
+func (s *server) handleGetReactions(c *gin.Context) {
+	slug := c.Param("slug")
+	if _, ok := s.readablePost(c, slug); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slug": slug, "reactions": s.reactions.counts(slug)})
+}
+
+// handleReact counts a reaction, each visitor counts once per reaction
+func (s *server) handleReact(c *gin.Context) {
+	slug := c.Param("slug")
+	if _, ok := s.readablePost(c, slug); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	var body struct {
+		Reaction string `json:"reaction" form:"reaction"`
+	}
+	if err := c.ShouldBind(&body); err != nil || !validReaction(body.Reaction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reaction must be one of " + strings.Join(reactionKinds, ", ")})
+		return
+	}
+
+	counts, counted, err := s.reactions.react(slug, body.Reaction, c.ClientIP())
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slug": slug, "reactions": counts, "counted": counted})
+}
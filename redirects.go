@@ -0,0 +1,109 @@
+package blog
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// matchPath matches a request path against a rule pattern, either an exact
+// path or a prefix ending in *, returning whatever the * matched
+func matchPath(pattern, p string) (splat string, ok bool) {
+	if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+		if strings.HasPrefix(p, prefix) {
+			return p[len(prefix):], true
+		}
+		return "", false
+	}
+	return "", strings.TrimSuffix(p, "/") == strings.TrimSuffix(pattern, "/")
+}
+
+func (r RedirectRule) code() int {
+	if r.Status == 0 {
+		return http.StatusMovedPermanently
+	}
+	return r.Status
+}
+
+// redirects sends requests matching a configured rule to its target, :splat
+// in the target is replaced with what the pattern's * matched
+func redirects(rules []RedirectRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, rule := range rules {
+			splat, ok := matchPath(rule.From, c.Request.URL.Path)
+			if !ok {
+				continue
+			}
+
+			to := strings.ReplaceAll(rule.To, ":splat", splat)
+			if c.Request.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(rule.code(), to)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// customHeaders adds the headers of every rule matching the path
+func customHeaders(rules []HeaderRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, rule := range rules {
+			if _, ok := matchPath(rule.Path, c.Request.URL.Path); !ok {
+				continue
+			}
+			for name, value := range rule.Values {
+				c.Header(name, value)
+			}
+		}
+		c.Next()
+	}
+}
+
+// paths whose responses depend on who's asking
+var personalPaths = []string{"/admin", "/api/", "/auth/", "/hooks/", "/debug/"}
+
+// personal reports whether the response to c can depend on who sent it:
+// the admin area and apis, anyone signed in or sending credentials, and
+// everything on a private site. Static assets never do
+func personal(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/static/") {
+		return false
+	}
+	if config().Private.Enabled || c.GetHeader("Authorization") != "" {
+		return true
+	}
+	if _, err := c.Cookie(sessionCookie); err == nil {
+		return true
+	}
+	for _, prefix := range personalPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheHeaders sets Cache-Control from the first cache rule matching the
+// path. Personal responses are never cached by anyone else whatever the
+// rules say
+func cacheHeaders(rules []CacheRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if personal(c) {
+			c.Header("Cache-Control", "private, no-cache")
+			c.Next()
+			return
+		}
+		for _, rule := range rules {
+			if _, ok := matchPath(rule.Path, c.Request.URL.Path); ok {
+				c.Header("Cache-Control", rule.Control)
+				break
+			}
+		}
+		c.Next()
+	}
+}
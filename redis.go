@@ -0,0 +1,243 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisTimeout = 2 * time.Second
+	// rendered markdown is keyed by its content, so this only bounds memory
+	renderedTTL = 7 * 24 * time.Hour
+)
+
+// redisStore shares state between instances behind a load balancer:
+// sessions, rate limits, view counts and rendered content
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisStore(cfg RedisConfig) (*redisStore, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "bloog:"
+	}
+
+	r := &redisStore{client: redis.NewClient(opts), prefix: prefix}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return r, nil
+}
+
+func (r *redisStore) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+func (r *redisStore) key(name string) string {
+	return r.prefix + name
+}
+
+// getJSON reports whether key was found and decoded into out
+func (r *redisStore) getJSON(key string, out interface{}) bool {
+	ctx, cancel := r.ctx()
+	defer cancel()
+
+	data, err := r.client.Get(ctx, r.key(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Error reading %s from redis: %v\n", key, err)
+		}
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
+
+func (r *redisStore) setJSON(key string, v interface{}, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding %s for redis: %v\n", key, err)
+		return
+	}
+
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.client.Set(ctx, r.key(key), data, ttl).Err(); err != nil {
+		log.Printf("Error writing %s to redis: %v\n", key, err)
+	}
+}
+
+// sessions, expiring in redis along with the cookie
+
+func (r *redisStore) load(id string) (Session, bool) {
+	var session Session
+	ok := r.getJSON("session:"+id, &session)
+	return session, ok
+}
+
+func (r *redisStore) store(id string, session Session) {
+	r.setJSON("session:"+id, session, time.Until(session.Expires))
+}
+
+func (r *redisStore) remove(id string) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.client.Del(ctx, r.key("session:"+id)).Err(); err != nil {
+		log.Printf("Error deleting session from redis: %v\n", err)
+	}
+}
+
+// rendered markdown, so a post is only rendered once across instances
+
+func (r *redisStore) rendered(hash string) (BlogPost, bool) {
+	var post BlogPost
+	ok := r.getJSON("rendered:"+hash, &post)
+	return post, ok
+}
+
+func (r *redisStore) storeRendered(hash string, post BlogPost) {
+	r.setJSON("rendered:"+hash, post, renderedTTL)
+}
+
+// redisRateLimiter is a rateLimiter counting in redis, each window is a key
+// that expires with it
+type redisRateLimiter struct {
+	redis  *redisStore
+	name   string
+	limit  int
+	window time.Duration
+}
+
+func (r *redisStore) rateLimiter(name string, limit int, window time.Duration) *redisRateLimiter {
+	return &redisRateLimiter{redis: r, name: name, limit: limit, window: window}
+}
+
+func (l *redisRateLimiter) allow(key string) bool {
+	ctx, cancel := l.redis.ctx()
+	defer cancel()
+
+	bucket := time.Now().UnixNano() / int64(l.window)
+	k := l.redis.key(fmt.Sprintf("rate:%s:%s:%d", l.name, key, bucket))
+
+	var count *redis.IntCmd
+	_, err := l.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.Incr(ctx, k)
+		pipe.Expire(ctx, k, l.window)
+		return nil
+	})
+	if err != nil {
+		// better to let a few extra through than to stop everyone
+		log.Printf("Error rate limiting through redis: %v\n", err)
+		return true
+	}
+	return count.Val() <= int64(l.limit)
+}
+
+// redisViews keeps the view counts in a redis hash of slug to count
+type redisViews struct {
+	redis *redisStore
+}
+
+// newRedisViews imports the counts from the views json file the first time,
+// so switching to redis doesn't start every post from zero
+func newRedisViews(r *redisStore, path string) (*redisViews, error) {
+	v := &redisViews{redis: r}
+	ctx, cancel := r.ctx()
+	defer cancel()
+
+	n, err := r.client.HLen(ctx, r.key("views")).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if n > 0 {
+		return v, nil
+	}
+
+	counts := make(map[string]int)
+	if err := loadJSON(path, &counts); err != nil {
+		return nil, err
+	}
+	if len(counts) == 0 {
+		return v, nil
+	}
+	values := make(map[string]interface{}, len(counts))
+	for slug, count := range counts {
+		values[slug] = count
+	}
+	if err := r.client.HSet(ctx, r.key("views"), values).Err(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return v, nil
+}
+
+func (v *redisViews) hit(slug string) {
+	ctx, cancel := v.redis.ctx()
+	defer cancel()
+	if err := v.redis.client.HIncrBy(ctx, v.redis.key("views"), slug, 1).Err(); err != nil {
+		log.Printf("Error counting view in redis: %v\n", err)
+	}
+}
+
+func (v *redisViews) count(slug string) int {
+	ctx, cancel := v.redis.ctx()
+	defer cancel()
+	n, err := v.redis.client.HGet(ctx, v.redis.key("views"), slug).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Error reading view count from redis: %v\n", err)
+	}
+	return n
+}
+
+// counts is every post's count, by slug
+func (v *redisViews) counts() (map[string]int, error) {
+	ctx, cancel := v.redis.ctx()
+	defer cancel()
+	all, err := v.redis.client.HGetAll(ctx, v.redis.key("views")).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	counts := make(map[string]int, len(all))
+	for slug, value := range all {
+		counts[slug], _ = strconv.Atoi(value)
+	}
+	return counts, nil
+}
+
+func (v *redisViews) popular(posts []BlogPost, n int) []BlogPost {
+	counts, err := v.counts()
+	if err != nil {
+		log.Printf("Error reading view counts from redis: %v\n", err)
+		return nil
+	}
+
+	var viewed []BlogPost
+	for _, post := range posts {
+		if counts[post.Slug] > 0 {
+			viewed = append(viewed, post)
+		}
+	}
+	sort.SliceStable(viewed, func(i, j int) bool {
+		return counts[viewed[i].Slug] > counts[viewed[j].Slug]
+	})
+
+	if len(viewed) > n {
+		viewed = viewed[:n]
+	}
+	return viewed
+}
@@ -0,0 +1,67 @@
+package blog_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+	"github.com/anuragcsangal/blog/bloogtest"
+)
+
+func TestMarkdownGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/render/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			md, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bloogtest.Golden(t, strings.TrimSuffix(file, ".md")+".html", blog.MdToHTML(md))
+		})
+	}
+}
+
+func TestGoldmarkGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/render/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := blog.RenderConfig{Engine: "goldmark"}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			md, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".md") + ".html"
+			blog.WithRender(render, func() {
+				bloogtest.Golden(t, filepath.Join("testdata/render/goldmark", name), blog.MdToHTML(md))
+			})
+		})
+	}
+}
+
+func TestRenderHooksGolden(t *testing.T) {
+	render := blog.RenderConfig{Hooks: []string{"figures", "table-class", "table-wrap", "callouts", "mermaid"}, TableClass: "table", ColorScheme: "auto"}
+	for _, name := range []string{"images", "tables", "callouts", "diagrams"} {
+		md, err := os.ReadFile(filepath.Join("testdata/render", name+".md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blog.WithRender(render, func() {
+			bloogtest.Golden(t, filepath.Join("testdata/render/hooks", name+".html"), blog.MdToHTML(md))
+		})
+		render.Engine = "goldmark"
+		blog.WithRender(render, func() {
+			bloogtest.Golden(t, filepath.Join("testdata/render/hooks", name+".goldmark.html"), blog.MdToHTML(md))
+		})
+		render.Engine = ""
+	}
+}
@@ -0,0 +1,247 @@
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	gast "github.com/yuin/goldmark/ast"
+)
+
+// Hook is a markdown extension, changing the parsed document, how nodes are
+// rendered, or both. Sites turn them on by name under render.hooks in
+// bloog.yaml, extra ones are registered from an init func in a file of
+// their own. AST and Render are for the gomarkdown engine, Goldmark for
+// goldmark
+type Hook struct {
+	// AST changes the parsed document before it's rendered
+	AST func(doc ast.Node)
+	// Render is tried for every node before the default rendering, reporting
+	// true when it wrote the node itself
+	Render html.RenderNodeFunc
+	// Goldmark changes goldmark's parsed document, source is the markdown
+	// its text segments point into
+	Goldmark func(doc gast.Node, source []byte)
+}
+
+var hooks = map[string]Hook{
+	"figures":     {AST: figures, Goldmark: goldmarkFigures},
+	"table-class": {AST: tableClass, Goldmark: goldmarkTableClass},
+	"table-wrap":  {Render: tableWrap, Goldmark: goldmarkTableWrap},
+	"callouts":    {AST: callouts, Goldmark: goldmarkCallouts},
+	"image-cdn":   {Render: imageRenderHook, Goldmark: goldmarkImages},
+	"mermaid":     {Render: mermaid, Goldmark: goldmarkMermaid},
+}
+
+// RegisterHook makes a hook available to render.hooks, replacing any
+// built-in of the same name
+func RegisterHook(name string, hook Hook) {
+	hooks[name] = hook
+}
+
+// activeHooks are the configured hooks in order, with the image cdn on
+// whenever it's configured
+func activeHooks() []Hook {
+	var active []Hook
+	for _, name := range config().Render.Hooks {
+		if hook, ok := hooks[name]; ok {
+			active = append(active, hook)
+		}
+	}
+	if config().Images.enabled() {
+		active = append(active, hooks["image-cdn"])
+	}
+	return active
+}
+
+// checkRender reports an engine or hooks that don't exist, and hooks the
+// engine can't run
+func checkRender(cfg RenderConfig) error {
+	if _, ok := engines[cfg.Engine]; cfg.Engine != "" && !ok {
+		return fmt.Errorf("unknown markdown engine %q, expected gomarkdown or goldmark", cfg.Engine)
+	}
+	for _, name := range cfg.Hooks {
+		hook, ok := hooks[name]
+		if !ok {
+			var known []string
+			for name := range hooks {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown render hook %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		if cfg.Engine == "goldmark" && hook.Goldmark == nil {
+			return fmt.Errorf("render hook %q doesn't support the goldmark engine", name)
+		}
+	}
+	return nil
+}
+
+// renderHook chains the render funcs of hooks, the first to handle a node
+// wins
+func renderHook(active []Hook) html.RenderNodeFunc {
+	var funcs []html.RenderNodeFunc
+	for _, hook := range active {
+		if hook.Render != nil {
+			funcs = append(funcs, hook.Render)
+		}
+	}
+	if len(funcs) == 0 {
+		return nil
+	}
+
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		for _, fn := range funcs {
+			if status, handled := fn(w, node, entering); handled {
+				return status, true
+			}
+		}
+		return ast.GoToNext, false
+	}
+}
+
+// figures turns an image alone in its paragraph and with a title,
+// ![alt](/photo.jpg "caption"), into a figure with that caption
+func figures(doc ast.Node) {
+	var paragraphs []*ast.Paragraph
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if p, ok := node.(*ast.Paragraph); ok && entering {
+			paragraphs = append(paragraphs, p)
+		}
+		return ast.GoToNext
+	})
+
+	for _, p := range paragraphs {
+		// the parser leaves empty text nodes either side of the image
+		var img *ast.Image
+		others := 0
+		for _, child := range p.GetChildren() {
+			if text, ok := child.(*ast.Text); ok && len(bytes.TrimSpace(text.Literal)) == 0 {
+				continue
+			}
+			if i, ok := child.(*ast.Image); ok && img == nil {
+				img = i
+				continue
+			}
+			others++
+		}
+		if img == nil || others > 0 || len(img.Title) == 0 {
+			continue
+		}
+
+		caption := &ast.Caption{}
+		text := &ast.Text{}
+		text.Literal = img.Title
+		text.SetParent(caption)
+		caption.SetChildren([]ast.Node{text})
+
+		figure := &ast.CaptionFigure{}
+		img.SetParent(figure)
+		caption.SetParent(figure)
+		figure.SetChildren([]ast.Node{img, caption})
+		replaceNode(p, figure)
+	}
+}
+
+// tableClass adds render.table_class to every table, for css frameworks
+// that only style tables with a class
+func tableClass(doc ast.Node) {
+	if config().Render.TableClass == "" {
+		return
+	}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if table, ok := node.(*ast.Table); ok && entering {
+			addClass(&table.Container, config().Render.TableClass)
+		}
+		return ast.GoToNext
+	})
+}
+
+// tableWrapClasses are the classes of the div tables are wrapped in
+func tableWrapClasses() string {
+	if config().Render.TableWrap != "" {
+		return config().Render.TableWrap
+	}
+	return "table-wrap"
+}
+
+// tableWrap puts every table in a div that scrolls sideways, so a wide
+// table doesn't stretch the page on a phone. The table is still rendered
+// as usual, only its closing tag is written here to close the div after it
+func tableWrap(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	if _, ok := node.(*ast.Table); !ok {
+		return ast.GoToNext, false
+	}
+	if entering {
+		fmt.Fprintf(w, "<div class=\"%s\">\n", template.HTMLEscapeString(tableWrapClasses()))
+		return ast.GoToNext, false
+	}
+	io.WriteString(w, "</table>\n</div>\n")
+	return ast.GoToNext, true
+}
+
+// callouts styles github's alert syntax, a blockquote starting with
+// [!NOTE], [!TIP], [!IMPORTANT], [!WARNING] or [!CAUTION]
+func callouts(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		quote, ok := node.(*ast.BlockQuote)
+		if !ok || !entering || len(quote.Children) == 0 {
+			return ast.GoToNext
+		}
+		p, ok := quote.Children[0].(*ast.Paragraph)
+		if !ok || len(p.Children) == 0 {
+			return ast.GoToNext
+		}
+		text, ok := p.Children[0].(*ast.Text)
+		if !ok {
+			return ast.GoToNext
+		}
+
+		for _, kind := range []string{"note", "tip", "important", "warning", "caution"} {
+			marker := []byte("[!" + strings.ToUpper(kind) + "]")
+			if !bytes.HasPrefix(text.Literal, marker) {
+				continue
+			}
+			text.Literal = bytes.TrimLeft(text.Literal[len(marker):], " \n")
+			addClass(&quote.Container, "callout")
+			addClass(&quote.Container, "callout-"+kind)
+
+			title := &ast.Paragraph{}
+			addClass(&title.Container, "callout-title")
+			label := &ast.Text{}
+			label.Literal = []byte(strings.ToUpper(kind[:1]) + kind[1:])
+			label.SetParent(title)
+			title.SetChildren([]ast.Node{label})
+			title.SetParent(quote)
+			quote.SetChildren(append([]ast.Node{title}, quote.Children...))
+			break
+		}
+		return ast.GoToNext
+	})
+}
+
+func addClass(c *ast.Container, class string) {
+	if c.Attribute == nil {
+		c.Attribute = &ast.Attribute{}
+	}
+	c.Classes = append(c.Classes, []byte(class))
+}
+
+// replaceNode swaps old for node in old's parent. ast.RemoveFromTree
+// clears the children of what it removes, so it's no good for moving nodes
+func replaceNode(old, node ast.Node) {
+	parent := old.GetParent()
+	children := parent.GetChildren()
+	for i, child := range children {
+		if child == old {
+			children[i] = node
+		}
+	}
+	node.SetParent(parent)
+	parent.SetChildren(children)
+}
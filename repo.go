@@ -0,0 +1,58 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Contributor is someone who has committed to a post's source file
+type Contributor struct {
+	Name string
+	// github login, only known for github's noreply addresses
+	Login     string
+	AvatarURL string
+	Commits   int
+}
+
+// newContributor works out an avatar for a commit author. github's noreply
+// addresses carry the login, anything else goes to gravatar by a hash of the
+// email so the address itself isn't in the page
+func newContributor(name, email string) Contributor {
+	c := Contributor{Name: name, Commits: 1}
+
+	if local, ok := strings.CutSuffix(email, "@users.noreply.github.com"); ok {
+		// either login@ or id+login@
+		if _, login, found := strings.Cut(local, "+"); found {
+			local = login
+		}
+		c.Login = local
+		c.AvatarURL = "https://github.com/" + url.PathEscape(local) + ".png?size=64"
+		return c
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	c.AvatarURL = "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?s=64&d=identicon"
+	return c
+}
+
+// editURL links to the post's source file in the configured repository's
+// web editor, empty when no repository is configured
+func editURL(cfg RepoConfig, post BlogPost) string {
+	if cfg.URL == "" || post.SourcePath == "" {
+		return ""
+	}
+
+	branch := cfg.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	dir := cfg.ContentDir
+	if dir == "" {
+		dir = "markdown"
+	}
+
+	return strings.TrimSuffix(cfg.URL, "/") + "/edit/" + branch + "/" + path.Join(dir, post.SourcePath)
+}
@@ -0,0 +1,58 @@
+package blog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// ids from a proxy or client are kept when they look like ids, anything
+// else could be used to mess with the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDs gives every request an id, the one a proxy in front already
+// gave it or a new one, sent back in the X-Request-ID header and logged
+// with the request and anything that goes wrong with it
+func requestIDs(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Set("requestID", id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// requestID is the id requestIDs gave the request
+func requestID(c *gin.Context) string {
+	if id := c.GetString("requestID"); id != "" {
+		return id
+	}
+	return newRequestID()
+}
+
+// logRequest is gin's request log line with the request id added
+func logRequest(param gin.LogFormatterParams) string {
+	id, _ := param.Keys["requestID"].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-16s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		id,
+		param.Method,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
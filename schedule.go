@@ -0,0 +1,218 @@
+package blog
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobs are what schedule entries can run, by name
+var jobs = map[string]func(s *server) error{
+	// pull the content from git, then reload it
+	"pull": func(s *server) error {
+		if err := s.pullContent(); err != nil {
+			return err
+		}
+		_, err := s.reload()
+		return err
+	},
+	// re-read the content, for posts whose ExpiryDate has passed and feeds
+	// and sitemaps built from what changed
+	"reload": func(s *server) error {
+		_, err := s.reload()
+		return err
+	},
+	// forget sessions that have expired, redis does this by itself
+	"purge-sessions": func(s *server) error {
+		if p, ok := s.sessions.backend.(interface{ purge(time.Time) int }); ok {
+			if n := p.purge(time.Now()); n > 0 {
+				log.Printf("Purged %d expired sessions\n", n)
+			}
+		}
+		return nil
+	},
+}
+
+// RegisterJob makes fn available to schedule entries as name, replacing
+// any built-in of the same name. Call it from an init func in a file of
+// its own
+func RegisterJob(name string, fn func() error) {
+	jobs[name] = func(*server) error { return fn() }
+}
+
+// pullContent fast-forwards the content directory from its git remote
+func (s *server) pullContent() error {
+	cmd := exec.Command("git", "pull", "--ff-only")
+	cmd.Dir = s.contentDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git pull: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// cronSpec is a parsed cron expression, the minutes, hours, days of the
+// month, months and weekdays it matches
+type cronSpec struct {
+	minute, hour, day, month, weekday map[int]bool
+	// cron matches either day field when both are restricted
+	anyDay, anyWeekday bool
+}
+
+// parseCron reads the five fields of a cron expression: minute, hour, day
+// of the month, month and day of the week (0 or 7 is sunday). Each is *,
+// a number, a range, a list of them, or any of those with a /step
+func parseCron(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	var spec cronSpec
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*map[int]bool{&spec.minute, &spec.hour, &spec.day, &spec.month, &spec.weekday}
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return cronSpec{}, fmt.Errorf("cron %q: %v", expr, err)
+		}
+	}
+	if spec.weekday[7] {
+		spec.weekday[0] = true
+	}
+	spec.anyDay, spec.anyWeekday = fields[2] == "*", fields[4] == "*"
+	return spec, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		from, to := min, max
+		if span != "*" {
+			first, last, isRange := strings.Cut(span, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("bad range %q", part)
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for n := from; n <= to; n += step {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
+
+// next is the first minute after t the expression matches, the zero time
+// if none does within a few years (february 30th)
+func (c cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c cronSpec) matchesDay(t time.Time) bool {
+	day, weekday := c.day[t.Day()], c.weekday[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// checkSchedule reports entries with a job that doesn't exist or without a
+// valid time to run
+func checkSchedule(entries []ScheduleConfig) error {
+	for _, entry := range entries {
+		if _, ok := jobs[entry.Job]; !ok {
+			var known []string
+			for name := range jobs {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("schedule: unknown job %q, expected one of %s", entry.Job, strings.Join(known, ", "))
+		}
+		if (entry.Every > 0) == (entry.Cron != "") {
+			return fmt.Errorf("schedule: %s needs one of every or cron", entry.Job)
+		}
+		if entry.Cron != "" {
+			if _, err := parseCron(entry.Cron); err != nil {
+				return fmt.Errorf("schedule: %s: %v", entry.Job, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runSchedule runs each schedule entry's job at its times, one run of an
+// entry at a time. A run that's still going when the next is due makes it
+// wait
+func (s *server) runSchedule(entries []ScheduleConfig) {
+	for _, entry := range entries {
+		job, ok := jobs[entry.Job]
+		if !ok {
+			continue
+		}
+		every := entry.Every
+		next := func(t time.Time) time.Time { return t.Add(every) }
+		if entry.Cron != "" {
+			spec, err := parseCron(entry.Cron)
+			if err != nil {
+				continue
+			}
+			next = spec.next
+		}
+		go func(name string, job func(*server) error, next func(time.Time) time.Time) {
+			for at := next(time.Now()); !at.IsZero(); at = next(time.Now()) {
+				time.Sleep(time.Until(at))
+				if err := job(s); err != nil {
+					log.Printf("Error running scheduled %s: %v\n", name, err)
+				}
+			}
+		}(entry.Job, job, next)
+	}
+}
@@ -0,0 +1,60 @@
+package blog_test
+
+import (
+	"testing"
+	"time"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestNextCron(t *testing.T) {
+	// a wednesday
+	from := time.Date(2024, 1, 10, 12, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 10, 12, 31, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 10, 13, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * *", time.Date(2024, 1, 10, 12, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 1, 11, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 6 * * 1-5", time.Date(2024, 1, 11, 6, 30, 0, 0, time.UTC)},
+		// 0 and 7 are both sunday
+		{"0 0 * * 0", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		// with both day fields restricted either one matches
+		{"0 0 13 * 5", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		got, err := blog.NextCron(tt.expr, from)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%q: next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := blog.NextCron(expr, time.Now()); err == nil {
+			t.Errorf("%q: no error", expr)
+		}
+	}
+}
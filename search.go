@@ -0,0 +1,415 @@
+package blog
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const searchResultsPerPage = 10
+
+type SearchResult struct {
+	Title template.HTML
+	Slug  string
+	// the heading of the section that matched best, and its id to link
+	// to, empty when that's the top of the page
+	Heading template.HTML
+	Anchor  string
+	Snippet template.HTML
+	Score   int
+}
+
+type Pagination struct {
+	Page       int
+	TotalPages int
+	PrevPage   int
+	NextPage   int
+	HasPrev    bool
+	HasNext    bool
+}
+
+// searchBackend indexes posts and answers queries for /search
+type searchBackend interface {
+	index(posts []BlogPost) error
+	search(query string, offset, limit int) ([]SearchResult, int, error)
+}
+
+func newSearchBackend(cfg SearchConfig) (searchBackend, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return &searchIndex{}, nil
+	case "elasticsearch", "opensearch":
+		return newElasticsearch(cfg.Elasticsearch), nil
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", cfg.Backend)
+	}
+}
+
+// searchPage runs a search for the ?page= asked for, clamped to the pages
+// there are. The first page is searched for first since it says how many
+// there are
+func (s *server) searchPage(query, page string) ([]SearchResult, int, Pagination, error) {
+	results, total, err := s.search.search(query, 0, searchResultsPerPage)
+	if err != nil {
+		return nil, 0, Pagination{}, err
+	}
+	n, _ := strconv.Atoi(page)
+	pagination := paginate(total, n, searchResultsPerPage)
+	if pagination.Page > 1 {
+		results, total, err = s.search.search(query, (pagination.Page-1)*searchResultsPerPage, searchResultsPerPage)
+	}
+	return results, total, pagination, err
+}
+
+func (s *server) handleSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	results, total, pagination, err := s.searchPage(query, c.Query("page"))
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	// later pages are the same search
+	if pagination.Page == 1 && !s.offline {
+		s.searches.record(query, total)
+	}
+
+	s.html(c, http.StatusOK, "search.html", gin.H{
+		"Title":           "Search",
+		"Query":           query,
+		"Results":         results,
+		"Total":           total,
+		"Pagination":      pagination,
+		"SidebarData":     s.sidebar(c, ""),
+		"MetaDescription": "Search results for " + query,
+	})
+}
+
+// handleSearchAPI is the raw json version of the search page
+func (s *server) handleSearchAPI(c *gin.Context) {
+	results, total, pagination, err := s.searchPage(c.Query("q"), c.Query("page"))
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	if pagination.Page == 1 && !s.offline {
+		s.searches.record(c.Query("q"), total)
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": c.Query("q"), "total": total, "page": pagination.Page, "results": results})
+}
+
+type searchDoc struct {
+	post     BlogPost
+	text     string
+	sections []searchSection
+}
+
+// searchSection is the text under one heading, or before the first one,
+// with its terms counted when it's indexed
+type searchSection struct {
+	anchor  string
+	heading string
+	text    string
+	// term -> hits, a hit in the heading counting for more
+	terms map[string]int
+}
+
+var sectionHeading = regexp.MustCompile(`(?s)<h[2-6]\s[^>]*\bid="([^"]*)"[^>]*>(.*?)</h[2-6]>`)
+
+// splitSections cuts rendered html at every h2 to h6 with an id, so search
+// results can link to the part of the page that matched
+func splitSections(content string) []searchSection {
+	var sections []searchSection
+	last := 0
+	current := searchSection{}
+	for _, m := range sectionHeading.FindAllStringSubmatchIndex(content, -1) {
+		current.text = plainText(content[last:m[0]])
+		if current.heading != "" || current.text != "" {
+			sections = append(sections, current)
+		}
+		current = searchSection{anchor: content[m[2]:m[3]], heading: plainText(content[m[4]:m[5]])}
+		last = m[1]
+	}
+	current.text = plainText(content[last:])
+	sections = append(sections, current)
+
+	for i := range sections {
+		sections[i].terms = make(map[string]int)
+		for _, term := range tokenize(sections[i].heading) {
+			sections[i].terms[term] += 5
+		}
+		for _, term := range tokenize(sections[i].text) {
+			sections[i].terms[term]++
+		}
+	}
+	return sections
+}
+
+// bestSection picks the section with the most hits for the terms. Ties go
+// to the earlier section
+func bestSection(sections []searchSection, terms []string) searchSection {
+	best, bestScore := searchSection{}, -1
+	for _, section := range sections {
+		score := 0
+		for _, term := range terms {
+			score += section.terms[term]
+		}
+		if score > bestScore {
+			best, bestScore = section, score
+		}
+	}
+	return best
+}
+
+type searchIndex struct {
+	mu   sync.RWMutex
+	docs []searchDoc
+	// term -> doc index -> weighted hit count
+	terms map[string]map[int]int
+}
+
+var tagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// index replaces the in-process index with one built from posts
+func (idx *searchIndex) index(posts []BlogPost) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.docs = nil
+	idx.terms = make(map[string]map[int]int)
+
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+
+		doc := searchDoc{post: post, text: plainText(string(post.Content)), sections: splitSections(string(post.Content))}
+		id := len(idx.docs)
+		idx.docs = append(idx.docs, doc)
+
+		// title and header hits count for more than body hits
+		idx.add(id, post.Title, 10)
+		idx.add(id, strings.Join(post.Headers, " "), 5)
+		idx.add(id, post.Description, 3)
+		idx.add(id, doc.text, 1)
+	}
+
+	return nil
+}
+
+func (idx *searchIndex) add(id int, text string, weight int) {
+	for _, term := range tokenize(text) {
+		if idx.terms[term] == nil {
+			idx.terms[term] = make(map[int]int)
+		}
+		idx.terms[term][id] += weight
+	}
+}
+
+// search returns a page of the documents matching all terms in the query,
+// best first, along with the total number of matches
+func (idx *searchIndex) search(query string, offset, limit int) ([]SearchResult, int, error) {
+	if offset < 0 || limit < 0 {
+		return nil, 0, fmt.Errorf("search: negative offset %d or limit %d", offset, limit)
+	}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := make(map[int]int)
+	for i, term := range terms {
+		hits := idx.terms[term]
+		if i == 0 {
+			for id, score := range hits {
+				scores[id] = score
+			}
+			continue
+		}
+		for id := range scores {
+			if score, ok := hits[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return idx.docs[ids[i]].post.Slug < idx.docs[ids[j]].post.Slug
+	})
+
+	total := len(ids)
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		ids = ids[offset : offset+limit]
+	} else {
+		ids = ids[offset:]
+	}
+
+	// sections and snippets only for the page of results
+	var results []SearchResult
+	for _, id := range ids {
+		doc := idx.docs[id]
+		section := bestSection(doc.sections, terms)
+		text := section.text
+		if text == "" {
+			text = doc.text
+		}
+		results = append(results, SearchResult{
+			Title:   highlight(doc.post.Title, terms),
+			Slug:    doc.post.Slug,
+			Heading: highlight(section.heading, terms),
+			Anchor:  section.anchor,
+			Snippet: snippet(text, terms, 160),
+			Score:   scores[id],
+		})
+	}
+	return results, total, nil
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func plainText(htmlContent string) string {
+	text := tagRegexp.ReplaceAllString(htmlContent, " ")
+	text = html.UnescapeString(text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// snippet cuts a window of roughly width characters around the first query
+// term found in text, with every matched term highlighted
+func snippet(text string, terms []string, width int) template.HTML {
+	matches := termMatches(text, terms)
+	start := 0
+	if len(matches) > 0 {
+		start = matches[0][0]
+	}
+
+	from := start - width/3
+	if from < 0 {
+		from = 0
+	}
+	to := from + width
+	if to > len(text) {
+		to = len(text)
+	}
+
+	// don't cut words (or multi-byte runes) in half
+	for from > 0 && text[from-1] != ' ' {
+		from--
+	}
+	for to < len(text) && text[to] != ' ' {
+		to++
+	}
+
+	out := highlight(text[from:to], terms)
+	if from > 0 {
+		out = "&hellip;" + out
+	}
+	if to < len(text) {
+		out += "&hellip;"
+	}
+	return out
+}
+
+// termMatches are where the terms are in text, as whole words split the
+// way tokenize splits them. They're found in text itself, lowercasing can
+// change its length
+func termMatches(text string, terms []string) [][2]int {
+	if len(terms) == 0 {
+		return nil
+	}
+	want := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		want[term] = true
+	}
+
+	var matches [][2]int
+	start := -1
+	check := func(end int) {
+		if start >= 0 && want[strings.ToLower(text[start:end])] {
+			matches = append(matches, [2]int{start, end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if start < 0 {
+				start = i
+			}
+		} else {
+			check(i)
+		}
+	}
+	check(len(text))
+	return matches
+}
+
+// highlight escapes text and wraps every occurrence of the terms in <mark>
+func highlight(text string, terms []string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range termMatches(text, terms) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(b.String())
+}
+
+func paginate(total, page, perPage int) Pagination {
+	totalPages := (total + perPage - 1) / perPage
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	return Pagination{
+		Page:       page,
+		TotalPages: totalPages,
+		PrevPage:   page - 1,
+		NextPage:   page + 1,
+		HasPrev:    page > 1,
+		HasNext:    page < totalPages,
+	}
+}
@@ -0,0 +1,28 @@
+package blog_test
+
+import (
+	"testing"
+
+	blog "github.com/anuragcsangal/blog"
+)
+
+func TestSnippet(t *testing.T) {
+	for _, tt := range []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Go is fun", []string{"go"}, "<mark>Go</mark> is fun"},
+		// only whole words are highlighted
+		{"gopher go", []string{"go"}, "gopher <mark>go</mark>"},
+		{"un café noir", []string{"café"}, "un <mark>café</mark> noir"},
+		{"東京 と 大阪", []string{"大阪"}, "東京 と <mark>大阪</mark>"},
+		// lowercasing these changes their length, the offsets must still hold
+		{"İİİİ ȺȺȺȺ word", []string{"word"}, "İİİİ ȺȺȺȺ <mark>word</mark>"},
+		{"a <b> c", []string{"b"}, "a &lt;<mark>b</mark>&gt; c"},
+	} {
+		if got := string(blog.Snippet(tt.text, tt.terms, 200)); got != tt.want {
+			t.Errorf("Snippet(%q, %q) = %q, want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+}
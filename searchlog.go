@@ -0,0 +1,94 @@
+package blog
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many distinct queries the search log keeps
+const maxSearchQueries = 1000
+
+// searchQuery is something readers searched for
+type searchQuery struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+	// how many of those searches found nothing
+	ZeroResults int       `json:"zero_results"`
+	Last        time.Time `json:"last"`
+}
+
+// searchLog counts what readers search for, persisted to a json file, so
+// searches that find nothing can become new pages
+type searchLog struct {
+	*counterStore[searchQuery]
+}
+
+func newSearchLog(path string) (*searchLog, error) {
+	store, err := newCounterStore(path, "the search log", maxSearchQueries, func(q searchQuery) (int, time.Time) {
+		return q.Count, q.Last
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &searchLog{store}, nil
+}
+
+// normalizeQuery folds queries differing only in case and spacing together
+func normalizeQuery(query string) string {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if len(query) > 100 {
+		query = strings.ToValidUTF8(query[:100], "")
+	}
+	return query
+}
+
+func (l *searchLog) record(query string, total int) {
+	query = normalizeQuery(query)
+	if query == "" {
+		return
+	}
+
+	l.update(query, func(q *searchQuery) {
+		q.Query = query
+		q.Count++
+		if total == 0 {
+			q.ZeroResults++
+		}
+		q.Last = time.Now()
+	})
+}
+
+// list is every query, most searched for first, or only the ones that found
+// nothing, most often first
+func (l *searchLog) list(zeroResults bool) []searchQuery {
+	list := []searchQuery{}
+	for _, q := range l.all() {
+		if !zeroResults || q.ZeroResults > 0 {
+			list = append(list, q)
+		}
+	}
+	count := func(q searchQuery) int {
+		if zeroResults {
+			return q.ZeroResults
+		}
+		return q.Count
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if count(list[i]) != count(list[j]) {
+			return count(list[i]) > count(list[j])
+		}
+		return list[i].Query < list[j].Query
+	})
+	return list
+}
+
+// handleSearchesAPI lists what readers searched for, ?zero=1 for only the
+// searches that found nothing
+func (s *server) handleSearchesAPI(c *gin.Context) {
+	zero := c.Query("zero") == "1" || c.Query("zero") == "true"
+	c.JSON(http.StatusOK, gin.H{"searches": s.searches.list(zero)})
+}
@@ -0,0 +1,285 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yuin/goldmark"
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// the largest image that's downloaded
+const maxRemoteImage = 20 << 20
+
+// images on other sites, in markdown and in html
+var remoteImage = regexp.MustCompile(`(!\[[^\]]*\]\()(https?://[^\s)]+)|(<img\s[^>]*?src=["'])(https?://[^"'\s>]+)`)
+
+// selfHostDir is where downloaded images are kept, static/remote unless
+// images.self_host_dir says otherwise
+func selfHostDir() string {
+	if config().Images.SelfHostDir == "" {
+		return "static/remote"
+	}
+	return config().Images.SelfHostDir
+}
+
+// selfHostURL is where the downloaded images are served from, the
+// directory's path for one under static/
+func selfHostURL() string {
+	if config().Images.SelfHostURL != "" {
+		return strings.TrimRight(config().Images.SelfHostURL, "/")
+	}
+	return "/" + filepath.ToSlash(filepath.Clean(selfHostDir()))
+}
+
+// selfHostRoute is the path the server serves the copies at when static/
+// doesn't cover it, "" when it does or they're served from elsewhere
+func selfHostRoute() string {
+	url := selfHostURL()
+	if !strings.HasPrefix(url, "/") || strings.HasPrefix(url, "//") || url == "/static" || strings.HasPrefix(url, "/static/") {
+		return ""
+	}
+	return url
+}
+
+// remoteImages finds the remote images in markdown, as the submatch indexes
+// of remoteImage, leaving out the ones in code blocks and spans
+func remoteImages(content []byte) [][]int {
+	matches := remoteImage.FindAllSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	code := codeRanges(content)
+	var images [][]int
+	for _, m := range matches {
+		if !inRanges(code, m[0]) {
+			images = append(images, m)
+		}
+	}
+	return images
+}
+
+// codeRanges is where the code is in markdown, found by parsing it since
+// fences and backticks can't be told apart from the text around them
+func codeRanges(content []byte) [][2]int {
+	var ranges [][2]int
+	doc := goldmark.DefaultParser().Parse(text.NewReader(content))
+	gast.Walk(doc, func(node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if !entering {
+			return gast.WalkContinue, nil
+		}
+		switch node.Kind() {
+		case gast.KindFencedCodeBlock, gast.KindCodeBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				ranges = append(ranges, [2]int{line.Start, line.Stop})
+			}
+			return gast.WalkSkipChildren, nil
+		case gast.KindCodeSpan:
+			for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+				if t, ok := child.(*gast.Text); ok {
+					ranges = append(ranges, [2]int{t.Segment.Start, t.Segment.Stop})
+				}
+			}
+			return gast.WalkSkipChildren, nil
+		}
+		return gast.WalkContinue, nil
+	})
+	return ranges
+}
+
+func inRanges(ranges [][2]int, i int) bool {
+	for _, r := range ranges {
+		if i >= r[0] && i < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteImageURL is the url of a remote image match and where it is in
+// content
+func remoteImageURL(content []byte, m []int) (url string, start, end int) {
+	if m[4] >= 0 {
+		return string(content[m[4]:m[5]]), m[4], m[5]
+	}
+	return string(content[m[8]:m[9]]), m[8], m[9]
+}
+
+// selfHostImages points a post's remote images at their downloaded copies,
+// so they outlive the sites they came from and readers aren't tracked by
+// them. Nothing is downloaded here, fetchRemoteImages does that before the
+// content is loaded, and an image without a copy is left as it was
+func selfHostImages(content []byte) []byte {
+	var out []byte
+	last := 0
+	for _, m := range remoteImages(content) {
+		url, start, end := remoteImageURL(content, m)
+		// a fragment picks an image cdn preset, keep it
+		src, fragment, hasFragment := strings.Cut(url, "#")
+		local, ok := selfHostedCopy(src)
+		if !ok {
+			continue
+		}
+		if hasFragment {
+			local += "#" + fragment
+		}
+		out = append(append(out, content[last:start]...), local...)
+		last = end
+	}
+	if out == nil {
+		return content
+	}
+	return append(out, content[last:]...)
+}
+
+// selfHostedCopy is the url of the downloaded copy of an image, if there is
+// one. Each is named after the url it came from
+func selfHostedCopy(url string) (string, bool) {
+	name := sha256Hex([]byte(url))[:16]
+	existing, _ := filepath.Glob(filepath.Join(selfHostDir(), name+".*"))
+	if len(existing) == 0 {
+		return "", false
+	}
+	return selfHostURL() + "/" + filepath.Base(existing[0]), true
+}
+
+// images that couldn't be downloaded, not tried again for an hour so a dead
+// link isn't fetched on every reload
+var selfHostFailures = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// fetchRemoteImages downloads the remote images in the markdown files in
+// dirs that haven't been yet. It runs before a reload takes its lock, so
+// slow sites don't hold it up
+func fetchRemoteImages(dirs []string) {
+	urls := make(map[string]bool)
+	for _, dir := range dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			for _, m := range remoteImages(content) {
+				url, _, _ := remoteImageURL(content, m)
+				src, _, _ := strings.Cut(url, "#")
+				urls[src] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(urls))
+	for url := range urls {
+		sorted = append(sorted, url)
+	}
+	sort.Strings(sorted)
+	for _, url := range sorted {
+		if _, ok := selfHostedCopy(url); ok {
+			continue
+		}
+		selfHostFailures.Lock()
+		failed, ok := selfHostFailures.at[url]
+		selfHostFailures.Unlock()
+		if ok && time.Since(failed) < time.Hour {
+			continue
+		}
+		if _, err := selfHostImage(url); err != nil {
+			log.Printf("Error self hosting image %s: %v\n", url, err)
+			selfHostFailures.Lock()
+			selfHostFailures.at[url] = time.Now()
+			selfHostFailures.Unlock()
+		}
+	}
+}
+
+// remoteClient fetches from other sites on behalf of posts, refusing
+// addresses on the server's own network. Checked when dialing, after the
+// name is resolved, so neither a redirect nor dns can get around it
+var remoteClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}).DialContext,
+	},
+}
+
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s isn't a public address", host)
+	}
+	return nil
+}
+
+// shared address space, what carrier grade nat hands out
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !sharedAddresses.Contains(ip)
+}
+
+// selfHostImage returns the url of the local copy of an image, downloading
+// it first when there isn't one
+func selfHostImage(url string) (string, error) {
+	if local, ok := selfHostedCopy(url); ok {
+		return local, nil
+	}
+
+	resp, err := remoteClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteImage+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxRemoteImage {
+		return "", errors.New("image is over 20MB")
+	}
+
+	// sniffed rather than trusting the header, and stripped of its metadata
+	// like an upload
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExts[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type %s", contentType)
+	}
+	if data, err = cleanImage(data, contentType); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(selfHostDir(), 0755); err != nil {
+		return "", err
+	}
+	name := sha256Hex([]byte(url))[:16] + ext
+	if err := writeFileAtomic(filepath.Join(selfHostDir(), name), data); err != nil {
+		return "", err
+	}
+	return selfHostURL() + "/" + name, nil
+}
@@ -0,0 +1,378 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many chunks are sent to the embedding api at once
+const embedBatchSize = 64
+
+func (s SemanticConfig) enabled() bool {
+	return s.Provider != ""
+}
+
+// embedder turns texts into vectors, similar texts pointing the same way
+type embedder interface {
+	embed(texts []string) ([][]float32, error)
+}
+
+func newEmbedder(cfg SemanticConfig) (embedder, error) {
+	switch cfg.Provider {
+	case "openai":
+		if cfg.URL == "" {
+			cfg.URL = "https://api.openai.com/v1"
+		}
+		if cfg.Model == "" {
+			cfg.Model = "text-embedding-3-small"
+		}
+		return openAIEmbedder(cfg), nil
+	case "ollama":
+		if cfg.URL == "" {
+			cfg.URL = "http://localhost:11434"
+		}
+		if cfg.Model == "" {
+			cfg.Model = "nomic-embed-text"
+		}
+		return ollamaEmbedder(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q, use openai or ollama", cfg.Provider)
+	}
+}
+
+// openAIEmbedder uses openai's embeddings api, or anything serving the
+// same api like llama.cpp, vllm or localai
+type openAIEmbedder SemanticConfig
+
+func (e openAIEmbedder) embed(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(e.URL, "/")+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := jsonRequest(req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("asked for %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// ollamaEmbedder uses a local ollama server
+type ollamaEmbedder SemanticConfig
+
+func (e ollamaEmbedder) embed(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(e.URL, "/")+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := jsonRequest(req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("asked for %d embeddings, got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
+}
+
+// semanticIndex holds an embedding of every chunk of content. Embeddings
+// are kept on disk by a hash of the model and text, so only chunks that
+// changed are sent to the provider again
+type semanticIndex struct {
+	embedder embedder
+	model    string
+	path     string
+
+	// one index build at a time, numbered as they're asked for so one
+	// that's been overtaken by a newer one is dropped
+	buildMu    sync.Mutex
+	generation atomic.Uint64
+
+	mu      sync.RWMutex
+	chunks  []contentChunk
+	vectors [][]float32
+	ready   bool
+}
+
+// SemanticResult is a chunk of content matching a query, best first
+type SemanticResult struct {
+	Title   string  `json:"title"`
+	URL     string  `json:"url"`
+	Heading string  `json:"heading,omitempty"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+func newSemanticIndex(cfg SemanticConfig) (*semanticIndex, error) {
+	e, err := newEmbedder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &semanticIndex{
+		embedder: e,
+		model:    cfg.Provider + "/" + cfg.Model,
+		path:     filepath.Join(config().DataDir, "embeddings.json"),
+	}, nil
+}
+
+// index embeds the chunks, reusing the embeddings of chunks seen before,
+// and swaps them in once they're all done, unless a newer build than
+// generation has been asked for by then
+func (idx *semanticIndex) index(generation uint64, chunks []contentChunk) error {
+	idx.buildMu.Lock()
+	defer idx.buildMu.Unlock()
+	if idx.generation.Load() != generation {
+		return nil
+	}
+
+	cached := make(map[string][]float32)
+	if err := loadJSON(idx.path, &cached); err != nil {
+		return err
+	}
+
+	keys := make([]string, len(chunks))
+	var missing []int
+	for i, chunk := range chunks {
+		keys[i] = sha256Hex([]byte(idx.model + "\x00" + embedText(chunk)))
+		if _, ok := cached[keys[i]]; !ok {
+			missing = append(missing, i)
+		}
+	}
+
+	for start := 0; start < len(missing); start += embedBatchSize {
+		batch := missing[start:min(start+embedBatchSize, len(missing))]
+		texts := make([]string, len(batch))
+		for j, i := range batch {
+			texts[j] = embedText(chunks[i])
+		}
+		vectors, err := idx.embedder.embed(texts)
+		if err != nil {
+			return err
+		}
+		for j, i := range batch {
+			cached[keys[i]] = normalize(vectors[j])
+		}
+	}
+
+	// only what's still in the content is kept
+	vectors := make([][]float32, len(chunks))
+	kept := make(map[string][]float32, len(chunks))
+	for i, key := range keys {
+		vectors[i] = cached[key]
+		kept[key] = cached[key]
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(idx.path, data); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.generation.Load() == generation {
+		idx.chunks, idx.vectors, idx.ready = chunks, vectors, true
+	}
+	return nil
+}
+
+// embedText is what's embedded for a chunk, its title and heading give
+// the content its context
+func embedText(chunk contentChunk) string {
+	text := chunk.Title
+	if chunk.Heading != "" {
+		text += " - " + chunk.Heading
+	}
+	return text + "\n\n" + chunk.Content
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	norm := float32(math.Sqrt(sum))
+	if norm == 0 {
+		return v
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+var errIndexNotReady = errors.New("the semantic index is still being built")
+
+// search finds the chunks closest in meaning to query
+func (idx *semanticIndex) search(query string, limit int) ([]SemanticResult, error) {
+	chunks, scores, err := idx.nearest(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SemanticResult, len(chunks))
+	for i, chunk := range chunks {
+		results[i] = SemanticResult{
+			Title:   chunk.Title,
+			URL:     chunk.URL,
+			Heading: chunk.Heading,
+			Snippet: chunkSnippet(chunk.Content),
+			Score:   math.Round(scores[i]*1000) / 1000,
+		}
+	}
+	return results, nil
+}
+
+// nearest is the limit chunks closest to query by the cosine of their
+// embeddings, with their scores
+func (idx *semanticIndex) nearest(query string, limit int) ([]contentChunk, []float64, error) {
+	idx.mu.RLock()
+	ready := idx.ready
+	idx.mu.RUnlock()
+	if !ready {
+		return nil, nil, errIndexNotReady
+	}
+
+	vectors, err := idx.embedder.embed([]string{query})
+	if err != nil {
+		return nil, nil, err
+	}
+	q := normalize(vectors[0])
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	type scored struct {
+		chunk contentChunk
+		score float64
+	}
+	matches := make([]scored, 0, len(idx.chunks))
+	for i, chunk := range idx.chunks {
+		v := idx.vectors[i]
+		if len(v) != len(q) {
+			continue
+		}
+		var score float64
+		for j := range v {
+			score += float64(v[j]) * float64(q[j])
+		}
+		matches = append(matches, scored{chunk, score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	chunks := make([]contentChunk, len(matches))
+	scores := make([]float64, len(matches))
+	for i, m := range matches {
+		chunks[i], scores[i] = m.chunk, m.score
+	}
+	return chunks, scores, nil
+}
+
+// chunkSnippet is the start of a chunk, cut at a word
+func chunkSnippet(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if len(content) <= 200 {
+		return content
+	}
+	cut := strings.LastIndex(content[:200], " ")
+	if cut < 0 {
+		cut = 200
+	}
+	return content[:cut] + "…"
+}
+
+// indexSemantic embeds every public page's chunks in the background, the
+// old index keeps answering until the new one is done. generation is from
+// the reload it's for, a later reload's build replaces it
+func (s *server) indexSemantic(generation uint64, posts []BlogPost) {
+	var chunks []contentChunk
+	for _, post := range llmsPosts(posts) {
+		postChunks, err := s.contentChunks(post)
+		if err != nil {
+			log.Printf("Error chunking %s for semantic search: %v\n", postSource(post), err)
+			continue
+		}
+		chunks = append(chunks, postChunks...)
+	}
+	if err := s.semantic.index(generation, chunks); err != nil {
+		log.Printf("Error building semantic index: %v\n", err)
+	}
+}
+
+// handleSemanticSearch answers natural language queries by meaning rather
+// than keywords: /api/semantic-search?q=how do I deploy&limit=5
+func (s *server) handleSemanticSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit < 1 || limit > 50 {
+		limit = searchResultsPerPage
+	}
+	if !s.semanticLimiter.allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too Many Requests"})
+		return
+	}
+
+	results, err := s.semantic.search(query, limit)
+	if errors.Is(err, errIndexNotReady) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
+}
@@ -0,0 +1,296 @@
+package blog
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// seoIssue is something wrong with a page's metadata, costing it points
+type seoIssue struct {
+	Penalty int    `json:"penalty"`
+	Message string `json:"message"`
+}
+
+// seoPage is a page's score out of 100 and what it lost points for
+type seoPage struct {
+	URL    string     `json:"url"`
+	Source string     `json:"source"`
+	Score  int        `json:"score"`
+	Issues []seoIssue `json:"issues"`
+}
+
+// seoMeta is what the audit reads from a rendered page
+type seoMeta struct {
+	title         string
+	description   string
+	ogTitle       string
+	ogDescription string
+	ogURL         string
+	ogImage       string
+	headings      []int
+	imagesNoAlt   int
+}
+
+var (
+	titleTag   = regexp.MustCompile(`(?is)<title>(.*?)</title>`)
+	metaTag    = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttr   = regexp.MustCompile(`(?i)(name|property|content)="([^"]*)"`)
+	headingTag = regexp.MustCompile(`(?i)<h([1-6])[\s>]`)
+	imgTag     = regexp.MustCompile(`(?is)<img\s[^>]*>`)
+	altAttr    = regexp.MustCompile(`(?i)\salt="[^"]+"`)
+	mainTag    = regexp.MustCompile(`(?is)<main[\s>].*</main>`)
+)
+
+func readSEOMeta(page string) seoMeta {
+	var m seoMeta
+	if t := titleTag.FindStringSubmatch(page); t != nil {
+		m.title = strings.TrimSpace(html.UnescapeString(t[1]))
+	}
+	for _, tag := range metaTag.FindAllString(page, -1) {
+		var key, content string
+		for _, attr := range metaAttr.FindAllStringSubmatch(tag, -1) {
+			if strings.EqualFold(attr[1], "content") {
+				content = strings.TrimSpace(html.UnescapeString(attr[2]))
+			} else {
+				key = strings.ToLower(attr[2])
+			}
+		}
+		switch key {
+		case "description":
+			m.description = content
+		case "og:title":
+			m.ogTitle = content
+		case "og:description":
+			m.ogDescription = content
+		case "og:url":
+			m.ogURL = content
+		case "og:image":
+			m.ogImage = content
+		}
+	}
+
+	// the headings and images of the page itself, not the sidebars
+	body := page
+	if main := mainTag.FindString(page); main != "" {
+		body = main
+	}
+	for _, h := range headingTag.FindAllStringSubmatch(body, -1) {
+		level, _ := strconv.Atoi(h[1])
+		m.headings = append(m.headings, level)
+	}
+	for _, img := range imgTag.FindAllString(body, -1) {
+		if !altAttr.MatchString(img) {
+			m.imagesNoAlt++
+		}
+	}
+	return m
+}
+
+// auditSEO scores a page's metadata. Missing things cost more than
+// imperfect ones
+func auditSEO(m seoMeta) []seoIssue {
+	var issues []seoIssue
+	add := func(penalty int, format string, args ...interface{}) {
+		issues = append(issues, seoIssue{Penalty: penalty, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch n := len([]rune(m.title)); {
+	case n == 0:
+		add(30, "no title")
+	case n < 10:
+		add(10, "title is %d characters, aim for 10 to 60", n)
+	case n > 60:
+		add(10, "title is %d characters, search results cut it at about 60", n)
+	}
+
+	switch n := len([]rune(m.description)); {
+	case n == 0:
+		add(25, "no meta description")
+	case n < 50:
+		add(10, "meta description is %d characters, aim for 50 to 160", n)
+	case n > 160:
+		add(10, "meta description is %d characters, search results cut it at about 160", n)
+	}
+
+	if m.ogTitle == "" {
+		add(5, "no og:title")
+	}
+	if m.ogDescription == "" {
+		add(5, "no og:description")
+	}
+	if m.ogURL == "" {
+		add(5, "no og:url")
+	}
+	if m.ogImage == "" {
+		add(10, "no og:image, shared links show without a picture")
+	}
+
+	h1 := 0
+	for i, level := range m.headings {
+		if level == 1 {
+			h1++
+		}
+		if i > 0 && level > m.headings[i-1]+1 {
+			add(5, "heading levels skip from h%d to h%d", m.headings[i-1], level)
+			break
+		}
+	}
+	switch {
+	case h1 == 0:
+		add(10, "no h1 heading")
+	case h1 > 1:
+		add(5, "%d h1 headings, a page should have one", h1)
+	}
+
+	if m.imagesNoAlt > 0 {
+		add(5, "%d image(s) without alt text", m.imagesNoAlt)
+	}
+	return issues
+}
+
+// seoReport renders every public page and scores it, worst first.
+// Duplicate titles and descriptions are only found across pages
+func seoReport(r http.Handler, posts []BlogPost) []seoPage {
+	type rendered struct {
+		page seoPage
+		meta seoMeta
+	}
+	var pages []rendered
+	for _, post := range publicPosts(posts) {
+		if post.Slug == "" {
+			continue
+		}
+		url := post.URL()
+		if post.SourcePath == "index.md" {
+			url = "/"
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			continue
+		}
+		meta := readSEOMeta(w.Body.String())
+		issues := auditSEO(meta)
+		// the made up ones fill the tag, but are rarely what a person would write
+		if post.MetaDescriptionDerived {
+			issues = append(issues, seoIssue{Penalty: 5, Message: "meta description is made up from the page, write a MetaDescription"})
+		}
+		pages = append(pages, rendered{
+			page: seoPage{URL: url, Source: postSource(post), Issues: issues},
+			meta: meta,
+		})
+	}
+
+	titles := make(map[string][]string)
+	descriptions := make(map[string][]string)
+	for _, p := range pages {
+		if p.meta.title != "" {
+			titles[p.meta.title] = append(titles[p.meta.title], p.page.URL)
+		}
+		if p.meta.description != "" {
+			descriptions[p.meta.description] = append(descriptions[p.meta.description], p.page.URL)
+		}
+	}
+
+	report := make([]seoPage, 0, len(pages))
+	for _, p := range pages {
+		page := p.page
+		if others := without(titles[p.meta.title], page.URL); len(others) > 0 {
+			page.Issues = append(page.Issues, seoIssue{Penalty: 15, Message: "same title as " + strings.Join(others, ", ")})
+		}
+		if others := without(descriptions[p.meta.description], page.URL); len(others) > 0 {
+			page.Issues = append(page.Issues, seoIssue{Penalty: 10, Message: "same meta description as " + strings.Join(others, ", ")})
+		}
+		sort.SliceStable(page.Issues, func(i, j int) bool { return page.Issues[i].Penalty > page.Issues[j].Penalty })
+
+		page.Score = 100
+		for _, issue := range page.Issues {
+			page.Score -= issue.Penalty
+		}
+		if page.Score < 0 {
+			page.Score = 0
+		}
+		if page.Issues == nil {
+			page.Issues = []seoIssue{}
+		}
+		report = append(report, page)
+	}
+
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Score < report[j].Score })
+	return report
+}
+
+func without(list []string, item string) []string {
+	var rest []string
+	for _, s := range list {
+		if s != item {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+// seoCommand prints every page's seo score, the pages needing the most
+// work first: seo [-json] [-min-score n]
+func seoCommand(args []string) error {
+	fs := flag.NewFlagSet("seo", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	minScore := fs.Int("min-score", 0, "exit non-zero if any page scores below this")
+	fs.Parse(args)
+
+	if config().Headless {
+		return fmt.Errorf("a headless site has no pages to audit")
+	}
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	st, err := s.newSite()
+	if err != nil {
+		return err
+	}
+	report := seoReport(st.engine, s.allPosts())
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		total := 0
+		for _, page := range report {
+			total += page.Score
+			if len(page.Issues) == 0 {
+				continue
+			}
+			fmt.Printf("%3d  %s (%s)\n", page.Score, page.URL, page.Source)
+			for _, issue := range page.Issues {
+				fmt.Printf("     -%-3d %s\n", issue.Penalty, issue.Message)
+			}
+		}
+		if len(report) > 0 {
+			fmt.Printf("%d pages, average score %d\n", len(report), total/len(report))
+		}
+	}
+
+	var below int
+	for _, page := range report {
+		if page.Score < *minScore {
+			below++
+		}
+	}
+	if below > 0 {
+		return fmt.Errorf("%d page(s) score below %d", below, *minScore)
+	}
+	return nil
+}
@@ -0,0 +1,678 @@
+package blog
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// server holds the loaded content and the stores behind every route, the
+// content can be swapped out at runtime by reload
+type server struct {
+	contentDir    string
+	cache         *contentCache
+	versionCaches map[string]*contentCache
+
+	// reloadMu serializes content reloads and configMu config reloads,
+	// mu guards the loaded content
+	reloadMu sync.Mutex
+	mu       sync.RWMutex
+	configMu sync.Mutex
+	posts    []BlogPost
+	bySlug   map[string]BlogPost
+	byURL    map[string]BlogPost
+	// index.md, looked up once per reload since / is the most hit page
+	home *BlogPost
+	// what the categories' _index.md files say, by category name
+	categories map[string]categoryMeta
+	// fires when the next post expires
+	expiry *time.Timer
+	// when the content last changed, and what went wrong reloading it since
+	loaded  time.Time
+	loadErr error
+	// why the templates last failed to reload, in dev mode
+	templateErr error
+	// sidebars by docs version, "" is the unversioned content
+	sidebars map[string]SideBar
+
+	search searchBackend
+	// nil unless search.semantic is configured
+	semantic        *semanticIndex
+	semanticLimiter limiter
+	// nil unless geoip.database is configured
+	geo *geoDB
+	// nil unless ask is configured too
+	chat           chatModel
+	askLimiter     limiter
+	views          viewStore
+	reactions      *reactionStore
+	comments       *commentStore
+	commentLimiter limiter
+	loginLimiter   limiter
+	sessions       *sessionStore
+	users          *userStore
+	tokens         *tokenStore
+
+	announcements *announcementStore
+	media         mediaStore
+	// what feed aggregators say about their subscribers
+	subscribers *feedFetchers
+	// requests for pages that don't exist
+	misses *notFoundLog
+	// what readers search for
+	searches *searchLog
+	// titles for the cmd-K palette
+	quick *quickIndex
+
+	changelog changelogCache
+	llms      llmsCache
+
+	// shared state for multi-instance deployments, nil when not configured
+	redis *redisStore
+	// what's serving, swapped out when the config is reloaded
+	live *liveRouter
+
+	plugins []*plugin
+
+	// one notion sync at a time
+	notionMu sync.Mutex
+
+	// what was wrong at startup, kept for running degraded
+	report *startupReport
+
+	// rendering for a command rather than serving readers: no views,
+	// misses or searches are counted, and nothing remote is indexed or
+	// fetched
+	offline bool
+}
+
+// newServer loads the content in contentDir to serve it
+func newServer(contentDir string) (*server, error) {
+	return openServer(contentDir, false)
+}
+
+// newOfflineServer loads the content in contentDir for a command that
+// renders or reads it, build, the link checker and the like, without
+// touching the production state the server keeps
+func newOfflineServer(contentDir string) (*server, error) {
+	return openServer(contentDir, true)
+}
+
+func openServer(contentDir string, offline bool) (*server, error) {
+	s := &server{
+		offline:        offline,
+		contentDir:     contentDir,
+		versionCaches:  make(map[string]*contentCache),
+		commentLimiter: newRateLimiter(5, time.Hour),
+		loginLimiter:   newRateLimiter(loginAttempts, 15*time.Minute),
+		media:          newMediaStore(config().Media),
+		quick:          &quickIndex{},
+	}
+
+	// collect every problem rather than stopping at the first
+	report := &startupReport{}
+	s.report = report
+
+	report.add(problemError, checkRender(config().Render))
+	report.add(problemError, checkColorSchemes(config().Render))
+	report.add(problemError, checkSecurity(config().Security))
+	report.add(problemError, checkHome(config().Home))
+	report.add(problemError, checkExpired(config().Expired))
+	report.add(problemError, checkSchedule(config().Schedule))
+
+	var err error
+	var sessions sessionBackend = newMemorySessions()
+	if config().Redis.URL != "" {
+		if s.redis, err = newRedisStore(config().Redis); err != nil {
+			report.addf(problemError, "redis: %v, keeping sessions, rate limits and views in memory", err)
+		} else {
+			s.commentLimiter = s.redis.rateLimiter("comments", 5, time.Hour)
+			s.loginLimiter = s.redis.rateLimiter("logins", loginAttempts, 15*time.Minute)
+			sessions = s.redis
+		}
+	}
+	s.plugins, err = loadPlugins(config().Plugins.Dir, s.allPosts)
+	report.add(problemError, err)
+	s.cache = s.newContentCache()
+
+	if s.offline {
+		// a remote index is the server's to fill
+		s.search = &searchIndex{}
+	} else if s.search, err = newSearchBackend(config().Search); err != nil {
+		report.addf(problemError, "search: %v, using the built in index", err)
+		s.search = &searchIndex{}
+	}
+	if config().Search.Semantic.enabled() && !s.offline {
+		if s.semantic, err = newSemanticIndex(config().Search.Semantic); err != nil {
+			report.addf(problemError, "semantic search: %v", err)
+		}
+		limit := config().Search.Semantic.RateLimit
+		if limit < 1 {
+			limit = 60
+		}
+		s.semanticLimiter = newRateLimiter(limit, time.Hour)
+		if s.redis != nil {
+			s.semanticLimiter = s.redis.rateLimiter("semantic", limit, time.Hour)
+		}
+	}
+	if config().Ask.enabled() && !s.offline {
+		s.setupAsk(report)
+	}
+	if config().GeoIP.Database != "" {
+		if s.geo, err = openGeoDB(config().GeoIP.Database); err != nil {
+			report.addf(problemError, "geoip: %v", err)
+		}
+	}
+	viewsPath := filepath.Join(config().DataDir, "views.json")
+	if s.redis != nil {
+		s.views, err = newRedisViews(s.redis, viewsPath)
+	} else {
+		s.views, err = newViewCounter(viewsPath)
+	}
+	report.add(problemFatal, dataError(viewsPath, err))
+
+	// the stores hold what visitors and admins wrote, never start without
+	// one of them
+	path := filepath.Join(config().DataDir, "reactions.json")
+	s.reactions, err = newReactionStore(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "comments.json")
+	s.comments, err = newCommentStore(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "users.db")
+	s.users, err = newUserStore(path)
+	report.add(problemFatal, dataError(path, err))
+	s.sessions = newSessionStore(sessions, func(username string) (string, bool) {
+		return userRole(s.users, username)
+	})
+	path = filepath.Join(config().DataDir, "tokens.json")
+	s.tokens, err = newTokenStore(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "announcements.json")
+	s.announcements, err = newAnnouncementStore(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "404s.json")
+	s.misses, err = newNotFoundLog(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "searches.json")
+	s.searches, err = newSearchLog(path)
+	report.add(problemFatal, dataError(path, err))
+	path = filepath.Join(config().DataDir, "subscribers.json")
+	s.subscribers, err = newFeedFetchers(path)
+	report.add(problemFatal, dataError(path, err))
+
+	s.checkTemplates(report)
+
+	// the site goes up before the content is loaded, its subscribers index
+	// the first load like any other
+	s.live = &liveRouter{}
+	st, err := s.serverSite()
+	if err != nil {
+		report.add(problemFatal, err)
+		return nil, report
+	}
+	s.live.current.Store(st)
+
+	changes, err := s.reload()
+	report.add(problemError, err)
+	for _, skipped := range changes.Skipped {
+		report.add(problemError, errors.New(skipped))
+	}
+	report.add(problemWarning, errors.Join(checkPosts(s.allPosts())...))
+	report.add(problemWarning, errors.Join(checkCategories(s.allPosts())...))
+
+	if report.failed() {
+		return nil, report
+	}
+	if s.offline {
+		return s, nil
+	}
+
+	if counter, ok := s.views.(*viewCounter); ok {
+		go counter.persist(30 * time.Second)
+	}
+	go s.misses.persist(30 * time.Second)
+	go s.searches.persist(30 * time.Second)
+	go s.subscribers.persist(30 * time.Second)
+
+	return s, nil
+}
+
+// newContentCache is a content cache sharing rendered posts through redis
+// when it's configured
+func (s *server) newContentCache() *contentCache {
+	cc := newContentCache()
+	cc.plugins = s.plugins
+	if s.redis != nil {
+		cc.rendered = s.redis
+	}
+	return cc
+}
+
+// reload re-reads the content directory and swaps in the new posts. Only
+// changed files are re-parsed, the search index is only rebuilt when
+// something changed and the sidebar only when the structure did
+func (s *server) reload() (contentChanges, error) {
+	if config().Images.SelfHost && !s.offline {
+		dirs := []string{s.contentDir}
+		for _, v := range config().Versions {
+			dirs = append(dirs, filepath.Join(s.contentDir, v.Name))
+		}
+		fetchRemoteImages(dirs)
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// in degraded mode the posts that didn't load are left out and listed
+	// in the changes rather than failing the reload, otherwise the site
+	// keeps what it had
+	posts, loadErr := s.loadContent()
+	categories, err := loadCategories(s.contentDir)
+	loadErr = errors.Join(loadErr, err)
+	posts = withoutExpired(withoutDrafts(withVariants(posts)))
+	s.scheduleExpiry(posts)
+	if config().Duplicates.Canonicalize {
+		canonicalizeDuplicates(posts, findDuplicates(posts))
+	}
+	s.mu.Lock()
+	s.loadErr = loadErr
+	s.mu.Unlock()
+	if loadErr != nil && !config().Degraded {
+		return contentChanges{}, loadErr
+	}
+
+	bySlug := make(map[string]BlogPost)
+	byURL := make(map[string]BlogPost)
+	var home *BlogPost
+	for i, post := range posts {
+		if post.SourcePath == "index.md" {
+			home = &posts[i]
+		}
+		if post.Slug == "" {
+			continue
+		}
+		bySlug[post.Slug] = post
+		byURL[post.URL()] = post
+	}
+
+	s.mu.RLock()
+	changes := diffPosts(s.bySlug, posts)
+	changes.Skipped = skippedFiles(loadErr)
+	public := publicChanges(s.bySlug, posts, changes)
+	initial := s.bySlug == nil
+	categoriesChanged := !reflect.DeepEqual(s.categories, categories)
+	s.mu.RUnlock()
+	if !initial {
+		for _, skipped := range changes.Skipped {
+			log.Printf("Warning: skipping %s\n", skipped)
+		}
+	}
+
+	if !initial && changes.empty() && !categoriesChanged {
+		return changes, nil
+	}
+	// the startup report covers the first load
+	if !initial {
+		for _, err := range append(checkPosts(posts), checkCategories(posts)...) {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.posts = posts
+	s.bySlug = bySlug
+	s.byURL = byURL
+	s.home = home
+	s.loaded = time.Now()
+	// the sidebars hold copies of the posts, so any change to one of them
+	// means building them again, which is cheap next to rendering
+	s.sidebars = versionSidebars(posts, categories)
+	s.categories = categories
+	s.mu.Unlock()
+
+	return changes, s.current().events.publishChanges(posts, changes, public, initial)
+}
+
+func (s *server) allPosts() []BlogPost {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.posts
+}
+
+func (s *server) post(slug string) (BlogPost, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	post, ok := s.bySlug[slug]
+	return post, ok
+}
+
+func (s *server) postByURL(url string) (BlogPost, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	post, ok := s.byURL[url]
+	return post, ok
+}
+
+// postURL is the path of the post with slug, for templates that only have
+// the slug to hand
+func (s *server) postURL(slug string) string {
+	if post, ok := s.post(slug); ok {
+		return post.URL()
+	}
+	return "/" + slug
+}
+
+// sidebarData is the sidebar as anyone can see it
+func (s *server) sidebarData() SideBar {
+	return s.sidebarFor("").visibleTo(nil)
+}
+
+// sidebar is a version's sidebar as whoever sent c can see it
+func (s *server) sidebar(c *gin.Context, version string) SideBar {
+	return s.sidebarFor(version).visibleTo(s.sessions.get(c))
+}
+
+func (s *server) funcMap(st *site) template.FuncMap {
+	funcs := template.FuncMap{
+		"loadSidebar": s.sidebarData,
+		// every post anyone can read, for where and sortBy
+		"posts": func() []BlogPost {
+			return publicPosts(s.allPosts())
+		},
+		"dict": dict,
+		// the featured posts, for a pinned section of a listing
+		"featured": func() []BlogPost {
+			return featuredPosts(publicPosts(s.allPosts()))
+		},
+		"tagCloud": func() []TermCount {
+			return tagCloud(publicPosts(s.allPosts()))
+		},
+		"categoryCounts": func() []TermCount {
+			return categoryCounts(publicPosts(s.allPosts()))
+		},
+		"popularPosts": func(n int) []BlogPost {
+			return s.views.popular(publicPosts(s.allPosts()), n)
+		},
+		"reactions": s.reactions.list,
+		"comments":  s.comments.approved,
+		"githubLogin": func() bool {
+			return config().GitHub.enabled()
+		},
+		"postURL":       s.postURL,
+		"feeds":         s.feedLinks,
+		"analytics":     analyticsHTML,
+		"siteRobots":    siteRobots,
+		"colorScheme":   pageColorScheme,
+		"mermaidScript": mermaidScript,
+		"criticalCSS": func() template.CSS {
+			return st.criticalCSS
+		},
+		// not every page has a slug, so this takes whatever it's given
+		"resourceHints": func(slug interface{}) []resourceHint {
+			current, _ := slug.(string)
+			return s.resourceHints(st.preloads, current)
+		},
+	}
+	for name, fn := range themeFuncs {
+		funcs[name] = fn
+	}
+	for name, fn := range pluginFuncs(s.plugins) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func (s *server) routes(r *gin.Engine, st *site) error {
+	r.Use(requestIDs, s.recoverPages)
+	if s.geo != nil {
+		r.Use(s.geoIP)
+	}
+	// a staging site mustn't end up in search results
+	if siteRobots() != "" {
+		r.Use(noIndexSite)
+	}
+
+	if config().CriticalCSS && !config().Headless {
+		css, err := criticalCSS("static/css/style.css", "templates")
+		if err != nil {
+			log.Printf("Error extracting critical css: %v\n", err)
+		}
+		st.criticalCSS = css
+	}
+	if config().Assets.Hints && !config().Headless {
+		st.preloads = preloadHints(config().Assets)
+		if config().Assets.LinkHeaders {
+			r.Use(s.linkHeaders(st.preloads))
+		}
+	}
+
+	// a headless site has no pages, and doesn't need templates
+	if !config().Headless {
+		// load in the templates, checkTemplates has already reported
+		// any that don't parse
+		templates, err := loadTemplates(templatesDir, s.funcMap(st))
+		if err != nil {
+			return err
+		}
+		st.templates.Store(templates)
+		r.HTMLRender = st
+	}
+
+	if config().Private.Enabled {
+		r.Use(s.privateSite())
+	}
+	if len(config().Redirects) > 0 {
+		r.Use(redirects(config().Redirects))
+	}
+	if len(config().Cache) > 0 {
+		r.Use(cacheHeaders(config().Cache))
+	}
+	if len(config().Headers) > 0 {
+		r.Use(customHeaders(config().Headers))
+	}
+	if config().Minify {
+		r.Use(minifyResponses)
+	}
+
+	// serve static assets, compressed up front if configured
+	if config().Assets.Precompress {
+		static, err := newStaticFiles("./static")
+		if err != nil {
+			log.Printf("Error compressing static files: %v\n", err)
+			r.Static("/static", "./static")
+		} else {
+			r.GET("/static/*filepath", static.handler("/static"))
+			r.HEAD("/static/*filepath", static.handler("/static"))
+		}
+	} else {
+		r.Static("/static", "./static")
+	}
+	if route := selfHostRoute(); config().Images.SelfHost && route != "" {
+		r.Static(route, selfHostDir())
+	}
+
+	if config().Headless {
+		r.GET("/", handleAPIIndex)
+	} else {
+		// single route for the home page
+		r.GET("/", s.handleIndex)
+
+		if config().Changelog.Enabled {
+			r.GET("/changelog", s.handleChangelog)
+		}
+		r.GET("/search", s.handleSearch)
+		r.GET("/categories/:name", s.handleCategory)
+		r.GET("/categories/:name/all", s.handleCategoryAll)
+		r.GET("/all", s.handleAll)
+	}
+
+	r.GET("/feed.xml", s.handleRSS)
+	r.GET("/atom.xml", s.handleAtom)
+	r.GET("/sitemap.xml", s.handleSitemap)
+	r.GET("/sitemap/:page", s.handleSitemapPage)
+	r.GET("/llms.txt", s.handleLLMs)
+	r.GET("/llms-full.txt", s.handleLLMsFull)
+	r.GET("/llms.jsonl", s.handleLLMsChunks)
+	if config().Security.enabled() {
+		r.GET("/.well-known/security.txt", handleSecurityTxt)
+	}
+	if config().Humans.enabled() {
+		r.GET("/humans.txt", s.handleHumansTxt)
+	}
+
+	r.GET("/api/search", s.handleSearchAPI)
+	r.GET("/api/quick-search", s.handleQuickSearch)
+	r.GET("/api/geo", handleGeo)
+	r.GET("/api/searches", s.requireScope(scopeAnalyticsRead, roleViewer), s.handleSearchesAPI)
+	if s.semantic != nil {
+		r.GET("/api/semantic-search", s.handleSemanticSearch)
+	}
+	if s.chat != nil {
+		r.GET("/api/ask", s.handleAsk)
+		r.POST("/api/ask", s.handleAsk)
+	}
+
+	r.GET("/api/posts", s.handleListPosts)
+	r.GET("/api/posts/:slug", s.handleGetPost)
+	r.PUT("/api/posts/:slug", s.requireScope(scopePostsWrite, roleEditor), s.handlePutPost)
+	r.DELETE("/api/posts/:slug", s.requireScope(scopePostsWrite, roleEditor), s.handleDeletePost)
+
+	r.GET("/api/reactions/:slug", s.handleGetReactions)
+	r.POST("/api/reactions/:slug", s.handleReact)
+
+	r.GET("/api/comments/:slug", s.handleGetComments)
+	r.POST("/api/comments/:slug", s.handlePostComment)
+
+	// github sign in, so comments carry a verified identity
+	if config().GitHub.enabled() {
+		r.GET("/auth/github/login", s.handleGitHubLogin)
+		r.GET("/auth/github/callback", s.handleGitHubCallback)
+	}
+	r.GET("/auth/logout", func(c *gin.Context) {
+		s.sessions.end(c)
+		c.Redirect(http.StatusFound, localPath(c.Query("return")))
+	})
+
+	if config().Hooks.Secret != "" {
+		r.POST("/hooks/rebuild", s.handleRebuildHook)
+	}
+	// notion has to reach it to send the token it signs with
+	if config().Notion.enabled() {
+		r.POST("/hooks/notion", s.handleNotionHook)
+	}
+
+	if !config().Headless {
+		s.adminRoutes(r)
+	}
+
+	if config().Debug.Enabled {
+		s.debugRoutes(r)
+	}
+
+	pluginRoutes(r, s.plugins)
+
+	if config().Headless {
+		r.NoRoute(func(c *gin.Context) {
+			if c.Request.Method == http.MethodGet && !s.offline {
+				s.misses.record(c.Request.URL.Path, c.Request.Referer())
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		})
+		return nil
+	}
+
+	// every post is served from here, based off of slug following the /
+	r.NoRoute(s.handlePost)
+	return nil
+}
+
+func (s *server) handlePost(c *gin.Context) {
+	// posts are the fallback route, so HEAD requests for them land here too
+	get := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	if get && s.versionRedirect(c) {
+		return
+	}
+
+	// links from wordpress and the like end in a slash
+	path := c.Request.URL.Path
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	post, ok := s.postByURL(path)
+	if !ok && get {
+		// flat links to posts that moved to dated urls
+		if moved, found := s.post(path[1:]); found {
+			c.Redirect(http.StatusMovedPermanently, moved.URL())
+			return
+		}
+	}
+	if !ok || !get {
+		s.notFound(c)
+		return
+	}
+	if path != c.Request.URL.Path {
+		c.Redirect(http.StatusMovedPermanently, post.URL())
+		return
+	}
+
+	s.renderPost(c, post, "layout.html", false)
+}
+
+// renderPost serves a post's page with tmpl, the home page's included
+func (s *server) renderPost(c *gin.Context, post BlogPost, tmpl string, home bool) {
+	session := s.sessions.get(c)
+	if !canAccess(session, post) {
+		s.denyAccess(c, session, post)
+		return
+	}
+	if post.restricted() {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+
+	if !s.offline {
+		s.views.hit(post.Slug)
+	}
+	post, variant := assignVariant(c, post)
+	robots := robotsDirectives(post)
+	if robots != "" {
+		c.Header("X-Robots-Tag", robots)
+	}
+
+	s.html(c, http.StatusOK, tmpl, gin.H{
+		"Viewer":                  session,
+		"Title":                   post.Title,
+		"Content":                 post.Content,
+		"SidebarData":             s.sidebar(c, post.Version).activeFor(post.Slug),
+		"Versions":                s.versionLinks(post),
+		"Headers":                 post.Headers,
+		"Description":             post.Description,
+		"Stale":                   post.Stale(),
+		"Archived":                post.Expired(),
+		"Variant":                 variant,
+		"LastModified":            post.LastModified,
+		"TOC":                     createSidebarLinks(post.Content),
+		"CurrentSlug":             post.Slug,
+		"EditURL":                 editURL(config().Repo, post),
+		"Contributors":            post.Contributors,
+		"MetaDescription":         post.MetaDescription,
+		"MetaPropertyTitle":       post.MetaPropertyTitle,
+		"MetaPropertyDescription": post.MetaPropertyDescription,
+		"MetaOgURL":               post.OgURL(),
+		"OgImage":                 post.ImageURL(),
+		"Canonical":               post.CanonicalURL(),
+		"Robots":                  robots,
+		"StructuredData":          s.structuredData(post, home),
+	})
+}
@@ -0,0 +1,136 @@
+package blog
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie = "bloog_session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+// Session is whoever is signed in on a browser, either a commenter through
+// github or an admin area user with a role
+type Session struct {
+	GitHubLogin string
+	Name        string
+	AvatarURL   string
+	Username    string
+	Role        string
+	Expires     time.Time
+}
+
+// sessionBackend holds sessions by their cookie value, in memory or in
+// redis when several instances need to share them
+type sessionBackend interface {
+	load(id string) (Session, bool)
+	store(id string, session Session)
+	remove(id string)
+}
+
+// sessionStore keeps sessions keyed by a random cookie value
+type sessionStore struct {
+	backend sessionBackend
+	// looks up an admin area user's current role, false once they're gone
+	roles func(username string) (string, bool)
+}
+
+func newSessionStore(backend sessionBackend, roles func(username string) (string, bool)) *sessionStore {
+	return &sessionStore{backend: backend, roles: roles}
+}
+
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: make(map[string]Session)}
+}
+
+func (m *memorySessions) load(id string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	return session, ok
+}
+
+func (m *memorySessions) store(id string, session Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[id] = session
+}
+
+func (m *memorySessions) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+// purge forgets the sessions expired by now, reporting how many
+func (m *memorySessions) purge(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, session := range m.sessions {
+		if now.After(session.Expires) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// get returns the session for the request, or nil when not signed in
+func (s *sessionStore) get(c *gin.Context) *Session {
+	id, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	session, ok := s.backend.load(id)
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		s.backend.remove(id)
+		return nil
+	}
+	// the role is looked up every time rather than kept from when they
+	// signed in, so demoting or deleting a user takes effect at once
+	if session.Username != "" && s.roles != nil {
+		role, ok := s.roles(session.Username)
+		if !ok {
+			s.backend.remove(id)
+			return nil
+		}
+		session.Role = role
+	}
+	return &session
+}
+
+func (s *sessionStore) start(c *gin.Context, session Session) {
+	id := newID() + newID()
+	session.Expires = time.Now().Add(sessionTTL)
+
+	s.backend.store(id, session)
+
+	setCookie(c, sessionCookie, id, int(sessionTTL.Seconds()))
+}
+
+func (s *sessionStore) end(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookie); err == nil {
+		s.backend.remove(id)
+	}
+
+	setCookie(c, sessionCookie, "", -1)
+}
+
+func setCookie(c *gin.Context, name, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", "", c.Request.TLS != nil, true)
+}
@@ -0,0 +1,52 @@
+package blog_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anuragcsangal/blog/bloogtest"
+)
+
+func TestSiteGolden(t *testing.T) {
+	srv, err := bloogtest.NewServer("testdata/site/markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// in order, every visit counts towards the popular posts
+	pages := []struct{ path, file string }{
+		{"/", "index.html"},
+		{"/hello-world", "hello-world.html"},
+		{"/second-post", "second-post.html"},
+		{"/from-hugo", "from-hugo.html"},
+		{"/generated", "generated.html"},
+		{"/no-frontmatter", "no-frontmatter.html"},
+		{"/yaml-block", "yaml-block.html"},
+		{"/categories/getting-started", "category.html"},
+		{"/categories/getting-started/all", "category-all.html"},
+		{"/missing", "404.html"},
+		{"/helo-world", "404-near-miss.html"},
+		{"/llms.txt", "llms.txt"},
+	}
+	for _, page := range pages {
+		path, file := page.path, page.file
+		status, body, err := srv.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := http.StatusOK; strings.HasPrefix(file, "404") {
+			if status != http.StatusNotFound {
+				t.Errorf("GET %s: status %d, want 404", path, status)
+			}
+		} else if status != want {
+			t.Errorf("GET %s: status %d, want %d", path, status, want)
+		}
+		// the server's address changes every run
+		body = strings.ReplaceAll(body, srv.URL, "http://bloog.test")
+		body = strings.ReplaceAll(body, strings.TrimPrefix(srv.URL, "http://"), "bloog.test")
+		bloogtest.Golden(t, filepath.Join("testdata/site/golden", file), []byte(body))
+	}
+}
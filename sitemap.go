@@ -0,0 +1,138 @@
+package blog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the sitemap protocol allows this many urls per file, bigger sites get a
+// sitemap index pointing at several sitemaps
+const sitemapMaxURLs = 50000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+func sitemapDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+// sitemapURLs lists the home page and every post search engines may index,
+// with the frontmatter's Priority and ChangeFreq overriding the defaults
+func sitemapURLs(posts []BlogPost) []sitemapURL {
+	var newest time.Time
+	var urls []sitemapURL
+	for _, post := range publicPosts(currentPosts(posts)) {
+		// copies are left for their originals
+		if post.Slug == "" || post.noindex() || post.Canonical != "" {
+			continue
+		}
+		if post.LastModified.After(newest) {
+			newest = post.LastModified
+		}
+
+		url := sitemapURL{
+			Loc:        baseURL() + post.URL(),
+			LastMod:    sitemapDate(post.LastModified),
+			ChangeFreq: post.ChangeFreq,
+			Priority:   post.Priority,
+		}
+		if url.ChangeFreq == "" {
+			url.ChangeFreq = "monthly"
+		}
+		if url.Priority == "" {
+			url.Priority = "0.5"
+		}
+		urls = append(urls, url)
+	}
+
+	// the home page changes whenever anything does
+	home := sitemapURL{Loc: baseURL() + "/", LastMod: sitemapDate(newest), ChangeFreq: "daily", Priority: "1.0"}
+	return append([]sitemapURL{home}, urls...)
+}
+
+func sitemapPages(urls int) int {
+	return (urls + sitemapMaxURLs - 1) / sitemapMaxURLs
+}
+
+// handleSitemap serves the sitemap, or a sitemap index once there are more
+// urls than one sitemap can hold
+func (s *server) handleSitemap(c *gin.Context) {
+	urls := sitemapURLs(s.allPosts())
+	if len(urls) <= sitemapMaxURLs {
+		writeXML(c, sitemapURLSet{NS: sitemapNS, URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{NS: sitemapNS}
+	for i := 1; i <= sitemapPages(len(urls)); i++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapRef{
+			Loc:     fmt.Sprintf("%s/sitemap/%d.xml", baseURL(), i),
+			LastMod: urls[0].LastMod,
+		})
+	}
+	writeXML(c, index)
+}
+
+// handleSitemapPage serves one of the sitemaps listed in the sitemap index
+func (s *server) handleSitemapPage(c *gin.Context) {
+	urls := sitemapURLs(s.allPosts())
+	page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+	if err != nil || page < 1 || page > sitemapPages(len(urls)) || len(urls) <= sitemapMaxURLs {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	end := page * sitemapMaxURLs
+	if end > len(urls) {
+		end = len(urls)
+	}
+	writeXML(c, sitemapURLSet{NS: sitemapNS, URLs: urls[(page-1)*sitemapMaxURLs : end]})
+}
+
+// writeXML sends v as an xml document, with the declaration c.XML leaves out
+func writeXML(c *gin.Context, v interface{}) {
+	writeXMLType(c, "application/xml; charset=utf-8", v)
+}
+
+func writeXMLType(c *gin.Context, contentType string, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), data...))
+}
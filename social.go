@@ -0,0 +1,291 @@
+package blog
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultSocialMessage = "{{ .Title }} {{ .URL }}"
+
+func (c SocialConfig) enabled() bool {
+	return c.Mastodon.Token != "" || c.Bluesky.AppPassword != "" || c.X.AccessToken != ""
+}
+
+// announcement is what the message template is executed with
+type announcement struct {
+	Title       string
+	URL         string
+	Description string
+	Tags        []string
+	// the tags as #hashtags
+	Hashtags string
+}
+
+// announcementStore remembers which posts went out on which network, so a
+// post that's removed and put back isn't announced twice
+type announcementStore struct {
+	mu   sync.Mutex
+	path string
+	// slug -> network -> when
+	sent map[string]map[string]time.Time
+}
+
+func newAnnouncementStore(path string) (*announcementStore, error) {
+	a := &announcementStore{path: path, sent: make(map[string]map[string]time.Time)}
+	if err := loadJSON(path, &a.sent); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *announcementStore) done(slug, network string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, ok := a.sent[slug][network]
+	return ok
+}
+
+func (a *announcementStore) record(slug, network string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.sent[slug] == nil {
+		a.sent[slug] = make(map[string]time.Time)
+	}
+	a.sent[slug][network] = time.Now()
+
+	return saveJSON(a.path, a.sent)
+}
+
+// announce posts newly published posts to every configured network
+func (s *server) announce(slugs []string) {
+	tmpl := config().Social.Message
+	if tmpl == "" {
+		tmpl = defaultSocialMessage
+	}
+	message, err := template.New("social").Parse(tmpl)
+	if err != nil {
+		log.Printf("Error parsing social message: %v\n", err)
+		return
+	}
+
+	networks := map[string]func(text, link string) error{}
+	if config().Social.Mastodon.Token != "" {
+		networks["mastodon"] = config().Social.Mastodon.post
+	}
+	if config().Social.Bluesky.AppPassword != "" {
+		networks["bluesky"] = config().Social.Bluesky.post
+	}
+	if config().Social.X.AccessToken != "" {
+		networks["x"] = config().Social.X.post
+	}
+
+	for _, slug := range slugs {
+		post, ok := s.post(slug)
+		if !ok || post.restricted() {
+			continue
+		}
+
+		text, link, err := announcementText(message, post)
+		if err != nil {
+			log.Printf("Error rendering announcement for %s: %v\n", slug, err)
+			continue
+		}
+
+		for network, send := range networks {
+			if s.announcements.done(slug, network) {
+				continue
+			}
+			if err := send(text, link); err != nil {
+				log.Printf("Error announcing %s on %s: %v\n", slug, network, err)
+				continue
+			}
+			if err := s.announcements.record(slug, network); err != nil {
+				log.Printf("Error saving announcements: %v\n", err)
+			}
+		}
+	}
+}
+
+func announcementText(message *template.Template, post BlogPost) (string, string, error) {
+	a := announcement{
+		Title:       post.Title,
+		URL:         baseURL() + post.URL(),
+		Description: post.Description,
+		Tags:        post.Tags,
+	}
+	var hashtags []string
+	for _, tag := range post.Tags {
+		hashtags = append(hashtags, "#"+strings.ReplaceAll(tag, " ", ""))
+	}
+	a.Hashtags = strings.Join(hashtags, " ")
+
+	var b bytes.Buffer
+	if err := message.Execute(&b, a); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(b.String()), a.URL, nil
+}
+
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// jsonRequest sends an api request and decodes the json reply into out, if
+// given
+func jsonRequest(req *http.Request, out interface{}) error {
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s failed: %s: %s", req.Method, req.URL.Host, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (m MastodonConfig) post(text, link string) error {
+	form := url.Values{"status": {text}}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(m.Server, "/")+"/api/v1/statuses", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	// retries of the same announcement don't make duplicate statuses
+	req.Header.Set("Idempotency-Key", sha256Hex([]byte(text)))
+
+	return jsonRequest(req, nil)
+}
+
+func (b BlueskyConfig) post(text, link string) error {
+	server := strings.TrimRight(b.Server, "/")
+	if server == "" {
+		server = "https://bsky.social"
+	}
+
+	body, _ := json.Marshal(map[string]string{"identifier": b.Handle, "password": b.AppPassword})
+	req, err := http.NewRequest(http.MethodPost, server+"/xrpc/com.atproto.server.createSession", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var session struct {
+		AccessJwt string `json:"accessJwt"`
+		DID       string `json:"did"`
+	}
+	if err := jsonRequest(req, &session); err != nil {
+		return err
+	}
+
+	record := map[string]interface{}{
+		"$type":     "app.bsky.feed.post",
+		"text":      text,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+	}
+	// links are only clickable when marked up as a facet, by byte offset
+	if i := strings.Index(text, link); i >= 0 {
+		record["facets"] = []interface{}{map[string]interface{}{
+			"index": map[string]int{"byteStart": i, "byteEnd": i + len(link)},
+			"features": []interface{}{map[string]string{
+				"$type": "app.bsky.richtext.facet#link",
+				"uri":   link,
+			}},
+		}}
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"repo":       session.DID,
+		"collection": "app.bsky.feed.post",
+		"record":     record,
+	})
+	req, err = http.NewRequest(http.MethodPost, server+"/xrpc/com.atproto.repo.createRecord", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+session.AccessJwt)
+
+	return jsonRequest(req, nil)
+}
+
+const xTweetsURL = "https://api.twitter.com/2/tweets"
+
+func (x XConfig) post(text, link string) error {
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequest(http.MethodPost, xTweetsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", x.oauth1(http.MethodPost, xTweetsURL, time.Now()))
+
+	return jsonRequest(req, nil)
+}
+
+// oauth1 signs a request with HMAC-SHA1 the way the x api wants for user
+// context. Json bodies aren't part of the signature
+func (x XConfig) oauth1(method, endpoint string, now time.Time) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	params := map[string]string{
+		"oauth_consumer_key":     x.APIKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(now.Unix(), 10),
+		"oauth_token":            x.AccessToken,
+		"oauth_version":          "1.0",
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, oauthEscape(k)+"="+oauthEscape(params[k]))
+	}
+	base := method + "&" + oauthEscape(endpoint) + "&" + oauthEscape(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(oauthEscape(x.APISecret)+"&"+oauthEscape(x.AccessSecret)))
+	mac.Write([]byte(base))
+	params["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	var header []string
+	for _, k := range append(keys, "oauth_signature") {
+		header = append(header, fmt.Sprintf(`%s="%s"`, oauthEscape(k), oauthEscape(params[k])))
+	}
+	return "OAuth " + strings.Join(header, ", ")
+}
+
+// oauthEscape is rfc 3986 percent encoding, which differs from query
+// escaping in spaces
+func oauthEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,96 @@
+package blog
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// months is how long pages in section go unchanged before they're stale,
+// 0 for never
+func (c StaleConfig) months(section string) int {
+	if n, ok := c.Sections[section]; ok {
+		return n
+	}
+	return c.After
+}
+
+// Stale reports whether the post hasn't changed in longer than its
+// section allows, for templates to warn that it may be outdated
+func (p BlogPost) Stale() bool {
+	months := config().Stale.months(p.Parent)
+	return months > 0 && !p.LastModified.IsZero() && p.LastModified.Before(time.Now().AddDate(0, -months, 0))
+}
+
+// stalePage is a page that's gone unchanged for too long
+type stalePage struct {
+	Source       string    `json:"source"`
+	URL          string    `json:"url"`
+	Section      string    `json:"section,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	// how many months its section allows
+	Months int `json:"months"`
+}
+
+// stalePages lists the stale posts, longest unchanged first
+func stalePages(posts []BlogPost) []stalePage {
+	var stale []stalePage
+	for _, post := range posts {
+		if post.Slug == "" || !post.Stale() {
+			continue
+		}
+		stale = append(stale, stalePage{
+			Source:       postSource(post),
+			URL:          post.URL(),
+			Section:      post.Parent,
+			LastModified: post.LastModified,
+			Months:       config().Stale.months(post.Parent),
+		})
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastModified.Before(stale[j].LastModified)
+	})
+	return stale
+}
+
+// staleCommand lists the pages that haven't changed in longer than the
+// stale config allows: stale [-json]
+func staleCommand(args []string) error {
+	fs := flag.NewFlagSet("stale", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	fs.Parse(args)
+
+	if config().Stale.After == 0 && len(config().Stale.Sections) == 0 {
+		return fmt.Errorf("set stale.after or stale.sections in bloog.yaml to say when pages go stale")
+	}
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	stale := stalePages(s.allPosts())
+
+	if *asJSON {
+		if stale == nil {
+			stale = []stalePage{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stale)
+	}
+	if len(stale) == 0 {
+		fmt.Println("no stale pages")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAGE\tSECTION\tLAST CHANGED\tALLOWED")
+	for _, page := range stale {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d months\n", page.Source, page.Section, page.LastModified.Format("2006-01-02"), page.Months)
+	}
+	w.Flush()
+	fmt.Printf("%d stale page(s)\n", len(stale))
+	return nil
+}
@@ -0,0 +1,60 @@
+// decrypts password protected pages in the browser, the other half of
+// protected.go: PBKDF2-SHA256 for the key, AES-GCM for the content
+(function () {
+    function bytes(base64) {
+        return Uint8Array.from(atob(base64), function (c) { return c.charCodeAt(0); });
+    }
+
+    function decrypt(page, password) {
+        var payload = bytes(page.dataset.payload);
+        var salt = payload.slice(0, 16);
+        var nonce = payload.slice(16, 28);
+        var sealed = payload.slice(28);
+        var encoder = new TextEncoder();
+
+        return crypto.subtle.importKey('raw', encoder.encode(password), 'PBKDF2', false, ['deriveKey'])
+            .then(function (material) {
+                return crypto.subtle.deriveKey(
+                    { name: 'PBKDF2', salt: salt, iterations: parseInt(page.dataset.iterations, 10), hash: 'SHA-256' },
+                    material, { name: 'AES-GCM', length: 256 }, false, ['decrypt']);
+            })
+            .then(function (key) {
+                return crypto.subtle.decrypt({ name: 'AES-GCM', iv: nonce }, key, sealed);
+            })
+            .then(function (html) {
+                return new TextDecoder().decode(html);
+            });
+    }
+
+    function unlock(page, password, remember) {
+        return decrypt(page, password).then(function (html) {
+            page.outerHTML = html;
+            // the password is kept for the tab, so going back doesn't ask again
+            if (remember) {
+                sessionStorage.setItem('protected:' + location.pathname, password);
+            }
+        });
+    }
+
+    document.querySelectorAll('.protected-page').forEach(function (page) {
+        var form = page.querySelector('form');
+        var input = form.querySelector('input');
+        var error = form.querySelector('.protected-error');
+
+        var saved = sessionStorage.getItem('protected:' + location.pathname);
+        if (saved) {
+            unlock(page, saved, false).catch(function () {
+                sessionStorage.removeItem('protected:' + location.pathname);
+            });
+        }
+
+        form.addEventListener('submit', function (e) {
+            e.preventDefault();
+            error.hidden = true;
+            unlock(page, input.value, true).catch(function () {
+                error.hidden = false;
+                input.select();
+            });
+        });
+    });
+})();
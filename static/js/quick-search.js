@@ -0,0 +1,125 @@
+// the cmd-K (ctrl-K elsewhere) palette, jumping to pages by title and to
+// the current page's sections by heading
+(function () {
+    var dialog = document.getElementById('quick-search');
+    var input = dialog.querySelector('input');
+    var list = dialog.querySelector('ul');
+    var results = [];
+    var selected = 0;
+    var latest = 0;
+
+    // the page's headings and their ids, from the contents sidebar, with
+    // the nested ones flattened out in page order
+    var sections = [];
+    function addSections(entries) {
+        (entries || []).forEach(function (entry) {
+            sections.push({ title: entry.title, url: '#' + entry.id, section: true });
+            addSections(entry.children);
+        });
+    }
+    var data = document.getElementById('page-sections');
+    if (data) {
+        addSections(JSON.parse(data.textContent));
+    }
+
+    // the sections with a word starting with every word of the query, as
+    // the server matches titles
+    function matchSections(query) {
+        var terms = query.toLowerCase().split(/\s+/);
+        return sections.filter(function (section) {
+            var words = section.title.toLowerCase().split(/[^\p{L}\p{N}]+/u);
+            return terms.every(function (term) {
+                return words.some(function (word) { return word.indexOf(term) === 0; });
+            });
+        });
+    }
+
+    function go(result) {
+        dialog.close();
+        window.location = result.url;
+    }
+
+    function render() {
+        list.innerHTML = '';
+        results.forEach(function (result, i) {
+            var li = document.createElement('li');
+            var a = document.createElement('a');
+            a.href = result.url;
+            a.textContent = result.title;
+            if (result.section) {
+                a.className = 'quick-search-section';
+            }
+            li.appendChild(a);
+            li.setAttribute('role', 'option');
+            if (i === selected) {
+                li.className = 'selected';
+                li.setAttribute('aria-selected', 'true');
+            }
+            list.appendChild(li);
+        });
+    }
+
+    input.addEventListener('input', function () {
+        var query = input.value.trim();
+        var request = ++latest;
+        if (!query) {
+            results = [];
+            render();
+            return;
+        }
+        // this page's sections show straight away, pages join them after
+        var onPage = matchSections(query);
+        results = onPage;
+        selected = 0;
+        render();
+        fetch('/api/quick-search?q=' + encodeURIComponent(query))
+            .then(function (resp) { return resp.json(); })
+            .then(function (data) {
+                // answers can come back out of order
+                if (request !== latest) {
+                    return;
+                }
+                results = onPage.concat(data.results || []);
+                selected = 0;
+                render();
+            });
+    });
+
+    input.addEventListener('keydown', function (e) {
+        if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+            e.preventDefault();
+            if (results.length) {
+                selected = (selected + (e.key === 'ArrowDown' ? 1 : results.length - 1)) % results.length;
+                render();
+            }
+        } else if (e.key === 'Enter' && results[selected]) {
+            e.preventDefault();
+            go(results[selected]);
+        }
+    });
+
+    // a section is on this page, so nothing reloads to close the box
+    list.addEventListener('click', function (e) {
+        if (e.target.classList.contains('quick-search-section')) {
+            dialog.close();
+        }
+    });
+
+    document.addEventListener('keydown', function (e) {
+        if ((e.metaKey || e.ctrlKey) && e.key.toLowerCase() === 'k') {
+            e.preventDefault();
+            input.value = '';
+            results = [];
+            render();
+            dialog.showModal();
+            input.focus();
+        }
+    });
+
+    // clicking outside the box closes it
+    dialog.addEventListener('click', function (e) {
+        if (e.target === dialog) {
+            dialog.close();
+        }
+    });
+})();
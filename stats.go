@@ -0,0 +1,187 @@
+package blog
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// how fast reading times assume people read
+const wordsPerMinute = 200
+
+// readingMinutes is how long words take to read, at least a minute
+func readingMinutes(words int) int {
+	return max(1, int(math.Ceil(float64(words)/wordsPerMinute)))
+}
+
+// namedCount is how many posts, and words in them, a section, tag or
+// author has
+type namedCount struct {
+	Name  string `json:"name"`
+	Posts int    `json:"posts"`
+	Words int    `json:"words"`
+}
+
+// pageSize is how long a page is to read and how much html it is
+type pageSize struct {
+	Source         string `json:"source"`
+	URL            string `json:"url"`
+	Words          int    `json:"words"`
+	ReadingMinutes int    `json:"reading_minutes"`
+	// rendered html, before the layout
+	Bytes int `json:"bytes"`
+}
+
+// contentStats is the whole site's content in numbers
+type contentStats struct {
+	Posts                 int          `json:"posts"`
+	Words                 int          `json:"words"`
+	AverageWords          int          `json:"average_words"`
+	AverageReadingMinutes float64      `json:"average_reading_minutes"`
+	Sections              []namedCount `json:"sections"`
+	Tags                  []namedCount `json:"tags"`
+	// who added the posts, from git, empty outside a repository
+	Authors []namedCount `json:"authors"`
+	Largest []pageSize   `json:"largest"`
+}
+
+// postAuthors is who added each markdown file under dir, by path, as git
+// remembers it
+func postAuthors(dir string) map[string]string {
+	out, err := exec.Command("git", "-c", "core.quotepath=off", "log", "--diff-filter=A",
+		"--format=%x01%aN", "--name-only", "--", dir).Output()
+	if err != nil {
+		return nil
+	}
+	authors := make(map[string]string)
+	for _, commit := range strings.Split(string(out), "\x01") {
+		lines := strings.Split(strings.TrimSpace(commit), "\n")
+		for _, file := range lines[1:] {
+			file = filepath.FromSlash(strings.TrimSpace(file))
+			// the log is newest first, a file added again keeps its latest author
+			if _, ok := authors[file]; !ok && file != "" {
+				authors[file] = lines[0]
+			}
+		}
+	}
+	return authors
+}
+
+// countNames turns counts by name into a list, most posts first
+func countNames(counts map[string]*namedCount) []namedCount {
+	list := []namedCount{}
+	for _, count := range counts {
+		list = append(list, *count)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Posts != list[j].Posts {
+			return list[i].Posts > list[j].Posts
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// collectStats counts the posts' words by section, tag and author, and
+// lists the top largest pages by words
+func collectStats(posts []BlogPost, authors map[string]string, top int) contentStats {
+	stats := contentStats{Largest: []pageSize{}}
+	sections := make(map[string]*namedCount)
+	tags := make(map[string]*namedCount)
+	byAuthor := make(map[string]*namedCount)
+	add := func(counts map[string]*namedCount, name string, words int) {
+		if counts[name] == nil {
+			counts[name] = &namedCount{Name: name}
+		}
+		counts[name].Posts++
+		counts[name].Words += words
+	}
+
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+		words := len(strings.Fields(plainText(string(post.Content))))
+		stats.Posts++
+		stats.Words += words
+		stats.Largest = append(stats.Largest, pageSize{
+			Source:         postSource(post),
+			URL:            post.URL(),
+			Words:          words,
+			ReadingMinutes: readingMinutes(words),
+			Bytes:          len(post.Content),
+		})
+
+		section := post.Parent
+		if section == "" {
+			section = "(none)"
+		}
+		add(sections, section, words)
+		for _, tag := range post.Tags {
+			add(tags, tag, words)
+		}
+		if author, ok := authors[postSource(post)]; ok {
+			add(byAuthor, author, words)
+		}
+	}
+
+	if stats.Posts > 0 {
+		stats.AverageWords = stats.Words / stats.Posts
+		stats.AverageReadingMinutes = math.Round(float64(stats.Words)/wordsPerMinute/float64(stats.Posts)*10) / 10
+	}
+	stats.Sections, stats.Tags, stats.Authors = countNames(sections), countNames(tags), countNames(byAuthor)
+	sort.SliceStable(stats.Largest, func(i, j int) bool { return stats.Largest[i].Words > stats.Largest[j].Words })
+	if len(stats.Largest) > top {
+		stats.Largest = stats.Largest[:top]
+	}
+	return stats
+}
+
+// statsCommand reports how much content there is and where, for editorial
+// planning and page weight budgets: stats [-json] [-top n]
+func statsCommand(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	top := fs.Int("top", 10, "how many of the largest pages to list")
+	fs.Parse(args)
+
+	s, err := newOfflineServer(markdownDir)
+	if err != nil {
+		return fmt.Errorf("loading content: %w", err)
+	}
+	stats := collectStats(s.allPosts(), postAuthors("markdown"), *top)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	fmt.Printf("%d posts, %d words, %d words or %.1f minutes to read on average\n",
+		stats.Posts, stats.Words, stats.AverageWords, stats.AverageReadingMinutes)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, group := range []struct {
+		heading string
+		counts  []namedCount
+	}{{"SECTION", stats.Sections}, {"TAG", stats.Tags}, {"AUTHOR", stats.Authors}} {
+		if len(group.counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\tPOSTS\tWORDS\n", group.heading)
+		for _, count := range group.counts {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", count.Name, count.Posts, count.Words)
+		}
+	}
+	fmt.Fprintln(w, "\nLARGEST\tWORDS\tMINUTES\tHTML")
+	for _, page := range stats.Largest {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f KB\n", page.Source, page.Words, page.ReadingMinutes, float64(page.Bytes)/1024)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,134 @@
+package blog
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// loadJSON decodes the json file at path into v, leaving v untouched when
+// the file doesn't exist yet
+func loadJSON(path string, v interface{}) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+func saveJSON(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, content)
+}
+
+// writeFileAtomic writes to a temp file first so a crash never leaves a
+// half written file behind
+func writeFileAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// counterStore is a bounded set of counters by key, saved to a json file,
+// for the logs visitors add to. When it's full the least counted entry, the
+// one counted longest ago of those, makes room for a new one
+type counterStore[T any] struct {
+	mu    sync.Mutex
+	path  string
+	name  string
+	limit int
+	// how often an entry was counted and when it last was
+	weight func(T) (int, time.Time)
+	items  map[string]T
+	dirty  bool
+}
+
+func newCounterStore[T any](path, name string, limit int, weight func(T) (int, time.Time)) (*counterStore[T], error) {
+	c := &counterStore[T]{path: path, name: name, limit: limit, weight: weight, items: make(map[string]T)}
+	if err := loadJSON(path, &c.items); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// update counts key with fn, given the zero value for a new key
+func (c *counterStore[T]) update(key string, fn func(*T)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok && len(c.items) >= c.limit {
+		c.evict()
+	}
+	fn(&item)
+	c.items[key] = item
+	c.dirty = true
+}
+
+func (c *counterStore[T]) evict() {
+	victim, found := "", false
+	var victimCount int
+	var victimLast time.Time
+	for key, item := range c.items {
+		count, last := c.weight(item)
+		if !found || count < victimCount || (count == victimCount && last.Before(victimLast)) {
+			victim, victimCount, victimLast, found = key, count, last, true
+		}
+	}
+	delete(c.items, victim)
+}
+
+// all is every entry, in no particular order
+func (c *counterStore[T]) all() []T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	all := make([]T, 0, len(c.items))
+	for _, item := range c.items {
+		all = append(all, item)
+	}
+	return all
+}
+
+func (c *counterStore[T]) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	if err := saveJSON(c.path, c.items); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// persist saves the counters every interval, forever
+func (c *counterStore[T]) persist(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.save(); err != nil {
+			log.Printf("Error saving %s: %v\n", c.name, err)
+		}
+	}
+}
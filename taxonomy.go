@@ -0,0 +1,67 @@
+package blog
+
+import (
+	"sort"
+)
+
+type TermCount struct {
+	Name  string
+	Count int
+	// Weight buckets the count from 1 to 5 for sizing tag cloud entries
+	Weight int
+}
+
+// tagCloud counts how many posts carry each tag, sorted by tag name
+func tagCloud(posts []BlogPost) []TermCount {
+	counts := make(map[string]int)
+	for _, post := range posts {
+		for _, tag := range post.Tags {
+			counts[tag]++
+		}
+	}
+
+	return termCounts(counts)
+}
+
+// categoryCounts counts how many posts are in each category, sorted by name
+func categoryCounts(posts []BlogPost) []TermCount {
+	counts := make(map[string]int)
+	for _, post := range posts {
+		if post.Parent != "" {
+			counts[post.Parent]++
+		}
+	}
+
+	return termCounts(counts)
+}
+
+func termCounts(counts map[string]int) []TermCount {
+	max := 0
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+
+	var terms []TermCount
+	for name, count := range counts {
+		terms = append(terms, TermCount{
+			Name:   name,
+			Count:  count,
+			Weight: 1 + (count-1)*4/maxInt(max-1, 1),
+		})
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].Name < terms[j].Name
+	})
+
+	return terms
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package blog
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// TemplateData is more for the pages rendered for a request, for what the
+// handlers know nothing about: feature flags, a banner for the environment,
+// an experiment's variant. Sites register their own from an init func in a
+// file of their own
+type TemplateData func(c *gin.Context) map[string]interface{}
+
+var templateData []TemplateData
+
+// RegisterTemplateData adds fn's data to every page, after the config's
+// and before the plugins'
+func RegisterTemplateData(fn TemplateData) {
+	templateData = append(templateData, fn)
+}
+
+// pageData is what's added to every page for the request: where it came
+// from, template_data from the config then the environment, the registered
+// funcs' and the plugins', later ones replacing earlier keys
+func (s *server) pageData(c *gin.Context) map[string]interface{} {
+	data := make(map[string]interface{})
+	if geo := requestGeo(c); geo != nil {
+		data["Geo"] = geo
+	}
+	for key, value := range config().TemplateData {
+		data[key] = value
+	}
+	for key, value := range environment().TemplateData {
+		data[key] = value
+	}
+	for _, fn := range templateData {
+		for key, value := range fn(c) {
+			data[key] = value
+		}
+	}
+	for _, p := range s.plugins {
+		for _, fn := range p.data {
+			ret, err := p.call(fn, pluginRequest(c))
+			if err != nil {
+				log.Printf("Error occured during operation: %v\n", err)
+				continue
+			}
+			if table, ok := ret.(*lua.LTable); ok {
+				values, _ := fromLua(table).(map[string]interface{})
+				for key, value := range values {
+					data[key] = value
+				}
+			}
+		}
+	}
+	return data
+}
+
+// html renders a page with the request's extra data added to what the
+// handler passed, which wins where both set a key
+func (s *server) html(c *gin.Context, code int, name string, data gin.H) {
+	for key, value := range s.pageData(c) {
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+	c.HTML(code, name, data)
+}
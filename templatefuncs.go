@@ -0,0 +1,161 @@
+package blog
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the helpers themes get on top of the site specific ones in funcMap, most
+// take their subject last so they read well in pipelines:
+// {{ .Description | truncate 140 }}
+var themeFuncs = template.FuncMap{
+	"markdownify": markdownify,
+	"dateFormat":  dateFormat,
+	"truncate":    truncate,
+	"slugify":     sanitizeHeaderForID,
+	"absURL":      absURL,
+	"jsonify":     jsonify,
+	"where":       where,
+	"sortBy":      sortBy,
+}
+
+// markdownify renders a snippet of markdown, like a description
+func markdownify(md string) template.HTML {
+	return template.HTML(mdToHTML([]byte(md)))
+}
+
+// dateFormat formats a time, or a date string as the Date metadata takes
+// them, with a go layout: {{ dateFormat "Jan 2, 2006" .Date }}
+func dateFormat(layout string, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		parsed, err := parseDate(v)
+		if err != nil {
+			return "", err
+		}
+		t = parsed
+	default:
+		return "", fmt.Errorf("dateFormat: can't format a %T", value)
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(layout), nil
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// truncate shortens text to at most n characters, at a word boundary where
+// there is one, with an ellipsis. Html is reduced to its text first
+func truncate(n int, value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case template.HTML:
+		text = html.UnescapeString(htmlTag.ReplaceAllString(string(v), ""))
+	case string:
+		text = v
+	default:
+		text = fmt.Sprint(value)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// absURL makes a site path absolute, for feeds and meta tags
+func absURL(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return baseURL() + "/" + strings.TrimPrefix(path, "/")
+}
+
+// jsonify encodes a value for a script tag
+func jsonify(value interface{}) (template.JS, error) {
+	b, err := json.Marshal(value)
+	return template.JS(b), err
+}
+
+// where keeps the posts whose field equals value, or contains it for list
+// fields like Tags: {{ where posts "Tags" "go" }}
+func where(posts []BlogPost, field string, value interface{}) ([]BlogPost, error) {
+	if _, ok := reflect.TypeOf(BlogPost{}).FieldByName(field); !ok {
+		return nil, fmt.Errorf("where: posts have no %s", field)
+	}
+
+	want := fmt.Sprint(value)
+	var matched []BlogPost
+	for _, post := range posts {
+		v := reflect.ValueOf(post).FieldByName(field)
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				if fmt.Sprint(v.Index(i).Interface()) == want {
+					matched = append(matched, post)
+					break
+				}
+			}
+			continue
+		}
+		if fmt.Sprint(v.Interface()) == want {
+			matched = append(matched, post)
+		}
+	}
+	return matched, nil
+}
+
+// sortBy sorts a copy of the posts by a string, number or date field,
+// ascending unless "desc" is given: {{ sortBy posts "Date" "desc" }}
+func sortBy(posts []BlogPost, field string, order ...string) ([]BlogPost, error) {
+	f, ok := reflect.TypeOf(BlogPost{}).FieldByName(field)
+	if !ok {
+		return nil, fmt.Errorf("sortBy: posts have no %s", field)
+	}
+
+	var less func(a, b reflect.Value) bool
+	switch {
+	case f.Type == reflect.TypeOf(time.Time{}):
+		less = func(a, b reflect.Value) bool {
+			return a.Interface().(time.Time).Before(b.Interface().(time.Time))
+		}
+	case f.Type.Kind() == reflect.Int:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case f.Type.Kind() == reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	default:
+		return nil, fmt.Errorf("sortBy: can't sort by %s", field)
+	}
+	desc := len(order) > 0 && strings.EqualFold(order[0], "desc")
+
+	sorted := append([]BlogPost(nil), posts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a := reflect.ValueOf(sorted[i]).FieldByIndex(f.Index)
+		b := reflect.ValueOf(sorted[j]).FieldByIndex(f.Index)
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return sorted, nil
+}
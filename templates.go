@@ -0,0 +1,92 @@
+package blog
+
+import (
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/render"
+)
+
+const templatesDir = "templates"
+
+var definesBlocks = regexp.MustCompile(`\{\{-?\s*define\s`)
+
+// pageTemplates is the site's templates, named by their path under
+// templates/. A page template that defines blocks gets a set of its own, so
+// what it fills in a base layout's blocks doesn't leak into other pages.
+// Everything else, partials and layouts in subdirectories included, shares
+// one set
+type pageTemplates struct {
+	common *template.Template
+	pages  map[string]*template.Template
+}
+
+// Instance is how gin renders a template by name
+func (p *pageTemplates) Instance(name string, data any) render.Render {
+	t, ok := p.pages[name]
+	if !ok {
+		t = p.common
+	}
+	return render.HTML{Template: t, Name: name, Data: data}
+}
+
+// Lookup finds a template by name, nil when there's none
+func (p *pageTemplates) Lookup(name string) *template.Template {
+	if t, ok := p.pages[name]; ok {
+		return t.Lookup(name)
+	}
+	return p.common.Lookup(name)
+}
+
+// loadTemplates parses every file under dir
+func loadTemplates(dir string, funcs template.FuncMap) (*pageTemplates, error) {
+	type file struct {
+		name, content string
+	}
+	var common, pages []file
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f := file{filepath.ToSlash(rel), string(content)}
+		if !strings.Contains(f.name, "/") && definesBlocks.MatchString(f.content) {
+			pages = append(pages, f)
+		} else {
+			common = append(common, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pageTemplates{common: template.New("").Funcs(funcs), pages: make(map[string]*template.Template)}
+	for _, f := range common {
+		if _, err := p.common.New(f.name).Parse(f.content); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range pages {
+		t, err := p.common.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.New(f.name).Parse(f.content); err != nil {
+			return nil, err
+		}
+		p.pages[f.name] = t
+	}
+	return p, nil
+}
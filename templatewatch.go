@@ -0,0 +1,71 @@
+package blog
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// templatesSignature changes whenever a file under templates/ is added,
+// removed or written
+func templatesSignature() (string, error) {
+	var sig strings.Builder
+	err := filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return sig.String(), err
+}
+
+// reloadTemplates parses the templates again and swaps them in once the
+// requests in flight are done. Templates that don't parse leave the old
+// ones serving, with the error shown on every page until it's fixed
+func (s *server) reloadTemplates() error {
+	st := s.current()
+	tmpl, err := loadTemplates(templatesDir, s.funcMap(st))
+
+	s.mu.Lock()
+	s.templateErr = err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	st.templates.Store(tmpl)
+	return nil
+}
+
+// watchTemplates polls the templates directory and re-parses the
+// templates whenever it changes, so a theme can be worked on without
+// restarting
+func (s *server) watchTemplates(interval time.Duration) {
+	last, _ := templatesSignature()
+
+	for range time.Tick(interval) {
+		sig, err := templatesSignature()
+		if err != nil {
+			log.Printf("Error watching templates: %v\n", err)
+			continue
+		}
+		if sig == last {
+			continue
+		}
+		last = sig
+
+		if err := s.reloadTemplates(); err != nil {
+			log.Printf("Error reloading templates: %v\n", err)
+			continue
+		}
+		log.Printf("Reloaded templates\n")
+	}
+}
@@ -0,0 +1,181 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scopePostsWrite       = "posts:write"
+	scopeCommentsModerate = "comments:moderate"
+	scopeAnalyticsRead    = "analytics:read"
+)
+
+var tokenScopes = []string{scopePostsWrite, scopeCommentsModerate, scopeAnalyticsRead}
+
+var (
+	errTokenNotFound = errors.New("token not found")
+	errInvalidScope  = errors.New("invalid scope")
+)
+
+// APIToken is a long lived credential for scripts and ci, only a hash of
+// the secret is kept
+type APIToken struct {
+	ID        string
+	Name      string
+	Hash      string
+	Scopes    []string
+	CreatedAt time.Time
+	LastUsed  time.Time
+}
+
+type tokenStore struct {
+	mu     sync.Mutex
+	path   string
+	Tokens []APIToken `json:"tokens"`
+	// when LastUsed was last written out
+	saved time.Time
+}
+
+func newTokenStore(path string) (*tokenStore, error) {
+	s := &tokenStore{path: path}
+	if err := loadJSON(path, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// create stores a new token and returns its secret, which can't be
+// recovered afterwards
+func (s *tokenStore) create(name string, scopes []string) (string, APIToken, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", APIToken{}, errInvalidScope
+		}
+	}
+
+	secret := "bloog_" + newID() + newID() + newID()
+	token := APIToken{
+		ID:        newID(),
+		Name:      name,
+		Hash:      hashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Tokens = append(s.Tokens, token)
+	return secret, token, saveJSON(s.path, s)
+}
+
+func (s *tokenStore) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Tokens {
+		if s.Tokens[i].ID == id {
+			s.Tokens = append(s.Tokens[:i], s.Tokens[i+1:]...)
+			return saveJSON(s.path, s)
+		}
+	}
+	return errTokenNotFound
+}
+
+// verify looks up the token with the given secret
+func (s *tokenStore) verify(secret string) (APIToken, bool) {
+	hash := hashToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(s.Tokens[i].Hash), []byte(hash)) == 1 {
+			s.Tokens[i].LastUsed = time.Now().UTC()
+			// saved at most once a minute, a busy client shouldn't mean a
+			// write per request
+			if time.Since(s.saved) > time.Minute {
+				s.saved = time.Now()
+				if err := saveJSON(s.path, s); err != nil {
+					log.Printf("Error saving api tokens: %v\n", err)
+				}
+			}
+			return s.Tokens[i], true
+		}
+	}
+	return APIToken{}, false
+}
+
+// list returns every token, newest first
+func (s *tokenStore) list() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := append([]APIToken(nil), s.Tokens...)
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+func (t APIToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(scope string) bool {
+	for _, s := range tokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope lets through requests carrying a bearer token with scope, or
+// from a signed in user with at least role
+func (s *server) requireScope(scope, role string) gin.HandlerFunc {
+	byRole := s.requireRole(role)
+
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			byRole(c)
+			return
+		}
+
+		token, ok := s.tokens.verify(strings.TrimPrefix(auth, "Bearer "))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !token.hasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token is missing the " + scope + " scope"})
+			return
+		}
+
+		c.Set("token", token)
+		c.Next()
+	}
+}
@@ -0,0 +1,197 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+)
+
+var roles = []string{roleViewer, roleEditor, roleAdmin}
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errUserExists   = errors.New("user already exists")
+	errInvalidRole  = errors.New("invalid role")
+)
+
+type User struct {
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// userStore keeps the admin area accounts in a sqlite database
+type userStore struct {
+	db *sql.DB
+}
+
+func newUserStore(path string) (*userStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite takes one writer at a time, one connection saves waiting on
+	// its lock
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &userStore{db: db}, nil
+}
+
+// roleAtLeast reports whether role grants everything need does
+func roleAtLeast(role, need string) bool {
+	rank := func(r string) int {
+		for i, known := range roles {
+			if known == r {
+				return i
+			}
+		}
+		return -1
+	}
+	return rank(role) >= 0 && rank(role) >= rank(need)
+}
+
+func validRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the user when the password matches, comparing
+// outside the query so a slow hash doesn't hold up every other lookup
+func (s *userStore) authenticate(username, password string) (User, bool) {
+	user, err := s.get(username)
+	if err != nil {
+		if !errors.Is(err, errUserNotFound) {
+			log.Printf("Error occured during operation: %v\n", err)
+		}
+		return User{}, false
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	return user, err == nil
+}
+
+func (s *userStore) get(username string) (User, error) {
+	var user User
+	err := s.db.QueryRow(`SELECT username, password_hash, role, created_at FROM users WHERE username = ?`, username).
+		Scan(&user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errUserNotFound
+	}
+	return user, err
+}
+
+// role is the user's current role, false once they've been deleted
+func (s *userStore) role(username string) (string, bool) {
+	user, err := s.get(username)
+	if err != nil {
+		if !errors.Is(err, errUserNotFound) {
+			log.Printf("Error occured during operation: %v\n", err)
+		}
+		return "", false
+	}
+	return user.Role, true
+}
+
+func (s *userStore) add(username, password, role string) error {
+	if !validRole(role) {
+		return errInvalidRole
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// the primary key turns away a second user by the same name
+	res, err := s.db.Exec(`INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?) ON CONFLICT (username) DO NOTHING`,
+		username, string(hash), role, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errUserExists
+	}
+	return nil
+}
+
+func (s *userStore) setRole(username, role string) error {
+	if !validRole(role) {
+		return errInvalidRole
+	}
+
+	res, err := s.db.Exec(`UPDATE users SET role = ? WHERE username = ?`, role, username)
+	return affected(res, err)
+}
+
+func (s *userStore) delete(username string) error {
+	res, err := s.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+	return affected(res, err)
+}
+
+// affected is errUserNotFound when the statement changed no rows
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// list returns every user sorted by username
+func (s *userStore) list() []User {
+	rows, err := s.db.Query(`SELECT username, password_hash, role, created_at FROM users ORDER BY username`)
+	if err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt); err != nil {
+			log.Printf("Error occured during operation: %v\n", err)
+			return users
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error occured during operation: %v\n", err)
+	}
+	return users
+}
@@ -0,0 +1,213 @@
+package blog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how bad a problem found at startup is
+type severity int
+
+const (
+	// the site works, but probably not how it was meant to
+	problemWarning severity = iota
+	// part of the site is left out, only served in degraded mode
+	problemError
+	// the site can't start at all
+	problemFatal
+)
+
+func (s severity) String() string {
+	switch s {
+	case problemWarning:
+		return "warning"
+	case problemError:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
+
+type problem struct {
+	severity severity
+	message  string
+}
+
+// startupReport collects everything wrong with the config, content and
+// templates, so it can all be fixed in one go rather than one restart at
+// a time
+type startupReport struct {
+	problems []problem
+}
+
+// add records err, one problem per line when it's several joined together
+func (r *startupReport) add(sev severity, err error) {
+	if err == nil {
+		return
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			r.problems = append(r.problems, problem{severity: sev, message: line})
+		}
+	}
+}
+
+func (r *startupReport) addf(sev severity, format string, args ...interface{}) {
+	r.add(sev, fmt.Errorf(format, args...))
+}
+
+func (r *startupReport) count(sev severity) int {
+	n := 0
+	for _, p := range r.problems {
+		if p.severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+// failed reports whether the server should stop: anything fatal, or any
+// error unless the site is allowed to run degraded
+func (r *startupReport) failed() bool {
+	return r.count(problemFatal) > 0 || (r.count(problemError) > 0 && !config().Degraded)
+}
+
+func (r *startupReport) Error() string {
+	return r.String()
+}
+
+func (r *startupReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bloog found %d problem(s) at startup:\n", len(r.problems))
+	for _, p := range r.problems {
+		fmt.Fprintf(&b, "  %-7s  %s\n", p.severity, p.message)
+	}
+
+	fatal, errs, warnings := r.count(problemFatal), r.count(problemError), r.count(problemWarning)
+	fmt.Fprintf(&b, "%d fatal, %d error(s), %d warning(s).", fatal, errs, warnings)
+	switch {
+	case fatal > 0:
+		b.WriteString(" Fix the fatal problems to start the server.")
+	case errs > 0 && config().Degraded:
+		b.WriteString(" Running degraded, with the broken parts left out.")
+	case errs > 0:
+		b.WriteString(" Fix the errors, or set degraded: true in bloog.yaml to start without the broken parts.")
+	}
+	return b.String()
+}
+
+// the templates handlers render by name
+var requiredTemplates = []string{
+	"layout.html", "index.html", "404.html", "500.html", "search.html", "changelog.html",
+	"admin-login.html", "admin-comments.html", "admin-users.html",
+	"admin-tokens.html", "admin-media.html", "admin-dashboard.html",
+	"quick-search.html", "list.html", "category.html", "all.html",
+}
+
+// checkTemplates parses the templates the way routes will, which panics on
+// errors, and looks for the ones that are missing
+func (s *server) checkTemplates(report *startupReport) {
+	if config().Headless {
+		return
+	}
+	tmpl, err := loadTemplates(templatesDir, s.funcMap(&site{}))
+	if err != nil {
+		report.addf(problemFatal, "templates: %v", err)
+		return
+	}
+	for _, name := range requiredTemplates {
+		if tmpl.Lookup(name) == nil {
+			report.addf(problemError, "templates: %s is missing, pages using it will fail", filepath.Join("templates", name))
+		}
+	}
+}
+
+// checkPosts finds posts that can't be reached: no slug, or a slug or url
+// another post already has
+func checkPosts(posts []BlogPost) []error {
+	var errs []error
+	bySlug := make(map[string]string)
+	byURL := make(map[string]string)
+	for _, post := range posts {
+		if post.Slug == "" {
+			errs = append(errs, fmt.Errorf("%s: post %q has an empty slug and no url of its own", postSource(post), post.Title))
+			continue
+		}
+		if other, ok := bySlug[post.Slug]; ok {
+			errs = append(errs, fmt.Errorf("%s: slug %q is already used by %s", postSource(post), post.Slug, other))
+			continue
+		}
+		bySlug[post.Slug] = postSource(post)
+		if other, ok := byURL[post.URL()]; ok {
+			errs = append(errs, fmt.Errorf("%s: url %s is already used by %s", postSource(post), post.URL(), other))
+			continue
+		}
+		byURL[post.URL()] = postSource(post)
+	}
+	return append(errs, duplicateErrors(posts, findDuplicates(posts))...)
+}
+
+func postSource(post BlogPost) string {
+	return filepath.Join("markdown", post.SourcePath)
+}
+
+// shadowedPosts finds posts whose url a built in route answers first, so
+// they're never served
+func shadowedPosts(routes gin.RoutesInfo, posts []BlogPost) []error {
+	var errs []error
+	for _, post := range posts {
+		if post.Slug == "" {
+			continue
+		}
+		for _, route := range routes {
+			if route.Method == "GET" && routeMatches(route.Path, post.URL()) {
+				errs = append(errs, fmt.Errorf("%s: url %s is taken by the route %s", postSource(post), post.URL(), route.Path))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// routeMatches matches a path against a gin pattern with :params and a
+// *wildcard
+func routeMatches(pattern, path string) bool {
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range want {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+		if i >= len(got) {
+			return false
+		}
+		if !strings.HasPrefix(part, ":") && part != got[i] {
+			return false
+		}
+	}
+	return len(want) == len(got)
+}
+
+// dataError names the data file an error came from
+func dataError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// printReport writes the report for whoever's starting the server
+func printReport(report *startupReport) {
+	fmt.Fprintln(os.Stderr, report)
+}
+
+// configProblem is a config that didn't load, as a report of its own
+func configProblem(err error) *startupReport {
+	report := &startupReport{}
+	report.add(problemFatal, fmt.Errorf("%s: %w", configPath(), err))
+	return report
+}
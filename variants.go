@@ -0,0 +1,148 @@
+package blog
+
+import (
+	"fmt"
+	"html/template"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the label of a post's own file among its variants
+const baseVariant = "a"
+
+// how long a reader keeps the variant they were given, 90 days
+const variantCookieAge = 90 * 24 * 60 * 60
+
+// pricing.b.md is variant b of pricing.md
+var variantFile = regexp.MustCompile(`^(.+)\.([a-z0-9]+)\.md$`)
+
+// Variant is the version of a post a reader was shown, for analytics
+type Variant struct {
+	Experiment string
+	Label      string
+}
+
+// withVariants takes the variant files out of posts and hands them to the
+// post they're a variant of. A variant file without its post is a post of
+// its own, and draft variants are left out
+func withVariants(posts []BlogPost) []BlogPost {
+	bySource := make(map[string]int)
+	for i, post := range posts {
+		bySource[post.SourcePath] = i
+	}
+
+	var kept []BlogPost
+	variants := make(map[int]map[string]BlogPost)
+	for _, post := range posts {
+		m := variantFile.FindStringSubmatch(post.SourcePath)
+		if m == nil || m[2] == baseVariant {
+			kept = append(kept, post)
+			continue
+		}
+		base, ok := bySource[m[1]+".md"]
+		if !ok {
+			kept = append(kept, post)
+			continue
+		}
+		if post.Draft {
+			continue
+		}
+		// rather than the one made up from its file name
+		if post.Title == defaultTitle(post.SourcePath) {
+			post.Title = ""
+		}
+		if variants[base] == nil {
+			variants[base] = make(map[string]BlogPost)
+		}
+		variants[base][m[2]] = post
+	}
+
+	for i := range kept {
+		if v, ok := variants[bySource[kept[i].SourcePath]]; ok {
+			kept[i].Variants = v
+		}
+	}
+	return kept
+}
+
+// variantLabels are the labels a reader can be given, the post's own first
+func (p BlogPost) variantLabels() []string {
+	labels := []string{baseVariant}
+	for label := range p.Variants {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels[1:])
+	return labels
+}
+
+// variant is the post as variant label shows it: the variant's content and
+// whichever of the title and descriptions it has, the rest the post's own
+func (p BlogPost) variant(label string) BlogPost {
+	v, ok := p.Variants[label]
+	if !ok {
+		return p
+	}
+	p.Content, p.Headers = v.Content, v.Headers
+	if v.Title != "" {
+		p.Title = v.Title
+	}
+	if v.Description != "" {
+		p.Description = v.Description
+	}
+	// one taken from the variant's content beats one from the post's
+	if v.MetaDescription != "" && (!v.MetaDescriptionDerived || p.MetaDescriptionDerived) {
+		if p.MetaPropertyDescription == p.MetaDescription {
+			p.MetaPropertyDescription = v.MetaPropertyDescription
+		}
+		p.MetaDescription = v.MetaDescription
+	}
+	return p
+}
+
+// variantCookie is the cookie a reader's variant of slug is kept in
+func variantCookie(slug string) string {
+	return "bloog_ab_" + strings.ReplaceAll(slug, "/", "_")
+}
+
+// assignVariant picks the variant of post the reader sees: ?variant= to
+// preview one, otherwise the one they were given before, otherwise one at
+// random that they keep. Pages with variants aren't cached for others
+func assignVariant(c *gin.Context, post BlogPost) (BlogPost, *Variant) {
+	if len(post.Variants) == 0 {
+		return post, nil
+	}
+	c.Header("Cache-Control", "private")
+	c.Header("Vary", "Cookie")
+
+	labels := post.variantLabels()
+	valid := func(label string) bool {
+		return label == baseVariant || post.Variants[label].SourcePath != ""
+	}
+	label := c.Query("variant")
+	if !valid(label) {
+		name := variantCookie(post.Slug)
+		label, _ = c.Cookie(name)
+		if !valid(label) {
+			label = labels[rand.Intn(len(labels))]
+			setCookie(c, name, label, variantCookieAge)
+		}
+	}
+	return post.variant(label), &Variant{Experiment: post.Slug, Label: label}
+}
+
+// variantAnalytics tells the analytics snippet which variant the page is,
+// as plausible props and google analytics parameters, and for any other
+// snippet as window.bloogVariant
+func variantAnalytics(v *Variant) (plausible, google, script string) {
+	if v == nil {
+		return "", "", ""
+	}
+	plausible = fmt.Sprintf(` event-experiment="%s" event-variant="%s"`, template.HTMLEscapeString(v.Experiment), template.HTMLEscapeString(v.Label))
+	google = fmt.Sprintf(", {'experiment': '%s', 'variant': '%s'}", template.JSEscapeString(v.Experiment), template.JSEscapeString(v.Label))
+	script = fmt.Sprintf("<script>window.bloogVariant = {experiment: '%s', variant: '%s'};</script>\n", template.JSEscapeString(v.Experiment), template.JSEscapeString(v.Label))
+	return plausible, google, script
+}
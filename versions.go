@@ -0,0 +1,163 @@
+package blog
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionLink is one entry of the version switcher
+type VersionLink struct {
+	Name    string
+	URL     string
+	Current bool
+	Latest  bool
+}
+
+// latestVersion is the version the /latest alias points at, the one marked
+// latest or otherwise the first listed
+func latestVersion() string {
+	for _, v := range config().Versions {
+		if v.Latest {
+			return v.Name
+		}
+	}
+	if len(config().Versions) > 0 {
+		return config().Versions[0].Name
+	}
+	return ""
+}
+
+// currentPosts drops the posts of every docs version but the latest, so
+// what lists the whole site (feeds, the sitemap, search) has each page once
+func currentPosts(posts []BlogPost) []BlogPost {
+	latest := latestVersion()
+	var current []BlogPost
+	for _, post := range posts {
+		if post.Version == "" || post.Version == latest {
+			current = append(current, post)
+		}
+	}
+	return current
+}
+
+// loadContent loads the unversioned posts in the content directory along
+// with every version's posts from its subdirectory. Versioned slugs are
+// prefixed with the version so they're served at /v1/slug
+func (s *server) loadContent() ([]BlogPost, error) {
+	// a version that doesn't load doesn't stop the others
+	posts, err := s.cache.load(s.contentDir)
+	errs := []error{err}
+
+	for _, v := range config().Versions {
+		cache, ok := s.versionCaches[v.Name]
+		if !ok {
+			cache = s.newContentCache()
+			s.versionCaches[v.Name] = cache
+		}
+
+		versioned, err := cache.load(filepath.Join(s.contentDir, v.Name))
+		errs = append(errs, err)
+		for _, post := range versioned {
+			post.Version = v.Name
+			if post.Slug != "" {
+				post.Slug = v.Name + "/" + post.Slug
+			}
+			post.SourcePath = v.Name + "/" + post.SourcePath
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, errors.Join(errs...)
+}
+
+// versionSidebars builds a sidebar per version, the unversioned posts are
+// under ""
+func versionSidebars(posts []BlogPost, categories map[string]categoryMeta) map[string]SideBar {
+	byVersion := make(map[string][]BlogPost)
+	for _, post := range posts {
+		byVersion[post.Version] = append(byVersion[post.Version], post)
+	}
+
+	sidebars := map[string]SideBar{"": withCategories(buildSidebarData(byVersion[""]), categories)}
+	for _, v := range config().Versions {
+		sidebars[v.Name] = withCategories(buildSidebarData(byVersion[v.Name]), categories)
+	}
+	return sidebars
+}
+
+// sidebarFor returns the sidebar of a version
+func (s *server) sidebarFor(version string) SideBar {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sidebars[version]
+}
+
+// versionLinks lists every version for the switcher, pointing at the same
+// page in that version when it exists and the version's first page if not
+func (s *server) versionLinks(post BlogPost) []VersionLink {
+	if post.Version == "" {
+		return nil
+	}
+	page := strings.TrimPrefix(post.Slug, post.Version+"/")
+	latest := latestVersion()
+
+	var links []VersionLink
+	for _, v := range config().Versions {
+		url := s.versionHome(v.Name)
+		if other, ok := s.post(v.Name + "/" + page); ok {
+			url = other.URL()
+		}
+		links = append(links, VersionLink{
+			Name:    v.Name,
+			URL:     url,
+			Current: v.Name == post.Version,
+			Latest:  v.Name == latest,
+		})
+	}
+	return links
+}
+
+// versionHome is the first page in a version's sidebar
+func (s *server) versionHome(version string) string {
+	for _, category := range s.sidebarFor(version).Categories {
+		if len(category.Pages) > 0 {
+			return category.Pages[0].URL()
+		}
+	}
+	return "/"
+}
+
+// versionRedirect handles /latest/... and bare /v1 style paths, reporting
+// whether it answered the request
+func (s *server) versionRedirect(c *gin.Context) bool {
+	if len(config().Versions) == 0 {
+		return false
+	}
+
+	p := strings.Trim(c.Request.URL.Path, "/")
+	first, rest, _ := strings.Cut(p, "/")
+
+	if first == "latest" {
+		if rest == "" {
+			c.Redirect(http.StatusFound, s.versionHome(latestVersion()))
+		} else {
+			c.Redirect(http.StatusFound, "/"+latestVersion()+"/"+rest)
+		}
+		return true
+	}
+
+	if rest == "" {
+		for _, v := range config().Versions {
+			if v.Name == first {
+				c.Redirect(http.StatusFound, s.versionHome(v.Name))
+				return true
+			}
+		}
+	}
+	return false
+}
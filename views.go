@@ -0,0 +1,94 @@
+package blog
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// viewStore counts page views per slug, in a json file or in redis when
+// several instances need to share them
+type viewStore interface {
+	hit(slug string)
+	count(slug string) int
+	popular(posts []BlogPost, n int) []BlogPost
+}
+
+// viewCounter keeps per slug page view counts, persisted to a json file
+type viewCounter struct {
+	mu     sync.Mutex
+	path   string
+	counts map[string]int
+	dirty  bool
+}
+
+func newViewCounter(path string) (*viewCounter, error) {
+	v := &viewCounter{path: path, counts: make(map[string]int)}
+	if err := loadJSON(path, &v.counts); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (v *viewCounter) hit(slug string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.counts[slug]++
+	v.dirty = true
+}
+
+func (v *viewCounter) count(slug string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.counts[slug]
+}
+
+func (v *viewCounter) save() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.dirty {
+		return nil
+	}
+	// stays dirty when the write fails, so the next save tries again
+	if err := saveJSON(v.path, v.counts); err != nil {
+		return err
+	}
+	v.dirty = false
+	return nil
+}
+
+// persist saves the counts every interval, forever
+func (v *viewCounter) persist(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := v.save(); err != nil {
+			log.Printf("Error saving view counts: %v\n", err)
+		}
+	}
+}
+
+// popular returns up to n of the posts, most viewed first, skipping unviewed
+func (v *viewCounter) popular(posts []BlogPost, n int) []BlogPost {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var viewed []BlogPost
+	for _, post := range posts {
+		if v.counts[post.Slug] > 0 {
+			viewed = append(viewed, post)
+		}
+	}
+
+	sort.SliceStable(viewed, func(i, j int) bool {
+		return v.counts[viewed[i].Slug] > v.counts[viewed[j].Slug]
+	})
+
+	if len(viewed) > n {
+		viewed = viewed[:n]
+	}
+	return viewed
+}
@@ -0,0 +1,110 @@
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s SecurityConfig) enabled() bool {
+	return len(s.Contact) > 0
+}
+
+func (h HumansConfig) enabled() bool {
+	return len(h.Team) > 0 || len(h.Thanks) > 0
+}
+
+// checkSecurity catches a security.txt that scanners would reject
+func checkSecurity(cfg SecurityConfig) error {
+	for _, contact := range cfg.Contact {
+		if !strings.HasPrefix(contact, "mailto:") && !strings.HasPrefix(contact, "https://") && !strings.HasPrefix(contact, "tel:") {
+			return fmt.Errorf("security: contact %q should be a mailto:, https:// or tel: uri", contact)
+		}
+	}
+	if !cfg.Expires.IsZero() && cfg.Expires.Before(time.Now()) {
+		return fmt.Errorf("security: expires %s has passed", cfg.Expires.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// handleSecurityTxt serves /.well-known/security.txt, where to report
+// vulnerabilities in the site: https://www.rfc-editor.org/rfc/rfc9116
+func handleSecurityTxt(c *gin.Context) {
+	cfg := config().Security
+	var b strings.Builder
+	for _, contact := range cfg.Contact {
+		b.WriteString("Contact: " + contact + "\n")
+	}
+	// the file has to expire, a year out keeps it valid while the config
+	// doesn't say
+	expires := cfg.Expires
+	if expires.IsZero() {
+		expires = time.Now().AddDate(1, 0, 0).Truncate(24 * time.Hour)
+	}
+	b.WriteString("Expires: " + expires.UTC().Format(time.RFC3339) + "\n")
+	if cfg.Encryption != "" {
+		b.WriteString("Encryption: " + cfg.Encryption + "\n")
+	}
+	if cfg.Acknowledgments != "" {
+		b.WriteString("Acknowledgments: " + cfg.Acknowledgments + "\n")
+	}
+	if len(cfg.PreferredLanguages) > 0 {
+		b.WriteString("Preferred-Languages: " + strings.Join(cfg.PreferredLanguages, ", ") + "\n")
+	}
+	if cfg.Policy != "" {
+		b.WriteString("Policy: " + cfg.Policy + "\n")
+	}
+	if cfg.Hiring != "" {
+		b.WriteString("Hiring: " + cfg.Hiring + "\n")
+	}
+	b.WriteString("Canonical: " + baseURL() + "/.well-known/security.txt\n")
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}
+
+// handleHumansTxt serves /humans.txt, the people behind the site:
+// https://humanstxt.org
+func (s *server) handleHumansTxt(c *gin.Context) {
+	cfg := config().Humans
+	var b strings.Builder
+	if len(cfg.Team) > 0 {
+		b.WriteString("/* TEAM */\n")
+		for _, human := range cfg.Team {
+			b.WriteString("\n")
+			role := human.Role
+			if role == "" {
+				role = "Author"
+			}
+			fmt.Fprintf(&b, "\t%s: %s\n", role, human.Name)
+			if human.Contact != "" {
+				fmt.Fprintf(&b, "\tContact: %s\n", human.Contact)
+			}
+			if human.Location != "" {
+				fmt.Fprintf(&b, "\tFrom: %s\n", human.Location)
+			}
+		}
+		b.WriteString("\n")
+	}
+	if len(cfg.Thanks) > 0 {
+		b.WriteString("/* THANKS */\n\n")
+		for _, name := range cfg.Thanks {
+			fmt.Fprintf(&b, "\t%s\n", name)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("/* SITE */\n\n")
+	var updated time.Time
+	for _, post := range publicPosts(s.allPosts()) {
+		if post.LastModified.After(updated) {
+			updated = post.LastModified
+		}
+	}
+	if !updated.IsZero() {
+		fmt.Fprintf(&b, "\tLast update: %s\n", updated.Format("2006/01/02"))
+	}
+	b.WriteString("\tSoftware: bloog\n")
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}